@@ -0,0 +1,146 @@
+package api
+
+import (
+	"crypto/subtle"
+	"errors"
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/crypto"
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/domain"
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/signing"
+	"net/http"
+	"strings"
+	"time"
+)
+
+type ImportSignatureDeviceRequest struct {
+	Id         string                     `json:"id" schema:"format=uuid"`
+	Algorithm  domain.CryptoAlgorithmType `json:"algorithm" schema:"required,enum=ECC|RSA"`
+	Label      string                     `json:"label" schema:"maxLength=256,pattern=^[\\p{L}\\p{N} ._:/()#-]*$"`
+	PrivateKey string                     `json:"private_key" schema:"required"`
+	Password   string                     `json:"password"`
+}
+
+type ExportSignatureDeviceRequest struct {
+	Password string `json:"password" schema:"required"`
+}
+
+type ExportSignatureDeviceResponse struct {
+	DeviceId   string                     `json:"device_id"`
+	Algorithm  domain.CryptoAlgorithmType `json:"algorithm"`
+	Label      string                     `json:"label"`
+	PrivateKey string                     `json:"private_key"`
+}
+
+// authorizeAdmin checks the bearer token of an admin request and writes an error response if it is not valid.
+func (s *Server) authorizeAdmin(response http.ResponseWriter, request *http.Request) bool {
+	if s.adminToken == "" {
+		WriteErrorResponse(response, http.StatusForbidden, []string{"admin API is disabled"})
+		return false
+	}
+	token, found := strings.CutPrefix(request.Header.Get("Authorization"), "Bearer ")
+	if !found || subtle.ConstantTimeCompare([]byte(token), []byte(s.adminToken)) != 1 {
+		response.Header().Set("WWW-Authenticate", "Bearer")
+		WriteErrorResponse(response, http.StatusUnauthorized, []string{http.StatusText(http.StatusUnauthorized)})
+		return false
+	}
+	return true
+}
+
+// ImportSignatureDevice creates a device from an existing private key.
+func (s *Server) ImportSignatureDevice(response http.ResponseWriter, request *http.Request) {
+	if !s.authorizeAdmin(response, request) {
+		return
+	}
+	var body ImportSignatureDeviceRequest
+	isValidRequest, errs := PostMethodTemplate(request, &body)
+	if !isValidRequest {
+		writeRequestErrors(response, errs)
+		return
+	}
+
+	done, ok := s.beginWrite(response, request)
+	if !ok {
+		return
+	}
+	defer done()
+	device, err := s.signatures.ImportDevice(request.Context(), body.Id, body.Algorithm, body.Label, []byte(body.PrivateKey), []byte(body.Password))
+	switch {
+	case errors.Is(err, signing.ErrInvalidPrivateKey), errors.Is(err, signing.ErrAlgorithmDisabled):
+		WriteErrorResponse(response, http.StatusBadRequest, []string{err.Error()})
+		return
+	case err != nil:
+		s.writeSigningError(response, request, err)
+		return
+	}
+	LogDeviceId(request.Context(), device.Id)
+	err = s.tracedStorage(request).AddAuditEvent(domain.AuditEvent{
+		Action:   domain.AuditPrivateKeyImported,
+		DeviceId: device.Id,
+		Origin:   request.RemoteAddr,
+		Time:     time.Now().UTC(),
+	})
+	if err != nil {
+		s.internalError(response, request, err)
+		return
+	}
+
+	WriteAPIResponse(response, http.StatusCreated, CreateSignatureDeviceResponse{
+		DeviceId: device.Id,
+		Label:    device.Label,
+	})
+}
+
+// ExportSignatureDevice exports the private key of a device as a password-encrypted PKCS#8 bundle.
+func (s *Server) ExportSignatureDevice(response http.ResponseWriter, request *http.Request) {
+	if !s.authorizeAdmin(response, request) {
+		return
+	}
+	var body ExportSignatureDeviceRequest
+	isValidRequest, errs := PostMethodTemplate(request, &body)
+	if !isValidRequest {
+		writeRequestErrors(response, errs)
+		return
+	}
+
+	device := s.pathDevice(response, request)
+	if device == nil {
+		return
+	}
+	if len(device.PrivateKey) == 0 {
+		WriteErrorResponse(response, http.StatusConflict, []string{"device has no key pair"})
+		return
+	}
+	done, ok := s.beginWrite(response, request)
+	if !ok {
+		return
+	}
+	defer done()
+	encryptedKey, err := crypto.ExportPrivateKey(device.Algorithm, device.PrivateKey, []byte(body.Password))
+	switch {
+	case errors.Is(err, crypto.ErrInvalidKey):
+		WriteErrorResponse(response, http.StatusBadRequest, []string{crypto.ErrInvalidKey.Error()})
+		return
+	case errors.Is(err, crypto.ErrKeyAlgorithmMismatch):
+		WriteErrorResponse(response, http.StatusConflict, []string{err.Error()})
+		return
+	case err != nil:
+		s.internalError(response, request, err)
+		return
+	}
+	err = s.tracedStorage(request).AddAuditEvent(domain.AuditEvent{
+		Action:   domain.AuditPrivateKeyExported,
+		DeviceId: device.Id,
+		Origin:   request.RemoteAddr,
+		Time:     time.Now().UTC(),
+	})
+	if err != nil {
+		s.internalError(response, request, err)
+		return
+	}
+
+	WriteAPIResponse(response, http.StatusOK, ExportSignatureDeviceResponse{
+		DeviceId:   device.Id,
+		Algorithm:  device.Algorithm,
+		Label:      device.Label,
+		PrivateKey: string(encryptedKey),
+	})
+}
@@ -0,0 +1,186 @@
+package api
+
+import (
+	"encoding/base64"
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/domain"
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/journal"
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/signing"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	// DefaultHistoryLimit is the number of signatures returned per history page unless a limit is given.
+	DefaultHistoryLimit = 100
+	// MaxHistoryLimit bounds the limit of a history page.
+	MaxHistoryLimit = 1000
+)
+
+type SignatureRecordResponse struct {
+	SignatureCounter int                    `json:"signature_counter"`
+	Signature        string                 `json:"signature"`
+	SignedData       string                 `json:"signed_data"`
+	Format           domain.SignatureFormat `json:"format"`
+	Timestamp        time.Time              `json:"timestamp"`
+	PayloadVersion   domain.PayloadVersion  `json:"payload_version,omitempty"`
+	// TimestampToken is the base64 encoded RFC 3161 timestamp token over the signature.
+	TimestampToken string `json:"timestamp_token,omitempty"`
+}
+
+type SignatureHistoryResponse struct {
+	DeviceId   string                    `json:"device_id"`
+	Signatures []SignatureRecordResponse `json:"signatures"`
+	// NextCounter is the from parameter of the next page, it is omitted on the last page.
+	NextCounter *int `json:"next_counter,omitempty"`
+}
+
+type ChainAuditResponse struct {
+	DeviceId       string                 `json:"device_id"`
+	SignatureCount int                    `json:"signature_count"`
+	Valid          bool                   `json:"valid"`
+	Failures       []ChainFailureResponse `json:"failures"`
+}
+
+type ChainFailureResponse struct {
+	SignatureCounter int    `json:"signature_counter"`
+	Reason           string `json:"reason"`
+}
+
+// SignatureHistory returns a page of the signatures of a device in counter order.
+// The page starts at the from query parameter and holds up to limit signatures.
+func (s *Server) SignatureHistory(response http.ResponseWriter, request *http.Request) {
+	if request.Method != http.MethodGet {
+		WriteMethodNotAllowed(response, http.MethodGet)
+		return
+	}
+	from, fromErr := queryInt(request, "from", 0, 0, -1)
+	limit, limitErr := queryInt(request, "limit", DefaultHistoryLimit, 1, MaxHistoryLimit)
+	if details := append(fromErr, limitErr...); len(details) > 0 {
+		WriteErrorDetails(response, http.StatusBadRequest, details)
+		return
+	}
+
+	deviceId := request.PathValue("id")
+	LogDeviceId(request.Context(), deviceId)
+	records, count, err := s.signatures.History(request.Context(), deviceId, from, limit)
+	if err != nil {
+		s.writeSigningError(response, request, err)
+		return
+	}
+	historyResponse := SignatureHistoryResponse{
+		DeviceId:   deviceId,
+		Signatures: make([]SignatureRecordResponse, 0, len(records)),
+	}
+	for _, record := range records {
+		historyResponse.Signatures = append(historyResponse.Signatures, SignatureRecordResponse{
+			SignatureCounter: record.Counter,
+			Signature:        base64.StdEncoding.EncodeToString(record.Signature),
+			SignedData:       record.SignedData,
+			Format:           record.Format,
+			Timestamp:        record.Timestamp,
+			PayloadVersion:   record.PayloadVersion,
+			TimestampToken:   base64.StdEncoding.EncodeToString(record.TimestampToken),
+		})
+	}
+	if next := from + len(records); len(records) > 0 && next < count {
+		historyResponse.NextCounter = &next
+	}
+	WriteAPIResponse(response, http.StatusOK, historyResponse)
+}
+
+// queryInt parses an integer query parameter within [minimum, maximum], a negative maximum
+// leaves it unbounded. Missing parameters take the default value.
+func queryInt(request *http.Request, name string, defaultValue int, minimum int, maximum int) (int, []ErrorDetail) {
+	text := request.URL.Query().Get(name)
+	if text == "" {
+		return defaultValue, nil
+	}
+	value, err := strconv.Atoi(text)
+	if err != nil {
+		return 0, []ErrorDetail{{Code: CodeInvalidType, Field: name, Message: "must be an integer"}}
+	}
+	if value < minimum || (maximum >= 0 && value > maximum) {
+		message := "must be at least " + strconv.Itoa(minimum)
+		if maximum >= 0 {
+			message = "must be between " + strconv.Itoa(minimum) + " and " + strconv.Itoa(maximum)
+		}
+		return 0, []ErrorDetail{{Code: CodeOutOfRange, Field: name, Message: message}}
+	}
+	return value, nil
+}
+
+// AuditSignatureChain checks the signature chain of a device and reports every inconsistency.
+func (s *Server) AuditSignatureChain(response http.ResponseWriter, request *http.Request) {
+	if request.Method != http.MethodGet {
+		WriteMethodNotAllowed(response, http.MethodGet)
+		return
+	}
+	deviceId := request.PathValue("id")
+	LogDeviceId(request.Context(), deviceId)
+	audit, err := s.signatures.AuditChain(request.Context(), deviceId)
+	if err != nil {
+		s.writeSigningError(response, request, err)
+		return
+	}
+	auditResponse := ChainAuditResponse{
+		DeviceId:       audit.DeviceId,
+		SignatureCount: audit.SignatureCount,
+		Valid:          audit.Valid(),
+		Failures:       make([]ChainFailureResponse, 0, len(audit.Failures)),
+	}
+	for _, failure := range audit.Failures {
+		auditResponse.Failures = append(auditResponse.Failures, ChainFailureResponse{
+			SignatureCounter: failure.Counter,
+			Reason:           failure.Reason,
+		})
+	}
+	WriteAPIResponse(response, http.StatusOK, auditResponse)
+}
+
+// SignatureJournal exports the journal of a device, which auditors verify offline.
+func (s *Server) SignatureJournal(response http.ResponseWriter, request *http.Request) {
+	if request.Method != http.MethodGet {
+		WriteMethodNotAllowed(response, http.MethodGet)
+		return
+	}
+	device := s.pathDevice(response, request)
+	if device == nil {
+		return
+	}
+	LogDeviceId(request.Context(), device.Id)
+	// The first page is read before the response starts, so its errors get a regular response.
+	records, count, err := s.signatures.History(request.Context(), device.Id, 0, MaxHistoryLimit)
+	if err != nil {
+		s.writeSigningError(response, request, err)
+		return
+	}
+	from := len(records)
+	next := func() (signing.SignatureRecord, error) {
+		if len(records) == 0 && from < count {
+			// Signatures created after the first page are left out.
+			records, _, err = s.signatures.History(request.Context(), device.Id, from, min(MaxHistoryLimit, count-from))
+			if err != nil {
+				return signing.SignatureRecord{}, err
+			}
+			from += len(records)
+		}
+		if len(records) == 0 {
+			return signing.SignatureRecord{}, io.EOF
+		}
+		record := records[0]
+		records = records[1:]
+		return record, nil
+	}
+
+	response.Header().Set("Content-Type", ContentTypeJSON)
+	response.Header().Set("Content-Disposition", `attachment; filename="journal-`+device.Id+`.json"`)
+	response.WriteHeader(http.StatusOK)
+	if err := journal.Stream(response, device, next); err != nil {
+		// The status has been sent, aborting the response keeps the client from taking a
+		// truncated journal for a complete one.
+		s.logRequestError(request, err)
+		panic(http.ErrAbortHandler)
+	}
+}
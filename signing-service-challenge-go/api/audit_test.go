@@ -0,0 +1,122 @@
+package api
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/assert"
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/domain"
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/journal"
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/signing"
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/tsa"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestServer_SignatureHistory(t *testing.T) {
+	server := newTestServer()
+	deviceId := createTestDevice(t, server, domain.ECC)
+	var batch SignBatchResponse
+	serveJSON(t, server, http.MethodPost, "/api/v0/devices/"+deviceId+"/sign-batch", `{"data": ["a", "b", "c"]}`, &batch)
+
+	var history SignatureHistoryResponse
+	code := serveJSON(t, server, http.MethodGet, "/api/v0/devices/"+deviceId+"/signatures?limit=2", "", &history)
+	assert.ShouldBe(t, code, http.StatusOK)
+	assert.ShouldBe(t, len(history.Signatures), 2)
+	assert.ShouldBe(t, history.Signatures[1].Signature, batch.Signatures[1].Signature)
+	assert.ShouldBe(t, history.Signatures[1].SignedData, batch.Signatures[1].SignedData)
+	assert.ShouldBe(t, history.Signatures[1].Timestamp.Equal(batch.Signatures[1].Timestamp), true)
+	assert.ShouldBe(t, history.Signatures[1].Timestamp.IsZero(), false)
+	assert.ShouldBe(t, history.Signatures[1].PayloadVersion, domain.PayloadV1)
+	assert.ShouldBe(t, *history.NextCounter, 2)
+
+	history = SignatureHistoryResponse{}
+	serveJSON(t, server, http.MethodGet, "/api/v0/devices/"+deviceId+"/signatures?from=2", "", &history)
+	assert.ShouldBe(t, len(history.Signatures), 1)
+	assert.ShouldBe(t, history.NextCounter == nil, true)
+
+	code = serveJSON(t, server, http.MethodGet, "/api/v0/devices/"+deviceId+"/signatures?limit=0", "", &history)
+	assert.ShouldBe(t, code, http.StatusBadRequest)
+}
+
+type unavailableAuthority struct{}
+
+func (unavailableAuthority) Timestamp(context.Context, []byte) ([]byte, error) {
+	return nil, errors.New("connection refused")
+}
+
+func TestServer_SignatureHistoryWithTimestampTokens(t *testing.T) {
+	authority, _ := tsa.NewLocalAuthority(time.Hour)
+	server := newTestServer()
+	server.signatures = signing.NewService(server.storage, signing.WithTimestampAuthority(authority, authority.Roots()))
+	deviceId := createTestDevice(t, server, domain.ECC)
+	var signature SignTransactionResponse
+	serveJSON(t, server, http.MethodPost, "/api/v0/sign-transaction", `{"device_id": "`+deviceId+`", "data": "a"}`, &signature)
+
+	var history SignatureHistoryResponse
+	serveJSON(t, server, http.MethodGet, "/api/v0/devices/"+deviceId+"/signatures", "", &history)
+	token, _ := base64.StdEncoding.DecodeString(history.Signatures[0].TimestampToken)
+	signatureBytes, _ := base64.StdEncoding.DecodeString(signature.Signature)
+	_, err := tsa.Verify(token, signatureBytes, authority.Roots())
+	assert.ShouldBe(t, err, nil)
+
+	// A signature the authority did not timestamp is stored and returned with the error.
+	server.signatures = signing.NewService(server.storage, signing.WithTimestampAuthority(unavailableAuthority{}, nil))
+	response := httptest.NewRecorder()
+	server.Handler().ServeHTTP(response, httptest.NewRequest(http.MethodPost, "/api/v0/sign-transaction", strings.NewReader(`{"device_id": "`+deviceId+`", "data": "b"}`)))
+	assert.ShouldBe(t, response.Code, http.StatusBadGateway)
+	var body struct {
+		ErrorResponse
+		Data SignTransactionResponse `json:"data"`
+	}
+	json.Unmarshal(response.Body.Bytes(), &body)
+	assert.ShouldBe(t, body.Type, ProblemTypePartialFailure)
+	assert.ShouldBe(t, body.Data.SignatureCounter, 1)
+}
+
+func TestServer_SignatureJournal(t *testing.T) {
+	server := newTestServer()
+	deviceId := createTestDevice(t, server, domain.ECC)
+	data := make([]string, MaxHistoryLimit+1)
+	for i := range data {
+		data[i] = strconv.Itoa(i)
+	}
+	_, err := server.signatures.SignBatch(context.Background(), deviceId, data, domain.FormatRaw)
+	assert.ShouldBe(t, err, nil)
+
+	// The journal spans more than one history page.
+	response := httptest.NewRecorder()
+	server.Handler().ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/api/v0/devices/"+deviceId+"/journal", nil))
+	assert.ShouldBe(t, response.Code, http.StatusOK)
+	assert.ShouldBe(t, response.Header().Get("Content-Type"), ContentTypeJSON)
+	exported, err := journal.Read(response.Body)
+	assert.ShouldBe(t, err, nil)
+	report, err := journal.Verify(exported, journal.Trust{PublicKey: server.storage.GetDevice(deviceId).PublicKey})
+	assert.ShouldBe(t, err, nil)
+	assert.ShouldBe(t, report.Passed(), true)
+	assert.ShouldBe(t, report.SignatureCount, MaxHistoryLimit+1)
+
+	response = httptest.NewRecorder()
+	server.Handler().ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/api/v0/devices/unknown/journal", nil))
+	assert.ShouldBe(t, response.Code, http.StatusNotFound)
+}
+
+func TestServer_AuditSignatureChain(t *testing.T) {
+	server := newTestServer()
+	deviceId := createTestDevice(t, server, domain.RSA)
+	serveJSON(t, server, http.MethodPost, "/api/v0/devices/"+deviceId+"/sign-batch", `{"data": ["a", "b"], "format": "cose_sign1"}`, &SignBatchResponse{})
+
+	var audit ChainAuditResponse
+	code := serveJSON(t, server, http.MethodGet, "/api/v0/devices/"+deviceId+"/audit", "", &audit)
+	assert.ShouldBe(t, code, http.StatusOK)
+	assert.ShouldBe(t, audit.Valid, true)
+	assert.ShouldBe(t, audit.SignatureCount, 2)
+
+	code = serveJSON(t, server, http.MethodGet, "/api/v0/devices/unknown/audit", "", &audit)
+	assert.ShouldBe(t, code, http.StatusNotFound)
+}
@@ -0,0 +1,165 @@
+package api
+
+import (
+	"errors"
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/domain"
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/persistence"
+	"net/http"
+	"time"
+)
+
+const (
+	ContentTypeCRL = "application/pkix-crl"
+
+	RevocationListValidity = 24 * time.Hour
+)
+
+type IssueCertificateRequest struct {
+	ValidityDays int `json:"validity_days" schema:"minimum=0"`
+}
+
+type RetireSignatureDeviceResponse struct {
+	DeviceId  string    `json:"device_id"`
+	RetiredAt time.Time `json:"retired_at"`
+}
+
+// hasAuthority checks that a certificate authority is configured and writes an error response if not.
+func (s *Server) hasAuthority(response http.ResponseWriter) bool {
+	if s.authority == nil {
+		WriteErrorResponse(response, http.StatusNotFound, []string{"certificate authority is not configured"})
+		return false
+	}
+	return true
+}
+
+// AuthorityCertificate returns the root certificate of the certificate authority.
+func (s *Server) AuthorityCertificate(response http.ResponseWriter, request *http.Request) {
+	if request.Method != http.MethodGet {
+		WriteMethodNotAllowed(response, http.MethodGet)
+		return
+	}
+	if !s.hasAuthority(response) {
+		return
+	}
+	WriteRawResponse(response, http.StatusOK, ContentTypePEM, s.authority.CertificateChain)
+}
+
+// RevocationList publishes the certificate revocation list of the certificate authority.
+// It revokes the certificates of all retired devices.
+func (s *Server) RevocationList(response http.ResponseWriter, request *http.Request) {
+	if request.Method != http.MethodGet {
+		WriteMethodNotAllowed(response, http.MethodGet)
+		return
+	}
+	if !s.hasAuthority(response) {
+		return
+	}
+
+	now := time.Now().UTC()
+	crl, err := s.authority.CreateRevocationList(s.tracedStorage(request).ListDevices(), now, now.Add(RevocationListValidity))
+	if err != nil {
+		s.internalError(response, request, err)
+		return
+	}
+	WriteRawResponse(response, http.StatusOK, ContentTypeCRL, crl)
+}
+
+// IssueCertificate lets the certificate authority issue a certificate for the device key
+// and stores it together with the root certificate as the certificate chain of the device.
+func (s *Server) IssueCertificate(response http.ResponseWriter, request *http.Request) {
+	if !s.authorizeAdmin(response, request) {
+		return
+	}
+	var body IssueCertificateRequest
+	isValidRequest, errs := PostMethodTemplate(request, &body)
+	if !isValidRequest {
+		writeRequestErrors(response, errs)
+		return
+	}
+	if body.ValidityDays == 0 {
+		body.ValidityDays = DefaultCertificateValidityDays
+	}
+	if !s.hasAuthority(response) {
+		return
+	}
+	device := s.pathDevice(response, request)
+	if device == nil {
+		return
+	}
+
+	done, ok := s.beginWrite(response, request)
+	if !ok {
+		return
+	}
+	defer done()
+	chain, err := s.authority.IssueCertificate(device, time.Now(), time.Duration(body.ValidityDays)*24*time.Hour)
+	if err != nil {
+		s.writeDeviceStateError(response, request, err)
+		return
+	}
+	err = s.tracedStorage(request).SetDeviceCertificate(device.Id, chain)
+	if err != nil {
+		s.writeDeviceStateError(response, request, err)
+		return
+	}
+
+	WriteAPIResponse(response, http.StatusCreated, CertificateResponse{
+		DeviceId:         device.Id,
+		CertificateChain: string(chain),
+	})
+}
+
+// RetireSignatureDevice takes a device out of service. Retired devices do not sign anymore
+// and their certificates issued by the certificate authority are revoked.
+func (s *Server) RetireSignatureDevice(response http.ResponseWriter, request *http.Request) {
+	if request.Method != http.MethodPost {
+		WriteMethodNotAllowed(response, http.MethodPost)
+		return
+	}
+	if !s.authorizeAdmin(response, request) {
+		return
+	}
+	device := s.pathDevice(response, request)
+	if device == nil {
+		return
+	}
+
+	done, ok := s.beginWrite(response, request)
+	if !ok {
+		return
+	}
+	defer done()
+	retiredAt := time.Now().UTC()
+	err := s.tracedStorage(request).RetireDevice(device.Id, retiredAt)
+	if err != nil {
+		s.writeDeviceStateError(response, request, err)
+		return
+	}
+	err = s.tracedStorage(request).AddAuditEvent(domain.AuditEvent{
+		Action:   domain.AuditDeviceRetired,
+		DeviceId: device.Id,
+		Origin:   request.RemoteAddr,
+		Time:     retiredAt,
+	})
+	if err != nil {
+		s.internalError(response, request, err)
+		return
+	}
+
+	WriteAPIResponse(response, http.StatusOK, RetireSignatureDeviceResponse{
+		DeviceId:  device.Id,
+		RetiredAt: retiredAt,
+	})
+}
+
+// writeDeviceStateError writes the error response for a failed change of the state of a device.
+func (s *Server) writeDeviceStateError(response http.ResponseWriter, request *http.Request, err error) {
+	switch {
+	case errors.Is(err, persistence.ErrDeviceNotFound):
+		WriteErrorResponse(response, http.StatusNotFound, []string{persistence.ErrDeviceNotFound.Error()})
+	case errors.Is(err, persistence.ErrDeviceRetired):
+		WriteErrorResponse(response, http.StatusConflict, []string{persistence.ErrDeviceRetired.Error()})
+	default:
+		s.internalError(response, request, err)
+	}
+}
@@ -0,0 +1,96 @@
+package api
+
+import (
+	"context"
+	"crypto/x509"
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/assert"
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/crypto"
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/domain"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func newAuthorityTestServer(t *testing.T) *Server {
+	server := newTestServer()
+	WithAdminToken("token")(server)
+	authority, err := crypto.NewCertificateAuthority(server.storage, domain.ECC, time.Now().UTC(), time.Hour)
+	assert.ShouldBe(t, err, nil)
+	WithCertificateAuthority(authority)(server)
+	return server
+}
+
+func TestServer_IssueCertificate(t *testing.T) {
+	server := newAuthorityTestServer(t)
+	deviceId := createTestDevice(t, server, domain.ECC)
+
+	request := adminRequest(http.MethodPost, "/api/v0/devices/"+deviceId+"/certificate/issue", "{}", "")
+	request.SetPathValue("id", deviceId)
+	response := httptest.NewRecorder()
+	server.IssueCertificate(response, request)
+	assert.ShouldBe(t, response.Code, http.StatusUnauthorized)
+
+	request = adminRequest(http.MethodPost, "/api/v0/devices/"+deviceId+"/certificate/issue", "{}", "token")
+	request.SetPathValue("id", deviceId)
+	response = httptest.NewRecorder()
+	server.IssueCertificate(response, request)
+	assert.ShouldBe(t, response.Code, http.StatusCreated)
+	chain, err := crypto.ParseCertificateChain(server.storage.GetDevice(deviceId).CertificateChain)
+	assert.ShouldBe(t, err, nil)
+	assert.ShouldBe(t, len(chain), 2)
+
+	// The root key is not a device, the devices only hold the key of the test.
+	assert.ShouldBe(t, len(server.storage.ListDevices()), 1)
+}
+
+func TestServer_RetireSignatureDevice(t *testing.T) {
+	server := newAuthorityTestServer(t)
+	deviceId := createTestDevice(t, server, domain.ECC)
+
+	retire := func(deviceId string, token string) int {
+		request := adminRequest(http.MethodPost, "/api/v0/devices/"+deviceId+"/retire", "", token)
+		request.SetPathValue("id", deviceId)
+		response := httptest.NewRecorder()
+		server.RetireSignatureDevice(response, request)
+		return response.Code
+	}
+	assert.ShouldBe(t, retire(deviceId, ""), http.StatusUnauthorized)
+	assert.ShouldBe(t, retire(deviceId, "token"), http.StatusOK)
+	assert.ShouldBe(t, retire(deviceId, "token"), http.StatusConflict)
+	assert.ShouldBe(t, retire("unknown", "token"), http.StatusNotFound)
+
+	request := adminRequest(http.MethodPost, "/api/v0/devices/"+deviceId+"/certificate/issue", "{}", "token")
+	request.SetPathValue("id", deviceId)
+	response := httptest.NewRecorder()
+	server.IssueCertificate(response, request)
+	assert.ShouldBe(t, response.Code, http.StatusConflict)
+
+	otherDeviceId := createTestDevice(t, server, domain.ECC)
+	assert.ShouldBe(t, server.signatures.Shutdown(context.Background()), nil)
+	assert.ShouldBe(t, retire(otherDeviceId, "token"), http.StatusServiceUnavailable)
+}
+
+func TestServer_RevocationList(t *testing.T) {
+	server := newAuthorityTestServer(t)
+	deviceId := createTestDevice(t, server, domain.ECC)
+	request := adminRequest(http.MethodPost, "/api/v0/devices/"+deviceId+"/certificate/issue", "{}", "token")
+	request.SetPathValue("id", deviceId)
+	server.IssueCertificate(httptest.NewRecorder(), request)
+	issued, _ := crypto.ParseCertificateChain(server.storage.GetDevice(deviceId).CertificateChain)
+
+	request = adminRequest(http.MethodPost, "/api/v0/devices/"+deviceId+"/certificate/self-signed", "{}", "token")
+	request.SetPathValue("id", deviceId)
+	server.SelfSignedCertificate(httptest.NewRecorder(), request)
+	request = adminRequest(http.MethodPost, "/api/v0/devices/"+deviceId+"/retire", "", "token")
+	request.SetPathValue("id", deviceId)
+	server.RetireSignatureDevice(httptest.NewRecorder(), request)
+
+	response := httptest.NewRecorder()
+	server.RevocationList(response, httptest.NewRequest(http.MethodGet, "/api/v0/ca/crl", nil))
+	assert.ShouldBe(t, response.Code, http.StatusOK)
+	crl, err := x509.ParseRevocationList(response.Body.Bytes())
+	assert.ShouldBe(t, err, nil)
+	assert.ShouldBe(t, len(crl.RevokedCertificateEntries), 1)
+	assert.ShouldBe(t, crl.RevokedCertificateEntries[0].SerialNumber.Cmp(issued[0].SerialNumber), 0)
+}
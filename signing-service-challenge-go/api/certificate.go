@@ -0,0 +1,168 @@
+package api
+
+import (
+	"errors"
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/crypto"
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/persistence"
+	"net/http"
+	"time"
+)
+
+const (
+	ContentTypeCSR = "application/pkcs10"
+
+	DefaultCertificateValidityDays = 365
+)
+
+type SelfSignedCertificateRequest struct {
+	ValidityDays int `json:"validity_days" schema:"minimum=0"`
+}
+
+type ImportCertificateRequest struct {
+	CertificateChain string `json:"certificate_chain" schema:"required"`
+}
+
+type CertificateResponse struct {
+	DeviceId         string `json:"device_id"`
+	CertificateChain string `json:"certificate_chain"`
+}
+
+// CertificateRequest generates a PKCS#10 certificate signing request for the device key.
+func (s *Server) CertificateRequest(response http.ResponseWriter, request *http.Request) {
+	if request.Method != http.MethodGet {
+		WriteMethodNotAllowed(response, http.MethodGet)
+		return
+	}
+	device := s.pathDevice(response, request)
+	if device == nil {
+		return
+	}
+	if device.IsRetired() {
+		s.writeDeviceStateError(response, request, persistence.ErrDeviceRetired)
+		return
+	}
+
+	csr, err := crypto.CreateCertificateRequest(device)
+	if err != nil {
+		s.internalError(response, request, err)
+		return
+	}
+	WriteRawResponse(response, http.StatusOK, ContentTypeCSR, csr)
+}
+
+// SelfSignedCertificate issues a certificate for the device key that is signed by the device itself
+// and stores it as the certificate chain of the device. It requires the admin token.
+func (s *Server) SelfSignedCertificate(response http.ResponseWriter, request *http.Request) {
+	if !s.authorizeAdmin(response, request) {
+		return
+	}
+	var body SelfSignedCertificateRequest
+	isValidRequest, errs := PostMethodTemplate(request, &body)
+	if !isValidRequest {
+		writeRequestErrors(response, errs)
+		return
+	}
+	if body.ValidityDays == 0 {
+		body.ValidityDays = DefaultCertificateValidityDays
+	}
+	device := s.pathDevice(response, request)
+	if device == nil {
+		return
+	}
+	if device.IsRetired() {
+		s.writeDeviceStateError(response, request, persistence.ErrDeviceRetired)
+		return
+	}
+
+	done, ok := s.beginWrite(response, request)
+	if !ok {
+		return
+	}
+	defer done()
+	certificate, err := crypto.CreateSelfSignedCertificate(device, time.Now(), time.Duration(body.ValidityDays)*24*time.Hour)
+	if err != nil {
+		s.internalError(response, request, err)
+		return
+	}
+	err = s.tracedStorage(request).SetDeviceCertificate(device.Id, certificate)
+	if err != nil {
+		s.writeDeviceStateError(response, request, err)
+		return
+	}
+
+	WriteAPIResponse(response, http.StatusCreated, CertificateResponse{
+		DeviceId:         device.Id,
+		CertificateChain: string(certificate),
+	})
+}
+
+// Certificate returns the certificate chain of a device on GET
+// and imports a CA-issued certificate chain for the device key on POST, which requires the admin token.
+func (s *Server) Certificate(response http.ResponseWriter, request *http.Request) {
+	switch request.Method {
+	case http.MethodGet:
+		device := s.pathDevice(response, request)
+		if device == nil {
+			return
+		}
+		if len(device.CertificateChain) == 0 {
+			WriteErrorResponse(response, http.StatusNotFound, []string{"device has no certificate"})
+			return
+		}
+		WriteRawResponse(response, http.StatusOK, ContentTypePEM, device.CertificateChain)
+	case http.MethodPost:
+		s.importCertificate(response, request)
+	default:
+		WriteMethodNotAllowed(response, http.MethodGet, http.MethodPost)
+	}
+}
+
+func (s *Server) importCertificate(response http.ResponseWriter, request *http.Request) {
+	if !s.authorizeAdmin(response, request) {
+		return
+	}
+	var body ImportCertificateRequest
+	isValidRequest, errs := PostMethodTemplate(request, &body)
+	if !isValidRequest {
+		writeRequestErrors(response, errs)
+		return
+	}
+	device := s.pathDevice(response, request)
+	if device == nil {
+		return
+	}
+	if device.IsRetired() {
+		s.writeDeviceStateError(response, request, persistence.ErrDeviceRetired)
+		return
+	}
+
+	done, ok := s.beginWrite(response, request)
+	if !ok {
+		return
+	}
+	defer done()
+	chain, err := crypto.ParseCertificateChain([]byte(body.CertificateChain))
+	if err != nil {
+		WriteErrorResponse(response, http.StatusBadRequest, []string{err.Error()})
+		return
+	}
+	err = crypto.VerifyCertificateChain(device, chain, time.Now())
+	if errors.Is(err, crypto.ErrCertificateKeyMismatch) {
+		WriteErrorResponse(response, http.StatusConflict, []string{err.Error()})
+		return
+	}
+	if err != nil {
+		WriteErrorResponse(response, http.StatusBadRequest, []string{err.Error()})
+		return
+	}
+	err = s.tracedStorage(request).SetDeviceCertificate(device.Id, []byte(body.CertificateChain))
+	if err != nil {
+		s.writeDeviceStateError(response, request, err)
+		return
+	}
+
+	WriteAPIResponse(response, http.StatusOK, CertificateResponse{
+		DeviceId:         device.Id,
+		CertificateChain: body.CertificateChain,
+	})
+}
@@ -0,0 +1,159 @@
+package api
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/assert"
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/crypto"
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/domain"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func deviceRequest(method string, target string, deviceId string, body string) *http.Request {
+	request := httptest.NewRequest(method, target, strings.NewReader(body))
+	request.SetPathValue("id", deviceId)
+	return request
+}
+
+func adminDeviceRequest(method string, target string, deviceId string, body string) *http.Request {
+	request := adminRequest(method, target, body, "token")
+	request.SetPathValue("id", deviceId)
+	return request
+}
+
+// newTestChain issues a certificate for the key of a CSR by a new CA and returns the PEM encoded chain.
+func newTestChain(csr *x509.CertificateRequest, caTemplate *x509.Certificate, notAfter time.Time) string {
+	caKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	caDER, _ := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	caCertificate, _ := x509.ParseCertificate(caDER)
+	leafDER, _ := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      csr.Subject,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     notAfter,
+	}, caCertificate, csr.PublicKey, caKey)
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: leafDER})) +
+		string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER}))
+}
+
+func TestServer_CertificateRequest(t *testing.T) {
+	server := newTestServer()
+	deviceId := createTestDevice(t, server, domain.ECC)
+	response := httptest.NewRecorder()
+	server.CertificateRequest(response, deviceRequest(http.MethodGet, "/api/v0/devices/"+deviceId+"/csr", deviceId, ""))
+	assert.ShouldBe(t, response.Code, http.StatusOK)
+
+	block, _ := pem.Decode(response.Body.Bytes())
+	csr, err := x509.ParseCertificateRequest(block.Bytes)
+	assert.ShouldBe(t, err, nil)
+	assert.ShouldBe(t, csr.CheckSignature(), nil)
+	assert.ShouldBe(t, csr.Subject.CommonName, deviceId)
+}
+
+func TestServer_SelfSignedCertificate(t *testing.T) {
+	server := newTestServer()
+	WithAdminToken("token")(server)
+	deviceId := createTestDevice(t, server, domain.RSA)
+	response := httptest.NewRecorder()
+	server.SelfSignedCertificate(response, deviceRequest(http.MethodPost, "/api/v0/devices/"+deviceId+"/certificate/self-signed", deviceId, `{}`))
+	assert.ShouldBe(t, response.Code, http.StatusUnauthorized)
+
+	response = httptest.NewRecorder()
+	server.SelfSignedCertificate(response, adminDeviceRequest(http.MethodPost, "/api/v0/devices/"+deviceId+"/certificate/self-signed", deviceId, `{"validity_days": 30}`))
+	assert.ShouldBe(t, response.Code, http.StatusCreated)
+
+	chain := server.storage.GetDevice(deviceId).CertificateChain
+	block, _ := pem.Decode(chain)
+	certificate, err := x509.ParseCertificate(block.Bytes)
+	assert.ShouldBe(t, err, nil)
+	assert.ShouldBe(t, certificate.CheckSignature(certificate.SignatureAlgorithm, certificate.RawTBSCertificate, certificate.Signature), nil)
+	assert.ShouldBe(t, certificate.NotAfter.Sub(certificate.NotBefore) > 29*24*time.Hour, true)
+
+	response = httptest.NewRecorder()
+	server.Certificate(response, deviceRequest(http.MethodGet, "/api/v0/devices/"+deviceId+"/certificate", deviceId, ""))
+	assert.ShouldBe(t, response.Body.String(), string(chain))
+}
+
+func TestServer_ImportCertificateChain(t *testing.T) {
+	server := newTestServer()
+	WithAdminToken("token")(server)
+	deviceId := createTestDevice(t, server, domain.ECC)
+	response := httptest.NewRecorder()
+	server.CertificateRequest(response, deviceRequest(http.MethodGet, "/api/v0/devices/"+deviceId+"/csr", deviceId, ""))
+	block, _ := pem.Decode(response.Body.Bytes())
+	csr, _ := x509.ParseCertificateRequest(block.Bytes)
+
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	chain := newTestChain(csr, caTemplate, time.Now().Add(time.Hour))
+	body, _ := json.Marshal(ImportCertificateRequest{CertificateChain: chain})
+	target := "/api/v0/devices/" + deviceId + "/certificate"
+	response = httptest.NewRecorder()
+	server.Certificate(response, deviceRequest(http.MethodPost, target, deviceId, string(body)))
+	assert.ShouldBe(t, response.Code, http.StatusUnauthorized)
+
+	response = httptest.NewRecorder()
+	server.Certificate(response, adminDeviceRequest(http.MethodPost, target, deviceId, string(body)))
+	assert.ShouldBe(t, response.Code, http.StatusOK)
+	assert.ShouldBe(t, string(server.storage.GetDevice(deviceId).CertificateChain), chain)
+
+	otherDeviceId := createTestDevice(t, server, domain.ECC)
+	response = httptest.NewRecorder()
+	server.Certificate(response, adminDeviceRequest(http.MethodPost, "/api/v0/devices/"+otherDeviceId+"/certificate", otherDeviceId, string(body)))
+	assert.ShouldBe(t, response.Code, http.StatusConflict)
+}
+
+func TestServer_ImportCertificateChainValidity(t *testing.T) {
+	server := newTestServer()
+	WithAdminToken("token")(server)
+	deviceId := createTestDevice(t, server, domain.ECC)
+	csr := &x509.CertificateRequest{Subject: pkix.Name{CommonName: deviceId}}
+	device := server.storage.GetDevice(deviceId)
+	csr.PublicKey, _ = crypto.ParsePublicKey(device.Algorithm, device.PublicKey)
+	caTemplate := func(isCA bool) *x509.Certificate {
+		return &x509.Certificate{
+			SerialNumber:          big.NewInt(1),
+			Subject:               pkix.Name{CommonName: "Test CA"},
+			NotBefore:             time.Now().Add(-time.Hour),
+			NotAfter:              time.Now().Add(time.Hour),
+			IsCA:                  isCA,
+			BasicConstraintsValid: true,
+			KeyUsage:              x509.KeyUsageCertSign,
+		}
+	}
+	importChain := func(chain string) int {
+		body, _ := json.Marshal(ImportCertificateRequest{CertificateChain: chain})
+		response := httptest.NewRecorder()
+		server.Certificate(response, adminDeviceRequest(http.MethodPost, "/api/v0/devices/"+deviceId+"/certificate", deviceId, string(body)))
+		return response.Code
+	}
+	assert.ShouldBe(t, importChain(newTestChain(csr, caTemplate(true), time.Now().Add(-time.Minute))), http.StatusBadRequest)
+	assert.ShouldBe(t, importChain(newTestChain(csr, caTemplate(false), time.Now().Add(time.Hour))), http.StatusBadRequest)
+	assert.ShouldBe(t, len(server.storage.GetDevice(deviceId).CertificateChain), 0)
+
+	server.storage.RetireDevice(deviceId, time.Now())
+	assert.ShouldBe(t, importChain(newTestChain(csr, caTemplate(true), time.Now().Add(time.Hour))), http.StatusConflict)
+	response := httptest.NewRecorder()
+	server.SelfSignedCertificate(response, adminDeviceRequest(http.MethodPost, "/api/v0/devices/"+deviceId+"/certificate/self-signed", deviceId, `{}`))
+	assert.ShouldBe(t, response.Code, http.StatusConflict)
+	response = httptest.NewRecorder()
+	server.CertificateRequest(response, deviceRequest(http.MethodGet, "/api/v0/devices/"+deviceId+"/csr", deviceId, ""))
+	assert.ShouldBe(t, response.Code, http.StatusConflict)
+}
@@ -1,11 +1,18 @@
 package api
 
 import (
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"github.com/DrMonez/coding-challenges/signing-service-challenge/crypto"
 	"github.com/DrMonez/coding-challenges/signing-service-challenge/domain"
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/signing"
+	"io"
 	"net/http"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
 )
 
 type CreateSignatureDeviceResponse struct {
@@ -14,44 +21,164 @@ type CreateSignatureDeviceResponse struct {
 }
 
 type CreateSignatureDeviceRequest struct {
-	Id        string                     `json:"id"`
-	Algorithm domain.CryptoAlgorithmType `json:"algorithm"`
-	Label     string                     `json:"label"`
+	Id        string                     `json:"id" schema:"format=uuid"`
+	Algorithm domain.CryptoAlgorithmType `json:"algorithm" schema:"required,enum=ECC|RSA"`
+	Label     string                     `json:"label" schema:"maxLength=256,pattern=^[\\p{L}\\p{N} ._:/()#-]*$"`
 }
 
 type SignTransactionResponse struct {
-	Signature  string `json:"signature"`
-	SignedData string `json:"signed_data"`
+	SignatureCounter int    `json:"signature_counter"`
+	Signature        string `json:"signature"`
+	SignedData       string `json:"signed_data"`
+	// Timestamp is the server time the signature counter has been allocated at.
+	Timestamp time.Time `json:"timestamp"`
+	// Envelope is the compact JWS or the base64 encoded COSE_Sign1 message, both with a detached payload.
+	Envelope string `json:"envelope,omitempty"`
 }
 
+type SignBatchRequest struct {
+	Data   []string               `json:"data" schema:"required,minItems=1,maxItems=1000,items.minLength=1,items.maxLength=65536"`
+	Format domain.SignatureFormat `json:"format" schema:"enum=raw|jws|cose_sign1"`
+}
+
+type SignBatchResponse struct {
+	Signatures []SignTransactionResponse `json:"signatures"`
+}
+
+// MaxBatchSize limits the number of data items signed by a single batch request,
+// it is enforced by the schema of SignBatchRequest.
+const MaxBatchSize = 1000
+
 type SignTransactionRequest struct {
-	DeviceId string `json:"device_id"`
-	Data     string `json:"data"`
+	DeviceId string                 `json:"device_id" schema:"required,format=uuid"`
+	Data     string                 `json:"data" schema:"required,maxLength=65536"`
+	Format   domain.SignatureFormat `json:"format" schema:"enum=raw|jws|cose_sign1"`
 }
 
-func PostMethodTemplate[T any](request *http.Request, body *T) (isValid bool, errors []string) {
+type DeviceResponse struct {
+	Id               string                     `json:"id"`
+	Algorithm        domain.CryptoAlgorithmType `json:"algorithm"`
+	Label            string                     `json:"label"`
+	SignatureCounter int                        `json:"signature_counter"`
+	RetiredAt        *time.Time                 `json:"retired_at,omitempty"`
+}
+
+type ListSignatureDevicesResponse struct {
+	Devices []DeviceResponse `json:"devices"`
+}
+
+type VerifySignatureRequest struct {
+	DeviceId   string                 `json:"device_id" schema:"required,format=uuid"`
+	SignedData string                 `json:"signed_data" schema:"required"`
+	Signature  string                 `json:"signature" schema:"required"`
+	Format     domain.SignatureFormat `json:"format" schema:"enum=raw|jws|cose_sign1"`
+}
+
+type VerifySignatureResponse struct {
+	Valid bool `json:"valid"`
+}
+
+// PostMethodTemplate decodes the JSON body of a POST request after validating it
+// against the schema of T, violations are reported per field.
+func PostMethodTemplate[T any](request *http.Request, body *T) (isValid bool, details []ErrorDetail) {
 	if request.Method != http.MethodPost {
-		return false, []string{http.StatusText(http.StatusMethodNotAllowed)}
+		return false, []ErrorDetail{{
+			Code:    CodeMethodNotAllowed,
+			Message: http.StatusText(http.StatusMethodNotAllowed),
+		}}
+	}
+	bytes, err := io.ReadAll(request.Body)
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return false, []ErrorDetail{{
+			Code:    CodeBodyTooLarge,
+			Message: fmt.Sprintf("request body must not be larger than %d bytes", tooLarge.Limit),
+		}}
+	}
+	if err != nil {
+		return false, []ErrorDetail{invalidJSON}
+	}
+	details = DecodeValid(bytes, body)
+	return len(details) == 0, details
+}
+
+var invalidJSON = ErrorDetail{
+	Code:    CodeInvalidJSON,
+	Message: "request body is not valid JSON",
+}
+
+// invalidSchema reports a request type whose schema tags do not build, which
+// compileRequestSchemas rules out for the types of the routes.
+var invalidSchema = ErrorDetail{
+	Code:    StatusCode(http.StatusInternalServerError),
+	Message: "request schema is invalid",
+}
+
+// DecodeValid decodes JSON into body if it satisfies the schema of T
+// and returns the violations otherwise.
+func DecodeValid[T any](bytes []byte, body *T) []ErrorDetail {
+	var value interface{}
+	err := json.Unmarshal(bytes, &value)
+	if err != nil {
+		return []ErrorDetail{invalidJSON}
 	}
-	decoder := json.NewDecoder(request.Body)
-	err := decoder.Decode(&body)
+	compiled, err := requestSchemas.schemaOf(reflect.TypeOf(body))
 	if err != nil {
-		return false, []string{http.StatusText(http.StatusBadRequest)}
+		return []ErrorDetail{invalidSchema}
 	}
-	return true, nil
+	errors := compiled.schemas.Validate(compiled.schema, value, "")
+	if len(errors) > 0 {
+		return errors
+	}
+	err = json.Unmarshal(bytes, body)
+	if err != nil {
+		return []ErrorDetail{invalidJSON}
+	}
+	return nil
+}
+
+// writeRequestErrors writes the errors of PostMethodTemplate as an HTTP error response.
+func writeRequestErrors(response http.ResponseWriter, errors []ErrorDetail) {
+	if len(errors) == 1 && errors[0].Code == CodeMethodNotAllowed {
+		WriteMethodNotAllowed(response, http.MethodPost)
+		return
+	}
+	problem := ErrorResponse{
+		Status: http.StatusBadRequest,
+		Detail: problemDetail(errors),
+		Errors: errors,
+	}
+	if errors[0] == invalidSchema {
+		problem.Status = http.StatusInternalServerError
+	} else if errors[0].Code == CodeBodyTooLarge {
+		problem.Status = http.StatusRequestEntityTooLarge
+	} else if errors[0].Code != CodeInvalidJSON {
+		problem.Type = ProblemTypeInvalidRequest
+		problem.Title = "Invalid request"
+	}
+	WriteProblem(response, problem)
 }
 
 func (s *Server) CreateSignatureDevice(response http.ResponseWriter, request *http.Request) {
 	var body CreateSignatureDeviceRequest
-	isValidRequest, errors := PostMethodTemplate(request, &body)
+	isValidRequest, errs := PostMethodTemplate(request, &body)
 	if !isValidRequest {
-		WriteErrorResponse(response, http.StatusBadRequest, errors)
+		writeRequestErrors(response, errs)
 		return
 	}
-	deviceId, label := s.storage.CreateSignatureDevice(body.Id, body.Algorithm, body.Label)
+	device, err := s.signatures.CreateDevice(request.Context(), body.Id, body.Algorithm, body.Label)
+	if errors.Is(err, signing.ErrShuttingDown) {
+		s.writeSigningError(response, request, err)
+		return
+	}
+	if err != nil {
+		WriteErrorResponse(response, http.StatusBadRequest, []string{err.Error()})
+		return
+	}
+	LogDeviceId(request.Context(), device.Id)
 	createSignatureDeviceResponse := CreateSignatureDeviceResponse{
-		DeviceId: deviceId,
-		Label:    label,
+		DeviceId: device.Id,
+		Label:    device.Label,
 	}
 
 	WriteAPIResponse(response, http.StatusOK, createSignatureDeviceResponse)
@@ -61,65 +188,208 @@ func (s *Server) SignTransaction(response http.ResponseWriter, request *http.Req
 	var body SignTransactionRequest
 	isValidRequest, errors := PostMethodTemplate(request, &body)
 	if !isValidRequest {
-		WriteErrorResponse(response, http.StatusBadRequest, errors)
+		writeRequestErrors(response, errors)
 		return
 	}
-
-	device := s.storage.GetDevice(body.DeviceId)
-	if device == nil {
-		WriteInternalError(response)
+	if !s.limitDevice(response, request, body.DeviceId) {
 		return
 	}
 
-	lastSignature, err := s.storage.GetLastDeviceSignature(body.DeviceId)
-	if err != nil && device.SignatureCounter != 0 {
-		WriteInternalError(response)
+	LogDeviceId(request.Context(), body.DeviceId)
+	signature, err := s.signatures.SignTransaction(request.Context(), body.DeviceId, body.Data, body.Format)
+	if err != nil && signature != nil {
+		// The signature is committed without a timestamp token, the chain continues after it.
+		s.writePartialSigningError(response, request, err, "", newSignTransactionResponse(signature, body.Format))
 		return
 	}
-
-	var lastSignedData = []byte(body.DeviceId)
-	if lastSignature != nil && lastSignature.SignedData != nil {
-		lastSignedData = lastSignature.SignedData
+	if err != nil {
+		s.writeSigningError(response, request, err)
+		return
 	}
 
-	signer, err := s.GetSigner(device)
-	if err != nil {
-		WriteInternalError(response)
+	WriteAPIResponse(response, http.StatusOK, newSignTransactionResponse(signature, body.Format))
+}
+
+// SignBatch signs an ordered list of data items with consecutive signature counters.
+func (s *Server) SignBatch(response http.ResponseWriter, request *http.Request) {
+	var body SignBatchRequest
+	isValidRequest, errs := PostMethodTemplate(request, &body)
+	if !isValidRequest {
+		writeRequestErrors(response, errs)
 		return
 	}
 
-	signedData, err := signer.Sign([]byte(body.Data))
+	signatures, err := s.signatures.SignBatch(request.Context(), request.PathValue("id"), body.Data, body.Format)
+	signBatchResponse := SignBatchResponse{
+		Signatures: make([]SignTransactionResponse, 0, len(signatures)),
+	}
+	for _, signature := range signatures {
+		signBatchResponse.Signatures = append(signBatchResponse.Signatures, newSignTransactionResponse(signature, body.Format))
+	}
+	if err != nil && len(signatures) > 0 {
+		// The signatures are committed, the client must learn about them to continue the chain.
+		s.writePartialSigningError(response, request, err, fmt.Sprintf("data[%d]", len(signatures)), signBatchResponse)
+		return
+	}
 	if err != nil {
-		WriteInternalError(response)
+		s.writeSigningError(response, request, err)
 		return
 	}
-	signatureCounter := s.storage.GetDeviceSignaturesCount(body.DeviceId)
+	WriteAPIResponse(response, http.StatusOK, signBatchResponse)
+}
 
+func newSignTransactionResponse(signature *signing.Signature, format domain.SignatureFormat) SignTransactionResponse {
 	signTransactionResponse := SignTransactionResponse{
-		Signature:  string(signedData),
-		SignedData: fmt.Sprintf("%d_%s_%s", signatureCounter, body.Data, string(lastSignedData)),
-	}
-
-	WriteAPIResponse(response, http.StatusOK, signTransactionResponse)
-}
-
-func (s *Server) GetSigner(device *domain.Device) (crypto.Signer, error) {
-	switch device.Algorithm {
-	case domain.RSA:
-		return &crypto.RSASigner{
-			Storage:      s.storage,
-			RsaMarshaler: crypto.NewRSAMarshaler(),
-			RsaGenerator: crypto.RSAGenerator{},
-			Device:       device,
-		}, nil
-	case domain.ECC:
-		return crypto.ECCSigner{
-			Storage:      s.storage,
-			EccMarshaler: crypto.NewECCMarshaler(),
-			EccGenerator: crypto.ECCGenerator{},
-			Device:       device,
-		}, nil
+		SignatureCounter: signature.Counter,
+		Signature:        base64.StdEncoding.EncodeToString(signature.Signature),
+		SignedData:       signature.SignedData,
+		Timestamp:        signature.Timestamp,
+	}
+	switch format {
+	case domain.FormatJWS:
+		signTransactionResponse.Envelope = string(signature.Envelope)
+	case domain.FormatCOSESign1:
+		signTransactionResponse.Envelope = base64.StdEncoding.EncodeToString(signature.Envelope)
+	}
+	return signTransactionResponse
+}
+
+// beginWrite registers a request that writes to the storage outside the signing service,
+// so that shutting down the signing service waits for it. It fails once the service is shutting down.
+func (s *Server) beginWrite(response http.ResponseWriter, request *http.Request) (func(), bool) {
+	done, err := s.signatures.Begin()
+	if err != nil {
+		s.writeSigningError(response, request, err)
+		return nil, false
+	}
+	return done, true
+}
+
+// writeSigningError maps errors of the signing service to HTTP error responses.
+func (s *Server) writeSigningError(response http.ResponseWriter, request *http.Request, err error) {
+	s.writePartialSigningError(response, request, err, "", nil)
+}
+
+// writePartialSigningError writes the error response of a request that failed after the
+// results in data have been committed. The field locates the failing item in the request.
+func (s *Server) writePartialSigningError(response http.ResponseWriter, request *http.Request, err error, field string, data interface{}) {
+	problem := ErrorResponse{Data: data}
+	if data != nil {
+		problem.Type = ProblemTypePartialFailure
+	}
+	message := err.Error()
+	switch {
+	case errors.Is(err, signing.ErrDeviceNotFound), errors.Is(err, signing.ErrTransactionNotFound):
+		problem.Status = http.StatusNotFound
+	case errors.Is(err, signing.ErrDeviceRetired), errors.Is(err, signing.ErrTransactionFinished):
+		problem.Status = http.StatusConflict
+	case errors.Is(err, signing.ErrUnsupportedFormat):
+		problem.Status = http.StatusBadRequest
+	case errors.Is(err, signing.ErrShuttingDown):
+		response.Header().Set("Retry-After", "1")
+		problem.Status = http.StatusServiceUnavailable
+	case errors.Is(err, signing.ErrTimestampFailed):
+		s.logger.ErrorContext(request.Context(), "could not timestamp signature", "error", err)
+		problem.Status = http.StatusBadGateway
+		message = signing.ErrTimestampFailed.Error()
+	case data == nil:
+		s.internalError(response, request, err)
+		return
 	default:
-		return nil, fmt.Errorf("algorithm is not implemented")
+		s.logRequestError(request, err)
+		problem.Status = http.StatusInternalServerError
+		message = http.StatusText(http.StatusInternalServerError)
+	}
+	problem.Errors = []ErrorDetail{{
+		Code:    StatusCode(problem.Status),
+		Field:   field,
+		Message: message,
+	}}
+	problem.Detail = problemDetail(problem.Errors)
+	WriteProblem(response, problem)
+}
+
+// pathDevice looks up the device addressed by the id path parameter
+// and writes a not found response if it does not exist.
+func (s *Server) pathDevice(response http.ResponseWriter, request *http.Request) *domain.Device {
+	device := s.tracedStorage(request).GetDevice(request.PathValue("id"))
+	if device == nil {
+		WriteErrorResponse(response, http.StatusNotFound, []string{"device not found"})
+	}
+	return device
+}
+
+// ListSignatureDevices lists all devices ordered by id.
+func (s *Server) ListSignatureDevices(response http.ResponseWriter, request *http.Request) {
+	if request.Method != http.MethodGet {
+		WriteMethodNotAllowed(response, http.MethodGet)
+		return
+	}
+	devices := s.tracedStorage(request).ListDevices()
+	slices.SortFunc(devices, func(a, b *domain.Device) int {
+		return strings.Compare(a.Id, b.Id)
+	})
+	listResponse := ListSignatureDevicesResponse{
+		Devices: make([]DeviceResponse, 0, len(devices)),
+	}
+	for _, device := range devices {
+		listResponse.Devices = append(listResponse.Devices, s.newDeviceResponse(request, device))
+	}
+	WriteAPIResponse(response, http.StatusOK, listResponse)
+}
+
+// SignatureDevice returns a device.
+func (s *Server) SignatureDevice(response http.ResponseWriter, request *http.Request) {
+	if request.Method != http.MethodGet {
+		WriteMethodNotAllowed(response, http.MethodGet)
+		return
+	}
+	device := s.pathDevice(response, request)
+	if device == nil {
+		return
+	}
+	WriteAPIResponse(response, http.StatusOK, s.newDeviceResponse(request, device))
+}
+
+func (s *Server) newDeviceResponse(request *http.Request, device *domain.Device) DeviceResponse {
+	deviceResponse := DeviceResponse{
+		Id:               device.Id,
+		Algorithm:        device.Algorithm,
+		Label:            device.Label,
+		SignatureCounter: s.tracedStorage(request).GetDeviceSignaturesCount(device.Id),
+	}
+	if device.IsRetired() {
+		deviceResponse.RetiredAt = &device.RetiredAt
+	}
+	return deviceResponse
+}
+
+// VerifySignature checks a signature of a device over signed data.
+func (s *Server) VerifySignature(response http.ResponseWriter, request *http.Request) {
+	var body VerifySignatureRequest
+	isValidRequest, errs := PostMethodTemplate(request, &body)
+	if !isValidRequest {
+		writeRequestErrors(response, errs)
+		return
+	}
+	signature, err := base64.StdEncoding.DecodeString(body.Signature)
+	if err != nil {
+		WriteErrorDetails(response, http.StatusBadRequest, []ErrorDetail{{
+			Code:    CodeInvalidFormat,
+			Field:   "signature",
+			Message: "must be base64 encoded",
+		}})
+		return
+	}
+	LogDeviceId(request.Context(), body.DeviceId)
+	isValid, err := s.signatures.Verify(request.Context(), body.DeviceId, body.SignedData, signature, body.Format)
+	if errors.Is(err, signing.ErrInvalidSignedData) {
+		WriteErrorResponse(response, http.StatusBadRequest, []string{err.Error()})
+		return
+	}
+	if err != nil {
+		s.writeSigningError(response, request, err)
+		return
 	}
+	WriteAPIResponse(response, http.StatusOK, VerifySignatureResponse{Valid: isValid})
 }
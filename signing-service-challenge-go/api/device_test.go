@@ -1,16 +1,23 @@
 package api
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
 	"github.com/DrMonez/coding-challenges/signing-service-challenge/assert"
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/domain"
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/persistence"
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/signing"
 	"io"
 	"net/http"
+	"net/http/httptest"
 	"strings"
 	"testing"
 )
 
 func TestPostMethodTemplateWithOptionalParameter(t *testing.T) {
 	var body CreateSignatureDeviceRequest
-	json := `{ "id": "123456", "algorithm":"RSA", "label":"some label" }`
+	json := `{ "id": "4cc2a6b4-3f45-4b1e-9a51-7e1b6b2d7c10", "algorithm":"RSA", "label":"some label" }`
 	request := http.Request{
 		Method: http.MethodPost,
 		Body:   io.NopCloser(strings.NewReader(json)),
@@ -18,12 +25,12 @@ func TestPostMethodTemplateWithOptionalParameter(t *testing.T) {
 	isValid, errors := PostMethodTemplate(&request, &body)
 	assert.ShouldBe(t, isValid, true)
 	assert.ShouldBe(t, len(errors), 0)
-	assert.ShouldBe(t, body, CreateSignatureDeviceRequest{Id: "123456", Algorithm: "RSA", Label: "some label"})
+	assert.ShouldBe(t, body, CreateSignatureDeviceRequest{Id: "4cc2a6b4-3f45-4b1e-9a51-7e1b6b2d7c10", Algorithm: "RSA", Label: "some label"})
 }
 
 func TestPostMethodTemplateWithoutOptionalParameter(t *testing.T) {
 	var body CreateSignatureDeviceRequest
-	json := `{ "id": "123456", "algorithm":"RSA" }`
+	json := `{ "id": "4cc2a6b4-3f45-4b1e-9a51-7e1b6b2d7c10", "algorithm":"RSA" }`
 	request := http.Request{
 		Method: http.MethodPost,
 		Body:   io.NopCloser(strings.NewReader(json)),
@@ -31,5 +38,157 @@ func TestPostMethodTemplateWithoutOptionalParameter(t *testing.T) {
 	isValid, errors := PostMethodTemplate(&request, &body)
 	assert.ShouldBe(t, isValid, true)
 	assert.ShouldBe(t, len(errors), 0)
-	assert.ShouldBe(t, body, CreateSignatureDeviceRequest{Id: "123456", Algorithm: "RSA", Label: ""})
+	assert.ShouldBe(t, body, CreateSignatureDeviceRequest{Id: "4cc2a6b4-3f45-4b1e-9a51-7e1b6b2d7c10", Algorithm: "RSA", Label: ""})
+}
+
+func TestServer_SignBatch(t *testing.T) {
+	server := newTestServer()
+	deviceId := createTestDevice(t, server, domain.ECC)
+	request := httptest.NewRequest(http.MethodPost, "/api/v0/devices/"+deviceId+"/sign-batch", strings.NewReader(`{"data": ["a", "b", "c"]}`))
+	request.SetPathValue("id", deviceId)
+	response := httptest.NewRecorder()
+	server.SignBatch(response, request)
+	assert.ShouldBe(t, response.Code, http.StatusOK)
+
+	var body struct {
+		Data SignBatchResponse `json:"data"`
+	}
+	json.Unmarshal(response.Body.Bytes(), &body)
+	assert.ShouldBe(t, len(body.Data.Signatures), 3)
+	for i, signature := range body.Data.Signatures {
+		assert.ShouldBe(t, signature.SignatureCounter, i)
+		assert.ShouldBe(t, strings.HasPrefix(signature.SignedData, fmt.Sprintf("%d_", i)), true)
+	}
+	assert.ShouldBe(t, body.Data.Signatures[2].SignedData, "2_c_"+body.Data.Signatures[1].Signature)
+}
+
+// failingStorage fails to store signatures once it has stored the given number of them.
+type failingStorage struct {
+	persistence.Storage
+	remaining int
+}
+
+func (s *failingStorage) AddSignatureRecord(deviceId string, signature domain.Signature) (*domain.Signature, error) {
+	if s.remaining == 0 {
+		return nil, errors.New("disk full")
+	}
+	s.remaining--
+	return s.Storage.AddSignatureRecord(deviceId, signature)
+}
+
+func TestServer_SignBatchPartialFailure(t *testing.T) {
+	server := newTestServer()
+	deviceId := createTestDevice(t, server, domain.ECC)
+	server.signatures = signing.NewService(&failingStorage{Storage: server.storage, remaining: 2})
+	request := httptest.NewRequest(http.MethodPost, "/api/v0/devices/"+deviceId+"/sign-batch", strings.NewReader(`{"data": ["a", "b", "c"]}`))
+	request.SetPathValue("id", deviceId)
+	response := httptest.NewRecorder()
+	server.SignBatch(response, request)
+	assert.ShouldBe(t, response.Code, http.StatusInternalServerError)
+	assert.ShouldBe(t, response.Header().Get("Content-Type"), ContentTypeProblem)
+
+	var body struct {
+		ErrorResponse
+		Data SignBatchResponse `json:"data"`
+	}
+	json.Unmarshal(response.Body.Bytes(), &body)
+	assert.ShouldBe(t, body.Type, ProblemTypePartialFailure)
+	assert.ShouldBe(t, len(body.Errors), 1)
+	assert.ShouldBe(t, body.Errors[0].Field, "data[2]")
+	assert.ShouldBe(t, len(body.Data.Signatures), 2)
+	assert.ShouldBe(t, body.Data.Signatures[1].SignatureCounter, 1)
+	assert.ShouldBe(t, server.storage.GetDeviceSignaturesCount(deviceId), 2)
+}
+
+func TestServer_SignBatchRejectsEmptyBatch(t *testing.T) {
+	server := newTestServer()
+	deviceId := createTestDevice(t, server, domain.ECC)
+	request := httptest.NewRequest(http.MethodPost, "/api/v0/devices/"+deviceId+"/sign-batch", strings.NewReader(`{"data": []}`))
+	request.SetPathValue("id", deviceId)
+	response := httptest.NewRecorder()
+	server.SignBatch(response, request)
+	assert.ShouldBe(t, response.Code, http.StatusBadRequest)
+	assert.ShouldBe(t, server.storage.GetDeviceSignaturesCount(deviceId), 0)
+}
+
+func TestPostMethodTemplateRejectsWrongMethod(t *testing.T) {
+	server := newTestServer()
+	response := httptest.NewRecorder()
+	server.SignTransaction(response, httptest.NewRequest(http.MethodGet, "/api/v0/sign-transaction", nil))
+	assert.ShouldBe(t, response.Code, http.StatusMethodNotAllowed)
+	assert.ShouldBe(t, response.Header().Get("Allow"), http.MethodPost)
+
+	var body ErrorResponse
+	json.Unmarshal(response.Body.Bytes(), &body)
+	assert.ShouldBe(t, body.Errors[0].Code, CodeMethodNotAllowed)
+}
+
+func TestServer_CreateSignatureDeviceValidation(t *testing.T) {
+	server := newTestServer()
+	response := httptest.NewRecorder()
+	server.CreateSignatureDevice(response, httptest.NewRequest(http.MethodPost, "/api/v0/create-signature-device",
+		strings.NewReader(`{"id": "123456", "algorithm": "ECC", "label": "<script>"}`)))
+	assert.ShouldBe(t, response.Code, http.StatusBadRequest)
+
+	var body ErrorResponse
+	json.Unmarshal(response.Body.Bytes(), &body)
+	assert.ShouldBe(t, len(body.Errors), 2)
+	assert.ShouldBe(t, body.Errors[0], ErrorDetail{Code: CodeInvalidFormat, Field: "id", Message: "must be a UUID"})
+	assert.ShouldBe(t, body.Errors[1].Code, CodeInvalidFormat)
+	assert.ShouldBe(t, body.Errors[1].Field, "label")
+
+	response = httptest.NewRecorder()
+	server.CreateSignatureDevice(response, httptest.NewRequest(http.MethodPost, "/api/v0/create-signature-device",
+		strings.NewReader(`{"algorithm": "ECC", "label": "Kasse 1 (Filiale #2)"}`)))
+	assert.ShouldBe(t, response.Code, http.StatusOK)
+}
+
+func TestServer_SignTransactionValidation(t *testing.T) {
+	server := newTestServer()
+	response := httptest.NewRecorder()
+	server.SignTransaction(response, httptest.NewRequest(http.MethodPost, "/api/v0/sign-transaction",
+		strings.NewReader(`{"device_id": "not-a-uuid", "data": ""}`)))
+	assert.ShouldBe(t, response.Code, http.StatusBadRequest)
+
+	var body ErrorResponse
+	json.Unmarshal(response.Body.Bytes(), &body)
+	assert.ShouldBe(t, len(body.Errors), 2)
+	assert.ShouldBe(t, body.Errors[0], ErrorDetail{Code: CodeRequired, Field: "data", Message: "is required"})
+	assert.ShouldBe(t, body.Errors[1], ErrorDetail{Code: CodeInvalidFormat, Field: "device_id", Message: "must be a UUID"})
+}
+
+func TestServer_VerifySignature(t *testing.T) {
+	server := newTestServer()
+	deviceId := createTestDevice(t, server, domain.ECC)
+	var signature SignTransactionResponse
+	serveJSON(t, server, http.MethodPost, "/api/v0/sign-transaction", `{"device_id": "`+deviceId+`", "data": "a"}`, &signature)
+
+	var verification VerifySignatureResponse
+	code := serveJSON(t, server, http.MethodPost, "/api/v0/verify-signature",
+		`{"device_id": "`+deviceId+`", "signed_data": "`+signature.SignedData+`", "signature": "`+signature.Signature+`"}`, &verification)
+	assert.ShouldBe(t, code, http.StatusOK)
+	assert.ShouldBe(t, verification.Valid, true)
+
+	serveJSON(t, server, http.MethodPost, "/api/v0/verify-signature",
+		`{"device_id": "`+deviceId+`", "signed_data": "0_b", "signature": "`+signature.Signature+`"}`, &verification)
+	assert.ShouldBe(t, verification.Valid, false)
+
+	code = serveJSON(t, server, http.MethodPost, "/api/v0/verify-signature",
+		`{"device_id": "`+deviceId+`", "signed_data": "0_b", "signature": "%%"}`, &verification)
+	assert.ShouldBe(t, code, http.StatusBadRequest)
+}
+
+func TestServer_SignatureDevices(t *testing.T) {
+	server := newTestServer()
+	deviceId := createTestDevice(t, server, domain.ECC)
+
+	var devices ListSignatureDevicesResponse
+	assert.ShouldBe(t, serveJSON(t, server, http.MethodGet, "/api/v0/devices", "", &devices), http.StatusOK)
+	assert.ShouldBe(t, len(devices.Devices), 1)
+	assert.ShouldBe(t, devices.Devices[0].Id, deviceId)
+
+	var device DeviceResponse
+	assert.ShouldBe(t, serveJSON(t, server, http.MethodGet, "/api/v0/devices/"+deviceId, "", &device), http.StatusOK)
+	assert.ShouldBe(t, device.Algorithm, domain.CryptoAlgorithmType(domain.ECC))
+	assert.ShouldBe(t, device.RetiredAt == nil, true)
 }
@@ -0,0 +1,95 @@
+package api
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/domain"
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/export"
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/signing"
+	"io"
+	"net/http"
+	"time"
+)
+
+const ContentTypeTar = "application/x-tar"
+
+// ExportArchive packages the signatures of a device into a tar archive with a manifest signed
+// by the service key. The from_counter and to_counter query parameters select an inclusive
+// counter range, from and to a half-open range of RFC 3339 times.
+func (s *Server) ExportArchive(response http.ResponseWriter, request *http.Request) {
+	if request.Method != http.MethodGet {
+		WriteMethodNotAllowed(response, http.MethodGet)
+		return
+	}
+	if s.exportKey == nil {
+		WriteErrorResponse(response, http.StatusNotFound, []string{"export is not configured"})
+		return
+	}
+	exportRange, details := exportRange(request)
+	if len(details) > 0 {
+		WriteErrorDetails(response, http.StatusBadRequest, details)
+		return
+	}
+	device := s.pathDevice(response, request)
+	if device == nil {
+		return
+	}
+	LogDeviceId(request.Context(), device.Id)
+
+	var archive bytes.Buffer
+	if err := export.Write(&archive, s.exportKey, device, s.exportSignatures(request, device, exportRange), exportRange); err != nil {
+		s.internalError(response, request, err)
+		return
+	}
+	response.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="export-%s.tar"`, device.Id))
+	WriteRawResponse(response, http.StatusOK, ContentTypeTar, archive.Bytes())
+}
+
+// exportRange parses the range query parameters of an export.
+func exportRange(request *http.Request) (export.Range, []ErrorDetail) {
+	var details []ErrorDetail
+	fromCounter, errs := queryInt(request, "from_counter", 0, 0, -1)
+	details = append(details, errs...)
+	toCounter, errs := queryInt(request, "to_counter", -1, 0, -1)
+	details = append(details, errs...)
+	exportRange := export.Range{FromCounter: fromCounter, ToCounter: toCounter}
+	for _, bound := range []struct {
+		name  string
+		value **time.Time
+	}{{"from", &exportRange.From}, {"to", &exportRange.To}} {
+		text := request.URL.Query().Get(bound.name)
+		if text == "" {
+			continue
+		}
+		value, err := time.Parse(time.RFC3339, text)
+		if err != nil {
+			details = append(details, ErrorDetail{Code: CodeInvalidFormat, Field: bound.name, Message: "must be an RFC 3339 date-time"})
+			continue
+		}
+		value = value.UTC()
+		*bound.value = &value
+	}
+	return exportRange, details
+}
+
+// exportSignatures reads the signatures of a device in the counter range of an export one at
+// a time. Signatures created after the export has started are left out.
+func (s *Server) exportSignatures(request *http.Request, device *domain.Device, exportRange export.Range) func() (*domain.Signature, error) {
+	storage := s.tracedStorage(request)
+	last := storage.GetDeviceSignaturesCount(device.Id) - 1
+	if exportRange.ToCounter >= 0 && exportRange.ToCounter < last {
+		last = exportRange.ToCounter
+	}
+	counter := exportRange.FromCounter
+	return func() (*domain.Signature, error) {
+		if counter > last {
+			return nil, io.EOF
+		}
+		signature, err := storage.GetSignature(device.Id, counter)
+		if err != nil {
+			return nil, signing.ErrChainInconsistency
+		}
+		counter++
+		return signature, nil
+	}
+}
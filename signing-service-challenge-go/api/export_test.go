@@ -0,0 +1,50 @@
+package api
+
+import (
+	"bytes"
+	"crypto/x509"
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/assert"
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/crypto"
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/domain"
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/export"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestServer_ExportArchive(t *testing.T) {
+	server := newTestServer()
+	authority, err := crypto.NewCertificateAuthority(server.storage, domain.ECC, time.Now().UTC(), time.Hour)
+	assert.ShouldBe(t, err, nil)
+	key, err := export.NewServiceKey(authority, time.Now().UTC(), time.Hour)
+	assert.ShouldBe(t, err, nil)
+	WithExportKey(key)(server)
+	deviceId := createTestDevice(t, server, domain.ECC)
+	serveJSON(t, server, http.MethodPost, "/api/v0/devices/"+deviceId+"/sign-batch", `{"data": ["a", "b", "c"]}`, &SignBatchResponse{})
+
+	response := httptest.NewRecorder()
+	server.Handler().ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/api/v0/devices/"+deviceId+"/export?from_counter=1", nil))
+	assert.ShouldBe(t, response.Code, http.StatusOK)
+	assert.ShouldBe(t, response.Header().Get("Content-Type"), ContentTypeTar)
+
+	root, _ := authority.Certificate()
+	roots := x509.NewCertPool()
+	roots.AddCert(root)
+	manifest, err := export.Verify(bytes.NewReader(response.Body.Bytes()), roots)
+	assert.ShouldBe(t, err, nil)
+	assert.ShouldBe(t, manifest.DeviceId, deviceId)
+	assert.ShouldBe(t, manifest.SignatureCount, 2)
+
+	response = httptest.NewRecorder()
+	server.Handler().ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/api/v0/devices/"+deviceId+"/export?from=yesterday", nil))
+	assert.ShouldBe(t, response.Code, http.StatusBadRequest)
+}
+
+func TestServer_ExportArchiveWithoutKey(t *testing.T) {
+	server := newTestServer()
+	deviceId := createTestDevice(t, server, domain.ECC)
+	response := httptest.NewRecorder()
+	server.Handler().ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/api/v0/devices/"+deviceId+"/export", nil))
+	assert.ShouldBe(t, response.Code, http.StatusNotFound)
+}
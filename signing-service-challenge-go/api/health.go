@@ -1,25 +1,139 @@
 package api
 
-import "net/http"
+import (
+	"encoding/json"
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/build"
+	"net/http"
+	"time"
+)
 
+// ContentTypeHealth is the media type of the health check response format for HTTP APIs draft.
+const ContentTypeHealth = "application/health+json"
+
+// Health statuses, a warning still counts as healthy.
+const (
+	HealthPass = "pass"
+	HealthWarn = "warn"
+	HealthFail = "fail"
+)
+
+// HealthResponse is a health check response as proposed by the health check response format
+// for HTTP APIs draft. Checks are keyed by "<component>:<measurement>".
 type HealthResponse struct {
-	Status  string `json:"status"`
-	Version string `json:"version"`
+	Status      string                   `json:"status"`
+	Version     string                   `json:"version"`
+	ReleaseId   string                   `json:"releaseId,omitempty"`
+	Description string                   `json:"description,omitempty"`
+	Checks      map[string][]HealthCheck `json:"checks,omitempty"`
+}
+
+// HealthCheck is the result of checking a single component.
+type HealthCheck struct {
+	ComponentId   string      `json:"componentId,omitempty"`
+	ComponentType string      `json:"componentType,omitempty"`
+	ObservedValue interface{} `json:"observedValue,omitempty"`
+	ObservedUnit  string      `json:"observedUnit,omitempty"`
+	Status        string      `json:"status"`
+	Time          time.Time   `json:"time"`
+	Output        string      `json:"output,omitempty"`
 }
 
-// Health evaluates the health of the service and writes a standardized response.
+// Health reports whether the process is alive, it checks no dependencies.
 func (s *Server) Health(response http.ResponseWriter, request *http.Request) {
 	if request.Method != http.MethodGet {
-		WriteErrorResponse(response, http.StatusMethodNotAllowed, []string{
-			http.StatusText(http.StatusMethodNotAllowed),
-		})
+		WriteMethodNotAllowed(response, http.MethodGet)
 		return
 	}
 
+	now := time.Now().UTC()
+	s.writeHealth(response, request, map[string][]HealthCheck{
+		"uptime": {{
+			ComponentType: "system",
+			ObservedValue: now.Sub(s.startedAt).Seconds(),
+			ObservedUnit:  "s",
+			Status:        HealthPass,
+			Time:          now,
+		}},
+	})
+}
+
+// Readiness reports whether the service can sign: the storage must be reachable and
+// every enabled algorithm must pass a signing self-test.
+func (s *Server) Readiness(response http.ResponseWriter, request *http.Request) {
+	if request.Method != http.MethodGet {
+		WriteMethodNotAllowed(response, http.MethodGet)
+		return
+	}
+
+	storage := s.checkHealth(request, "", "datastore", func() error {
+		return s.tracedStorage(request).Ping()
+	})
+	algorithms := s.signatures.Algorithms()
+	selfTests := make([]HealthCheck, 0, len(algorithms))
+	for _, algorithm := range algorithms {
+		selfTests = append(selfTests, s.checkHealth(request, string(algorithm), "component", func() error {
+			return s.signatures.SelfTest(request.Context(), algorithm)
+		}))
+	}
+	s.writeHealth(response, request, map[string][]HealthCheck{
+		"storage:responseTime": {storage},
+		"signing:selfTest":     selfTests,
+	})
+}
+
+// checkHealth runs a check and reports its duration in milliseconds. Failures are logged,
+// since their output is the only trace of why a probe failed.
+func (s *Server) checkHealth(request *http.Request, componentId string, componentType string, check func() error) HealthCheck {
+	start := time.Now()
+	err := check()
+	result := HealthCheck{
+		ComponentId:   componentId,
+		ComponentType: componentType,
+		ObservedValue: float64(time.Since(start).Microseconds()) / 1000,
+		ObservedUnit:  "ms",
+		Status:        HealthPass,
+		Time:          start.UTC(),
+	}
+	if err != nil {
+		result.Status = HealthFail
+		result.Output = err.Error()
+		s.logger.ErrorContext(request.Context(), "health check failed",
+			"component_type", componentType,
+			"component_id", componentId,
+			"request_id", RequestId(request.Context()),
+			"error", err.Error(),
+		)
+	}
+	return result
+}
+
+// writeHealth writes a health check response with the worst status of the checks.
+// Failing services answer with 503, so load balancers and orchestrators take them out of rotation.
+func (s *Server) writeHealth(response http.ResponseWriter, request *http.Request, checks map[string][]HealthCheck) {
 	health := HealthResponse{
-		Status:  "pass",
-		Version: "v0",
+		Status:      HealthPass,
+		Version:     build.Version(),
+		ReleaseId:   build.Revision(),
+		Description: "Signature Service",
+		Checks:      checks,
+	}
+	for _, results := range checks {
+		for _, result := range results {
+			if result.Status == HealthFail || (result.Status == HealthWarn && health.Status == HealthPass) {
+				health.Status = result.Status
+			}
+		}
+	}
+	status := http.StatusOK
+	if health.Status == HealthFail {
+		status = http.StatusServiceUnavailable
 	}
 
-	WriteAPIResponse(response, http.StatusOK, health)
+	bytes, err := json.MarshalIndent(health, "", "  ")
+	if err != nil {
+		s.internalError(response, request, err)
+		return
+	}
+	response.Header().Set("Cache-Control", "no-store")
+	WriteRawResponse(response, status, ContentTypeHealth, bytes)
 }
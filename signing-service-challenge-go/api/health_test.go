@@ -0,0 +1,64 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/assert"
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/domain"
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/persistence"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+type unreachableStorage struct {
+	*persistence.LocalStorage
+}
+
+func (s unreachableStorage) Ping() error {
+	return errors.New("connection refused")
+}
+
+func getHealth(t *testing.T, server *Server, path string) (*httptest.ResponseRecorder, HealthResponse) {
+	response := httptest.NewRecorder()
+	server.Handler().ServeHTTP(response, httptest.NewRequest(http.MethodGet, path, nil))
+	assert.ShouldBe(t, response.Header().Get("Content-Type"), ContentTypeHealth)
+	var health HealthResponse
+	assert.ShouldBe(t, json.Unmarshal(response.Body.Bytes(), &health), nil)
+	return response, health
+}
+
+func TestServer_Health(t *testing.T) {
+	for _, path := range []string{"/api/v0/health", "/api/v0/health/live"} {
+		response, health := getHealth(t, newTestServer(), path)
+		assert.ShouldBe(t, response.Code, http.StatusOK)
+		assert.ShouldBe(t, health.Status, HealthPass)
+		assert.ShouldNotBe(t, health.Version, "")
+		assert.ShouldBe(t, health.Checks["uptime"][0].ObservedUnit, "s")
+	}
+}
+
+func TestServer_Readiness(t *testing.T) {
+	response, health := getHealth(t, newTestServer(), "/api/v0/health/ready")
+	assert.ShouldBe(t, response.Code, http.StatusOK)
+	assert.ShouldBe(t, health.Status, HealthPass)
+	assert.ShouldBe(t, health.Checks["storage:responseTime"][0].Status, HealthPass)
+	selfTests := health.Checks["signing:selfTest"]
+	assert.ShouldBe(t, len(selfTests), len(domain.CryptoAlgorithms))
+	for i, algorithm := range domain.CryptoAlgorithms {
+		assert.ShouldBe(t, selfTests[i].ComponentId, string(algorithm))
+		assert.ShouldBe(t, selfTests[i].Status, HealthPass)
+	}
+}
+
+func TestServer_ReadinessFailsWithUnreachableStorage(t *testing.T) {
+	server := newTestServer()
+	server.storage = unreachableStorage{server.storage.(*persistence.LocalStorage)}
+
+	response, health := getHealth(t, server, "/api/v0/health/ready")
+	assert.ShouldBe(t, response.Code, http.StatusServiceUnavailable)
+	assert.ShouldBe(t, health.Status, HealthFail)
+	assert.ShouldBe(t, health.Checks["storage:responseTime"][0].Status, HealthFail)
+	assert.ShouldBe(t, health.Checks["storage:responseTime"][0].Output, "connection refused")
+	assert.ShouldBe(t, health.Checks["signing:selfTest"][0].Status, HealthPass)
+}
@@ -0,0 +1,97 @@
+package api
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// requestLog collects the attributes of a request that only handlers know about.
+type requestLog struct {
+	deviceId string
+	traceId  string
+	tenant   string
+}
+
+// LogDeviceId records the device a request operates on for the access log.
+func LogDeviceId(ctx context.Context, deviceId string) {
+	if entry, ok := ctx.Value(requestLogKey).(*requestLog); ok {
+		entry.deviceId = deviceId
+	}
+}
+
+// statusRecorder captures the status code written by a handler.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(bytes []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	return r.ResponseWriter.Write(bytes)
+}
+
+// Unwrap lets http.ResponseController reach the flushing and full duplex support of the wrapped writer.
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// accessLog logs one entry per request once it has been handled.
+// Request and response bodies are never logged, they may contain keys and transaction data.
+func (s *Server) accessLog(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		start := time.Now()
+		entry := &requestLog{}
+		recorder := &statusRecorder{ResponseWriter: response}
+		request = request.WithContext(context.WithValue(request.Context(), requestLogKey, entry))
+
+		next.ServeHTTP(recorder, request)
+
+		// The mux sets the pattern and path values on the request it was handed.
+		if entry.deviceId == "" {
+			entry.deviceId = request.PathValue("id")
+		}
+		if recorder.status == 0 {
+			recorder.status = http.StatusOK
+		}
+		level := slog.LevelInfo
+		if recorder.status >= http.StatusInternalServerError {
+			level = slog.LevelError
+		}
+		s.logger.LogAttrs(request.Context(), level, "request",
+			slog.String("method", request.Method),
+			slog.String("route", request.Pattern),
+			slog.Int("status", recorder.status),
+			slog.Duration("latency", time.Since(start)),
+			slog.String("device_id", entry.deviceId),
+			slog.String("request_id", RequestId(request.Context())),
+			slog.String("trace_id", entry.traceId),
+			slog.String("tenant", entry.tenant),
+		)
+	})
+}
+
+// internalError logs an unexpected failure of a request and writes a generic internal error response.
+func (s *Server) internalError(response http.ResponseWriter, request *http.Request, err error) {
+	s.logRequestError(request, err)
+	WriteInternalError(response)
+}
+
+// logRequestError logs an error that is not shown to the client.
+func (s *Server) logRequestError(request *http.Request, err error) {
+	s.logger.LogAttrs(request.Context(), slog.LevelError, "request failed",
+		slog.String("route", request.Pattern),
+		slog.String("request_id", RequestId(request.Context())),
+		slog.String("error", err.Error()),
+	)
+}
@@ -0,0 +1,70 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/assert"
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/domain"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func newLoggedTestServer() (*Server, *bytes.Buffer) {
+	var logs bytes.Buffer
+	server := newTestServer()
+	WithLogger(slog.New(slog.NewJSONHandler(&logs, nil)))(server)
+	return server, &logs
+}
+
+func logEntries(logs *bytes.Buffer) []map[string]interface{} {
+	var entries []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(logs.String()), "\n") {
+		var entry map[string]interface{}
+		json.Unmarshal([]byte(line), &entry)
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+func TestServer_AccessLog(t *testing.T) {
+	server, logs := newLoggedTestServer()
+	deviceId := createTestDevice(t, server, domain.ECC)
+	request := httptest.NewRequest(http.MethodPost, "/api/v0/sign-transaction",
+		strings.NewReader(`{"device_id": "`+deviceId+`", "data": "confidential payload"}`))
+	request.Header.Set(HeaderRequestId, "abc")
+	server.Handler().ServeHTTP(httptest.NewRecorder(), request)
+
+	entries := logEntries(logs)
+	assert.ShouldBe(t, len(entries), 1)
+	assert.ShouldBe(t, entries[0]["msg"], "request")
+	assert.ShouldBe(t, entries[0]["method"], http.MethodPost)
+	assert.ShouldBe(t, entries[0]["route"], "/api/v0/sign-transaction")
+	assert.ShouldBe(t, entries[0]["status"], float64(http.StatusOK))
+	assert.ShouldBe(t, entries[0]["device_id"], deviceId)
+	assert.ShouldBe(t, entries[0]["request_id"], "abc")
+	assert.ShouldNotBe(t, entries[0]["latency"], nil)
+	assert.ShouldBe(t, strings.Contains(logs.String(), "confidential"), false)
+}
+
+func TestServer_LogsInternalErrors(t *testing.T) {
+	server, logs := newLoggedTestServer()
+	deviceId := createTestDevice(t, server, domain.ECC)
+	device := server.storage.GetDevice(deviceId)
+	server.storage.SetDeviceKeys(deviceId, device.PublicKey, []byte("corrupt"))
+
+	response := httptest.NewRecorder()
+	server.Handler().ServeHTTP(response, httptest.NewRequest(http.MethodPost, "/api/v0/devices/"+deviceId+"/sign-batch", strings.NewReader(`{"data": ["a"]}`)))
+	assert.ShouldBe(t, response.Code, http.StatusInternalServerError)
+
+	entries := logEntries(logs)
+	assert.ShouldBe(t, len(entries), 2)
+	assert.ShouldBe(t, entries[0]["msg"], "request failed")
+	assert.ShouldBe(t, entries[0]["level"], "ERROR")
+	assert.ShouldNotBe(t, entries[0]["error"], "")
+	assert.ShouldBe(t, entries[1]["route"], "/api/v0/devices/{id}/sign-batch")
+	assert.ShouldBe(t, entries[1]["device_id"], deviceId)
+	assert.ShouldBe(t, strings.Contains(logs.String(), "PRIVATE KEY"), false)
+}
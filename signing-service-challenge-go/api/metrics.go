@@ -0,0 +1,54 @@
+package api
+
+import (
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/metrics"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// registerMetrics registers the HTTP metrics of the Server and the number of active devices.
+func (s *Server) registerMetrics() {
+	s.requests = s.registry.NewCounterVec("http_requests_total", "HTTP requests handled.", "method", "route", "status")
+	s.requestDuration = s.registry.NewHistogramVec("http_request_duration_seconds", "Time taken to handle HTTP requests.", metrics.DefaultBuckets, "method", "route")
+	s.rateLimited = s.registry.NewCounterVec("http_rate_limited_total", "HTTP requests rejected by a rate limit.", "scope")
+	s.registry.NewGaugeFunc("signing_active_devices", "Signature devices that have not been retired.", func() float64 {
+		active := 0
+		for _, device := range s.storage.ListDevices() {
+			if !device.IsRetired() {
+				active++
+			}
+		}
+		return float64(active)
+	})
+}
+
+// measure counts the requests and measures their latency per route.
+func (s *Server) measure(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: response}
+
+		next.ServeHTTP(recorder, request)
+
+		// Requests that match no route share a label, the raw path would make the cardinality unbounded.
+		route := request.Pattern
+		if route == "" {
+			route = "unmatched"
+		}
+		if recorder.status == 0 {
+			recorder.status = http.StatusOK
+		}
+		s.requests.Inc(request.Method, route, strconv.Itoa(recorder.status))
+		s.requestDuration.Observe(time.Since(start).Seconds(), request.Method, route)
+	})
+}
+
+// Metrics serves the metrics in the Prometheus text exposition format.
+func (s *Server) Metrics(response http.ResponseWriter, request *http.Request) {
+	if request.Method != http.MethodGet {
+		WriteMethodNotAllowed(response, http.MethodGet)
+		return
+	}
+	s.registry.ServeHTTP(response, request)
+}
@@ -0,0 +1,28 @@
+package api
+
+import (
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/assert"
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/domain"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestServer_Metrics(t *testing.T) {
+	server := newTestServer()
+	handler := server.Handler()
+	deviceId := createTestDevice(t, server, domain.ECC)
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/api/v0/devices/"+deviceId+"/sign-batch", strings.NewReader(`{"data": ["a"]}`)))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/unknown/"+deviceId, nil))
+
+	response := httptest.NewRecorder()
+	handler.ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.ShouldBe(t, response.Code, http.StatusOK)
+	scrape := response.Body.String()
+	assert.ShouldBe(t, strings.Contains(scrape, `http_requests_total{method="POST",route="/api/v0/devices/{id}/sign-batch",status="200"} 1`), true)
+	assert.ShouldBe(t, strings.Contains(scrape, `http_requests_total{method="GET",route="unmatched",status="404"} 1`), true)
+	assert.ShouldBe(t, strings.Contains(scrape, `http_request_duration_seconds_count{method="POST",route="/api/v0/devices/{id}/sign-batch"} 1`), true)
+	assert.ShouldBe(t, strings.Contains(scrape, "signing_active_devices 1\n"), true)
+	assert.ShouldBe(t, strings.Contains(scrape, deviceId), false)
+}
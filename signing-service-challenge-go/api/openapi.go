@@ -0,0 +1,345 @@
+package api
+
+import (
+	"encoding/json"
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/metrics"
+	"net/http"
+	"reflect"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const (
+	ContentTypeJSON = "application/json"
+
+	// MaxDataSize limits the size of the data of a single transaction.
+	MaxDataSize = 64 * 1024
+)
+
+// route binds a URL pattern to its handler and describes the operations it serves.
+type route struct {
+	Pattern    string
+	Handler    http.HandlerFunc
+	Operations []operation
+}
+
+// streaming reports whether the route reads its request body as a stream,
+// which the maximum body size and the connection timeouts do not apply to.
+func (r route) streaming() bool {
+	for _, op := range r.Operations {
+		if slices.Contains(op.ContentTypes, ContentTypeNDJSON) {
+			return true
+		}
+	}
+	return false
+}
+
+// probe reports whether the route serves health probes or metrics scrapes,
+// which the rate limits do not apply to.
+func (r route) probe() bool {
+	for _, op := range r.Operations {
+		if slices.Contains(op.ContentTypes, ContentTypeHealth) || slices.Contains(op.ContentTypes, metrics.ContentType) {
+			return true
+		}
+	}
+	return false
+}
+
+// operation describes a single method of a route for the OpenAPI document.
+type operation struct {
+	Method  string
+	Summary string
+	// Request is the JSON request body, nil if the operation has none.
+	Request interface{}
+	// Response is wrapped into the data field of a Response, nil if the operation answers
+	// with the raw ContentTypes instead.
+	Response     interface{}
+	ContentTypes []string
+	Status       int
+	Admin        bool
+	// Query lists the optional integer query parameters of the operation.
+	Query []string
+	// TimeQuery lists the optional date-time query parameters of the operation.
+	TimeQuery []string
+}
+
+// routes lists all routes of the API. Run registers them and the OpenAPI document describes them.
+func (s *Server) routes() []route {
+	return []route{
+		{"/api/v0/health", s.Health, []operation{
+			{Method: http.MethodGet, Summary: "Reports whether the service is alive, same as /api/v0/health/live", ContentTypes: []string{ContentTypeHealth}},
+		}},
+		{"/api/v0/health/live", s.Health, []operation{
+			{Method: http.MethodGet, Summary: "Reports whether the service is alive", ContentTypes: []string{ContentTypeHealth}},
+		}},
+		{"/api/v0/health/ready", s.Readiness, []operation{
+			{Method: http.MethodGet, Summary: "Reports whether the storage is reachable and signing passes a self-test for every enabled algorithm", ContentTypes: []string{ContentTypeHealth}},
+		}},
+		{"/metrics", s.Metrics, []operation{
+			{Method: http.MethodGet, Summary: "Exposes the metrics in the Prometheus text exposition format", ContentTypes: []string{metrics.ContentType}},
+		}},
+		{"/api/v0/openapi.json", s.OpenAPI, []operation{
+			{Method: http.MethodGet, Summary: "Returns this OpenAPI document", ContentTypes: []string{ContentTypeJSON}},
+		}},
+		{"/api/v0/create-signature-device", s.CreateSignatureDevice, []operation{
+			{Method: http.MethodPost, Summary: "Creates a signature device with a new key pair", Request: CreateSignatureDeviceRequest{}, Response: CreateSignatureDeviceResponse{}},
+		}},
+		{"/api/v0/devices", s.ListSignatureDevices, []operation{
+			{Method: http.MethodGet, Summary: "Lists all devices", Response: ListSignatureDevicesResponse{}},
+		}},
+		{"/api/v0/devices/{id}", s.SignatureDevice, []operation{
+			{Method: http.MethodGet, Summary: "Returns a device", Response: DeviceResponse{}},
+		}},
+		{"/api/v0/sign-transaction", s.SignTransaction, []operation{
+			{Method: http.MethodPost, Summary: "Signs transaction data with a device", Request: SignTransactionRequest{}, Response: SignTransactionResponse{}},
+		}},
+		{"/api/v0/devices/{id}/sign-batch", s.SignBatch, []operation{
+			{Method: http.MethodPost, Summary: "Signs a list of data items with consecutive signature counters", Request: SignBatchRequest{}, Response: SignBatchResponse{}},
+		}},
+		{"/api/v0/devices/{id}/sign-stream", s.SignStream, []operation{
+			{Method: http.MethodPost, Summary: "Signs newline-delimited JSON data items as they arrive", ContentTypes: []string{ContentTypeNDJSON}},
+		}},
+		{"/api/v0/verify-signature", s.VerifySignature, []operation{
+			{Method: http.MethodPost, Summary: "Verifies a signature of a device over signed data", Request: VerifySignatureRequest{}, Response: VerifySignatureResponse{}},
+		}},
+		{"/api/v0/devices/{id}/signatures", s.SignatureHistory, []operation{
+			{Method: http.MethodGet, Summary: "Returns a page of the signatures of a device in counter order", Response: SignatureHistoryResponse{}, Query: []string{"from", "limit"}},
+		}},
+		{"/api/v0/devices/{id}/audit", s.AuditSignatureChain, []operation{
+			{Method: http.MethodGet, Summary: "Checks the counters, links and signatures of the signature chain of a device", Response: ChainAuditResponse{}},
+		}},
+		{"/api/v0/devices/{id}/journal", s.SignatureJournal, []operation{
+			{Method: http.MethodGet, Summary: "Exports the signature journal of a device for offline verification", ContentTypes: []string{ContentTypeJSON}},
+		}},
+		{"/api/v0/devices/{id}/export", s.ExportArchive, []operation{
+			{Method: http.MethodGet, Summary: "Exports the signatures of a device in a counter or time range as a tar archive with a signed manifest", ContentTypes: []string{ContentTypeTar}, Query: []string{"from_counter", "to_counter"}, TimeQuery: []string{"from", "to"}},
+		}},
+		{"/api/v0/devices/{id}/transactions", s.StartTransaction, []operation{
+			{Method: http.MethodPost, Summary: "Starts the next transaction of a device with a signed start step", Request: StartTransactionRequest{}, Response: TransactionStepResponse{}, Status: http.StatusCreated},
+		}},
+		{"/api/v0/devices/{id}/transactions/open", s.OpenTransactions, []operation{
+			{Method: http.MethodGet, Summary: "Lists the transactions of a device that have not been finished", Response: OpenTransactionsResponse{}},
+		}},
+		{"/api/v0/devices/{id}/transactions/close-stale", s.CloseStaleTransactions, []operation{
+			{Method: http.MethodPost, Summary: "Force-finishes the open transactions of a device that have been idle for too long", Request: CloseStaleTransactionsRequest{}, Response: CloseStaleTransactionsResponse{}, Admin: true},
+		}},
+		{"/api/v0/devices/{id}/transactions/{number}", s.Transaction, []operation{
+			{Method: http.MethodGet, Summary: "Returns a transaction of a device", Response: TransactionResponse{}},
+		}},
+		{"/api/v0/devices/{id}/transactions/{number}/update", s.UpdateTransaction, []operation{
+			{Method: http.MethodPost, Summary: "Signs new process data for an open transaction", Request: TransactionRequest{}, Response: TransactionStepResponse{}},
+		}},
+		{"/api/v0/devices/{id}/transactions/{number}/finish", s.FinishTransaction, []operation{
+			{Method: http.MethodPost, Summary: "Signs the final process data of an open transaction and finishes it", Request: TransactionRequest{}, Response: TransactionStepResponse{}},
+		}},
+		{"/api/v0/devices/{id}/public-key", s.PublicKey, []operation{
+			{Method: http.MethodGet, Summary: "Exports the public key of a device", ContentTypes: []string{ContentTypePEM, ContentTypeDER, ContentTypeJWK}},
+		}},
+		{"/api/v0/devices/{id}/csr", s.CertificateRequest, []operation{
+			{Method: http.MethodGet, Summary: "Creates a certificate signing request for the device key", ContentTypes: []string{ContentTypeCSR}},
+		}},
+		{"/api/v0/devices/{id}/certificate", s.Certificate, []operation{
+			{Method: http.MethodGet, Summary: "Returns the certificate chain of a device", ContentTypes: []string{ContentTypePEM}},
+			{Method: http.MethodPost, Summary: "Imports a CA-issued certificate chain for the device key", Request: ImportCertificateRequest{}, Response: CertificateResponse{}, Admin: true},
+		}},
+		{"/api/v0/devices/{id}/certificate/self-signed", s.SelfSignedCertificate, []operation{
+			{Method: http.MethodPost, Summary: "Issues a self-signed certificate for the device key", Request: SelfSignedCertificateRequest{}, Response: CertificateResponse{}, Status: http.StatusCreated, Admin: true},
+		}},
+		{"/api/v0/devices/{id}/certificate/issue", s.IssueCertificate, []operation{
+			{Method: http.MethodPost, Summary: "Issues a certificate for the device key by the certificate authority", Request: IssueCertificateRequest{}, Response: CertificateResponse{}, Status: http.StatusCreated, Admin: true},
+		}},
+		{"/api/v0/devices/{id}/retire", s.RetireSignatureDevice, []operation{
+			{Method: http.MethodPost, Summary: "Retires a device", Response: RetireSignatureDeviceResponse{}, Admin: true},
+		}},
+		{"/api/v0/ca/certificate", s.AuthorityCertificate, []operation{
+			{Method: http.MethodGet, Summary: "Returns the root certificate of the certificate authority", ContentTypes: []string{ContentTypePEM}},
+		}},
+		{"/api/v0/ca/crl", s.RevocationList, []operation{
+			{Method: http.MethodGet, Summary: "Returns the certificate revocation list", ContentTypes: []string{ContentTypeCRL}},
+		}},
+		{"/api/v0/admin/devices/import", s.ImportSignatureDevice, []operation{
+			{Method: http.MethodPost, Summary: "Creates a device from an existing private key", Request: ImportSignatureDeviceRequest{}, Response: CreateSignatureDeviceResponse{}, Status: http.StatusCreated, Admin: true},
+		}},
+		{"/api/v0/admin/devices/{id}/export", s.ExportSignatureDevice, []operation{
+			{Method: http.MethodPost, Summary: "Exports the private key of a device", Request: ExportSignatureDeviceRequest{}, Response: ExportSignatureDeviceResponse{}, Admin: true},
+		}},
+	}
+}
+
+// OpenAPIDocument is the subset of the OpenAPI 3 document used to describe the API.
+type OpenAPIDocument struct {
+	OpenAPI    string                                  `json:"openapi"`
+	Info       OpenAPIInfo                             `json:"info"`
+	Paths      map[string]map[string]*OpenAPIOperation `json:"paths"`
+	Components OpenAPIComponents                       `json:"components"`
+}
+
+type OpenAPIInfo struct {
+	Title   string `json:"title"`
+	Version string `json:"version"`
+}
+
+type OpenAPIOperation struct {
+	Summary     string                      `json:"summary"`
+	Parameters  []OpenAPIParameter          `json:"parameters,omitempty"`
+	RequestBody *OpenAPIRequestBody         `json:"requestBody,omitempty"`
+	Responses   map[string]*OpenAPIResponse `json:"responses"`
+	Security    []map[string][]string       `json:"security,omitempty"`
+}
+
+type OpenAPIParameter struct {
+	Name     string  `json:"name"`
+	In       string  `json:"in"`
+	Required bool    `json:"required"`
+	Schema   *Schema `json:"schema"`
+}
+
+type OpenAPIRequestBody struct {
+	Required bool                         `json:"required"`
+	Content  map[string]*OpenAPIMediaType `json:"content"`
+}
+
+type OpenAPIResponse struct {
+	Description string                       `json:"description"`
+	Content     map[string]*OpenAPIMediaType `json:"content,omitempty"`
+}
+
+type OpenAPIMediaType struct {
+	Schema *Schema `json:"schema,omitempty"`
+}
+
+type OpenAPIComponents struct {
+	Schemas         Schemas                           `json:"schemas"`
+	SecuritySchemes map[string]*OpenAPISecurityScheme `json:"securitySchemes"`
+}
+
+type OpenAPISecurityScheme struct {
+	Type   string `json:"type"`
+	Scheme string `json:"scheme"`
+}
+
+var pathParameter = regexp.MustCompile(`\{([^}]+)\}`)
+
+// NewOpenAPIDocument describes the routes of the API, the schemas are generated from the API types.
+func (s *Server) NewOpenAPIDocument() (*OpenAPIDocument, error) {
+	schemas := Schemas{}
+	document := &OpenAPIDocument{
+		OpenAPI: "3.0.3",
+		Info: OpenAPIInfo{
+			Title:   "Signature Service",
+			Version: "v0",
+		},
+		Paths: make(map[string]map[string]*OpenAPIOperation),
+		Components: OpenAPIComponents{
+			Schemas: schemas,
+			SecuritySchemes: map[string]*OpenAPISecurityScheme{
+				"admin": {Type: "http", Scheme: "bearer"},
+			},
+		},
+	}
+	errorSchema, err := schemas.SchemaOf(reflect.TypeOf(ErrorResponse{}))
+	if err != nil {
+		return nil, err
+	}
+	errorResponse := &OpenAPIResponse{
+		Description: "Error",
+		Content: map[string]*OpenAPIMediaType{
+			ContentTypeProblem: {Schema: errorSchema},
+		},
+	}
+
+	for _, route := range s.routes() {
+		path := make(map[string]*OpenAPIOperation)
+		document.Paths[route.Pattern] = path
+		for _, op := range route.Operations {
+			documented := &OpenAPIOperation{
+				Summary: op.Summary,
+				Responses: map[string]*OpenAPIResponse{
+					"default": errorResponse,
+				},
+			}
+			for _, match := range pathParameter.FindAllStringSubmatch(route.Pattern, -1) {
+				documented.Parameters = append(documented.Parameters, OpenAPIParameter{
+					Name:     match[1],
+					In:       "path",
+					Required: true,
+					Schema:   &Schema{Type: "string"},
+				})
+			}
+			for _, name := range op.Query {
+				documented.Parameters = append(documented.Parameters, OpenAPIParameter{
+					Name:   name,
+					In:     "query",
+					Schema: &Schema{Type: "integer"},
+				})
+			}
+			for _, name := range op.TimeQuery {
+				documented.Parameters = append(documented.Parameters, OpenAPIParameter{
+					Name:   name,
+					In:     "query",
+					Schema: &Schema{Type: "string", Format: "date-time"},
+				})
+			}
+			if op.Request != nil {
+				requestSchema, err := schemas.SchemaOf(reflect.TypeOf(op.Request))
+				if err != nil {
+					return nil, err
+				}
+				documented.RequestBody = &OpenAPIRequestBody{
+					Required: true,
+					Content: map[string]*OpenAPIMediaType{
+						ContentTypeJSON: {Schema: requestSchema},
+					},
+				}
+			}
+			status := op.Status
+			if status == 0 {
+				status = http.StatusOK
+			}
+			success := &OpenAPIResponse{
+				Description: http.StatusText(status),
+				Content:     make(map[string]*OpenAPIMediaType),
+			}
+			if op.Response != nil {
+				responseSchema, err := schemas.SchemaOf(reflect.TypeOf(op.Response))
+				if err != nil {
+					return nil, err
+				}
+				success.Content[ContentTypeJSON] = &OpenAPIMediaType{Schema: &Schema{
+					Type:       "object",
+					Properties: map[string]*Schema{"data": responseSchema},
+				}}
+			}
+			for _, contentType := range op.ContentTypes {
+				success.Content[contentType] = &OpenAPIMediaType{}
+			}
+			documented.Responses[strconv.Itoa(status)] = success
+			if op.Admin {
+				documented.Security = []map[string][]string{{"admin": {}}}
+			}
+			path[strings.ToLower(op.Method)] = documented
+		}
+	}
+	return document, nil
+}
+
+// OpenAPI serves the OpenAPI document of the API.
+func (s *Server) OpenAPI(response http.ResponseWriter, request *http.Request) {
+	if request.Method != http.MethodGet {
+		WriteMethodNotAllowed(response, http.MethodGet)
+		return
+	}
+	document, err := s.NewOpenAPIDocument()
+	if err != nil {
+		s.internalError(response, request, err)
+		return
+	}
+	bytes, err := json.MarshalIndent(document, "", "  ")
+	if err != nil {
+		s.internalError(response, request, err)
+		return
+	}
+	WriteRawResponse(response, http.StatusOK, ContentTypeJSON, bytes)
+}
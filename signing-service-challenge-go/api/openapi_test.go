@@ -0,0 +1,94 @@
+package api
+
+import (
+	"encoding/json"
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/assert"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestServer_OpenAPI(t *testing.T) {
+	server := newTestServer()
+	response := httptest.NewRecorder()
+	server.OpenAPI(response, httptest.NewRequest(http.MethodGet, "/api/v0/openapi.json", nil))
+	assert.ShouldBe(t, response.Code, http.StatusOK)
+	assert.ShouldBe(t, response.Header().Get("Content-Type"), ContentTypeJSON)
+
+	var document OpenAPIDocument
+	err := json.Unmarshal(response.Body.Bytes(), &document)
+	assert.ShouldBe(t, err, nil)
+	assert.ShouldBe(t, document.OpenAPI, "3.0.3")
+	assert.ShouldBe(t, len(document.Paths), len(server.routes()))
+
+	signBatch := document.Paths["/api/v0/devices/{id}/sign-batch"]["post"]
+	assert.ShouldBe(t, signBatch.Parameters[0].Name, "id")
+	assert.ShouldBe(t, signBatch.RequestBody.Content[ContentTypeJSON].Schema.Ref, "#/components/schemas/SignBatchRequest")
+
+	createRequest := document.Components.Schemas["CreateSignatureDeviceRequest"]
+	assert.ShouldBe(t, strings.Join(createRequest.Required, ","), "algorithm")
+	assert.ShouldBe(t, strings.Join(createRequest.Properties["algorithm"].Enum, ","), "ECC,RSA")
+	assert.ShouldBe(t, *document.Components.Schemas["SignBatchRequest"].Properties["data"].MaxItems, MaxBatchSize)
+	assert.ShouldBe(t, *document.Components.Schemas["SignTransactionRequest"].Properties["data"].MaxLength, MaxDataSize)
+	assert.ShouldBe(t, len(document.Paths["/api/v0/admin/devices/import"]["post"].Security), 1)
+}
+
+func TestPostMethodTemplateReportsFieldErrors(t *testing.T) {
+	var body SignTransactionRequest
+	request := httptest.NewRequest(http.MethodPost, "/api/v0/sign-transaction", strings.NewReader(`{"data": 1, "format": "pdf"}`))
+	isValid, errors := PostMethodTemplate(request, &body)
+	assert.ShouldBe(t, isValid, false)
+	assert.ShouldBe(t, len(errors), 3)
+	assert.ShouldBe(t, errors[0], ErrorDetail{Code: CodeRequired, Field: "device_id", Message: "is required"})
+	assert.ShouldBe(t, errors[1].String(), "data: must be a string")
+	assert.ShouldBe(t, errors[2].String(), "format: must be one of raw, jws, cose_sign1")
+
+	var batch SignBatchRequest
+	request = httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"data": ["a", "`+strings.Repeat("x", MaxDataSize+1)+`"]}`))
+	isValid, errors = PostMethodTemplate(request, &batch)
+	assert.ShouldBe(t, isValid, false)
+	assert.ShouldBe(t, len(errors), 1)
+	assert.ShouldBe(t, errors[0], ErrorDetail{Code: CodeTooLong, Field: "data[1]", Message: "must be at most 65536 characters long"})
+}
+
+func TestServer_CreateSignatureDeviceRejectsUnknownAlgorithm(t *testing.T) {
+	server := newTestServer()
+	response := httptest.NewRecorder()
+	server.CreateSignatureDevice(response, httptest.NewRequest(http.MethodPost, "/api/v0/create-signature-device", strings.NewReader(`{"algorithm": "DSA"}`)))
+	assert.ShouldBe(t, response.Code, http.StatusBadRequest)
+
+	var body ErrorResponse
+	json.Unmarshal(response.Body.Bytes(), &body)
+	assert.ShouldBe(t, len(body.Errors), 1)
+	assert.ShouldBe(t, body.Errors[0], ErrorDetail{Code: CodeInvalidEnum, Field: "algorithm", Message: "must be one of ECC, RSA"})
+	assert.ShouldBe(t, len(server.storage.ListDevices()), 0)
+}
+
+func TestSchemas_SchemaOfRejectsInvalidConstraints(t *testing.T) {
+	type unknownConstraint struct {
+		Data string `json:"data" schema:"maxSize=1"`
+	}
+	type invalidInteger struct {
+		Data string `json:"data" schema:"maxLength=many"`
+	}
+	type invalidPattern struct {
+		Data string `json:"data" schema:"pattern=("`
+	}
+	for _, value := range []interface{}{unknownConstraint{}, invalidInteger{}, invalidPattern{}} {
+		_, err := Schemas{}.SchemaOf(reflect.TypeOf(value))
+		assert.ShouldNotBe(t, err, nil)
+	}
+
+	var body invalidPattern
+	isValid, errors := PostMethodTemplate(httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"data": "a"}`)), &body)
+	assert.ShouldBe(t, isValid, false)
+	response := httptest.NewRecorder()
+	writeRequestErrors(response, errors)
+	assert.ShouldBe(t, response.Code, http.StatusInternalServerError)
+}
+
+func TestCompileRequestSchemas(t *testing.T) {
+	assert.ShouldBe(t, compileRequestSchemas(newTestServer().routes()), nil)
+}
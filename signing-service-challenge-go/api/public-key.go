@@ -0,0 +1,120 @@
+package api
+
+import (
+	"encoding/json"
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/crypto"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	ContentTypePEM = "application/x-pem-file"
+	ContentTypeDER = "application/pkix-key"
+	ContentTypeJWK = "application/jwk+json"
+)
+
+// PublicKey exports the public key of a device.
+// The format is negotiated through the Accept header and defaults to PEM.
+func (s *Server) PublicKey(response http.ResponseWriter, request *http.Request) {
+	if request.Method != http.MethodGet {
+		WriteMethodNotAllowed(response, http.MethodGet)
+		return
+	}
+
+	contentType, ok := negotiatePublicKeyContentType(request.Header.Get("Accept"))
+	if !ok {
+		WriteErrorResponse(response, http.StatusNotAcceptable, []string{
+			"supported formats are " + strings.Join([]string{ContentTypePEM, ContentTypeDER, ContentTypeJWK}, ", "),
+		})
+		return
+	}
+
+	device := s.pathDevice(response, request)
+	if device == nil {
+		return
+	}
+	if len(device.PublicKey) == 0 {
+		WriteErrorResponse(response, http.StatusConflict, []string{"device has no key pair"})
+		return
+	}
+
+	switch contentType {
+	case ContentTypeDER:
+		der, err := crypto.PublicKeyDER(device.Algorithm, device.PublicKey)
+		if err != nil {
+			s.internalError(response, request, err)
+			return
+		}
+		WriteRawResponse(response, http.StatusOK, contentType, der)
+	case ContentTypeJWK:
+		publicKey, err := crypto.ParsePublicKey(device.Algorithm, device.PublicKey)
+		if err != nil {
+			s.internalError(response, request, err)
+			return
+		}
+		jwk, err := crypto.NewJWK(publicKey, device.Id)
+		if err != nil {
+			s.internalError(response, request, err)
+			return
+		}
+		bytes, err := json.Marshal(jwk)
+		if err != nil {
+			s.internalError(response, request, err)
+			return
+		}
+		WriteRawResponse(response, http.StatusOK, contentType, bytes)
+	default:
+		encoded, err := crypto.PublicKeyPEM(device.Algorithm, device.PublicKey)
+		if err != nil {
+			s.internalError(response, request, err)
+			return
+		}
+		WriteRawResponse(response, http.StatusOK, contentType, encoded)
+	}
+}
+
+// negotiatePublicKeyContentType picks the supported media type an Accept header prefers most.
+// Ranges are tried by descending quality, ranges with q=0 are not acceptable.
+func negotiatePublicKeyContentType(accept string) (string, bool) {
+	if strings.TrimSpace(accept) == "" {
+		return ContentTypePEM, true
+	}
+	type acceptRange struct {
+		mediaType string
+		quality   float64
+	}
+	var ranges []acceptRange
+	for _, mediaRange := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(mediaRange)
+		if err != nil {
+			continue
+		}
+		quality := 1.0
+		if q, ok := params["q"]; ok {
+			quality, err = strconv.ParseFloat(q, 64)
+			if err != nil {
+				continue
+			}
+		}
+		if quality > 0 {
+			ranges = append(ranges, acceptRange{mediaType: mediaType, quality: quality})
+		}
+	}
+	sort.SliceStable(ranges, func(i, j int) bool {
+		return ranges[i].quality > ranges[j].quality
+	})
+	for _, mediaRange := range ranges {
+		switch mediaRange.mediaType {
+		case ContentTypePEM, "application/x-pem", "text/plain", "*/*", "application/*":
+			return ContentTypePEM, true
+		case ContentTypeDER, "application/octet-stream":
+			return ContentTypeDER, true
+		case ContentTypeJWK, "application/json":
+			return ContentTypeJWK, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,134 @@
+package api
+
+import (
+	"crypto/ecdsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/assert"
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/crypto"
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/domain"
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/persistence"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func newTestServer() *Server {
+	return NewServer("", persistence.NewLocalStorage())
+}
+
+func createTestDevice(t *testing.T, server *Server, algorithm domain.CryptoAlgorithmType) string {
+	publicKey, privateKey, err := crypto.GenerateKeyPair(algorithm)
+	assert.ShouldBe(t, err, nil)
+	deviceId, _ := server.storage.CreateSignatureDevice("test", algorithm, "")
+	server.storage.SetDeviceKeys(deviceId, publicKey, privateKey)
+	return deviceId
+}
+
+func serveJSON[T any](t *testing.T, server *Server, method string, target string, body string, data *T) int {
+	response := httptest.NewRecorder()
+	server.Handler().ServeHTTP(response, httptest.NewRequest(method, target, strings.NewReader(body)))
+	var envelope struct {
+		Data *T `json:"data"`
+	}
+	envelope.Data = data
+	if response.Code == http.StatusOK || response.Code == http.StatusCreated {
+		assert.ShouldBe(t, json.Unmarshal(response.Body.Bytes(), &envelope), nil)
+	}
+	return response.Code
+}
+
+func requestPublicKey(server *Server, deviceId string, accept string) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(http.MethodGet, "/api/v0/devices/"+deviceId+"/public-key", nil)
+	request.SetPathValue("id", deviceId)
+	if accept != "" {
+		request.Header.Set("Accept", accept)
+	}
+	response := httptest.NewRecorder()
+	server.PublicKey(response, request)
+	return response
+}
+
+func TestServer_PublicKeyDefaultsToPEM(t *testing.T) {
+	server := newTestServer()
+	deviceId := createTestDevice(t, server, domain.RSA)
+	response := requestPublicKey(server, deviceId, "")
+	assert.ShouldBe(t, response.Code, http.StatusOK)
+	assert.ShouldBe(t, response.Header().Get("Content-Type"), ContentTypePEM)
+	block, _ := pem.Decode(response.Body.Bytes())
+	assert.ShouldBe(t, block.Type, "RSA PUBLIC KEY")
+	stored, _ := pem.Decode(server.storage.GetDevice(deviceId).PublicKey)
+	assert.ShouldBe(t, string(block.Bytes), string(stored.Bytes))
+
+	deviceId = createTestDevice(t, server, domain.ECC)
+	response = requestPublicKey(server, deviceId, ContentTypePEM)
+	block, _ = pem.Decode(response.Body.Bytes())
+	assert.ShouldBe(t, block.Type, "PUBLIC KEY")
+	_, err := x509.ParsePKIXPublicKey(block.Bytes)
+	assert.ShouldBe(t, err, nil)
+}
+
+func TestServer_PublicKeyHonoursQuality(t *testing.T) {
+	server := newTestServer()
+	deviceId := createTestDevice(t, server, domain.ECC)
+	response := requestPublicKey(server, deviceId, "application/jwk+json;q=0, application/x-pem-file")
+	assert.ShouldBe(t, response.Header().Get("Content-Type"), ContentTypePEM)
+	response = requestPublicKey(server, deviceId, "application/x-pem-file;q=0.5, application/pkix-key;q=0.8")
+	assert.ShouldBe(t, response.Header().Get("Content-Type"), ContentTypeDER)
+	response = requestPublicKey(server, deviceId, "application/jwk+json;q=0")
+	assert.ShouldBe(t, response.Code, http.StatusNotAcceptable)
+}
+
+func TestServer_PublicKeyAsDER(t *testing.T) {
+	server := newTestServer()
+	for _, algorithm := range []domain.CryptoAlgorithmType{domain.ECC, domain.RSA} {
+		deviceId := createTestDevice(t, server, algorithm)
+		response := requestPublicKey(server, deviceId, ContentTypeDER)
+		assert.ShouldBe(t, response.Code, http.StatusOK)
+		_, err := x509.ParsePKIXPublicKey(response.Body.Bytes())
+		assert.ShouldBe(t, err, nil)
+		device := server.storage.GetDevice(deviceId)
+		publicKey, _ := crypto.ParsePublicKey(device.Algorithm, device.PublicKey)
+		expected, _ := x509.MarshalPKIXPublicKey(publicKey)
+		assert.ShouldBe(t, response.Body.String(), string(expected))
+	}
+}
+
+func TestServer_PublicKeyAsJWK(t *testing.T) {
+	server := newTestServer()
+	rsaDeviceId := createTestDevice(t, server, domain.RSA)
+	response := requestPublicKey(server, rsaDeviceId, "application/jwk+json, */*;q=0.1")
+	assert.ShouldBe(t, response.Code, http.StatusOK)
+	var rsaJWK crypto.JWK
+	json.Unmarshal(response.Body.Bytes(), &rsaJWK)
+	assert.ShouldBe(t, rsaJWK.KeyType, "RSA")
+	assert.ShouldBe(t, rsaJWK.KeyId, rsaDeviceId)
+	assert.ShouldBe(t, rsaJWK.E, "AQAB")
+
+	eccDeviceId := createTestDevice(t, server, domain.ECC)
+	response = requestPublicKey(server, eccDeviceId, ContentTypeJWK)
+	var eccJWK crypto.JWK
+	json.Unmarshal(response.Body.Bytes(), &eccJWK)
+	assert.ShouldBe(t, eccJWK.KeyType, "EC")
+	assert.ShouldBe(t, eccJWK.Curve, "P-384")
+	assert.ShouldBe(t, len(eccJWK.X), 64)
+}
+
+func TestServer_PublicKeyMatchesSigningKey(t *testing.T) {
+	server := newTestServer()
+	deviceId := createTestDevice(t, server, domain.ECC)
+	device := server.storage.GetDevice(deviceId)
+	publicKey, err := crypto.ParsePublicKey(domain.ECC, device.PublicKey)
+	assert.ShouldBe(t, err, nil)
+	keyPair, _ := crypto.NewECCMarshaler().Decode(device.PrivateKey)
+	assert.ShouldBe(t, publicKey.(*ecdsa.PublicKey).Equal(keyPair.Public), true)
+}
+
+func TestServer_PublicKeyErrors(t *testing.T) {
+	server := newTestServer()
+	assert.ShouldBe(t, requestPublicKey(server, "missing", "").Code, http.StatusNotFound)
+	deviceId := createTestDevice(t, server, domain.RSA)
+	assert.ShouldBe(t, requestPublicKey(server, deviceId, "image/png").Code, http.StatusNotAcceptable)
+}
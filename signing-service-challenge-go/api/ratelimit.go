@@ -0,0 +1,159 @@
+package api
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/apiconfig"
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/ratelimit"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// WithRateLimits limits the requests per client, per tenant of a client certificate and per device.
+// Health probes and metrics scrapes are not limited.
+func WithRateLimits(limits apiconfig.RateLimits) Option {
+	return func(s *Server) {
+		s.rateLimiters = newRateLimiters(limits)
+	}
+}
+
+// scopeDevice is the scope of the device limit, which handlers also apply to devices named in the body.
+const scopeDevice = "device"
+
+// rateLimiter is the limiter of one scope, key extracts the key of a request in that scope.
+type rateLimiter struct {
+	scope   string
+	limiter *ratelimit.Limiter
+	key     func(request *http.Request) string
+}
+
+func newRateLimiters(limits apiconfig.RateLimits) []rateLimiter {
+	var limiters []rateLimiter
+	for _, limiter := range []rateLimiter{
+		{scope: "client", limiter: ratelimit.NewLimiter(limits.Client), key: clientKey},
+		{scope: "tenant", limiter: ratelimit.NewLimiter(limits.Tenant), key: tenant},
+		{scope: scopeDevice, limiter: ratelimit.NewLimiter(limits.Device), key: pathDeviceId},
+	} {
+		if limiter.limiter.Limit().Enabled() {
+			limiters = append(limiters, limiter)
+		}
+	}
+	return limiters
+}
+
+// HeaderAPIKey carries the API key of a client, which a gateway in front of the service checks.
+const HeaderAPIKey = "X-API-Key"
+
+// clientKey identifies a client by its API key, otherwise by the name of its verified
+// certificate and anonymous clients by their IP address. API keys are only kept hashed.
+func clientKey(request *http.Request) string {
+	if apiKey := request.Header.Get(HeaderAPIKey); apiKey != "" {
+		digest := sha256.Sum256([]byte(apiKey))
+		return "api-key:" + hex.EncodeToString(digest[:])
+	}
+	if identity := Client(request.Context()); identity != nil {
+		return "certificate:" + identity.Name
+	}
+	host, _, err := net.SplitHostPort(request.RemoteAddr)
+	if err != nil {
+		host = request.RemoteAddr
+	}
+	return "ip:" + host
+}
+
+func tenant(request *http.Request) string {
+	if identity := Client(request.Context()); identity != nil {
+		return identity.Tenant
+	}
+	return ""
+}
+
+func pathDeviceId(request *http.Request) string {
+	return request.PathValue("id")
+}
+
+// rateLimitTokens are the tokens the rate limits granted a request so far.
+type rateLimitTokens struct {
+	taken    []rateLimitToken
+	reported *ratelimit.Decision
+}
+
+type rateLimitToken struct {
+	limiter *ratelimit.Limiter
+	key     string
+}
+
+// take takes a token from the bucket of key. If there is none, it refunds the tokens taken
+// so far, since requests that are turned away do not count against the other limits, and
+// writes the 429 response. The headers report the most restrictive limit.
+func (t *rateLimitTokens) take(s *Server, response http.ResponseWriter, limiter rateLimiter, key string) bool {
+	decision := limiter.limiter.Allow(key)
+	if t.reported == nil || decision.Remaining < t.reported.Remaining || !decision.Allowed {
+		t.reported = &decision
+	}
+	writeRateLimitHeaders(response, *t.reported)
+	if !decision.Allowed {
+		for _, taken := range t.taken {
+			taken.limiter.Refund(taken.key)
+		}
+		t.taken = nil
+		s.rateLimited.Inc(limiter.scope)
+		response.Header().Set("Retry-After", strconv.Itoa(seconds(decision.RetryAfter)))
+		WriteErrorResponse(response, http.StatusTooManyRequests, []string{"rate limit per " + limiter.scope + " exceeded"})
+		return false
+	}
+	t.taken = append(t.taken, rateLimitToken{limiter.limiter, key})
+	return true
+}
+
+// rateLimit rejects requests that exceed any of the limits of their client, tenant or device
+// with 429 Too Many Requests. Every response reports the most restrictive limit in the
+// RateLimit-Limit, RateLimit-Remaining and RateLimit-Reset headers.
+func (s *Server) rateLimit(next http.Handler) http.Handler {
+	if len(s.rateLimiters) == 0 {
+		return next
+	}
+	return http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		tokens := &rateLimitTokens{}
+		for _, limiter := range s.rateLimiters {
+			key := limiter.key(request)
+			if key == "" {
+				continue
+			}
+			if !tokens.take(s, response, limiter, key) {
+				return
+			}
+		}
+		next.ServeHTTP(response, request.WithContext(context.WithValue(request.Context(), rateLimitKey, tokens)))
+	})
+}
+
+// limitDevice applies the device limit to a request that names its device in the body,
+// handlers call it once the body is decoded. It writes the 429 response if the limit is exceeded.
+func (s *Server) limitDevice(response http.ResponseWriter, request *http.Request, deviceId string) bool {
+	tokens, ok := request.Context().Value(rateLimitKey).(*rateLimitTokens)
+	if !ok || deviceId == "" {
+		return true
+	}
+	for _, limiter := range s.rateLimiters {
+		if limiter.scope == scopeDevice {
+			return tokens.take(s, response, limiter, deviceId)
+		}
+	}
+	return true
+}
+
+func writeRateLimitHeaders(response http.ResponseWriter, decision ratelimit.Decision) {
+	response.Header().Set("RateLimit-Limit", strconv.Itoa(decision.Limit))
+	response.Header().Set("RateLimit-Remaining", strconv.Itoa(decision.Remaining))
+	response.Header().Set("RateLimit-Reset", strconv.Itoa(seconds(decision.Reset)))
+}
+
+// seconds rounds a duration up to whole seconds, as the headers require.
+func seconds(duration time.Duration) int {
+	return int(math.Ceil(duration.Seconds()))
+}
@@ -0,0 +1,131 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/apiconfig"
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/assert"
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/domain"
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/ratelimit"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// slowly refills a token per hour, so no token returns while a test runs.
+const slowly = 1.0 / 3600
+
+func requestFrom(server *Server, method string, target string, body string, remoteAddr string) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(method, target, strings.NewReader(body))
+	request.RemoteAddr = remoteAddr
+	response := httptest.NewRecorder()
+	server.Handler().ServeHTTP(response, request)
+	return response
+}
+
+func TestServer_RateLimitsPerClient(t *testing.T) {
+	server := newTestServer()
+	WithRateLimits(apiconfig.RateLimits{Client: ratelimit.Limit{Rate: slowly, Burst: 2}})(server)
+	handler := server.Handler()
+
+	for remaining := 1; remaining >= 0; remaining-- {
+		response := requestFrom(server, http.MethodGet, "/api/v0/devices", "", "192.0.2.1:1234")
+		assert.ShouldBe(t, response.Code, http.StatusOK)
+		assert.ShouldBe(t, response.Header().Get("RateLimit-Limit"), "2")
+		assert.ShouldBe(t, response.Header().Get("RateLimit-Remaining"), strconv.Itoa(remaining))
+	}
+
+	// Anonymous clients are identified by their IP address, other headers do not matter.
+	request := httptest.NewRequest(http.MethodGet, "/api/v0/devices", nil)
+	request.RemoteAddr = "192.0.2.1:4321"
+	request.Header.Set("Authorization", "Bearer other")
+	response := httptest.NewRecorder()
+	handler.ServeHTTP(response, request)
+	assert.ShouldBe(t, response.Code, http.StatusTooManyRequests)
+	assert.ShouldBe(t, response.Header().Get("Retry-After"), "3600")
+	assert.ShouldBe(t, response.Header().Get("RateLimit-Remaining"), "0")
+	assert.ShouldBe(t, response.Header().Get("RateLimit-Reset"), "7200")
+	var body ErrorResponse
+	json.Unmarshal(response.Body.Bytes(), &body)
+	assert.ShouldBe(t, body.Errors[0].Message, "rate limit per client exceeded")
+	assert.ShouldBe(t, server.rateLimited.Value("client"), 1.0)
+
+	assert.ShouldBe(t, requestFrom(server, http.MethodGet, "/api/v0/devices", "", "192.0.2.2:1234").Code, http.StatusOK)
+
+	// Clients with a certificate are identified by it, whatever address they connect from.
+	request = httptest.NewRequest(http.MethodGet, "/api/v0/devices", nil)
+	request = request.WithContext(context.WithValue(request.Context(), clientIdentityKey, &ClientIdentity{Name: "client.example.com"}))
+	response = httptest.NewRecorder()
+	handler.ServeHTTP(response, request)
+	assert.ShouldBe(t, response.Code, http.StatusOK)
+
+	// Clients with an API key are identified by it, whatever address they connect from.
+	withAPIKey := func(apiKey string, remoteAddr string) int {
+		request := httptest.NewRequest(http.MethodGet, "/api/v0/devices", nil)
+		request.RemoteAddr = remoteAddr
+		request.Header.Set(HeaderAPIKey, apiKey)
+		response := httptest.NewRecorder()
+		handler.ServeHTTP(response, request)
+		return response.Code
+	}
+	assert.ShouldBe(t, withAPIKey("alpha", "192.0.2.1:1234"), http.StatusOK)
+	assert.ShouldBe(t, withAPIKey("alpha", "192.0.2.3:1234"), http.StatusOK)
+	assert.ShouldBe(t, withAPIKey("alpha", "192.0.2.4:1234"), http.StatusTooManyRequests)
+	assert.ShouldBe(t, withAPIKey("beta", "192.0.2.4:1234"), http.StatusOK)
+}
+
+func TestServer_RateLimitsSkipProbes(t *testing.T) {
+	server := newTestServer()
+	WithRateLimits(apiconfig.RateLimits{Client: ratelimit.Limit{Rate: slowly, Burst: 1}})(server)
+	assert.ShouldBe(t, requestFrom(server, http.MethodGet, "/api/v0/devices", "", "192.0.2.1:1234").Code, http.StatusOK)
+	assert.ShouldBe(t, requestFrom(server, http.MethodGet, "/api/v0/devices", "", "192.0.2.1:1234").Code, http.StatusTooManyRequests)
+	for _, target := range []string{"/api/v0/health", "/api/v0/health/live", "/metrics"} {
+		response := requestFrom(server, http.MethodGet, target, "", "192.0.2.1:1234")
+		assert.ShouldBe(t, response.Code, http.StatusOK)
+		assert.ShouldBe(t, response.Header().Get("RateLimit-Limit"), "")
+	}
+}
+
+func TestServer_RateLimitsPerTenant(t *testing.T) {
+	server := newTestServer()
+	WithRateLimits(apiconfig.RateLimits{Tenant: ratelimit.Limit{Rate: slowly, Burst: 1}})(server)
+	handler := server.Handler()
+	serve := func(tenant string) int {
+		request := httptest.NewRequest(http.MethodGet, "/api/v0/devices", nil)
+		request = request.WithContext(context.WithValue(request.Context(), clientIdentityKey, &ClientIdentity{Name: tenant, Tenant: tenant}))
+		response := httptest.NewRecorder()
+		handler.ServeHTTP(response, request)
+		return response.Code
+	}
+	assert.ShouldBe(t, serve("acme"), http.StatusOK)
+	assert.ShouldBe(t, serve("acme"), http.StatusTooManyRequests)
+	assert.ShouldBe(t, serve("globex"), http.StatusOK)
+}
+
+func TestServer_RateLimitsPerDevice(t *testing.T) {
+	server := newTestServer()
+	WithRateLimits(apiconfig.RateLimits{
+		Client: ratelimit.Limit{Rate: slowly, Burst: 3},
+		Device: ratelimit.Limit{Rate: slowly, Burst: 1},
+	})(server)
+	deviceId := createTestDevice(t, server, domain.ECC)
+	body := `{"device_id": "` + deviceId + `", "data": "payload"}`
+
+	// sign-transaction names its device in the body, the handler applies the limit once it is decoded.
+	assert.ShouldBe(t, requestFrom(server, http.MethodPost, "/api/v0/sign-transaction", body, "192.0.2.1:1234").Code, http.StatusOK)
+	response := requestFrom(server, http.MethodPost, "/api/v0/devices/"+deviceId+"/sign-batch", `{"data": ["payload"]}`, "192.0.2.1:1234")
+	assert.ShouldBe(t, response.Code, http.StatusTooManyRequests)
+	var errorBody ErrorResponse
+	json.Unmarshal(response.Body.Bytes(), &errorBody)
+	assert.ShouldBe(t, errorBody.Errors[0].Message, "rate limit per device exceeded")
+	response = requestFrom(server, http.MethodPost, "/api/v0/sign-transaction", body, "192.0.2.1:1234")
+	assert.ShouldBe(t, response.Code, http.StatusTooManyRequests)
+	assert.ShouldBe(t, response.Header().Get("RateLimit-Remaining"), "0")
+
+	// The tokens the client gave for the rejected requests have been refunded.
+	response = requestFrom(server, http.MethodGet, "/api/v0/devices", "", "192.0.2.1:1234")
+	assert.ShouldBe(t, response.Code, http.StatusOK)
+	assert.ShouldBe(t, response.Header().Get("RateLimit-Remaining"), "1")
+}
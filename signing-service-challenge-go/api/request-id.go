@@ -0,0 +1,44 @@
+package api
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"net/http"
+	"regexp"
+)
+
+// HeaderRequestId carries the id that correlates a request with its response and log entries.
+const HeaderRequestId = "X-Request-ID"
+
+// contextKey keys the values the server attaches to request contexts.
+type contextKey int
+
+const (
+	requestIdKey contextKey = iota
+	requestLogKey
+	clientIdentityKey
+	rateLimitKey
+)
+
+// validRequestId restricts request ids taken from clients to short, log-safe values.
+var validRequestId = regexp.MustCompile(`^[A-Za-z0-9._-]{1,128}$`)
+
+// WithRequestId assigns every request an id, taken from the X-Request-ID header if it is
+// valid and generated otherwise. The id is echoed in the X-Request-ID response header
+// and available to handlers through RequestId.
+func WithRequestId(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		requestId := request.Header.Get(HeaderRequestId)
+		if !validRequestId.MatchString(requestId) {
+			requestId = uuid.New().String()
+		}
+		response.Header().Set(HeaderRequestId, requestId)
+		next.ServeHTTP(response, request.WithContext(context.WithValue(request.Context(), requestIdKey, requestId)))
+	})
+}
+
+// RequestId returns the id assigned to a request by WithRequestId, if any.
+func RequestId(ctx context.Context) string {
+	requestId, _ := ctx.Value(requestIdKey).(string)
+	return requestId
+}
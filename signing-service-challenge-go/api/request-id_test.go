@@ -0,0 +1,61 @@
+package api
+
+import (
+	"encoding/json"
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestWithRequestId(t *testing.T) {
+	handler := newTestServer().Handler()
+
+	request := httptest.NewRequest(http.MethodGet, "/api/v0/health", nil)
+	request.Header.Set(HeaderRequestId, "client-id.1")
+	response := httptest.NewRecorder()
+	handler.ServeHTTP(response, request)
+	assert.ShouldBe(t, response.Header().Get(HeaderRequestId), "client-id.1")
+
+	request = httptest.NewRequest(http.MethodGet, "/api/v0/health", nil)
+	request.Header.Set(HeaderRequestId, "not\nsafe")
+	response = httptest.NewRecorder()
+	handler.ServeHTTP(response, request)
+	assert.ShouldBe(t, len(response.Header().Get(HeaderRequestId)), 36)
+}
+
+func TestWriteProblem(t *testing.T) {
+	handler := newTestServer().Handler()
+	request := httptest.NewRequest(http.MethodPost, "/api/v0/sign-transaction",
+		strings.NewReader(`{"device_id": "4cc2a6b4-3f45-4b1e-9a51-7e1b6b2d7c10", "data": "data"}`))
+	request.Header.Set(HeaderRequestId, "abc")
+	response := httptest.NewRecorder()
+	handler.ServeHTTP(response, request)
+	assert.ShouldBe(t, response.Code, http.StatusNotFound)
+	assert.ShouldBe(t, response.Header().Get("Content-Type"), ContentTypeProblem)
+
+	var problem ErrorResponse
+	json.Unmarshal(response.Body.Bytes(), &problem)
+	assert.ShouldBe(t, problem.Type, ProblemTypeDefault)
+	assert.ShouldBe(t, problem.Title, "Not Found")
+	assert.ShouldBe(t, problem.Status, http.StatusNotFound)
+	assert.ShouldBe(t, problem.Detail, "device not found")
+	assert.ShouldBe(t, problem.Instance, "urn:request-id:abc")
+	assert.ShouldBe(t, problem.RequestId, "abc")
+
+	response = httptest.NewRecorder()
+	handler.ServeHTTP(response, httptest.NewRequest(http.MethodPost, "/api/v0/sign-transaction", strings.NewReader(`{}`)))
+	json.Unmarshal(response.Body.Bytes(), &problem)
+	assert.ShouldBe(t, problem.Type, ProblemTypeInvalidRequest)
+	assert.ShouldBe(t, problem.Status, http.StatusBadRequest)
+	assert.ShouldBe(t, problem.Detail, "data: is required; device_id: is required")
+}
+
+func TestWriteInternalError(t *testing.T) {
+	response := httptest.NewRecorder()
+	WriteInternalError(response)
+	assert.ShouldBe(t, response.Code, http.StatusInternalServerError)
+	assert.ShouldBe(t, response.Header().Get("Content-Type"), ContentTypeProblem)
+	assert.ShouldBe(t, response.Body.String(), `{"type":"about:blank","title":"Internal Server Error","status":500}`)
+}
@@ -0,0 +1,363 @@
+package api
+
+import (
+	"fmt"
+	"github.com/google/uuid"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// Schema is the subset of the OpenAPI 3 schema object used to describe the API types.
+type Schema struct {
+	Ref        string             `json:"$ref,omitempty"`
+	Type       string             `json:"type,omitempty"`
+	Format     string             `json:"format,omitempty"`
+	Properties map[string]*Schema `json:"properties,omitempty"`
+	Required   []string           `json:"required,omitempty"`
+	Items      *Schema            `json:"items,omitempty"`
+	Enum       []string           `json:"enum,omitempty"`
+	Pattern    string             `json:"pattern,omitempty"`
+	MinLength  *int               `json:"minLength,omitempty"`
+	MaxLength  *int               `json:"maxLength,omitempty"`
+	MinItems   *int               `json:"minItems,omitempty"`
+	MaxItems   *int               `json:"maxItems,omitempty"`
+	Minimum    *int               `json:"minimum,omitempty"`
+	Maximum    *int               `json:"maximum,omitempty"`
+
+	pattern *regexp.Regexp
+}
+
+// Schemas collects the schemas of named struct types, keyed by type name.
+type Schemas map[string]*Schema
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// SchemaOf returns the schema of a Go value. Named struct types are added to the schemas
+// and referenced. Struct fields are described by their json tag and constrained by
+// their schema tag, a comma separated list of "required" and keyword=value pairs,
+// where keywords prefixed with "items." constrain the items of an array.
+func (schemas Schemas) SchemaOf(t reflect.Type) (*Schema, error) {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch {
+	case t == timeType:
+		return &Schema{Type: "string", Format: "date-time"}, nil
+	case t.Kind() == reflect.Struct:
+		if _, ok := schemas[t.Name()]; !ok {
+			schemas[t.Name()] = &Schema{Type: "object"}
+			schema, err := schemas.structSchema(t)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", t.Name(), err)
+			}
+			schemas[t.Name()] = schema
+		}
+		return &Schema{Ref: "#/components/schemas/" + t.Name()}, nil
+	case t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.Uint8:
+		return &Schema{Type: "string", Format: "byte"}, nil
+	case t.Kind() == reflect.Slice:
+		items, err := schemas.SchemaOf(t.Elem())
+		if err != nil {
+			return nil, err
+		}
+		return &Schema{Type: "array", Items: items}, nil
+	case t.Kind() == reflect.String:
+		return &Schema{Type: "string"}, nil
+	case t.Kind() == reflect.Bool:
+		return &Schema{Type: "boolean"}, nil
+	case t.Kind() >= reflect.Int && t.Kind() <= reflect.Uint64:
+		return &Schema{Type: "integer"}, nil
+	case t.Kind() == reflect.Float32 || t.Kind() == reflect.Float64:
+		return &Schema{Type: "number"}, nil
+	default:
+		return &Schema{}, nil
+	}
+}
+
+// Resolve follows a reference to a named schema.
+func (schemas Schemas) Resolve(schema *Schema) *Schema {
+	if schema.Ref == "" {
+		return schema
+	}
+	return schemas[strings.TrimPrefix(schema.Ref, "#/components/schemas/")]
+}
+
+func (schemas Schemas) structSchema(t reflect.Type) (*Schema, error) {
+	schema := &Schema{
+		Type:       "object",
+		Properties: make(map[string]*Schema),
+	}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		// Embedded structs without a json name are flattened, like encoding/json does.
+		if field.Anonymous && field.Tag.Get("json") == "" {
+			embedded, err := schemas.SchemaOf(field.Type)
+			if err != nil {
+				return nil, err
+			}
+			embedded = schemas.Resolve(embedded)
+			for name, property := range embedded.Properties {
+				schema.Properties[name] = property
+			}
+			schema.Required = append(schema.Required, embedded.Required...)
+			continue
+		}
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		property, err := schemas.SchemaOf(field.Type)
+		if err != nil {
+			return nil, err
+		}
+		for _, constraint := range strings.Split(field.Tag.Get("schema"), ",") {
+			if constraint == "" {
+				continue
+			}
+			if constraint == "required" {
+				schema.Required = append(schema.Required, name)
+				continue
+			}
+			if err := applyConstraint(property, constraint); err != nil {
+				return nil, fmt.Errorf("field %s: %w", field.Name, err)
+			}
+		}
+		schema.Properties[name] = property
+	}
+	sort.Strings(schema.Required)
+	return schema, nil
+}
+
+func applyConstraint(schema *Schema, constraint string) error {
+	keyword, value, _ := strings.Cut(constraint, "=")
+	if rest, ok := strings.CutPrefix(keyword, "items."); ok && schema.Items != nil {
+		return applyConstraint(schema.Items, rest+"="+value)
+	}
+	var err error
+	switch keyword {
+	case "enum":
+		schema.Enum = strings.Split(value, "|")
+	case "format":
+		schema.Format = value
+	case "pattern":
+		schema.Pattern = value
+		schema.pattern, err = regexp.Compile(value)
+	case "minLength":
+		schema.MinLength, err = intConstraint(constraint, value)
+	case "maxLength":
+		schema.MaxLength, err = intConstraint(constraint, value)
+	case "minItems":
+		schema.MinItems, err = intConstraint(constraint, value)
+	case "maxItems":
+		schema.MaxItems, err = intConstraint(constraint, value)
+	case "minimum":
+		schema.Minimum, err = intConstraint(constraint, value)
+	case "maximum":
+		schema.Maximum, err = intConstraint(constraint, value)
+	default:
+		err = fmt.Errorf("unknown schema constraint %q", constraint)
+	}
+	return err
+}
+
+func intConstraint(constraint string, value string) (*int, error) {
+	number, err := strconv.Atoi(value)
+	if err != nil {
+		return nil, fmt.Errorf("schema constraint %q is not an integer", constraint)
+	}
+	return &number, nil
+}
+
+// compiledSchema is the schema of a type along with the named schemas it references.
+type compiledSchema struct {
+	schemas Schemas
+	schema  *Schema
+}
+
+// schemaCache builds the schema of each type once, it is shared by all requests.
+type schemaCache struct {
+	mutex sync.RWMutex
+	types map[reflect.Type]compiledSchema
+}
+
+// requestSchemas holds the schemas request bodies are validated against.
+var requestSchemas = &schemaCache{types: make(map[reflect.Type]compiledSchema)}
+
+// schemaOf returns the schema of a type, building it on first use.
+func (c *schemaCache) schemaOf(t reflect.Type) (compiledSchema, error) {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	c.mutex.RLock()
+	compiled, ok := c.types[t]
+	c.mutex.RUnlock()
+	if ok {
+		return compiled, nil
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if compiled, ok := c.types[t]; ok {
+		return compiled, nil
+	}
+	schemas := Schemas{}
+	schema, err := schemas.SchemaOf(t)
+	if err != nil {
+		return compiledSchema{}, err
+	}
+	compiled = compiledSchema{schemas: schemas, schema: schema}
+	c.types[t] = compiled
+	return compiled, nil
+}
+
+// compileRequestSchemas builds the schemas of the request bodies of the routes ahead of the
+// first request, so an invalid schema tag fails the start of the server.
+func compileRequestSchemas(routes []route) error {
+	types := []reflect.Type{reflect.TypeOf(SignStreamItem{})}
+	for _, route := range routes {
+		for _, op := range route.Operations {
+			if op.Request != nil {
+				types = append(types, reflect.TypeOf(op.Request))
+			}
+		}
+	}
+	for _, t := range types {
+		if _, err := requestSchemas.schemaOf(t); err != nil {
+			return fmt.Errorf("request schema %w", err)
+		}
+	}
+	return nil
+}
+
+// Validate checks a decoded JSON value against a schema and returns one error per violation
+// with the path of the offending field. Required fields must not be null or empty strings.
+func (schemas Schemas) Validate(schema *Schema, value interface{}, path string) []ErrorDetail {
+	schema = schemas.Resolve(schema)
+	if value == nil {
+		return nil
+	}
+	var errors []ErrorDetail
+	fail := func(code string, format string, args ...interface{}) {
+		errors = append(errors, ErrorDetail{
+			Code:    code,
+			Field:   fieldPath(path),
+			Message: fmt.Sprintf(format, args...),
+		})
+	}
+
+	switch schema.Type {
+	case "object":
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			fail(CodeInvalidType, "must be an object")
+			return errors
+		}
+		for _, name := range schema.Required {
+			if object[name] == nil || object[name] == "" {
+				errors = append(errors, ErrorDetail{
+					Code:    CodeRequired,
+					Field:   joinPath(path, name),
+					Message: "is required",
+				})
+			}
+		}
+		names := make([]string, 0, len(schema.Properties))
+		for name := range schema.Properties {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			errors = append(errors, schemas.Validate(schema.Properties[name], object[name], joinPath(path, name))...)
+		}
+	case "array":
+		array, ok := value.([]interface{})
+		if !ok {
+			fail(CodeInvalidType, "must be an array")
+			return errors
+		}
+		if schema.MinItems != nil && len(array) < *schema.MinItems {
+			fail(CodeTooFewItems, "must contain at least %d items", *schema.MinItems)
+		}
+		if schema.MaxItems != nil && len(array) > *schema.MaxItems {
+			fail(CodeTooManyItems, "must contain at most %d items", *schema.MaxItems)
+		}
+		for i, item := range array {
+			errors = append(errors, schemas.Validate(schema.Items, item, fmt.Sprintf("%s[%d]", path, i))...)
+		}
+	case "string":
+		text, ok := value.(string)
+		if !ok {
+			fail(CodeInvalidType, "must be a string")
+			return errors
+		}
+		// The empty string selects the default of optional fields, required fields reject it on their own.
+		if text == "" && schema.MinLength == nil {
+			return errors
+		}
+		if len(schema.Enum) > 0 && !contains(schema.Enum, text) {
+			fail(CodeInvalidEnum, "must be one of %s", strings.Join(schema.Enum, ", "))
+		}
+		length := utf8.RuneCountInString(text)
+		if schema.MinLength != nil && length < *schema.MinLength {
+			fail(CodeTooShort, "must be at least %d characters long", *schema.MinLength)
+		}
+		if schema.MaxLength != nil && length > *schema.MaxLength {
+			fail(CodeTooLong, "must be at most %d characters long", *schema.MaxLength)
+		}
+		if schema.pattern != nil && !schema.pattern.MatchString(text) {
+			fail(CodeInvalidFormat, "contains characters that are not allowed")
+		}
+		// Only the canonical form of a UUID is accepted.
+		if schema.Format == "uuid" && (len(text) != 36 || uuid.Validate(text) != nil) {
+			fail(CodeInvalidFormat, "must be a UUID")
+		}
+	case "integer":
+		number, ok := value.(float64)
+		if !ok || number != float64(int64(number)) {
+			fail(CodeInvalidType, "must be an integer")
+			return errors
+		}
+		if schema.Minimum != nil && number < float64(*schema.Minimum) {
+			fail(CodeOutOfRange, "must be at least %d", *schema.Minimum)
+		}
+		if schema.Maximum != nil && number > float64(*schema.Maximum) {
+			fail(CodeOutOfRange, "must be at most %d", *schema.Maximum)
+		}
+	}
+	return errors
+}
+
+func contains(values []string, value string) bool {
+	for _, candidate := range values {
+		if candidate == value {
+			return true
+		}
+	}
+	return false
+}
+
+func joinPath(path string, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+func fieldPath(path string) string {
+	if path == "" {
+		return "body"
+	}
+	return path
+}
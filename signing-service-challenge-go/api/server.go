@@ -1,9 +1,22 @@
 package api
 
 import (
+	"context"
+	"crypto/tls"
 	"encoding/json"
+	"errors"
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/apiconfig"
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/crypto"
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/export"
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/metrics"
 	"github.com/DrMonez/coding-challenges/signing-service-challenge/persistence"
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/signing"
+	"log/slog"
+	"net"
 	"net/http"
+	"strings"
+	"sync"
+	"time"
 )
 
 // Response is the generic API response container.
@@ -11,62 +24,370 @@ type Response struct {
 	Data interface{} `json:"data"`
 }
 
-// ErrorResponse is the generic error API response container.
+// ErrorResponse is the generic error API response, an RFC 7807 problem details document.
+// It is extended by the id of the request and the details of the individual errors.
 type ErrorResponse struct {
-	Errors []string `json:"errors"`
+	Type      string        `json:"type"`
+	Title     string        `json:"title"`
+	Status    int           `json:"status"`
+	Detail    string        `json:"detail,omitempty"`
+	Instance  string        `json:"instance,omitempty"`
+	RequestId string        `json:"request_id,omitempty"`
+	Errors    []ErrorDetail `json:"errors,omitempty"`
+	// Data holds the results committed before a request failed partway, like in a Response.
+	Data interface{} `json:"data,omitempty"`
+}
+
+const (
+	ContentTypeProblem = "application/problem+json"
+
+	// ProblemTypeDefault indicates that the problem has no semantics beyond its HTTP status.
+	ProblemTypeDefault = "about:blank"
+	// ProblemTypeInvalidRequest indicates that the request body failed validation, see the errors.
+	ProblemTypeInvalidRequest = "urn:signing-service:problem:invalid-request"
+	// ProblemTypePartialFailure indicates that the request failed after some of its results
+	// have been committed, see the data.
+	ProblemTypePartialFailure = "urn:signing-service:problem:partial-failure"
+)
+
+// ErrorDetail describes a single error by a machine-readable code and, for invalid
+// requests, the path of the offending field in the request body.
+type ErrorDetail struct {
+	Code    string `json:"code"`
+	Field   string `json:"field,omitempty"`
+	Message string `json:"message"`
+}
+
+// Error codes of invalid requests. Other errors use the snake case HTTP status text as code.
+const (
+	CodeInvalidJSON      = "invalid_json"
+	CodeRequired         = "required"
+	CodeInvalidType      = "invalid_type"
+	CodeInvalidEnum      = "invalid_enum"
+	CodeInvalidFormat    = "invalid_format"
+	CodeTooShort         = "too_short"
+	CodeTooLong          = "too_long"
+	CodeTooFewItems      = "too_few_items"
+	CodeTooManyItems     = "too_many_items"
+	CodeOutOfRange       = "out_of_range"
+	CodeMethodNotAllowed = "method_not_allowed"
+	CodeBodyTooLarge     = "body_too_large"
+)
+
+// String formats the error for plain text contexts.
+func (e ErrorDetail) String() string {
+	if e.Field == "" {
+		return e.Message
+	}
+	return e.Field + ": " + e.Message
+}
+
+// StatusCode returns the error code of an HTTP status, e.g. "not_found".
+func StatusCode(status int) string {
+	return strings.ReplaceAll(strings.ToLower(http.StatusText(status)), " ", "_")
 }
 
 // Server manages HTTP requests and dispatches them to the appropriate services.
 type Server struct {
 	listenAddress string
 	storage       persistence.Storage
+	signatures    *signing.Service
+	adminToken    string
+	authority     *crypto.CertificateAuthority
+	exportKey     *export.ServiceKey
+	logger        *slog.Logger
+	startedAt     time.Time
+	tlsCertFile   string
+	tlsKeyFile    string
+	clientCAFile  string
+	clientAuth    apiconfig.ClientAuth
+	clientTenants map[string]string
+	timeouts      apiconfig.Timeouts
+	maxBodySize   int64
+	rateLimiters  []rateLimiter
+
+	// certificateReloadInterval throttles the checks of the TLS certificate files for changes.
+	certificateReloadInterval time.Duration
+
+	httpServerOnce sync.Once
+	httpServer     *http.Server
+
+	registry        *metrics.Registry
+	requests        *metrics.CounterVec
+	requestDuration *metrics.HistogramVec
+	rateLimited     *metrics.CounterVec
+}
+
+// Option configures optional behaviour of a Server.
+type Option func(*Server)
+
+// WithAdminToken enables the admin API, authenticated by the given bearer token.
+func WithAdminToken(token string) Option {
+	return func(s *Server) {
+		s.adminToken = token
+	}
+}
+
+// WithCertificateAuthority enables issuing device certificates by the given certificate authority.
+func WithCertificateAuthority(authority *crypto.CertificateAuthority) Option {
+	return func(s *Server) {
+		s.authority = authority
+	}
+}
+
+// WithExportKey enables the export of signed archives, the key signs their manifests.
+func WithExportKey(key *export.ServiceKey) Option {
+	return func(s *Server) {
+		s.exportKey = key
+	}
+}
+
+// WithLogger makes the Server write its access and error logs to the given logger.
+func WithLogger(logger *slog.Logger) Option {
+	return func(s *Server) {
+		s.logger = logger
+	}
+}
+
+// WithMetrics makes the Server register its metrics in the given registry and serve
+// all metrics of the registry, e.g. those of the signing service, at /metrics.
+func WithMetrics(registry *metrics.Registry) Option {
+	return func(s *Server) {
+		s.registry = registry
+	}
+}
+
+// WithTLS makes the Server serve HTTPS with the PEM encoded certificate chain and private key in the given files.
+func WithTLS(certFile string, keyFile string) Option {
+	return func(s *Server) {
+		s.tlsCertFile = certFile
+		s.tlsKeyFile = keyFile
+	}
+}
+
+// WithClientCertificates enables mutual TLS: client certificates are verified against the
+// PEM encoded CA certificates in caFile and identify the tenant of a request, see Client.
+func WithClientCertificates(caFile string, auth apiconfig.ClientAuth) Option {
+	return func(s *Server) {
+		s.clientCAFile = caFile
+		s.clientAuth = auth
+	}
+}
+
+// WithClientTenants maps SANs and subject common names of client certificates to tenants.
+// Clients whose certificate has no mapped name are rejected.
+func WithClientTenants(tenants map[string]string) Option {
+	return func(s *Server) {
+		s.clientTenants = tenants
+	}
+}
+
+// WithTimeouts sets the timeouts of the connections of the Server.
+func WithTimeouts(timeouts apiconfig.Timeouts) Option {
+	return func(s *Server) {
+		s.timeouts = timeouts
+	}
+}
+
+// WithMaxBodySize limits the size of request bodies in bytes.
+func WithMaxBodySize(size int64) Option {
+	return func(s *Server) {
+		s.maxBodySize = size
+	}
+}
+
+// WithSigningService makes the Server share a signing service, e.g. with the gRPC server,
+// so signing stays serialized per device across both APIs.
+func WithSigningService(signatures *signing.Service) Option {
+	return func(s *Server) {
+		s.signatures = signatures
+	}
 }
 
 // NewServer is a factory to instantiate a new Server.
 func NewServer(
 	listenAddress string,
 	storage persistence.Storage,
+	options ...Option,
 ) *Server {
-	return &Server{
+	server := &Server{
 		listenAddress: listenAddress,
 		storage:       storage,
+		signatures:    signing.NewService(storage),
+		logger:        slog.Default(),
+		startedAt:     time.Now().UTC(),
+		timeouts:      apiconfig.DefaultTimeouts,
+		maxBodySize:   apiconfig.DefaultMaxBodySize,
+
+		certificateReloadInterval: CertificateReloadInterval,
 	}
+	for _, option := range options {
+		option(server)
+	}
+	if server.registry == nil {
+		server.registry = metrics.NewRegistry()
+	}
+	server.registerMetrics()
+	return server
 }
 
-// Run registers all HandlerFuncs for the existing HTTP routes and starts the Server.
+// Run starts the Server and blocks until it fails or has been shut down, which is not an error.
 func (s *Server) Run() error {
+	listener, err := net.Listen("tcp", s.listenAddress)
+	if err != nil {
+		return err
+	}
+	return s.Serve(listener)
+}
+
+// Serve accepts connections on the listener, over TLS if a certificate has been configured.
+// TLS certificates are reloaded when their files change.
+func (s *Server) Serve(listener net.Listener) error {
+	err := compileRequestSchemas(s.routes())
+	if err != nil {
+		listener.Close()
+		return err
+	}
+	if s.tlsCertFile != "" {
+		var config *tls.Config
+		config, err = s.tlsConfig()
+		if err != nil {
+			listener.Close()
+			return err
+		}
+		server := s.HTTPServer()
+		server.TLSConfig = config
+		err = server.ServeTLS(listener, "", "")
+	} else {
+		err = s.HTTPServer().Serve(listener)
+	}
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+// Shutdown stops accepting connections and waits until the requests in flight have been
+// answered, or until ctx is done.
+func (s *Server) Shutdown(ctx context.Context) error {
+	return s.HTTPServer().Shutdown(ctx)
+}
+
+// HTTPServer returns the http.Server started by Run.
+func (s *Server) HTTPServer() *http.Server {
+	s.httpServerOnce.Do(func() {
+		s.httpServer = &http.Server{
+			Addr:              s.listenAddress,
+			Handler:           s.Handler(),
+			ReadHeaderTimeout: s.timeouts.ReadHeader,
+			ReadTimeout:       s.timeouts.Read,
+			WriteTimeout:      s.timeouts.Write,
+			IdleTimeout:       s.timeouts.Idle,
+			ErrorLog:          slog.NewLogLogger(s.logger.Handler(), slog.LevelWarn),
+		}
+	})
+	return s.httpServer
+}
+
+// Handler returns the handler of all HTTP routes including their middleware.
+func (s *Server) Handler() http.Handler {
 	mux := http.NewServeMux()
 
-	mux.Handle("/api/v0/health", http.HandlerFunc(s.Health))
-	mux.Handle("/api/v0/create-signature-device", http.HandlerFunc(s.CreateSignatureDevice))
-	mux.Handle("/api/v0/sign-transaction", http.HandlerFunc(s.SignTransaction))
+	for _, route := range s.routes() {
+		var handler http.Handler = route.Handler
+		if !route.probe() {
+			handler = s.rateLimit(handler)
+		}
+		if !route.streaming() {
+			handler = s.limitBody(handler)
+		}
+		mux.Handle(route.Pattern, s.trace(route.Pattern, handler))
+	}
+
+	return WithRequestId(s.accessLog(s.authenticateClient(s.measure(mux))))
+}
 
-	return http.ListenAndServe(s.listenAddress, mux)
+// limitBody rejects request bodies larger than the maximum body size while they are read.
+func (s *Server) limitBody(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		request.Body = http.MaxBytesReader(response, request.Body, s.maxBodySize)
+		next.ServeHTTP(response, request)
+	})
 }
 
 // WriteInternalError writes a default internal error message as an HTTP response.
 func WriteInternalError(w http.ResponseWriter) {
-	w.WriteHeader(http.StatusInternalServerError)
-	w.Write([]byte(http.StatusText(http.StatusInternalServerError)))
+	WriteProblem(w, ErrorResponse{Status: http.StatusInternalServerError})
 }
 
-// WriteErrorResponse takes an HTTP status code and a slice of errors
+// WriteErrorResponse takes an HTTP status code and a slice of error messages
 // and writes those as an HTTP error response in a structured format.
 func WriteErrorResponse(w http.ResponseWriter, code int, errors []string) {
-	w.WriteHeader(code)
+	details := make([]ErrorDetail, 0, len(errors))
+	for _, message := range errors {
+		details = append(details, ErrorDetail{
+			Code:    StatusCode(code),
+			Message: message,
+		})
+	}
+	WriteErrorDetails(w, code, details)
+}
 
-	errorResponse := ErrorResponse{
-		Errors: errors,
+// WriteProblem writes a problem details document as an HTTP error response.
+// The type and title default to those of the status, the instance identifies the request.
+func WriteProblem(w http.ResponseWriter, problem ErrorResponse) {
+	if problem.Type == "" {
+		problem.Type = ProblemTypeDefault
+	}
+	if problem.Title == "" {
+		problem.Title = http.StatusText(problem.Status)
+	}
+	// WithRequestId has already set the response header.
+	problem.RequestId = w.Header().Get(HeaderRequestId)
+	if problem.RequestId != "" {
+		problem.Instance = "urn:request-id:" + problem.RequestId
 	}
 
-	bytes, err := json.Marshal(errorResponse)
+	bytes, err := json.Marshal(problem)
 	if err != nil {
-		WriteInternalError(w)
+		bytes = []byte(`{"type":"about:blank","title":"Internal Server Error","status":500}`)
+		problem.Status = http.StatusInternalServerError
 	}
 
+	w.Header().Set("Content-Type", ContentTypeProblem)
+	w.WriteHeader(problem.Status)
 	w.Write(bytes)
 }
 
+// WriteMethodNotAllowed writes a method not allowed error response
+// that lists the allowed methods in the Allow header.
+func WriteMethodNotAllowed(w http.ResponseWriter, allowed ...string) {
+	w.Header().Set("Allow", strings.Join(allowed, ", "))
+	WriteErrorDetails(w, http.StatusMethodNotAllowed, []ErrorDetail{{
+		Code:    CodeMethodNotAllowed,
+		Message: http.StatusText(http.StatusMethodNotAllowed),
+	}})
+}
+
+// WriteErrorDetails takes an HTTP status code and a slice of error details
+// and writes those as an HTTP error response in a structured format.
+func WriteErrorDetails(w http.ResponseWriter, code int, errors []ErrorDetail) {
+	WriteProblem(w, ErrorResponse{
+		Status: code,
+		Detail: problemDetail(errors),
+		Errors: errors,
+	})
+}
+
+// problemDetail summarizes error details in a human-readable sentence.
+func problemDetail(errors []ErrorDetail) string {
+	messages := make([]string, 0, len(errors))
+	for _, detail := range errors {
+		messages = append(messages, detail.String())
+	}
+	return strings.Join(messages, "; ")
+}
+
 // WriteAPIResponse takes an HTTP status code and a generic data struct
 // and writes those as an HTTP response in a structured format.
 func WriteAPIResponse(w http.ResponseWriter, code int, data interface{}) {
@@ -83,3 +404,11 @@ func WriteAPIResponse(w http.ResponseWriter, code int, data interface{}) {
 
 	w.Write(bytes)
 }
+
+// WriteRawResponse takes an HTTP status code, a content type and a body
+// and writes them unwrapped as an HTTP response.
+func WriteRawResponse(w http.ResponseWriter, code int, contentType string, body []byte) {
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(code)
+	w.Write(body)
+}
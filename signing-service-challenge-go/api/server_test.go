@@ -0,0 +1,55 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/apiconfig"
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/assert"
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/domain"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestServer_RejectsLargeBodies(t *testing.T) {
+	server := newTestServer()
+	WithMaxBodySize(64)(server)
+	response := httptest.NewRecorder()
+	server.Handler().ServeHTTP(response, httptest.NewRequest(http.MethodPost, "/api/v0/create-signature-device",
+		strings.NewReader(`{"algorithm": "ECC", "label": "`+strings.Repeat("a", 64)+`"}`)))
+	assert.ShouldBe(t, response.Code, http.StatusRequestEntityTooLarge)
+
+	var body ErrorResponse
+	json.Unmarshal(response.Body.Bytes(), &body)
+	assert.ShouldBe(t, body.Errors[0].Code, CodeBodyTooLarge)
+	assert.ShouldBe(t, body.Errors[0].Message, "request body must not be larger than 64 bytes")
+}
+
+func TestServer_SignStreamIsExemptFromMaxBodySize(t *testing.T) {
+	server := newTestServer()
+	WithMaxBodySize(64)(server)
+	deviceId := createTestDevice(t, server, domain.ECC)
+	body := strings.Repeat(`{"data": "`+strings.Repeat("a", 32)+`"}`+"\n", 4)
+	response := httptest.NewRecorder()
+	server.Handler().ServeHTTP(response, httptest.NewRequest(http.MethodPost, "/api/v0/devices/"+deviceId+"/sign-stream", strings.NewReader(body)))
+	assert.ShouldBe(t, response.Code, http.StatusOK)
+	assert.ShouldBe(t, strings.Count(response.Body.String(), `"signature_counter"`), 4)
+}
+
+func TestServer_Shutdown(t *testing.T) {
+	server := newTestServer()
+	server.listenAddress = "127.0.0.1:0"
+	WithTimeouts(apiconfig.Timeouts{Read: time.Second, Write: 2 * time.Second})(server)
+	assert.ShouldBe(t, server.HTTPServer().ReadTimeout, time.Second)
+	assert.ShouldBe(t, server.HTTPServer().WriteTimeout, 2*time.Second)
+
+	stopped := make(chan error)
+	go func() {
+		stopped <- server.Run()
+	}()
+	assert.ShouldBe(t, server.Shutdown(context.Background()), nil)
+	// A server that has been shut down has stopped as requested, which is not a failure.
+	assert.ShouldBe(t, <-stopped, nil)
+}
@@ -0,0 +1,134 @@
+package api
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/domain"
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/signing"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+const (
+	ContentTypeNDJSON = "application/x-ndjson"
+
+	// MaxStreamLineSize limits the size of a single NDJSON line of a signing stream.
+	MaxStreamLineSize = 1 << 20
+)
+
+type SignStreamItem struct {
+	Data string `json:"data" schema:"required,maxLength=65536"`
+}
+
+type SignStreamResult struct {
+	Line int `json:"line"`
+	*SignTransactionResponse
+	Error string `json:"error,omitempty"`
+}
+
+// errInvalidLine marks NDJSON lines that cannot be decoded or are invalid. They are reported and skipped.
+var errInvalidLine = errors.New("invalid line")
+
+// SignStream reads newline-delimited JSON transactions from the request body and writes
+// one NDJSON result per line back as soon as it has been signed.
+// The signature format is selected by the format query parameter.
+func (s *Server) SignStream(response http.ResponseWriter, request *http.Request) {
+	if request.Method != http.MethodPost {
+		WriteMethodNotAllowed(response, http.MethodPost)
+		return
+	}
+	deviceId := request.PathValue("id")
+	format := domain.SignatureFormat(request.URL.Query().Get("format"))
+	// Fail fast with a regular error response before the stream starts.
+	if err := signing.ValidateFormat(format); err != nil {
+		s.writeSigningError(response, request, err)
+		return
+	}
+	if s.tracedStorage(request).GetDevice(deviceId) == nil {
+		s.writeSigningError(response, request, signing.ErrDeviceNotFound)
+		return
+	}
+
+	controller := http.NewResponseController(response)
+	// A stream lasts as long as the client keeps sending, the connection timeouts would cut it off.
+	// Transports without deadlines have no timeouts to lift.
+	_ = controller.SetReadDeadline(time.Time{})
+	_ = controller.SetWriteDeadline(time.Time{})
+	// Reading the request while writing the response is not supported by every
+	// transport, results are still written in order if it is not.
+	_ = controller.EnableFullDuplex()
+	response.Header().Set("Content-Type", ContentTypeNDJSON)
+	response.WriteHeader(http.StatusOK)
+
+	scanner := bufio.NewScanner(request.Body)
+	scanner.Buffer(make([]byte, 0, 64*1024), MaxStreamLineSize)
+	encoder := json.NewEncoder(response)
+	line := 0
+
+	next := func() (string, error) {
+		for scanner.Scan() {
+			line++
+			if len(scanner.Bytes()) == 0 {
+				continue
+			}
+			var item SignStreamItem
+			message := ""
+			if !json.Valid(scanner.Bytes()) {
+				message = errInvalidLine.Error()
+			} else if errs := DecodeValid(scanner.Bytes(), &item); len(errs) > 0 {
+				message = errInvalidLine.Error() + ": " + problemDetail(errs)
+			}
+			if message != "" {
+				if err := s.writeStreamResult(controller, encoder, SignStreamResult{Line: line, Error: message}); err != nil {
+					return "", err
+				}
+				continue
+			}
+			return item.Data, nil
+		}
+		if err := scanner.Err(); err != nil {
+			return "", err
+		}
+		return "", io.EOF
+	}
+	emit := func(signature *signing.Signature) error {
+		signTransactionResponse := newSignTransactionResponse(signature, format)
+		return s.writeStreamResult(controller, encoder, SignStreamResult{Line: line, SignTransactionResponse: &signTransactionResponse})
+	}
+
+	_, err := s.signatures.SignStream(request.Context(), deviceId, format, next, emit)
+	if err != nil {
+		s.writeStreamResult(controller, encoder, SignStreamResult{Line: line, Error: fmt.Sprintf("stream aborted: %s", s.streamError(request, err))})
+	}
+}
+
+func (s *Server) writeStreamResult(controller *http.ResponseController, encoder *json.Encoder, result SignStreamResult) error {
+	err := encoder.Encode(result)
+	if err != nil {
+		return err
+	}
+	return controller.Flush()
+}
+
+// streamError hides internal error details from the client, they are logged instead.
+func (s *Server) streamError(request *http.Request, err error) string {
+	switch {
+	case errors.Is(err, signing.ErrDeviceNotFound),
+		errors.Is(err, signing.ErrDeviceRetired),
+		errors.Is(err, signing.ErrUnsupportedFormat),
+		errors.Is(err, signing.ErrShuttingDown),
+		errors.Is(err, bufio.ErrTooLong):
+		return err.Error()
+	default:
+		s.logger.LogAttrs(request.Context(), slog.LevelError, "stream aborted",
+			slog.String("route", request.Pattern),
+			slog.String("request_id", RequestId(request.Context())),
+			slog.String("error", err.Error()),
+		)
+		return http.StatusText(http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,50 @@
+package api
+
+import (
+	"bufio"
+	"encoding/json"
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/assert"
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/domain"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestServer_SignStream(t *testing.T) {
+	server := newTestServer()
+	deviceId := createTestDevice(t, server, domain.RSA)
+	body := `{"data": "a"}` + "\n" + `not json` + "\n\n" + `{"data": "b"}` + "\n"
+	request := httptest.NewRequest(http.MethodPost, "/api/v0/devices/"+deviceId+"/sign-stream?format=jws", strings.NewReader(body))
+	request.SetPathValue("id", deviceId)
+	response := httptest.NewRecorder()
+	server.SignStream(response, request)
+	assert.ShouldBe(t, response.Code, http.StatusOK)
+	assert.ShouldBe(t, response.Header().Get("Content-Type"), ContentTypeNDJSON)
+
+	var results []SignStreamResult
+	scanner := bufio.NewScanner(response.Body)
+	for scanner.Scan() {
+		var result SignStreamResult
+		json.Unmarshal(scanner.Bytes(), &result)
+		results = append(results, result)
+	}
+	assert.ShouldBe(t, len(results), 3)
+	assert.ShouldBe(t, results[0].Line, 1)
+	assert.ShouldBe(t, results[0].SignatureCounter, 0)
+	assert.ShouldNotBe(t, results[0].Envelope, "")
+	assert.ShouldBe(t, results[1].Line, 2)
+	assert.ShouldBe(t, results[1].Error, errInvalidLine.Error())
+	assert.ShouldBe(t, results[2].Line, 4)
+	assert.ShouldBe(t, results[2].SignedData, "1_b_"+results[0].Signature)
+	assert.ShouldBe(t, server.storage.GetDeviceSignaturesCount(deviceId), 2)
+}
+
+func TestServer_SignStreamUnknownDevice(t *testing.T) {
+	server := newTestServer()
+	request := httptest.NewRequest(http.MethodPost, "/api/v0/devices/missing/sign-stream", strings.NewReader(`{"data": "a"}`))
+	request.SetPathValue("id", "missing")
+	response := httptest.NewRecorder()
+	server.SignStream(response, request)
+	assert.ShouldBe(t, response.Code, http.StatusNotFound)
+}
@@ -0,0 +1,193 @@
+package api
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/apiconfig"
+	"log/slog"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// CertificateReloadInterval is the minimum time between two checks of the certificate files for changes.
+const CertificateReloadInterval = time.Second
+
+// certificateReloader serves the certificate in a pair of PEM files and reloads it once the files
+// change, so renewed certificates are picked up without a restart. A broken renewal keeps
+// the previous certificate in place.
+type certificateReloader struct {
+	certFile string
+	keyFile  string
+	interval time.Duration
+	logger   *slog.Logger
+
+	mutex       sync.Mutex
+	certificate *tls.Certificate
+	modified    time.Time
+	checked     time.Time
+}
+
+func newCertificateReloader(certFile string, keyFile string, interval time.Duration, logger *slog.Logger) (*certificateReloader, error) {
+	reloader := &certificateReloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+		interval: interval,
+		logger:   logger,
+	}
+	if err := reloader.load(); err != nil {
+		return nil, err
+	}
+	return reloader, nil
+}
+
+// load reads the certificate and records the modification time of the newest file.
+func (r *certificateReloader) load() error {
+	modified, err := r.modifiedAt()
+	if err != nil {
+		return err
+	}
+	certificate, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+	r.certificate = &certificate
+	r.modified = modified
+	return nil
+}
+
+func (r *certificateReloader) modifiedAt() (time.Time, error) {
+	var modified time.Time
+	for _, file := range []string{r.certFile, r.keyFile} {
+		info, err := os.Stat(file)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if info.ModTime().After(modified) {
+			modified = info.ModTime()
+		}
+	}
+	return modified, nil
+}
+
+// GetCertificate implements tls.Config.GetCertificate.
+func (r *certificateReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if now := time.Now(); now.Sub(r.checked) >= r.interval {
+		r.checked = now
+		modified, err := r.modifiedAt()
+		if err == nil && !modified.Equal(r.modified) {
+			err = r.load()
+			if err == nil {
+				r.logger.Info("reloaded TLS certificate", "cert_file", r.certFile)
+			} else {
+				// A half-written renewal is retried once the files change again.
+				r.modified = modified
+			}
+		}
+		if err != nil {
+			r.logger.Error("could not reload TLS certificate, keeping the previous one", "cert_file", r.certFile, "error", err)
+		}
+	}
+	return r.certificate, nil
+}
+
+// tlsConfig builds the TLS configuration of the Server, it fails if the certificates cannot be loaded.
+func (s *Server) tlsConfig() (*tls.Config, error) {
+	reloader, err := newCertificateReloader(s.tlsCertFile, s.tlsKeyFile, s.certificateReloadInterval, s.logger)
+	if err != nil {
+		return nil, fmt.Errorf("could not load TLS certificate: %w", err)
+	}
+	config := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: reloader.GetCertificate,
+	}
+	if s.clientAuth == apiconfig.ClientAuthNone {
+		return config, nil
+	}
+	pem, err := os.ReadFile(s.clientCAFile)
+	if err != nil {
+		return nil, fmt.Errorf("could not read client CA certificates: %w", err)
+	}
+	config.ClientCAs = x509.NewCertPool()
+	if !config.ClientCAs.AppendCertsFromPEM(pem) {
+		return nil, errors.New("client CA file contains no PEM certificates")
+	}
+	config.ClientAuth = tls.VerifyClientCertIfGiven
+	if s.clientAuth == apiconfig.ClientAuthRequired {
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return config, nil
+}
+
+// ClientIdentity is the identity of a client that authenticated with a certificate.
+type ClientIdentity struct {
+	// Name is the SAN or subject common name of the certificate the tenant was mapped from.
+	Name   string
+	Tenant string
+}
+
+// Client returns the identity of the client of a request, nil for clients without certificate.
+func Client(ctx context.Context) *ClientIdentity {
+	identity, _ := ctx.Value(clientIdentityKey).(*ClientIdentity)
+	return identity
+}
+
+// certificateNames lists the names of a certificate in the order they are matched:
+// URI, DNS and email SANs, then the subject common name.
+func certificateNames(certificate *x509.Certificate) []string {
+	var names []string
+	for _, uri := range certificate.URIs {
+		names = append(names, uri.String())
+	}
+	names = append(names, certificate.DNSNames...)
+	names = append(names, certificate.EmailAddresses...)
+	if certificate.Subject.CommonName != "" {
+		names = append(names, certificate.Subject.CommonName)
+	}
+	return names
+}
+
+// identify maps a verified client certificate to a tenant. Without a tenant mapping, the first
+// name of the certificate is the tenant. With a mapping, the first mapped name wins and
+// certificates without a mapped name are not identified.
+func (s *Server) identify(certificate *x509.Certificate) *ClientIdentity {
+	names := certificateNames(certificate)
+	if len(s.clientTenants) == 0 {
+		if len(names) == 0 {
+			return nil
+		}
+		return &ClientIdentity{Name: names[0], Tenant: names[0]}
+	}
+	for _, name := range names {
+		if tenant, ok := s.clientTenants[name]; ok {
+			return &ClientIdentity{Name: name, Tenant: tenant}
+		}
+	}
+	return nil
+}
+
+// authenticateClient attaches the identity of a client certificate to the request.
+// Certificates that the TLS handshake has verified but that map to no tenant are rejected.
+func (s *Server) authenticateClient(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		if request.TLS == nil || len(request.TLS.VerifiedChains) == 0 {
+			next.ServeHTTP(response, request)
+			return
+		}
+		identity := s.identify(request.TLS.VerifiedChains[0][0])
+		if identity == nil {
+			WriteErrorResponse(response, http.StatusForbidden, []string{"client certificate is not mapped to a tenant"})
+			return
+		}
+		if entry, ok := request.Context().Value(requestLogKey).(*requestLog); ok {
+			entry.tenant = identity.Tenant
+		}
+		next.ServeHTTP(response, request.WithContext(context.WithValue(request.Context(), clientIdentityKey, identity)))
+	})
+}
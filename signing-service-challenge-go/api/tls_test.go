@@ -0,0 +1,195 @@
+package api
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/apiconfig"
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/assert"
+	"math/big"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testPKI issues certificates from a CA generated for a single test.
+type testPKI struct {
+	dir         string
+	certificate *x509.Certificate
+	key         *ecdsa.PrivateKey
+	pool        *x509.CertPool
+}
+
+func newTestPKI(t *testing.T) *testPKI {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.ShouldBe(t, err, nil)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.ShouldBe(t, err, nil)
+	certificate, _ := x509.ParseCertificate(der)
+	pki := &testPKI{dir: t.TempDir(), certificate: certificate, key: key, pool: x509.NewCertPool()}
+	pki.pool.AddCert(certificate)
+	os.WriteFile(pki.path("ca.pem"), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600)
+	return pki
+}
+
+func (p *testPKI) path(name string) string {
+	return filepath.Join(p.dir, name)
+}
+
+// issue creates a certificate and key for the template and writes them to <name>.pem and <name>-key.pem.
+func (p *testPKI) issue(t *testing.T, name string, template *x509.Certificate) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.ShouldBe(t, err, nil)
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(time.Hour)
+	der, err := x509.CreateCertificate(rand.Reader, template, p.certificate, &key.PublicKey, p.key)
+	assert.ShouldBe(t, err, nil)
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	assert.ShouldBe(t, err, nil)
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})
+	assert.ShouldBe(t, os.WriteFile(p.path(name+".pem"), certPEM, 0o600), nil)
+	assert.ShouldBe(t, os.WriteFile(p.path(name+"-key.pem"), keyPEM, 0o600), nil)
+	certificate, err := tls.X509KeyPair(certPEM, keyPEM)
+	assert.ShouldBe(t, err, nil)
+	return certificate
+}
+
+func (p *testPKI) issueServer(t *testing.T, serial int64) {
+	p.issue(t, "server", &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	})
+	// File systems with a coarse timestamp resolution would hide a rewrite within the same tick.
+	later := time.Now().Add(time.Duration(serial) * time.Second)
+	os.Chtimes(p.path("server.pem"), later, later)
+}
+
+func (p *testPKI) issueClient(t *testing.T, commonName string, uri string) tls.Certificate {
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(100),
+		Subject:      pkix.Name{CommonName: commonName},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	if uri != "" {
+		parsed, _ := url.Parse(uri)
+		template.URIs = []*url.URL{parsed}
+	}
+	return p.issue(t, "client", template)
+}
+
+// serveTLS starts the server on a local port and returns its base URL.
+func serveTLS(t *testing.T, server *Server) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.ShouldBe(t, err, nil)
+	go server.Serve(listener)
+	t.Cleanup(func() { server.Shutdown(context.Background()) })
+	return "https://" + listener.Addr().String()
+}
+
+func (p *testPKI) client(certificates ...tls.Certificate) *http.Client {
+	return &http.Client{Transport: &http.Transport{
+		DisableKeepAlives: true,
+		TLSClientConfig: &tls.Config{
+			RootCAs:      p.pool,
+			Certificates: certificates,
+		},
+	}}
+}
+
+func TestServer_ServeTLSReloadsCertificate(t *testing.T) {
+	pki := newTestPKI(t)
+	pki.issueServer(t, 2)
+	server := newTestServer()
+	WithTLS(pki.path("server.pem"), pki.path("server-key.pem"))(server)
+	server.certificateReloadInterval = 0
+	address := serveTLS(t, server)
+	client := pki.client()
+
+	servedSerial := func() int64 {
+		response, err := client.Get(address + "/api/v0/health")
+		assert.ShouldBe(t, err, nil)
+		response.Body.Close()
+		assert.ShouldBe(t, response.StatusCode, http.StatusOK)
+		return response.TLS.PeerCertificates[0].SerialNumber.Int64()
+	}
+	assert.ShouldBe(t, servedSerial(), int64(2))
+
+	pki.issueServer(t, 3)
+	assert.ShouldBe(t, servedSerial(), int64(3))
+
+	// A broken renewal keeps the previous certificate.
+	os.WriteFile(pki.path("server.pem"), []byte("broken"), 0o600)
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(pki.path("server.pem"), later, later)
+	assert.ShouldBe(t, servedSerial(), int64(3))
+}
+
+func TestServer_ServeTLSWithoutCertificate(t *testing.T) {
+	server := newTestServer()
+	WithTLS(filepath.Join(t.TempDir(), "missing.pem"), filepath.Join(t.TempDir(), "missing-key.pem"))(server)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.ShouldBe(t, err, nil)
+	assert.ShouldNotBe(t, server.Serve(listener), nil)
+}
+
+func TestServer_MutualTLS(t *testing.T) {
+	pki := newTestPKI(t)
+	pki.issueServer(t, 2)
+	server, logs := newLoggedTestServer()
+	WithTLS(pki.path("server.pem"), pki.path("server-key.pem"))(server)
+	WithClientCertificates(pki.path("ca.pem"), apiconfig.ClientAuthRequired)(server)
+	WithClientTenants(map[string]string{"spiffe://example.com/tenant-a/pos-1": "tenant-a"})(server)
+	address := serveTLS(t, server)
+
+	_, err := pki.client().Get(address + "/api/v0/health")
+	assert.ShouldNotBe(t, err, nil)
+
+	mapped := pki.issueClient(t, "Kasse 1", "spiffe://example.com/tenant-a/pos-1")
+	response, err := pki.client(mapped).Get(address + "/api/v0/health")
+	assert.ShouldBe(t, err, nil)
+	response.Body.Close()
+	assert.ShouldBe(t, response.StatusCode, http.StatusOK)
+	entries := logEntries(logs)
+	assert.ShouldBe(t, entries[len(entries)-1]["tenant"], "tenant-a")
+
+	unmapped := pki.issueClient(t, "Kasse 2", "")
+	response, err = pki.client(unmapped).Get(address + "/api/v0/health")
+	assert.ShouldBe(t, err, nil)
+	response.Body.Close()
+	assert.ShouldBe(t, response.StatusCode, http.StatusForbidden)
+}
+
+func TestServer_Identify(t *testing.T) {
+	server := newTestServer()
+	uri, _ := url.Parse("spiffe://example.com/tenant-b")
+	certificate := &x509.Certificate{
+		Subject:  pkix.Name{CommonName: "Kasse 3"},
+		URIs:     []*url.URL{uri},
+		DNSNames: []string{"pos-3.example.com"},
+	}
+	assert.ShouldBe(t, *server.identify(certificate), ClientIdentity{Name: "spiffe://example.com/tenant-b", Tenant: "spiffe://example.com/tenant-b"})
+
+	WithClientTenants(map[string]string{"Kasse 3": "tenant-c", "pos-3.example.com": "tenant-b"})(server)
+	assert.ShouldBe(t, *server.identify(certificate), ClientIdentity{Name: "pos-3.example.com", Tenant: "tenant-b"})
+}
@@ -0,0 +1,51 @@
+package api
+
+import (
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/persistence"
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/tracing"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"net/http"
+)
+
+// trace wraps the handler of a route in a server span that continues the trace context
+// propagated by the client, if any. It runs behind the mux, so the route is known.
+func (s *Server) trace(pattern string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		ctx := otel.GetTextMapPropagator().Extract(request.Context(), propagation.HeaderCarrier(request.Header))
+		ctx, span := tracing.Tracer().Start(ctx, request.Method+" "+pattern,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				attribute.String("http.request.method", request.Method),
+				attribute.String("http.route", pattern),
+				attribute.String("signing.request_id", RequestId(ctx)),
+			),
+		)
+		defer span.End()
+		if identity := Client(ctx); identity != nil {
+			span.SetAttributes(attribute.String("signing.tenant", identity.Tenant))
+		}
+		if entry, ok := ctx.Value(requestLogKey).(*requestLog); ok && span.SpanContext().HasTraceID() {
+			entry.traceId = span.SpanContext().TraceID().String()
+		}
+		recorder := &statusRecorder{ResponseWriter: response}
+
+		next.ServeHTTP(recorder, request.WithContext(ctx))
+
+		if recorder.status == 0 {
+			recorder.status = http.StatusOK
+		}
+		span.SetAttributes(attribute.Int("http.response.status_code", recorder.status))
+		if recorder.status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(recorder.status))
+		}
+	})
+}
+
+// tracedStorage returns the storage instrumented with spans that are children of the request span.
+func (s *Server) tracedStorage(request *http.Request) persistence.Storage {
+	return tracing.Storage(request.Context(), s.storage)
+}
@@ -0,0 +1,59 @@
+package api
+
+import (
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/assert"
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/domain"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestServer_Tracing(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	previousProvider, previousPropagator := otel.GetTracerProvider(), otel.GetTextMapPropagator()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() {
+		otel.SetTracerProvider(previousProvider)
+		otel.SetTextMapPropagator(previousPropagator)
+	})
+
+	server, logs := newLoggedTestServer()
+	deviceId := createTestDevice(t, server, domain.ECC)
+	logs.Reset()
+	request := httptest.NewRequest(http.MethodPost, "/api/v0/devices/"+deviceId+"/sign-batch", strings.NewReader(`{"data": ["a"]}`))
+	request.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	response := httptest.NewRecorder()
+	server.Handler().ServeHTTP(response, request)
+	assert.ShouldBe(t, response.Code, http.StatusOK)
+
+	var serverSpan, signerSpan sdktrace.ReadOnlySpan
+	storageSpans := 0
+	for _, span := range recorder.Ended() {
+		if span.SpanContext().TraceID().String() != "4bf92f3577b34da6a3ce929d0e0e4736" {
+			continue
+		}
+		switch {
+		case span.Name() == "POST /api/v0/devices/{id}/sign-batch":
+			serverSpan = span
+		case span.Name() == "Signer.Sign":
+			signerSpan = span
+		case strings.HasPrefix(span.Name(), "Storage."):
+			storageSpans++
+		}
+	}
+	assert.ShouldNotBe(t, serverSpan, nil)
+	assert.ShouldBe(t, serverSpan.SpanKind(), trace.SpanKindServer)
+	assert.ShouldBe(t, serverSpan.Parent().SpanID().String(), "00f067aa0ba902b7")
+	assert.ShouldNotBe(t, signerSpan, nil)
+	assert.ShouldNotBe(t, storageSpans, 0)
+
+	entries := logEntries(logs)
+	assert.ShouldBe(t, entries[len(entries)-1]["trace_id"], "4bf92f3577b34da6a3ce929d0e0e4736")
+}
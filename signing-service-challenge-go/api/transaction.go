@@ -0,0 +1,244 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/domain"
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/signing"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+type TransactionRequest struct {
+	// ProcessType may be left empty on updates and finishes to keep the current one.
+	ProcessType string                 `json:"process_type" schema:"maxLength=256"`
+	ProcessData string                 `json:"process_data" schema:"maxLength=65536"`
+	Format      domain.SignatureFormat `json:"format" schema:"enum=raw|jws|cose_sign1"`
+}
+
+type StartTransactionRequest struct {
+	ProcessType string                 `json:"process_type" schema:"required,maxLength=256"`
+	ProcessData string                 `json:"process_data" schema:"maxLength=65536"`
+	Format      domain.SignatureFormat `json:"format" schema:"enum=raw|jws|cose_sign1"`
+}
+
+type TransactionResponse struct {
+	DeviceId          string                  `json:"device_id"`
+	Number            int                     `json:"number"`
+	State             domain.TransactionState `json:"state"`
+	ProcessType       string                  `json:"process_type"`
+	ProcessData       string                  `json:"process_data"`
+	Revision          int                     `json:"revision"`
+	StartedAt         time.Time               `json:"started_at"`
+	UpdatedAt         time.Time               `json:"updated_at"`
+	FinishedAt        *time.Time              `json:"finished_at,omitempty"`
+	ForceClosed       bool                    `json:"force_closed,omitempty"`
+	SignatureCounters []int                   `json:"signature_counters"`
+}
+
+// TransactionStepResponse is a transaction as of a step along with the signature of that step.
+type TransactionStepResponse struct {
+	Transaction TransactionResponse     `json:"transaction"`
+	Signature   SignTransactionResponse `json:"signature"`
+}
+
+type OpenTransactionsResponse struct {
+	Transactions []TransactionResponse `json:"transactions"`
+}
+
+type CloseStaleTransactionsRequest struct {
+	MaxIdleSeconds int `json:"max_idle_seconds" schema:"required,minimum=1"`
+}
+
+type CloseStaleTransactionsResponse struct {
+	Closed []TransactionStepResponse `json:"closed"`
+	// Failed lists the numbers of the stale transactions that could not be closed. A transaction
+	// that has been closed but not timestamped is listed in both.
+	Failed []int `json:"failed,omitempty"`
+}
+
+func newTransactionResponse(transaction *domain.Transaction) TransactionResponse {
+	transactionResponse := TransactionResponse{
+		DeviceId:          transaction.DeviceId,
+		Number:            transaction.Number,
+		State:             transaction.State,
+		ProcessType:       transaction.ProcessType,
+		ProcessData:       transaction.ProcessData,
+		Revision:          transaction.Revision(),
+		StartedAt:         transaction.StartedAt,
+		UpdatedAt:         transaction.UpdatedAt,
+		ForceClosed:       transaction.ForceClosed,
+		SignatureCounters: transaction.SignatureCounters,
+	}
+	if !transaction.IsOpen() {
+		finishedAt := transaction.FinishedAt
+		transactionResponse.FinishedAt = &finishedAt
+	}
+	return transactionResponse
+}
+
+func newTransactionStepResponse(step *signing.TransactionStep, format domain.SignatureFormat) TransactionStepResponse {
+	return TransactionStepResponse{
+		Transaction: newTransactionResponse(step.Transaction),
+		Signature:   newSignTransactionResponse(step.Signature, format),
+	}
+}
+
+// pathTransactionNumber parses the number path parameter
+// and writes a not found response if it is not a transaction number.
+func pathTransactionNumber(response http.ResponseWriter, request *http.Request) (int, bool) {
+	number, err := strconv.Atoi(request.PathValue("number"))
+	if err != nil || number < 1 {
+		WriteErrorResponse(response, http.StatusNotFound, []string{signing.ErrTransactionNotFound.Error()})
+		return 0, false
+	}
+	return number, true
+}
+
+// StartTransaction starts the next transaction of a device with a signed start step.
+func (s *Server) StartTransaction(response http.ResponseWriter, request *http.Request) {
+	var body StartTransactionRequest
+	isValidRequest, errs := PostMethodTemplate(request, &body)
+	if !isValidRequest {
+		writeRequestErrors(response, errs)
+		return
+	}
+
+	deviceId := request.PathValue("id")
+	LogDeviceId(request.Context(), deviceId)
+	step, err := s.signatures.StartTransaction(request.Context(), deviceId, body.ProcessType, body.ProcessData, body.Format)
+	if err != nil && step != nil {
+		// The step is committed without a timestamp token.
+		s.writePartialSigningError(response, request, err, "", newTransactionStepResponse(step, body.Format))
+		return
+	}
+	if err != nil {
+		s.writeSigningError(response, request, err)
+		return
+	}
+	WriteAPIResponse(response, http.StatusCreated, newTransactionStepResponse(step, body.Format))
+}
+
+// Transaction returns a transaction of a device.
+func (s *Server) Transaction(response http.ResponseWriter, request *http.Request) {
+	if request.Method != http.MethodGet {
+		WriteMethodNotAllowed(response, http.MethodGet)
+		return
+	}
+	number, ok := pathTransactionNumber(response, request)
+	if !ok {
+		return
+	}
+
+	deviceId := request.PathValue("id")
+	LogDeviceId(request.Context(), deviceId)
+	transaction, err := s.signatures.Transaction(request.Context(), deviceId, number)
+	if err != nil {
+		s.writeSigningError(response, request, err)
+		return
+	}
+	WriteAPIResponse(response, http.StatusOK, newTransactionResponse(transaction))
+}
+
+// UpdateTransaction signs new process data for an open transaction.
+func (s *Server) UpdateTransaction(response http.ResponseWriter, request *http.Request) {
+	s.continueTransaction(response, request, s.signatures.UpdateTransaction)
+}
+
+// FinishTransaction signs the final process data of an open transaction and finishes it.
+func (s *Server) FinishTransaction(response http.ResponseWriter, request *http.Request) {
+	s.continueTransaction(response, request, s.signatures.FinishTransaction)
+}
+
+func (s *Server) continueTransaction(response http.ResponseWriter, request *http.Request, step func(ctx context.Context, deviceId string, number int, processType string, processData string, format domain.SignatureFormat) (*signing.TransactionStep, error)) {
+	var body TransactionRequest
+	isValidRequest, errs := PostMethodTemplate(request, &body)
+	if !isValidRequest {
+		writeRequestErrors(response, errs)
+		return
+	}
+	number, ok := pathTransactionNumber(response, request)
+	if !ok {
+		return
+	}
+
+	deviceId := request.PathValue("id")
+	LogDeviceId(request.Context(), deviceId)
+	transactionStep, err := step(request.Context(), deviceId, number, body.ProcessType, body.ProcessData, body.Format)
+	if err != nil && transactionStep != nil {
+		// The step is committed without a timestamp token.
+		s.writePartialSigningError(response, request, err, "", newTransactionStepResponse(transactionStep, body.Format))
+		return
+	}
+	if err != nil {
+		s.writeSigningError(response, request, err)
+		return
+	}
+	WriteAPIResponse(response, http.StatusOK, newTransactionStepResponse(transactionStep, body.Format))
+}
+
+// OpenTransactions lists the transactions of a device that have not been finished.
+func (s *Server) OpenTransactions(response http.ResponseWriter, request *http.Request) {
+	if request.Method != http.MethodGet {
+		WriteMethodNotAllowed(response, http.MethodGet)
+		return
+	}
+
+	deviceId := request.PathValue("id")
+	LogDeviceId(request.Context(), deviceId)
+	transactions, err := s.signatures.OpenTransactions(request.Context(), deviceId)
+	if err != nil {
+		s.writeSigningError(response, request, err)
+		return
+	}
+	openResponse := OpenTransactionsResponse{
+		Transactions: make([]TransactionResponse, 0, len(transactions)),
+	}
+	for _, transaction := range transactions {
+		openResponse.Transactions = append(openResponse.Transactions, newTransactionResponse(transaction))
+	}
+	WriteAPIResponse(response, http.StatusOK, openResponse)
+}
+
+// CloseStaleTransactions force-finishes the open transactions of a device that have been idle too long.
+// If some of them can not be closed, the response reports which were closed and which failed.
+func (s *Server) CloseStaleTransactions(response http.ResponseWriter, request *http.Request) {
+	if !s.authorizeAdmin(response, request) {
+		return
+	}
+	var body CloseStaleTransactionsRequest
+	isValidRequest, errs := PostMethodTemplate(request, &body)
+	if !isValidRequest {
+		writeRequestErrors(response, errs)
+		return
+	}
+
+	deviceId := request.PathValue("id")
+	LogDeviceId(request.Context(), deviceId)
+	maxIdle := time.Duration(body.MaxIdleSeconds) * time.Second
+	steps, err := s.signatures.CloseStaleTransactions(request.Context(), deviceId, maxIdle)
+	closeResponse := CloseStaleTransactionsResponse{
+		Closed: make([]TransactionStepResponse, 0, len(steps)),
+	}
+	for _, step := range steps {
+		closeResponse.Closed = append(closeResponse.Closed, newTransactionStepResponse(step, domain.FormatRaw))
+	}
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		for _, failure := range joined.Unwrap() {
+			var transactionError *signing.TransactionError
+			if errors.As(failure, &transactionError) {
+				closeResponse.Failed = append(closeResponse.Failed, transactionError.Number)
+			}
+		}
+	}
+	if len(closeResponse.Failed) > 0 {
+		s.writePartialSigningError(response, request, err, "", closeResponse)
+		return
+	}
+	if err != nil {
+		s.writeSigningError(response, request, err)
+		return
+	}
+	WriteAPIResponse(response, http.StatusOK, closeResponse)
+}
@@ -0,0 +1,84 @@
+package api
+
+import (
+	"encoding/json"
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/assert"
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/domain"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestServer_TransactionLifecycle(t *testing.T) {
+	server := newTestServer()
+	deviceId := createTestDevice(t, server, domain.ECC)
+	transactions := "/api/v0/devices/" + deviceId + "/transactions"
+
+	var started TransactionStepResponse
+	code := serveJSON(t, server, http.MethodPost, transactions, `{"process_type": "Kassenbeleg-V1", "process_data": "Beleg^0.00"}`, &started)
+	assert.ShouldBe(t, code, http.StatusCreated)
+	assert.ShouldBe(t, started.Transaction.Number, 1)
+	assert.ShouldBe(t, started.Transaction.State, domain.TransactionActive)
+	assert.ShouldBe(t, started.Transaction.FinishedAt == nil, true)
+	assert.ShouldBe(t, started.Signature.SignatureCounter, 0)
+
+	var updated TransactionStepResponse
+	code = serveJSON(t, server, http.MethodPost, transactions+"/1/update", `{"process_data": "Beleg^10.00", "format": "jws"}`, &updated)
+	assert.ShouldBe(t, code, http.StatusOK)
+	assert.ShouldBe(t, updated.Transaction.ProcessType, "Kassenbeleg-V1")
+	assert.ShouldBe(t, updated.Transaction.Revision, 2)
+	assert.ShouldBe(t, updated.Signature.Envelope != "", true)
+
+	var open OpenTransactionsResponse
+	code = serveJSON(t, server, http.MethodGet, transactions+"/open", "", &open)
+	assert.ShouldBe(t, code, http.StatusOK)
+	assert.ShouldBe(t, len(open.Transactions), 1)
+
+	var finished TransactionStepResponse
+	code = serveJSON(t, server, http.MethodPost, transactions+"/1/finish", `{"process_data": "Beleg^10.00"}`, &finished)
+	assert.ShouldBe(t, code, http.StatusOK)
+	assert.ShouldBe(t, finished.Transaction.State, domain.TransactionFinished)
+	assert.ShouldBe(t, finished.Transaction.FinishedAt.Equal(finished.Signature.Timestamp), true)
+
+	var transaction TransactionResponse
+	code = serveJSON(t, server, http.MethodGet, transactions+"/1", "", &transaction)
+	assert.ShouldBe(t, code, http.StatusOK)
+	assert.ShouldBe(t, len(transaction.SignatureCounters), 3)
+	assert.ShouldBe(t, transaction.SignatureCounters[2], 2)
+
+	open = OpenTransactionsResponse{}
+	serveJSON(t, server, http.MethodGet, transactions+"/open", "", &open)
+	assert.ShouldBe(t, len(open.Transactions), 0)
+}
+
+func TestServer_TransactionErrors(t *testing.T) {
+	server := newTestServer()
+	deviceId := createTestDevice(t, server, domain.ECC)
+	transactions := "/api/v0/devices/" + deviceId + "/transactions"
+	serveJSON(t, server, http.MethodPost, transactions, `{"process_type": "Kassenbeleg-V1"}`, &TransactionStepResponse{})
+	serveJSON(t, server, http.MethodPost, transactions+"/1/finish", `{}`, &TransactionStepResponse{})
+
+	var step TransactionStepResponse
+	assert.ShouldBe(t, serveJSON(t, server, http.MethodPost, transactions+"/1/update", `{}`, &step), http.StatusConflict)
+	assert.ShouldBe(t, serveJSON(t, server, http.MethodPost, transactions+"/2/update", `{}`, &step), http.StatusNotFound)
+	assert.ShouldBe(t, serveJSON(t, server, http.MethodGet, transactions+"/first", "", &step), http.StatusNotFound)
+	assert.ShouldBe(t, serveJSON(t, server, http.MethodPost, transactions, `{}`, &step), http.StatusBadRequest)
+	assert.ShouldBe(t, serveJSON(t, server, http.MethodGet, "/api/v0/devices/unknown/transactions/open", "", &step), http.StatusNotFound)
+	WithAdminToken("token")(server)
+	assert.ShouldBe(t, closeStale(server, transactions, `{"max_idle_seconds": 60}`, "").Code, http.StatusUnauthorized)
+	assert.ShouldBe(t, closeStale(server, transactions, `{"max_idle_seconds": 0}`, "token").Code, http.StatusBadRequest)
+
+	response := closeStale(server, transactions, `{"max_idle_seconds": 60}`, "token")
+	assert.ShouldBe(t, response.Code, http.StatusOK)
+	var closed struct {
+		Data CloseStaleTransactionsResponse `json:"data"`
+	}
+	assert.ShouldBe(t, json.Unmarshal(response.Body.Bytes(), &closed), nil)
+	assert.ShouldBe(t, len(closed.Data.Closed), 0)
+}
+
+func closeStale(server *Server, transactions string, body string, token string) *httptest.ResponseRecorder {
+	response := httptest.NewRecorder()
+	server.Handler().ServeHTTP(response, adminRequest(http.MethodPost, transactions+"/close-stale", body, token))
+	return response
+}
@@ -0,0 +1,47 @@
+// Package apiconfig holds the settings of the HTTP API that the configuration of the
+// service shares with the api package, so that neither has to import the other.
+package apiconfig
+
+import (
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/ratelimit"
+	"time"
+)
+
+// Timeouts bound the phases of HTTP connections, zero disables a timeout.
+// Signing streams are exempt from the read and write timeouts.
+type Timeouts struct {
+	ReadHeader time.Duration
+	Read       time.Duration
+	Write      time.Duration
+	Idle       time.Duration
+}
+
+// DefaultTimeouts leave enough time to sign a full batch with large RSA keys.
+var DefaultTimeouts = Timeouts{
+	ReadHeader: 5 * time.Second,
+	Read:       30 * time.Second,
+	Write:      60 * time.Second,
+	Idle:       120 * time.Second,
+}
+
+// DefaultMaxBodySize limits the size of request bodies, signing streams are exempt.
+const DefaultMaxBodySize = 8 << 20
+
+// ClientAuth selects whether clients have to present a certificate.
+type ClientAuth int
+
+const (
+	// ClientAuthNone does not ask clients for certificates.
+	ClientAuthNone ClientAuth = iota
+	// ClientAuthOptional verifies certificates of clients that present one.
+	ClientAuthOptional
+	// ClientAuthRequired rejects clients without a valid certificate during the handshake.
+	ClientAuthRequired
+)
+
+// RateLimits are the token bucket limits of the HTTP API, each disabled by a zero rate.
+type RateLimits struct {
+	Client ratelimit.Limit
+	Tenant ratelimit.Limit
+	Device ratelimit.Limit
+}
@@ -0,0 +1,41 @@
+// Package build reports the version of the running binary.
+package build
+
+import "runtime/debug"
+
+// version is set at build time by -ldflags "-X github.com/DrMonez/coding-challenges/signing-service-challenge/build.version=v1.2.3".
+// Without it, the version is taken from the module info embedded by the go command.
+var version string
+
+// Version returns the version of the binary, "(devel)" for builds outside of a module version.
+func Version() string {
+	if version != "" {
+		return version
+	}
+	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" {
+		return info.Main.Version
+	}
+	return "(devel)"
+}
+
+// Revision returns the VCS revision the binary was built from, with a "-dirty" suffix
+// for modified working trees, or the empty string if it is unknown.
+func Revision() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return ""
+	}
+	var revision, modified string
+	for _, setting := range info.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			revision = setting.Value
+		case "vcs.modified":
+			modified = setting.Value
+		}
+	}
+	if revision != "" && modified == "true" {
+		revision += "-dirty"
+	}
+	return revision
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/api"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// client calls the HTTP API of the signing service.
+type client struct {
+	baseURL string
+	apiKey  string
+	http    *http.Client
+}
+
+// clientOptions are the connection settings shared by all commands.
+type clientOptions struct {
+	server   string
+	apiKey   string
+	caFile   string
+	certFile string
+	keyFile  string
+	timeout  time.Duration
+}
+
+func newClient(options clientOptions) (*client, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if options.caFile != "" || options.certFile != "" {
+		transport.TLSClientConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+	}
+	if options.caFile != "" {
+		pem, err := os.ReadFile(options.caFile)
+		if err != nil {
+			return nil, err
+		}
+		roots := x509.NewCertPool()
+		if !roots.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("%s holds no PEM certificates", options.caFile)
+		}
+		transport.TLSClientConfig.RootCAs = roots
+	}
+	if options.certFile != "" {
+		certificate, err := tls.LoadX509KeyPair(options.certFile, options.keyFile)
+		if err != nil {
+			return nil, err
+		}
+		transport.TLSClientConfig.Certificates = []tls.Certificate{certificate}
+	}
+	return &client{
+		baseURL: strings.TrimSuffix(options.server, "/"),
+		apiKey:  options.apiKey,
+		http:    &http.Client{Transport: transport, Timeout: options.timeout},
+	}, nil
+}
+
+// apiError is an error response of the API.
+type apiError struct {
+	problem api.ErrorResponse
+}
+
+func (e *apiError) Error() string {
+	if e.problem.Detail == "" {
+		return fmt.Sprintf("%d %s", e.problem.Status, e.problem.Title)
+	}
+	return fmt.Sprintf("%d %s: %s", e.problem.Status, e.problem.Title, e.problem.Detail)
+}
+
+// do sends a request with an optional JSON body and returns the response if it succeeded.
+func (c *client) do(ctx context.Context, method string, path string, body interface{}, accept string) (*http.Response, error) {
+	var reader io.Reader
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(encoded)
+	}
+	request, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, reader)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		request.Header.Set("Content-Type", api.ContentTypeJSON)
+	}
+	if accept != "" {
+		request.Header.Set("Accept", accept)
+	}
+	if c.apiKey != "" {
+		request.Header.Set(api.HeaderAPIKey, c.apiKey)
+	}
+	response, err := c.http.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode >= 300 {
+		defer response.Body.Close()
+		problem := api.ErrorResponse{Status: response.StatusCode, Title: http.StatusText(response.StatusCode)}
+		json.NewDecoder(response.Body).Decode(&problem)
+		return nil, &apiError{problem: problem}
+	}
+	return response, nil
+}
+
+// call sends a request and decodes the data of the API response into a T.
+func call[T any](ctx context.Context, c *client, method string, path string, body interface{}) (*T, error) {
+	response, err := c.do(ctx, method, path, body, api.ContentTypeJSON)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	var envelope struct {
+		Data *T `json:"data"`
+	}
+	if err := json.NewDecoder(response.Body).Decode(&envelope); err != nil {
+		return nil, fmt.Errorf("could not decode the response of %s %s: %w", method, path, err)
+	}
+	if envelope.Data == nil {
+		return nil, fmt.Errorf("response of %s %s has no data", method, path)
+	}
+	return envelope.Data, nil
+}
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/api"
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/domain"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+)
+
+const (
+	OutputJSON = "json"
+	OutputCSV  = "csv"
+)
+
+// parse parses the flags of a command and checks the number of positional arguments.
+func parse(flags *flag.FlagSet, args []string, positional int) error {
+	flags.SetOutput(io.Discard)
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return err
+		}
+		return usageError{err.Error()}
+	}
+	if flags.NArg() != positional {
+		return usageError{fmt.Sprintf("expected %d arguments, got %d", positional, flags.NArg())}
+	}
+	return nil
+}
+
+// printJSON writes a value as indented JSON.
+func printJSON(w io.Writer, value interface{}) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(value)
+}
+
+// readInput reads the data of sign and verify from a file, or from stdin if no file is given.
+// The data is taken verbatim, including a trailing newline.
+func readInput(env *environment, file string) (string, error) {
+	if file == "" || file == "-" {
+		data, err := io.ReadAll(env.stdin)
+		return string(data), err
+	}
+	data, err := os.ReadFile(file)
+	return string(data), err
+}
+
+func devicePath(deviceId string, suffix string) string {
+	return "/api/v0/devices/" + url.PathEscape(deviceId) + suffix
+}
+
+func listDevices(ctx context.Context, env *environment, args []string) error {
+	if err := parse(flag.NewFlagSet("devices list", flag.ContinueOnError), args, 0); err != nil {
+		return err
+	}
+	devices, err := call[api.ListSignatureDevicesResponse](ctx, env.client, http.MethodGet, "/api/v0/devices", nil)
+	if err != nil {
+		return err
+	}
+	return printJSON(env.stdout, devices.Devices)
+}
+
+func createDevice(ctx context.Context, env *environment, args []string) error {
+	flags := flag.NewFlagSet("devices create", flag.ContinueOnError)
+	var request api.CreateSignatureDeviceRequest
+	algorithm := flags.String("algorithm", "", "algorithm of the key pair, ECC or RSA")
+	flags.StringVar(&request.Label, "label", "", "label of the device")
+	flags.StringVar(&request.Id, "id", "", "UUID of the device, generated if missing")
+	if err := parse(flags, args, 0); err != nil {
+		return err
+	}
+	if *algorithm == "" {
+		return usageError{"-algorithm is required"}
+	}
+	request.Algorithm = domain.CryptoAlgorithmType(*algorithm)
+	device, err := call[api.CreateSignatureDeviceResponse](ctx, env.client, http.MethodPost, "/api/v0/create-signature-device", request)
+	if err != nil {
+		return err
+	}
+	return printJSON(env.stdout, device)
+}
+
+func showDevice(ctx context.Context, env *environment, args []string) error {
+	flags := flag.NewFlagSet("devices show", flag.ContinueOnError)
+	if err := parse(flags, args, 1); err != nil {
+		return err
+	}
+	device, err := call[api.DeviceResponse](ctx, env.client, http.MethodGet, devicePath(flags.Arg(0), ""), nil)
+	if err != nil {
+		return err
+	}
+	return printJSON(env.stdout, device)
+}
+
+func sign(ctx context.Context, env *environment, args []string) error {
+	flags := flag.NewFlagSet("sign", flag.ContinueOnError)
+	var request api.SignTransactionRequest
+	flags.StringVar(&request.DeviceId, "device", "", "id of the signing device")
+	format := flags.String("format", "", "signature format, raw, jws or cose_sign1")
+	file := flags.String("file", "", "file holding the data, - or missing for stdin")
+	if err := parse(flags, args, 0); err != nil {
+		return err
+	}
+	if request.DeviceId == "" {
+		return usageError{"-device is required"}
+	}
+	request.Format = domain.SignatureFormat(*format)
+	data, err := readInput(env, *file)
+	if err != nil {
+		return err
+	}
+	request.Data = data
+	signature, err := call[api.SignTransactionResponse](ctx, env.client, http.MethodPost, "/api/v0/sign-transaction", request)
+	if err != nil {
+		return err
+	}
+	return printJSON(env.stdout, signature)
+}
+
+// verify prints the verification result and fails if the signature is not valid.
+func verify(ctx context.Context, env *environment, args []string) error {
+	flags := flag.NewFlagSet("verify", flag.ContinueOnError)
+	var request api.VerifySignatureRequest
+	flags.StringVar(&request.DeviceId, "device", "", "id of the signing device")
+	flags.StringVar(&request.Signature, "signature", "", "base64 encoded signature")
+	format := flags.String("format", "", "signature format, raw, jws or cose_sign1")
+	file := flags.String("file", "", "file holding the signed data, - or missing for stdin")
+	if err := parse(flags, args, 0); err != nil {
+		return err
+	}
+	if request.DeviceId == "" || request.Signature == "" {
+		return usageError{"-device and -signature are required"}
+	}
+	request.Format = domain.SignatureFormat(*format)
+	signedData, err := readInput(env, *file)
+	if err != nil {
+		return err
+	}
+	request.SignedData = signedData
+	verification, err := call[api.VerifySignatureResponse](ctx, env.client, http.MethodPost, "/api/v0/verify-signature", request)
+	if err != nil {
+		return err
+	}
+	if err := printJSON(env.stdout, verification); err != nil {
+		return err
+	}
+	if !verification.Valid {
+		return errFailed
+	}
+	return nil
+}
+
+func exportPublicKey(ctx context.Context, env *environment, args []string) error {
+	flags := flag.NewFlagSet("public-key", flag.ContinueOnError)
+	format := flags.String("format", "pem", "key format, pem, der or jwk")
+	if err := parse(flags, args, 1); err != nil {
+		return err
+	}
+	contentTypes := map[string]string{"pem": api.ContentTypePEM, "der": api.ContentTypeDER, "jwk": api.ContentTypeJWK}
+	contentType, ok := contentTypes[*format]
+	if !ok {
+		return usageError{fmt.Sprintf("-format %q is unknown, must be pem, der or jwk", *format)}
+	}
+	response, err := env.client.do(ctx, http.MethodGet, devicePath(flags.Arg(0), "/public-key"), nil, contentType)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	_, err = io.Copy(env.stdout, response.Body)
+	return err
+}
+
+// audit prints the audit of a signature chain and fails if the chain is not valid.
+func audit(ctx context.Context, env *environment, args []string) error {
+	flags := flag.NewFlagSet("audit", flag.ContinueOnError)
+	if err := parse(flags, args, 1); err != nil {
+		return err
+	}
+	result, err := call[api.ChainAuditResponse](ctx, env.client, http.MethodGet, devicePath(flags.Arg(0), "/audit"), nil)
+	if err != nil {
+		return err
+	}
+	if err := printJSON(env.stdout, result); err != nil {
+		return err
+	}
+	if !result.Valid {
+		return errFailed
+	}
+	return nil
+}
+
+// history pages through the signatures of a device. JSON output is a single array,
+// CSV output has a header row and is written page by page.
+func history(ctx context.Context, env *environment, args []string) error {
+	flags := flag.NewFlagSet("history", flag.ContinueOnError)
+	output := flags.String("output", OutputJSON, "output format, json or csv")
+	from := flags.Int("from", 0, "counter of the first signature")
+	if err := parse(flags, args, 1); err != nil {
+		return err
+	}
+	if *output != OutputJSON && *output != OutputCSV {
+		return usageError{fmt.Sprintf("-output %q is unknown, must be %s or %s", *output, OutputJSON, OutputCSV)}
+	}
+
+	csvWriter := csv.NewWriter(env.stdout)
+	if *output == OutputCSV {
+		csvWriter.Write([]string{"signature_counter", "signature", "signed_data", "format"})
+	}
+	signatures := make([]api.SignatureRecordResponse, 0)
+	next := from
+	for next != nil {
+		query := url.Values{"from": {strconv.Itoa(*next)}, "limit": {strconv.Itoa(api.MaxHistoryLimit)}}
+		page, err := call[api.SignatureHistoryResponse](ctx, env.client, http.MethodGet, devicePath(flags.Arg(0), "/signatures?"+query.Encode()), nil)
+		if err != nil {
+			return err
+		}
+		next = page.NextCounter
+		if *output == OutputJSON {
+			signatures = append(signatures, page.Signatures...)
+			continue
+		}
+		for _, signature := range page.Signatures {
+			csvWriter.Write([]string{strconv.Itoa(signature.SignatureCounter), signature.Signature, signature.SignedData, string(signature.Format)})
+		}
+		csvWriter.Flush()
+		if err := csvWriter.Error(); err != nil {
+			return err
+		}
+	}
+	if *output == OutputJSON {
+		return printJSON(env.stdout, signatures)
+	}
+	return nil
+}
+
+// exportJournal writes the journal of a device to a file or stdout. The file is only
+// created once the journal has been received.
+func exportJournal(ctx context.Context, env *environment, args []string) error {
+	flags := flag.NewFlagSet("journal", flag.ContinueOnError)
+	output := flags.String("o", "", "file to write the journal to, stdout if missing")
+	if err := parse(flags, args, 1); err != nil {
+		return err
+	}
+	response, err := env.client.do(ctx, http.MethodGet, devicePath(flags.Arg(0), "/journal"), nil, api.ContentTypeJSON)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		return err
+	}
+	if *output == "" {
+		_, err = env.stdout.Write(body)
+		return err
+	}
+	return os.WriteFile(*output, body, 0o644)
+}
+
+// exportArchive writes the signed tar archive of the signatures of a device in a counter
+// or time range to a file. Times are RFC 3339 date-times, the to bound is exclusive.
+func exportArchive(ctx context.Context, env *environment, args []string) error {
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	output := flags.String("o", "", "file to write the archive to")
+	fromCounter := flags.String("from-counter", "", "counter of the first signature")
+	toCounter := flags.String("to-counter", "", "counter of the last signature")
+	from := flags.String("from", "", "signatures created at or after this time")
+	to := flags.String("to", "", "signatures created before this time")
+	if err := parse(flags, args, 1); err != nil {
+		return err
+	}
+	if *output == "" {
+		return usageError{"-o is required"}
+	}
+	query := url.Values{}
+	for name, value := range map[string]string{"from_counter": *fromCounter, "to_counter": *toCounter, "from": *from, "to": *to} {
+		if value != "" {
+			query.Set(name, value)
+		}
+	}
+	path := devicePath(flags.Arg(0), "/export")
+	if len(query) > 0 {
+		path += "?" + query.Encode()
+	}
+	response, err := env.client.do(ctx, http.MethodGet, path, nil, api.ContentTypeTar)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	archive, err := io.ReadAll(response.Body)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(*output, archive, 0o644)
+}
@@ -0,0 +1,162 @@
+// Command sigctl administers a signing service through its HTTP API.
+//
+//	sigctl [flags] <command> [arguments]
+//
+// Run sigctl -h for the list of commands.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/api"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultServer is the address of the signing service unless -server or SIGCTL_SERVER is set.
+	DefaultServer = "http://localhost:8080"
+
+	exitFailure = 1
+	exitUsage   = 2
+)
+
+// errFailed fails a command without a message of its own, the command has printed its result.
+var errFailed = errors.New("failed")
+
+// usageError fails a command because it has been invoked wrongly.
+type usageError struct {
+	message string
+}
+
+func (e usageError) Error() string {
+	return e.message
+}
+
+// environment is the context commands run in.
+type environment struct {
+	client *client
+	stdin  io.Reader
+	stdout io.Writer
+}
+
+// command is a subcommand of sigctl.
+type command struct {
+	name    string
+	usage   string
+	summary string
+	run     func(ctx context.Context, env *environment, args []string) error
+}
+
+func commands() []command {
+	return []command{
+		{"devices list", "", "list all devices", listDevices},
+		{"devices create", "-algorithm ECC|RSA [-label label] [-id uuid]", "create a device with a new key pair", createDevice},
+		{"devices show", "<device-id>", "show a device", showDevice},
+		{"sign", "-device <device-id> [-format raw|jws|cose_sign1] [-file path]", "sign data from a file or stdin", sign},
+		{"verify", "-device <device-id> -signature <base64> [-format raw|jws|cose_sign1] [-file path]", "verify a signature over signed data from a file or stdin", verify},
+		{"public-key", "[-format pem|der|jwk] <device-id>", "export the public key of a device", exportPublicKey},
+		{"audit", "<device-id>", "check the signature chain of a device", audit},
+		{"history", "[-output json|csv] [-from counter] <device-id>", "dump the signatures of a device", history},
+		{"journal", "[-o file] <device-id>", "export the signature journal of a device for verify-journal", exportJournal},
+		{"export", "-o file [-from-counter n] [-to-counter n] [-from time] [-to time] <device-id>", "export a signed tar archive of the signatures of a device", exportArchive},
+	}
+}
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	code := run(ctx, os.Args[1:], os.Stdin, os.Stdout, os.Stderr, os.Getenv)
+	stop()
+	os.Exit(code)
+}
+
+// run executes sigctl with the given arguments and returns its exit code.
+func run(ctx context.Context, args []string, stdin io.Reader, stdout io.Writer, stderr io.Writer, getenv func(string) string) int {
+	flags := flag.NewFlagSet("sigctl", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	options := clientOptions{}
+	flags.StringVar(&options.server, "server", envOr(getenv, "SIGCTL_SERVER", DefaultServer), "base URL of the signing service, env SIGCTL_SERVER")
+	flags.StringVar(&options.apiKey, "api-key", "", "API key sent in the "+api.HeaderAPIKey+" header, env SIGCTL_API_KEY")
+	flags.StringVar(&options.caFile, "ca-file", "", "PEM certificates of the CAs the server certificate is verified with")
+	flags.StringVar(&options.certFile, "cert-file", "", "PEM client certificate for mutual TLS")
+	flags.StringVar(&options.keyFile, "key-file", "", "PEM private key of the client certificate")
+	flags.DurationVar(&options.timeout, "timeout", 30*time.Second, "timeout of a single request")
+	flags.Usage = func() {
+		fmt.Fprintln(stderr, "Usage: sigctl [flags] <command> [arguments]")
+		fmt.Fprintln(stderr, "\nCommands:")
+		for _, command := range commands() {
+			fmt.Fprintf(stderr, "  %-15s %s\n", command.name, command.summary)
+		}
+		fmt.Fprintln(stderr, "\nFlags:")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return exitUsage
+	}
+	// The key is read from the environment only now, so the usage never prints it as the default.
+	if options.apiKey == "" {
+		options.apiKey = getenv("SIGCTL_API_KEY")
+	}
+
+	command, rest, found := findCommand(flags.Args())
+	if !found {
+		if flags.NArg() > 0 {
+			fmt.Fprintf(stderr, "sigctl: unknown command %q\n", strings.Join(flags.Args(), " "))
+		}
+		flags.Usage()
+		return exitUsage
+	}
+	if (options.certFile == "") != (options.keyFile == "") {
+		fmt.Fprintln(stderr, "sigctl: -cert-file and -key-file must be set together")
+		return exitUsage
+	}
+	client, err := newClient(options)
+	if err != nil {
+		fmt.Fprintf(stderr, "sigctl: %v\n", err)
+		return exitUsage
+	}
+
+	err = command.run(ctx, &environment{client: client, stdin: stdin, stdout: stdout}, rest)
+	var usage usageError
+	switch {
+	case err == nil:
+		return 0
+	case errors.Is(err, flag.ErrHelp):
+		fmt.Fprintf(stderr, "Usage: sigctl %s %s\n", command.name, command.usage)
+		return 0
+	case errors.As(err, &usage):
+		fmt.Fprintf(stderr, "sigctl %s: %s\nUsage: sigctl %s %s\n", command.name, usage.message, command.name, command.usage)
+		return exitUsage
+	case errors.Is(err, errFailed):
+		return exitFailure
+	default:
+		fmt.Fprintf(stderr, "sigctl %s: %v\n", command.name, err)
+		return exitFailure
+	}
+}
+
+// findCommand looks up the command named by the leading arguments and returns the arguments after its name.
+func findCommand(args []string) (command, []string, bool) {
+	for _, command := range commands() {
+		words := strings.Fields(command.name)
+		if len(args) >= len(words) && strings.Join(args[:len(words)], " ") == command.name {
+			return command, args[len(words):], true
+		}
+	}
+	return command{}, nil, false
+}
+
+func envOr(getenv func(string) string, name string, defaultValue string) string {
+	if value := getenv(name); value != "" {
+		return value
+	}
+	return defaultValue
+}
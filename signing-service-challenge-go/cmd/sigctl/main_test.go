@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/pem"
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/api"
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/assert"
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/domain"
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/journal"
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/persistence"
+	"io"
+	"log/slog"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// sigctl runs sigctl against a server and returns its exit code and output.
+func sigctl(server *httptest.Server, stdin string, args ...string) (int, string, string) {
+	var stdout, stderr bytes.Buffer
+	getenv := func(name string) string {
+		if name == "SIGCTL_SERVER" {
+			return server.URL
+		}
+		return ""
+	}
+	code := run(context.Background(), args, strings.NewReader(stdin), &stdout, &stderr, getenv)
+	return code, stdout.String(), stderr.String()
+}
+
+func newTestServer(t *testing.T) *httptest.Server {
+	server := httptest.NewServer(api.NewServer("", persistence.NewLocalStorage(), api.WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil)))).Handler())
+	t.Cleanup(server.Close)
+	return server
+}
+
+func decode[T any](t *testing.T, output string) T {
+	var value T
+	assert.ShouldBe(t, json.Unmarshal([]byte(output), &value), nil)
+	return value
+}
+
+func TestSigctl(t *testing.T) {
+	server := newTestServer(t)
+
+	code, stdout, _ := sigctl(server, "", "devices", "create", "-algorithm", "ECC", "-label", "till")
+	assert.ShouldBe(t, code, 0)
+	deviceId := decode[api.CreateSignatureDeviceResponse](t, stdout).DeviceId
+
+	code, stdout, _ = sigctl(server, "", "devices", "list")
+	assert.ShouldBe(t, code, 0)
+	devices := decode[[]api.DeviceResponse](t, stdout)
+	assert.ShouldBe(t, len(devices), 1)
+	assert.ShouldBe(t, devices[0].Label, "till")
+
+	code, stdout, _ = sigctl(server, "receipt", "sign", "-device", deviceId)
+	assert.ShouldBe(t, code, 0)
+	signature := decode[api.SignTransactionResponse](t, stdout)
+	assert.ShouldBe(t, strings.HasPrefix(signature.SignedData, "0_receipt_"), true)
+
+	code, stdout, _ = sigctl(server, signature.SignedData, "verify", "-device", deviceId, "-signature", signature.Signature)
+	assert.ShouldBe(t, code, 0)
+	assert.ShouldBe(t, decode[api.VerifySignatureResponse](t, stdout).Valid, true)
+	code, _, _ = sigctl(server, "0_forged", "verify", "-device", deviceId, "-signature", signature.Signature)
+	assert.ShouldBe(t, code, exitFailure)
+
+	code, stdout, _ = sigctl(server, "", "devices", "show", deviceId)
+	assert.ShouldBe(t, code, 0)
+	assert.ShouldBe(t, decode[api.DeviceResponse](t, stdout).SignatureCounter, 1)
+
+	code, stdout, _ = sigctl(server, "", "public-key", deviceId)
+	assert.ShouldBe(t, code, 0)
+	block, _ := pem.Decode([]byte(stdout))
+	assert.ShouldBe(t, block != nil, true)
+
+	code, stdout, _ = sigctl(server, "", "audit", deviceId)
+	assert.ShouldBe(t, code, 0)
+	assert.ShouldBe(t, decode[api.ChainAuditResponse](t, stdout).Valid, true)
+}
+
+func TestSigctl_History(t *testing.T) {
+	server := newTestServer(t)
+	_, stdout, _ := sigctl(server, "", "devices", "create", "-algorithm", "ECC")
+	deviceId := decode[api.CreateSignatureDeviceResponse](t, stdout).DeviceId
+	for _, data := range []string{"a", "b,c"} {
+		sigctl(server, data, "sign", "-device", deviceId, "-format", "jws")
+	}
+
+	code, stdout, _ := sigctl(server, "", "history", deviceId)
+	assert.ShouldBe(t, code, 0)
+	signatures := decode[[]api.SignatureRecordResponse](t, stdout)
+	assert.ShouldBe(t, len(signatures), 2)
+	assert.ShouldBe(t, signatures[1].Format, domain.FormatJWS)
+
+	code, stdout, _ = sigctl(server, "", "history", "-output", "csv", "-from", "1", deviceId)
+	assert.ShouldBe(t, code, 0)
+	records, err := csv.NewReader(strings.NewReader(stdout)).ReadAll()
+	assert.ShouldBe(t, err, nil)
+	assert.ShouldBe(t, len(records), 2)
+	assert.ShouldBe(t, strings.Join(records[0], ","), "signature_counter,signature,signed_data,format")
+	assert.ShouldBe(t, records[1][2], signatures[1].SignedData)
+
+	path := filepath.Join(t.TempDir(), "journal.json")
+	code, _, _ = sigctl(server, "", "journal", "-o", path, deviceId)
+	assert.ShouldBe(t, code, 0)
+	file, err := os.Open(path)
+	assert.ShouldBe(t, err, nil)
+	defer file.Close()
+	exported, err := journal.Read(file)
+	assert.ShouldBe(t, err, nil)
+	_, publicKey, _ := sigctl(server, "", "public-key", deviceId)
+	report, err := journal.Verify(exported, journal.Trust{PublicKey: []byte(publicKey)})
+	assert.ShouldBe(t, err, nil)
+	assert.ShouldBe(t, report.Passed(), true)
+	assert.ShouldBe(t, report.SignatureCount, 2)
+}
+
+func TestSigctl_Errors(t *testing.T) {
+	server := newTestServer(t)
+
+	code, _, stderr := sigctl(server, "", "devices", "show", "unknown")
+	assert.ShouldBe(t, code, exitFailure)
+	assert.ShouldBe(t, stderr, "sigctl devices show: 404 Not Found: device not found\n")
+
+	code, _, stderr = sigctl(server, "", "sign")
+	assert.ShouldBe(t, code, exitUsage)
+	assert.ShouldBe(t, strings.HasPrefix(stderr, "sigctl sign: -device is required\n"), true)
+
+	code, _, stderr = sigctl(server, "", "devices", "remove")
+	assert.ShouldBe(t, code, exitUsage)
+	assert.ShouldBe(t, strings.HasPrefix(stderr, `sigctl: unknown command "devices remove"`), true)
+}
+
+func TestSigctl_UsageHidesAPIKey(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	getenv := func(name string) string {
+		if name == "SIGCTL_API_KEY" {
+			return "secret"
+		}
+		return ""
+	}
+	code := run(context.Background(), []string{"-h"}, strings.NewReader(""), &stdout, &stderr, getenv)
+	assert.ShouldBe(t, code, 0)
+	assert.ShouldBe(t, strings.Contains(stderr.String(), "secret"), false)
+}
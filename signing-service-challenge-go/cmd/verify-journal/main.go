@@ -0,0 +1,155 @@
+// Command verify-journal verifies an exported signature journal offline.
+//
+//	verify-journal [-json] -public-key <file> | -root <file> <journal-file>
+//
+// It checks the device key of the journal against a public key or CA root certificates the
+// auditor trusts, then every signature and every chain link of the journal without contacting
+// the signing service, and prints a pass or fail report. The exit code is 0 if the journal
+// passed, 1 if it failed and 2 if it could not be read.
+package main
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/journal"
+	"io"
+	"os"
+	"time"
+)
+
+const (
+	exitFailed = 1
+	exitUsage  = 2
+)
+
+// report is the JSON form of a journal.Report.
+type report struct {
+	DeviceId           string            `json:"device_id"`
+	SignatureCount     int               `json:"signature_count"`
+	Passed             bool              `json:"passed"`
+	FirstBrokenCounter *int              `json:"first_broken_counter,omitempty"`
+	Failures           []journal.Failure `json:"failures"`
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+// run verifies the journal named by the arguments and returns the exit code.
+func run(args []string, stdin io.Reader, stdout io.Writer, stderr io.Writer) int {
+	flags := flag.NewFlagSet("verify-journal", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	asJSON := flags.Bool("json", false, "print the report as JSON")
+	publicKeyFile := flags.String("public-key", "", "PEM public key of the device the journal is trusted for")
+	rootFile := flags.String("root", "", "PEM root certificates the certificate chain of the device has to lead to")
+	flags.Usage = func() {
+		fmt.Fprintln(stderr, "Usage: verify-journal [-json] -public-key <file> | -root <file> <journal-file>, - reads the journal from stdin")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return exitUsage
+	}
+	if flags.NArg() != 1 || (*publicKeyFile == "" && *rootFile == "") {
+		flags.Usage()
+		return exitUsage
+	}
+	trust, err := loadTrust(*publicKeyFile, *rootFile)
+	if err != nil {
+		fmt.Fprintf(stderr, "verify-journal: %v\n", err)
+		return exitUsage
+	}
+
+	input := stdin
+	if name := flags.Arg(0); name != "-" {
+		file, err := os.Open(name)
+		if err != nil {
+			fmt.Fprintf(stderr, "verify-journal: %v\n", err)
+			return exitUsage
+		}
+		defer file.Close()
+		input = file
+	}
+	exported, err := journal.Read(input)
+	if err != nil {
+		fmt.Fprintf(stderr, "verify-journal: %v\n", err)
+		return exitUsage
+	}
+	result, err := journal.Verify(exported, trust)
+	if err != nil {
+		fmt.Fprintf(stderr, "verify-journal: %v\n", err)
+		return exitFailed
+	}
+
+	if *asJSON {
+		printJSON(stdout, result)
+	} else {
+		printText(stdout, exported, result)
+	}
+	if !result.Passed() {
+		return exitFailed
+	}
+	return 0
+}
+
+// loadTrust reads the trusted public key and root certificates from their files, if named.
+func loadTrust(publicKeyFile string, rootFile string) (journal.Trust, error) {
+	var trust journal.Trust
+	if publicKeyFile != "" {
+		publicKey, err := os.ReadFile(publicKeyFile)
+		if err != nil {
+			return trust, err
+		}
+		trust.PublicKey = publicKey
+	}
+	if rootFile != "" {
+		roots, err := os.ReadFile(rootFile)
+		if err != nil {
+			return trust, err
+		}
+		trust.Roots = x509.NewCertPool()
+		if !trust.Roots.AppendCertsFromPEM(roots) {
+			return trust, fmt.Errorf("%s holds no PEM certificates", rootFile)
+		}
+	}
+	return trust, nil
+}
+
+func printJSON(w io.Writer, result *journal.Report) {
+	output := report{
+		DeviceId:       result.DeviceId,
+		SignatureCount: result.SignatureCount,
+		Passed:         result.Passed(),
+		Failures:       result.Failures,
+	}
+	if output.Failures == nil {
+		output.Failures = []journal.Failure{}
+	}
+	if !result.Passed() {
+		counter := result.FirstBrokenCounter()
+		output.FirstBrokenCounter = &counter
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(output)
+}
+
+func printText(w io.Writer, exported *journal.Journal, result *journal.Report) {
+	fmt.Fprintf(w, "device:     %s (%s)\n", result.DeviceId, exported.Device.Algorithm)
+	fmt.Fprintf(w, "exported:   %s\n", exported.ExportedAt.Format(time.RFC3339))
+	fmt.Fprintf(w, "signatures: %d\n", result.SignatureCount)
+	if result.Passed() {
+		fmt.Fprintln(w, "result:     PASS")
+		return
+	}
+	fmt.Fprintln(w, "result:     FAIL")
+	fmt.Fprintf(w, "first broken counter: %d\n", result.FirstBrokenCounter())
+	for _, failure := range result.Failures {
+		fmt.Fprintf(w, "  counter %d: %s\n", failure.SignatureCounter, failure.Reason)
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/assert"
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/crypto"
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/domain"
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/journal"
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/persistence"
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/signing"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeJournal signs three items with a new device and writes its journal and the public key
+// of the device to files, tamper may modify the journal before. It returns both paths.
+func writeJournal(t *testing.T, tamper func(*journal.Journal)) (string, string) {
+	storage := persistence.NewLocalStorage()
+	deviceId, _ := storage.CreateSignatureDevice("test", domain.ECC, "")
+	publicKey, privateKey, _ := crypto.GenerateKeyPair(domain.ECC)
+	storage.SetDeviceKeys(deviceId, publicKey, privateKey)
+	service := signing.NewService(storage)
+	_, err := service.SignBatch(context.Background(), deviceId, []string{"a", "b", "c"}, domain.FormatRaw)
+	assert.ShouldBe(t, err, nil)
+	records, _, err := service.History(context.Background(), deviceId, 0, 3)
+	assert.ShouldBe(t, err, nil)
+
+	exported := journal.New(storage.GetDevice(deviceId), records)
+	publicKeyPath := filepath.Join(t.TempDir(), "public-key.pem")
+	assert.ShouldBe(t, os.WriteFile(publicKeyPath, publicKey, 0o600), nil)
+	if tamper != nil {
+		tamper(exported)
+	}
+	var buffer bytes.Buffer
+	assert.ShouldBe(t, journal.Write(&buffer, exported), nil)
+	path := filepath.Join(t.TempDir(), "journal.json")
+	assert.ShouldBe(t, os.WriteFile(path, buffer.Bytes(), 0o600), nil)
+	return path, publicKeyPath
+}
+
+func verifyJournal(args ...string) (int, string, string) {
+	var stdout, stderr bytes.Buffer
+	code := run(args, strings.NewReader(""), &stdout, &stderr)
+	return code, stdout.String(), stderr.String()
+}
+
+func TestRun_Passes(t *testing.T) {
+	path, publicKeyPath := writeJournal(t, nil)
+	code, stdout, _ := verifyJournal("-public-key", publicKeyPath, path)
+	assert.ShouldBe(t, code, 0)
+	assert.ShouldBe(t, strings.Contains(stdout, "signatures: 3\nresult:     PASS\n"), true)
+}
+
+func TestRun_RequiresTrust(t *testing.T) {
+	path, _ := writeJournal(t, nil)
+	code, _, stderr := verifyJournal(path)
+	assert.ShouldBe(t, code, exitUsage)
+	assert.ShouldBe(t, strings.HasPrefix(stderr, "Usage: verify-journal"), true)
+
+	// A journal that verifies with its own key fails against the key of another device.
+	_, otherPublicKeyPath := writeJournal(t, nil)
+	code, _, stderr = verifyJournal("-public-key", otherPublicKeyPath, path)
+	assert.ShouldBe(t, code, exitFailed)
+	assert.ShouldBe(t, strings.HasSuffix(stderr, "is not the trusted public key\n"), true)
+}
+
+func TestRun_ReportsFirstBrokenCounter(t *testing.T) {
+	path, publicKeyPath := writeJournal(t, func(exported *journal.Journal) {
+		exported.Signatures[1].SignedData = strings.Replace(exported.Signatures[1].SignedData, "_b_", "_x_", 1)
+	})
+	code, stdout, _ := verifyJournal("-public-key", publicKeyPath, path)
+	assert.ShouldBe(t, code, exitFailed)
+	assert.ShouldBe(t, strings.Contains(stdout, "result:     FAIL\nfirst broken counter: 1\n"), true)
+
+	code, stdout, _ = verifyJournal("-json", "-public-key", publicKeyPath, path)
+	assert.ShouldBe(t, code, exitFailed)
+	var output report
+	assert.ShouldBe(t, json.Unmarshal([]byte(stdout), &output), nil)
+	assert.ShouldBe(t, output.Passed, false)
+	assert.ShouldBe(t, *output.FirstBrokenCounter, 1)
+	assert.ShouldBe(t, output.Failures[0].Reason, "signature does not verify: signature is not valid")
+}
+
+func TestRun_RejectsUnreadableJournals(t *testing.T) {
+	_, publicKeyPath := writeJournal(t, nil)
+	code, _, stderr := verifyJournal("-public-key", publicKeyPath, filepath.Join(t.TempDir(), "missing.json"))
+	assert.ShouldBe(t, code, exitUsage)
+	assert.ShouldBe(t, strings.HasPrefix(stderr, "verify-journal: open "), true)
+
+	code, _, _ = verifyJournal()
+	assert.ShouldBe(t, code, exitUsage)
+}
@@ -0,0 +1,504 @@
+// Package config loads the settings of the service from a YAML file, environment variables
+// and command line flags. Flags take precedence over environment variables, which take
+// precedence over the file, which takes precedence over the defaults.
+package config
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/apiconfig"
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/crypto"
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/domain"
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/ratelimit"
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/tracing"
+	"gopkg.in/yaml.v3"
+	"io"
+	"log/slog"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// EnvPrefix prefixes the environment variables of all settings.
+	EnvPrefix = "SIGNING_SERVICE_"
+	// FileVariable names the environment variable holding the path of the config file.
+	FileVariable = EnvPrefix + "CONFIG"
+
+	ClientAuthNone     = "none"
+	ClientAuthOptional = "optional"
+	ClientAuthRequire  = "require"
+
+	StorageMemory = "memory"
+
+	LogFormatJSON = "json"
+	LogFormatText = "text"
+)
+
+// Config holds the settings of the service.
+type Config struct {
+	ListenAddress     string `yaml:"listen_address"`
+	GRPCListenAddress string `yaml:"grpc_listen_address"`
+	// AdminToken enables the admin API, it can not be set by a flag to keep it out of process listings.
+	AdminToken string          `yaml:"admin_token"`
+	HTTP       HTTPConfig      `yaml:"http"`
+	TLS        TLSConfig       `yaml:"tls"`
+	Storage    StorageConfig   `yaml:"storage"`
+	Crypto     CryptoConfig    `yaml:"crypto"`
+	RateLimit  RateLimitConfig `yaml:"rate_limit"`
+	Timestamp  TimestampConfig `yaml:"timestamp"`
+	Log        LogConfig       `yaml:"log"`
+	Tracing    TracingConfig   `yaml:"tracing"`
+}
+
+// HTTPConfig bounds the resources taken by HTTP clients and the time taken to shut down.
+type HTTPConfig struct {
+	ReadTimeout  time.Duration `yaml:"read_timeout"`
+	WriteTimeout time.Duration `yaml:"write_timeout"`
+	IdleTimeout  time.Duration `yaml:"idle_timeout"`
+	// ShutdownTimeout bounds the time in-flight requests get to complete after a termination signal.
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+	// MaxBodySize limits the size of request bodies in bytes.
+	MaxBodySize int64 `yaml:"max_body_size"`
+}
+
+// TLSConfig enables HTTPS when both files are set. The certificate is reloaded when the files change.
+type TLSConfig struct {
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+	// ClientAuth is one of ClientAuthNone, ClientAuthOptional and ClientAuthRequire.
+	ClientAuth string `yaml:"client_auth"`
+	// ClientCAFile holds the PEM certificates of the CAs that issue client certificates.
+	ClientCAFile string `yaml:"client_ca_file"`
+	// ClientTenants maps SANs and subject common names of client certificates to tenants,
+	// without it the first name of a certificate is the tenant.
+	ClientTenants map[string]string `yaml:"client_tenants"`
+}
+
+type StorageConfig struct {
+	// Backend selects the storage, only StorageMemory is available.
+	Backend string `yaml:"backend"`
+	// DSN locates the database of backends that have one.
+	DSN string `yaml:"dsn"`
+}
+
+type CryptoConfig struct {
+	// Algorithms lists the algorithms new devices can be created with.
+	Algorithms []string `yaml:"algorithms"`
+	// RSAKeySize is the modulus size of generated RSA keys in bits, including the key of the CA.
+	RSAKeySize int `yaml:"rsa_key_size"`
+	// CAAlgorithm is the algorithm of the root key of the certificate authority.
+	CAAlgorithm string `yaml:"ca_algorithm"`
+	// PayloadVersion is the layout of the signed data of new signatures, v2 includes the timestamp.
+	PayloadVersion string `yaml:"payload_version"`
+}
+
+// RateLimitConfig limits the requests per client, per tenant of a client certificate and per device.
+type RateLimitConfig struct {
+	Client RateLimit `yaml:"client"`
+	Tenant RateLimit `yaml:"tenant"`
+	Device RateLimit `yaml:"device"`
+}
+
+// RateLimit is a token bucket, a rate of zero disables the limit.
+type RateLimit struct {
+	RequestsPerSecond float64 `yaml:"requests_per_second"`
+	Burst             int     `yaml:"burst"`
+}
+
+// TimestampConfig enables RFC 3161 timestamping of signatures when the URL of an authority is set.
+type TimestampConfig struct {
+	URL string `yaml:"url"`
+	// CAFile holds the PEM certificates of the CAs of the authority, by default the system roots are trusted.
+	CAFile  string        `yaml:"ca_file"`
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+type LogConfig struct {
+	// Level is one of debug, info, warn and error.
+	Level string `yaml:"level"`
+	// Format is one of LogFormatJSON and LogFormatText.
+	Format string `yaml:"format"`
+}
+
+type TracingConfig struct {
+	// Exporter is one of tracing.ExporterNone and tracing.ExporterOTLP.
+	Exporter string `yaml:"exporter"`
+}
+
+// Default returns the settings used for everything that is not configured.
+func Default() *Config {
+	algorithms := make([]string, 0, len(domain.CryptoAlgorithms))
+	for _, algorithm := range domain.CryptoAlgorithms {
+		algorithms = append(algorithms, string(algorithm))
+	}
+	return &Config{
+		ListenAddress:     ":8080",
+		GRPCListenAddress: ":9090",
+		HTTP: HTTPConfig{
+			ReadTimeout:     apiconfig.DefaultTimeouts.Read,
+			WriteTimeout:    apiconfig.DefaultTimeouts.Write,
+			IdleTimeout:     apiconfig.DefaultTimeouts.Idle,
+			ShutdownTimeout: 30 * time.Second,
+			MaxBodySize:     apiconfig.DefaultMaxBodySize,
+		},
+		TLS:     TLSConfig{ClientAuth: ClientAuthNone},
+		Storage: StorageConfig{Backend: StorageMemory},
+		Crypto: CryptoConfig{
+			Algorithms:     algorithms,
+			RSAKeySize:     crypto.RSAKeySize,
+			CAAlgorithm:    string(domain.ECC),
+			PayloadVersion: string(domain.PayloadV1),
+		},
+		Timestamp: TimestampConfig{Timeout: 5 * time.Second},
+		Log: LogConfig{
+			Level:  "info",
+			Format: LogFormatJSON,
+		},
+		Tracing: TracingConfig{Exporter: tracing.ExporterNone},
+	}
+}
+
+// setting binds a field of the Config to its flag and environment variable.
+type setting struct {
+	// name is the flag name, the environment variable is EnvPrefix followed by the name in upper snake case.
+	name  string
+	usage string
+	// env overrides the name of the environment variable.
+	env    string
+	noFlag bool
+	field  func(*Config) interface{}
+}
+
+func (s setting) envName() string {
+	if s.env != "" {
+		return s.env
+	}
+	return EnvPrefix + strings.ToUpper(strings.ReplaceAll(s.name, "-", "_"))
+}
+
+var settings = []setting{
+	{name: "listen-address", usage: "address of the HTTP server", field: func(c *Config) interface{} { return &c.ListenAddress }},
+	{name: "grpc-listen-address", usage: "address of the gRPC server", field: func(c *Config) interface{} { return &c.GRPCListenAddress }},
+	{name: "admin-token", usage: "bearer token of the admin API", noFlag: true, field: func(c *Config) interface{} { return &c.AdminToken }},
+	{name: "read-timeout", usage: "time allowed to read a request", field: func(c *Config) interface{} { return &c.HTTP.ReadTimeout }},
+	{name: "write-timeout", usage: "time allowed to write a response", field: func(c *Config) interface{} { return &c.HTTP.WriteTimeout }},
+	{name: "idle-timeout", usage: "time an idle keep-alive connection is kept open", field: func(c *Config) interface{} { return &c.HTTP.IdleTimeout }},
+	{name: "shutdown-timeout", usage: "time in-flight requests get to complete on shutdown", field: func(c *Config) interface{} { return &c.HTTP.ShutdownTimeout }},
+	{name: "max-body-size", usage: "maximum size of request bodies in bytes", field: func(c *Config) interface{} { return &c.HTTP.MaxBodySize }},
+	{name: "tls-cert-file", usage: "PEM certificate chain of the HTTPS server", field: func(c *Config) interface{} { return &c.TLS.CertFile }},
+	{name: "tls-key-file", usage: "PEM private key of the HTTPS server", field: func(c *Config) interface{} { return &c.TLS.KeyFile }},
+	{name: "tls-client-auth", usage: "client certificate policy, none, optional or require", field: func(c *Config) interface{} { return &c.TLS.ClientAuth }},
+	{name: "tls-client-ca-file", usage: "PEM certificates of the CAs of client certificates", field: func(c *Config) interface{} { return &c.TLS.ClientCAFile }},
+	{name: "storage-backend", usage: "storage backend, memory", field: func(c *Config) interface{} { return &c.Storage.Backend }},
+	{name: "storage-dsn", usage: "data source name of the storage backend", field: func(c *Config) interface{} { return &c.Storage.DSN }},
+	{name: "algorithms", usage: "comma separated algorithms new devices can be created with", field: func(c *Config) interface{} { return &c.Crypto.Algorithms }},
+	{name: "rsa-key-size", usage: "modulus size of generated RSA keys in bits", field: func(c *Config) interface{} { return &c.Crypto.RSAKeySize }},
+	{name: "ca-algorithm", usage: "algorithm of the root key of the certificate authority, ECC or RSA", field: func(c *Config) interface{} { return &c.Crypto.CAAlgorithm }},
+	{name: "payload-version", usage: "layout of the signed data of new signatures, v1 or v2", field: func(c *Config) interface{} { return &c.Crypto.PayloadVersion }},
+	{name: "rate-limit-client-rps", usage: "requests per second allowed per API key, otherwise per client certificate or IP address, 0 disables the limit", field: func(c *Config) interface{} { return &c.RateLimit.Client.RequestsPerSecond }},
+	{name: "rate-limit-client-burst", usage: "requests a client may send at once", field: func(c *Config) interface{} { return &c.RateLimit.Client.Burst }},
+	{name: "rate-limit-tenant-rps", usage: "requests per second allowed per tenant, 0 disables the limit", field: func(c *Config) interface{} { return &c.RateLimit.Tenant.RequestsPerSecond }},
+	{name: "rate-limit-tenant-burst", usage: "requests a tenant may send at once", field: func(c *Config) interface{} { return &c.RateLimit.Tenant.Burst }},
+	{name: "rate-limit-device-rps", usage: "requests per second allowed per device, 0 disables the limit", field: func(c *Config) interface{} { return &c.RateLimit.Device.RequestsPerSecond }},
+	{name: "rate-limit-device-burst", usage: "requests that may address a device at once", field: func(c *Config) interface{} { return &c.RateLimit.Device.Burst }},
+	{name: "tsa-url", usage: "URL of the RFC 3161 timestamp authority, empty disables timestamping", field: func(c *Config) interface{} { return &c.Timestamp.URL }},
+	{name: "tsa-ca-file", usage: "PEM certificates of the CAs of the timestamp authority", field: func(c *Config) interface{} { return &c.Timestamp.CAFile }},
+	{name: "tsa-timeout", usage: "time a timestamp request may take", field: func(c *Config) interface{} { return &c.Timestamp.Timeout }},
+	{name: "log-level", usage: "minimum level of log entries, debug, info, warn or error", field: func(c *Config) interface{} { return &c.Log.Level }},
+	{name: "log-format", usage: "format of log entries, json or text", field: func(c *Config) interface{} { return &c.Log.Format }},
+	{name: "trace-exporter", usage: "trace exporter, none or otlp", env: "OTEL_TRACES_EXPORTER", field: func(c *Config) interface{} { return &c.Tracing.Exporter }},
+}
+
+// Load reads the settings from the defaults, the config file, the environment and the
+// command line arguments, in increasing precedence, and validates them. The config file is
+// given by the -config flag or the FileVariable. All invalid settings are reported at once.
+func Load(args []string, getenv func(string) string) (*Config, error) {
+	flags := flag.NewFlagSet("signing-service", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	file := flags.String("config", getenv(FileVariable), "path of the YAML config file")
+	flagValues := make(map[string]string)
+	for _, s := range settings {
+		if s.noFlag {
+			continue
+		}
+		name := s.name
+		flags.Func(name, s.usage, func(value string) error {
+			flagValues[name] = value
+			return nil
+		})
+	}
+	if err := flags.Parse(args); err != nil {
+		return nil, err
+	}
+	if flags.NArg() > 0 {
+		return nil, fmt.Errorf("unexpected arguments: %s", strings.Join(flags.Args(), " "))
+	}
+
+	config := Default()
+	if *file != "" {
+		if err := config.readFile(*file); err != nil {
+			return nil, err
+		}
+	}
+
+	var errs []error
+	for _, s := range settings {
+		if value := getenv(s.envName()); value != "" {
+			if err := set(s.field(config), value); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", s.envName(), err))
+			}
+		}
+	}
+	for _, s := range settings {
+		if value, ok := flagValues[s.name]; ok {
+			if err := set(s.field(config), value); err != nil {
+				errs = append(errs, fmt.Errorf("-%s: %w", s.name, err))
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// Usage describes the flags and environment variables of all settings.
+func Usage(w io.Writer) {
+	fmt.Fprintf(w, "  -config string\n    \tpath of the YAML config file (%s)\n", FileVariable)
+	for _, s := range settings {
+		if s.noFlag {
+			fmt.Fprintf(w, "  %s\n    \t%s\n", s.envName(), s.usage)
+			continue
+		}
+		fmt.Fprintf(w, "  -%s\n    \t%s (%s)\n", s.name, s.usage, s.envName())
+	}
+}
+
+// readFile overlays the settings of a YAML file, unknown keys are rejected to catch typos.
+func (c *Config) readFile(path string) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("could not read config file: %w", err)
+	}
+	decoder := yaml.NewDecoder(bytes.NewReader(content))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(c); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("invalid config file %s: %w", path, err)
+	}
+	return nil
+}
+
+func set(field interface{}, value string) error {
+	switch field := field.(type) {
+	case *string:
+		*field = value
+	case *[]string:
+		*field = nil
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				*field = append(*field, item)
+			}
+		}
+	case *int:
+		number, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("%q is not an integer", value)
+		}
+		*field = number
+	case *int64:
+		number, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return fmt.Errorf("%q is not an integer", value)
+		}
+		*field = number
+	case *time.Duration:
+		duration, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("%q is not a duration", value)
+		}
+		*field = duration
+	case *float64:
+		number, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("%q is not a number", value)
+		}
+		*field = number
+	default:
+		panic(fmt.Sprintf("unsupported setting type %T", field))
+	}
+	return nil
+}
+
+// Validate checks the settings and reports every invalid one.
+func (c *Config) Validate() error {
+	var errs []error
+	fail := func(format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	if c.ListenAddress == "" {
+		fail("listen_address must not be empty")
+	}
+	if c.GRPCListenAddress == "" {
+		fail("grpc_listen_address must not be empty")
+	}
+	if c.HTTP.ReadTimeout < 0 || c.HTTP.WriteTimeout < 0 || c.HTTP.IdleTimeout < 0 {
+		fail("http timeouts must not be negative")
+	}
+	if c.HTTP.ShutdownTimeout <= 0 {
+		fail("http.shutdown_timeout must be positive")
+	}
+	if c.HTTP.MaxBodySize <= 0 {
+		fail("http.max_body_size must be positive")
+	}
+	if (c.TLS.CertFile == "") != (c.TLS.KeyFile == "") {
+		fail("tls.cert_file and tls.key_file must be set together")
+	}
+	switch c.TLS.ClientAuth {
+	case ClientAuthNone:
+	case ClientAuthOptional, ClientAuthRequire:
+		if c.TLS.CertFile == "" {
+			fail("tls.client_auth %s requires tls.cert_file and tls.key_file", c.TLS.ClientAuth)
+		}
+		if c.TLS.ClientCAFile == "" {
+			fail("tls.client_auth %s requires tls.client_ca_file", c.TLS.ClientAuth)
+		}
+	default:
+		fail("tls.client_auth %q is unknown, must be one of %s, %s, %s", c.TLS.ClientAuth, ClientAuthNone, ClientAuthOptional, ClientAuthRequire)
+	}
+	for _, file := range []string{c.TLS.CertFile, c.TLS.KeyFile, c.TLS.ClientCAFile} {
+		if file == "" {
+			continue
+		}
+		if _, err := os.Stat(file); err != nil {
+			fail("tls: %v", err)
+		}
+	}
+	switch c.Storage.Backend {
+	case StorageMemory:
+		if c.Storage.DSN != "" {
+			fail("storage.dsn is not supported by the %s storage backend", StorageMemory)
+		}
+	default:
+		fail("storage.backend %q is unknown, must be %s", c.Storage.Backend, StorageMemory)
+	}
+	if len(c.Crypto.Algorithms) == 0 {
+		fail("crypto.algorithms must not be empty")
+	}
+	for _, algorithm := range c.Crypto.Algorithms {
+		if !slices.Contains(domain.CryptoAlgorithms, domain.CryptoAlgorithmType(algorithm)) {
+			fail("crypto.algorithms: %q is unknown, must be one of %s", algorithm, joinAlgorithms())
+		}
+	}
+	if !slices.Contains(crypto.RSAKeySizes, c.Crypto.RSAKeySize) {
+		fail("crypto.rsa_key_size %d is not supported, must be one of %s", c.Crypto.RSAKeySize, joinInts(crypto.RSAKeySizes))
+	}
+	if !slices.Contains(domain.CryptoAlgorithms, domain.CryptoAlgorithmType(c.Crypto.CAAlgorithm)) {
+		fail("crypto.ca_algorithm %q is unknown, must be one of %s", c.Crypto.CAAlgorithm, joinAlgorithms())
+	}
+	if c.Crypto.PayloadVersion != string(domain.PayloadV1) && c.Crypto.PayloadVersion != string(domain.PayloadV2) {
+		fail("crypto.payload_version %q is unknown, must be %s or %s", c.Crypto.PayloadVersion, domain.PayloadV1, domain.PayloadV2)
+	}
+	for _, limit := range []struct {
+		name string
+		RateLimit
+	}{{"client", c.RateLimit.Client}, {"tenant", c.RateLimit.Tenant}, {"device", c.RateLimit.Device}} {
+		if limit.RequestsPerSecond < 0 {
+			fail("rate_limit.%s.requests_per_second must not be negative", limit.name)
+		}
+		if limit.Burst < 0 {
+			fail("rate_limit.%s.burst must not be negative", limit.name)
+		}
+		if limit.RequestsPerSecond > 0 && limit.Burst == 0 {
+			fail("rate_limit.%s.burst must be at least 1 when a rate limit is set", limit.name)
+		}
+	}
+	if c.Timestamp.URL != "" {
+		if parsed, err := url.Parse(c.Timestamp.URL); err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			fail("timestamp.url %q must be an absolute http or https URL", c.Timestamp.URL)
+		}
+	} else if c.Timestamp.CAFile != "" {
+		fail("timestamp.ca_file requires timestamp.url")
+	}
+	if c.Timestamp.CAFile != "" {
+		if _, err := os.Stat(c.Timestamp.CAFile); err != nil {
+			fail("timestamp: %v", err)
+		}
+	}
+	if c.Timestamp.Timeout <= 0 {
+		fail("timestamp.timeout must be positive")
+	}
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(c.Log.Level)); err != nil {
+		fail("log.level %q is unknown, must be one of debug, info, warn, error", c.Log.Level)
+	}
+	if c.Log.Format != LogFormatJSON && c.Log.Format != LogFormatText {
+		fail("log.format %q is unknown, must be %s or %s", c.Log.Format, LogFormatJSON, LogFormatText)
+	}
+	if c.Tracing.Exporter != tracing.ExporterNone && c.Tracing.Exporter != tracing.ExporterOTLP {
+		fail("tracing.exporter %q is unknown, must be %s or %s", c.Tracing.Exporter, tracing.ExporterNone, tracing.ExporterOTLP)
+	}
+	return errors.Join(errs...)
+}
+
+// CryptoAlgorithms returns the enabled algorithms.
+func (c *Config) CryptoAlgorithms() []domain.CryptoAlgorithmType {
+	algorithms := make([]domain.CryptoAlgorithmType, 0, len(c.Crypto.Algorithms))
+	for _, algorithm := range c.Crypto.Algorithms {
+		algorithms = append(algorithms, domain.CryptoAlgorithmType(algorithm))
+	}
+	return algorithms
+}
+
+// RateLimits returns the rate limits of the HTTP API.
+func (c *Config) RateLimits() apiconfig.RateLimits {
+	return apiconfig.RateLimits{
+		Client: ratelimit.Limit{Rate: c.RateLimit.Client.RequestsPerSecond, Burst: c.RateLimit.Client.Burst},
+		Tenant: ratelimit.Limit{Rate: c.RateLimit.Tenant.RequestsPerSecond, Burst: c.RateLimit.Tenant.Burst},
+		Device: ratelimit.Limit{Rate: c.RateLimit.Device.RequestsPerSecond, Burst: c.RateLimit.Device.Burst},
+	}
+}
+
+// ClientAuth returns the client certificate policy of the HTTP server.
+func (c *Config) ClientAuth() apiconfig.ClientAuth {
+	switch c.TLS.ClientAuth {
+	case ClientAuthOptional:
+		return apiconfig.ClientAuthOptional
+	case ClientAuthRequire:
+		return apiconfig.ClientAuthRequired
+	default:
+		return apiconfig.ClientAuthNone
+	}
+}
+
+// LogLevel returns the minimum level of log entries.
+func (c *Config) LogLevel() slog.Level {
+	var level slog.Level
+	level.UnmarshalText([]byte(c.Log.Level))
+	return level
+}
+
+func joinAlgorithms() string {
+	names := make([]string, 0, len(domain.CryptoAlgorithms))
+	for _, algorithm := range domain.CryptoAlgorithms {
+		names = append(names, string(algorithm))
+	}
+	return strings.Join(names, ", ")
+}
+
+func joinInts(values []int) string {
+	texts := make([]string, 0, len(values))
+	for _, value := range values {
+		texts = append(texts, strconv.Itoa(value))
+	}
+	return strings.Join(texts, ", ")
+}
@@ -0,0 +1,162 @@
+package config
+
+import (
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/apiconfig"
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/assert"
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/domain"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func environment(variables map[string]string) func(string) string {
+	return func(name string) string {
+		return variables[name]
+	}
+}
+
+func writeConfigFile(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	assert.ShouldBe(t, os.WriteFile(path, []byte(content), 0o600), nil)
+	return path
+}
+
+func TestLoad_Defaults(t *testing.T) {
+	config, err := Load(nil, environment(nil))
+	assert.ShouldBe(t, err, nil)
+	assert.ShouldBe(t, config.ListenAddress, ":8080")
+	assert.ShouldBe(t, config.GRPCListenAddress, ":9090")
+	assert.ShouldBe(t, config.Storage.Backend, StorageMemory)
+	assert.ShouldBe(t, config.Crypto.RSAKeySize, 2048)
+	assert.ShouldBe(t, config.Crypto.CAAlgorithm, "ECC")
+	assert.ShouldBe(t, config.Crypto.PayloadVersion, "v1")
+	assert.ShouldBe(t, len(config.CryptoAlgorithms()), len(domain.CryptoAlgorithms))
+	assert.ShouldBe(t, config.LogLevel(), slog.LevelInfo)
+}
+
+func TestLoad_Precedence(t *testing.T) {
+	path := writeConfigFile(t, `
+listen_address: ":1000"
+grpc_listen_address: ":2000"
+crypto:
+  algorithms: [ECC]
+  rsa_key_size: 3072
+  ca_algorithm: RSA
+  payload_version: v2
+log:
+  level: debug
+`)
+	config, err := Load([]string{"-config", path, "-listen-address", ":3000"}, environment(map[string]string{
+		"SIGNING_SERVICE_LISTEN_ADDRESS":      ":4000",
+		"SIGNING_SERVICE_GRPC_LISTEN_ADDRESS": ":5000",
+		"SIGNING_SERVICE_ADMIN_TOKEN":         "secret",
+		"SIGNING_SERVICE_LOG_FORMAT":          "text",
+	}))
+	assert.ShouldBe(t, err, nil)
+	assert.ShouldBe(t, config.ListenAddress, ":3000")
+	assert.ShouldBe(t, config.GRPCListenAddress, ":5000")
+	assert.ShouldBe(t, config.AdminToken, "secret")
+	assert.ShouldBe(t, config.Crypto.RSAKeySize, 3072)
+	assert.ShouldBe(t, config.Crypto.CAAlgorithm, "RSA")
+	assert.ShouldBe(t, config.Crypto.PayloadVersion, "v2")
+	assert.ShouldBe(t, strings.Join(config.Crypto.Algorithms, ","), "ECC")
+	assert.ShouldBe(t, config.LogLevel(), slog.LevelDebug)
+	assert.ShouldBe(t, config.Log.Format, LogFormatText)
+}
+
+func TestLoad_FileFromEnvironment(t *testing.T) {
+	path := writeConfigFile(t, "rate_limit:\n  device:\n    requests_per_second: 2.5\n    burst: 5\n")
+	config, err := Load([]string{"-algorithms", "RSA, ECC"}, environment(map[string]string{FileVariable: path}))
+	assert.ShouldBe(t, err, nil)
+	assert.ShouldBe(t, config.RateLimit.Device.RequestsPerSecond, 2.5)
+	assert.ShouldBe(t, config.RateLimit.Device.Burst, 5)
+	assert.ShouldBe(t, strings.Join(config.Crypto.Algorithms, ","), "RSA,ECC")
+}
+
+func TestLoad_Invalid(t *testing.T) {
+	_, err := Load([]string{"-rsa-key-size", "big"}, environment(nil))
+	assert.ShouldBe(t, err.Error(), `-rsa-key-size: "big" is not an integer`)
+
+	_, err = Load([]string{"-admin-token", "secret"}, environment(nil))
+	assert.ShouldNotBe(t, err, nil)
+
+	_, err = Load([]string{"-config", writeConfigFile(t, "listen_adress: \":1000\"\n")}, environment(nil))
+	assert.ShouldBe(t, strings.Contains(err.Error(), "field listen_adress not found"), true)
+
+	_, err = Load([]string{"-config", filepath.Join(t.TempDir(), "missing.yaml")}, environment(nil))
+	assert.ShouldBe(t, strings.HasPrefix(err.Error(), "could not read config file"), true)
+}
+
+func TestConfig_Validate(t *testing.T) {
+	config := Default()
+	config.TLS.CertFile = "server.pem"
+	config.Storage.Backend = "postgres"
+	config.Crypto.Algorithms = []string{"DSA"}
+	config.Crypto.RSAKeySize = 1024
+	config.Crypto.CAAlgorithm = "DSA"
+	config.Crypto.PayloadVersion = "v3"
+	config.RateLimit.Tenant.RequestsPerSecond = 10
+	config.Log.Level = "verbose"
+
+	errs := strings.Split(config.Validate().Error(), "\n")
+	assert.ShouldBe(t, errs[0], "tls.cert_file and tls.key_file must be set together")
+	assert.ShouldBe(t, strings.HasPrefix(errs[1], "tls: stat server.pem"), true)
+	assert.ShouldBe(t, errs[2], `storage.backend "postgres" is unknown, must be memory`)
+	assert.ShouldBe(t, errs[3], `crypto.algorithms: "DSA" is unknown, must be one of ECC, RSA`)
+	assert.ShouldBe(t, errs[4], "crypto.rsa_key_size 1024 is not supported, must be one of 2048, 3072, 4096")
+	assert.ShouldBe(t, errs[5], `crypto.ca_algorithm "DSA" is unknown, must be one of ECC, RSA`)
+	assert.ShouldBe(t, errs[6], `crypto.payload_version "v3" is unknown, must be v1 or v2`)
+	assert.ShouldBe(t, errs[7], "rate_limit.tenant.burst must be at least 1 when a rate limit is set")
+	assert.ShouldBe(t, errs[8], `log.level "verbose" is unknown, must be one of debug, info, warn, error`)
+	assert.ShouldBe(t, len(errs), 9)
+}
+
+func TestLoad_HTTP(t *testing.T) {
+	config, err := Load([]string{"-write-timeout", "2m", "-max-body-size", "1024"}, environment(map[string]string{
+		"SIGNING_SERVICE_SHUTDOWN_TIMEOUT": "5s",
+	}))
+	assert.ShouldBe(t, err, nil)
+	assert.ShouldBe(t, config.HTTP.WriteTimeout, 2*time.Minute)
+	assert.ShouldBe(t, config.HTTP.ShutdownTimeout, 5*time.Second)
+	assert.ShouldBe(t, config.HTTP.MaxBodySize, int64(1024))
+
+	_, err = Load([]string{"-shutdown-timeout", "soon"}, environment(nil))
+	assert.ShouldBe(t, err.Error(), `-shutdown-timeout: "soon" is not a duration`)
+}
+
+func TestLoad_Timestamp(t *testing.T) {
+	config, err := Load([]string{"-tsa-url", "https://tsa.example.com/rfc3161", "-tsa-timeout", "2s"}, environment(nil))
+	assert.ShouldBe(t, err, nil)
+	assert.ShouldBe(t, config.Timestamp.URL, "https://tsa.example.com/rfc3161")
+	assert.ShouldBe(t, config.Timestamp.Timeout, 2*time.Second)
+
+	_, err = Load([]string{"-tsa-url", "tsa.example.com", "-tsa-timeout", "0s"}, environment(nil))
+	errs := strings.Split(err.Error(), "\n")
+	assert.ShouldBe(t, errs[0], `timestamp.url "tsa.example.com" must be an absolute http or https URL`)
+	assert.ShouldBe(t, errs[1], "timestamp.timeout must be positive")
+
+	_, err = Load(nil, environment(map[string]string{"SIGNING_SERVICE_TSA_CA_FILE": filepath.Join(t.TempDir(), "tsa.pem")}))
+	errs = strings.Split(err.Error(), "\n")
+	assert.ShouldBe(t, errs[0], "timestamp.ca_file requires timestamp.url")
+	assert.ShouldBe(t, strings.HasPrefix(errs[1], "timestamp: stat "), true)
+}
+
+func TestLoad_ClientCertificates(t *testing.T) {
+	path := writeConfigFile(t, `
+tls:
+  client_auth: require
+  client_tenants:
+    "spiffe://example.com/tenant-a": tenant-a
+`)
+	// The files only have to exist for the validation.
+	config, err := Load([]string{"-config", path, "-tls-cert-file", path, "-tls-key-file", path, "-tls-client-ca-file", path}, environment(nil))
+	assert.ShouldBe(t, err, nil)
+	assert.ShouldBe(t, config.TLS.ClientTenants["spiffe://example.com/tenant-a"], "tenant-a")
+	assert.ShouldBe(t, config.ClientAuth(), apiconfig.ClientAuthRequired)
+
+	_, err = Load([]string{"-tls-client-auth", "require"}, environment(map[string]string{"SIGNING_SERVICE_TLS_CLIENT_CA_FILE": path}))
+	assert.ShouldBe(t, err.Error(), "tls.client_auth require requires tls.cert_file and tls.key_file")
+}
@@ -0,0 +1,185 @@
+package crypto
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/domain"
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/persistence"
+	"math/big"
+	"net/url"
+	"time"
+)
+
+const CertificateAuthorityLabel = "Signing Service Root CA"
+
+// CertificateAuthority issues X.509 certificates for device keys and records their serial numbers.
+// Its root key is held in the storage apart from the devices, so no device endpoint can sign with it.
+type CertificateAuthority struct {
+	storage     persistence.Storage
+	privateKey  crypto.Signer
+	certificate *x509.Certificate
+	// CertificateChain is the PEM encoded self-signed root certificate.
+	CertificateChain []byte
+}
+
+// NewCertificateAuthority loads the certificate authority held in the storage. If there is none yet,
+// it generates a root key of the given algorithm with the key options, issues the self-signed
+// root certificate and stores both, so certificates keep verifying across restarts.
+func NewCertificateAuthority(storage persistence.Storage, algorithm domain.CryptoAlgorithmType, now time.Time, validity time.Duration, options ...KeyOption) (*CertificateAuthority, error) {
+	stored := storage.GetCertificateAuthority()
+	if stored == nil {
+		created, err := createCertificateAuthority(algorithm, now, validity, options...)
+		if err != nil {
+			return nil, err
+		}
+		err = storage.SetCertificateAuthority(*created)
+		if err != nil {
+			return nil, err
+		}
+		stored = created
+	}
+
+	signer, err := ParsePrivateKey(stored.Algorithm, stored.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(stored.Certificate)
+	if block == nil {
+		return nil, errors.New("root certificate is not PEM encoded")
+	}
+	certificate, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	return &CertificateAuthority{
+		storage:          storage,
+		privateKey:       signer,
+		certificate:      certificate,
+		CertificateChain: stored.Certificate,
+	}, nil
+}
+
+// createCertificateAuthority generates a root key and its self-signed root certificate.
+func createCertificateAuthority(algorithm domain.CryptoAlgorithmType, now time.Time, validity time.Duration, options ...KeyOption) (*domain.CertificateAuthority, error) {
+	_, privateKey, err := GenerateKeyPair(algorithm, options...)
+	if err != nil {
+		return nil, err
+	}
+	signer, err := ParsePrivateKey(algorithm, privateKey)
+	if err != nil {
+		return nil, err
+	}
+	serialNumber, err := NewSerialNumber()
+	if err != nil {
+		return nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber:          serialNumber,
+		Subject:               pkix.Name{CommonName: CertificateAuthorityLabel},
+		NotBefore:             now.Add(-time.Minute),
+		NotAfter:              now.Add(validity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		MaxPathLenZero:        true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, signer.Public(), signer)
+	if err != nil {
+		return nil, err
+	}
+
+	return &domain.CertificateAuthority{
+		Algorithm:  algorithm,
+		PrivateKey: privateKey,
+		Certificate: pem.EncodeToMemory(&pem.Block{
+			Type:  "CERTIFICATE",
+			Bytes: der,
+		}),
+	}, nil
+}
+
+// Certificate returns the self-signed root certificate.
+func (a *CertificateAuthority) Certificate() (*x509.Certificate, error) {
+	return a.certificate, nil
+}
+
+// IssueCertificate issues a certificate that binds the device id and label to the device public key.
+// It returns the PEM encoded chain of the issued certificate followed by the root certificate.
+func (a *CertificateAuthority) IssueCertificate(device *domain.Device, now time.Time, validity time.Duration) ([]byte, error) {
+	if device.IsRetired() {
+		return nil, fmt.Errorf("certificates are not issued for retired devices: %w", persistence.ErrDeviceRetired)
+	}
+	publicKey, err := ParsePublicKey(device.Algorithm, device.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	serialNumber, err := NewSerialNumber()
+	if err != nil {
+		return nil, err
+	}
+	notAfter := now.Add(validity)
+	if notAfter.After(a.certificate.NotAfter) {
+		notAfter = a.certificate.NotAfter
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+		SerialNumber:          serialNumber,
+		Subject:               DeviceSubject(device),
+		URIs:                  []*url.URL{deviceURI(device)},
+		NotBefore:             now.Add(-time.Minute),
+		NotAfter:              notAfter,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageContentCommitment,
+		BasicConstraintsValid: true,
+	}, a.certificate, publicKey, a.privateKey)
+	if err != nil {
+		return nil, err
+	}
+	err = a.storage.AddIssuedCertificate(domain.IssuedCertificate{
+		DeviceId:     device.Id,
+		SerialNumber: serialNumber,
+		IssuedAt:     now,
+		NotAfter:     notAfter,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	leaf := pem.EncodeToMemory(&pem.Block{
+		Type:  "CERTIFICATE",
+		Bytes: der,
+	})
+	return append(leaf, a.CertificateChain...), nil
+}
+
+// CreateRevocationList creates a DER encoded CRL that revokes every certificate
+// the authority issued to retired devices. It is valid until nextUpdate.
+func (a *CertificateAuthority) CreateRevocationList(devices []*domain.Device, now time.Time, nextUpdate time.Time) ([]byte, error) {
+	var entries []x509.RevocationListEntry
+	for _, device := range devices {
+		if !device.IsRetired() {
+			continue
+		}
+		issued, err := a.storage.ListIssuedCertificates(device.Id)
+		if err != nil {
+			return nil, err
+		}
+		for _, certificate := range issued {
+			entries = append(entries, x509.RevocationListEntry{
+				SerialNumber:   certificate.SerialNumber,
+				RevocationTime: device.RetiredAt,
+				ReasonCode:     5, // cessationOfOperation
+			})
+		}
+	}
+
+	return x509.CreateRevocationList(rand.Reader, &x509.RevocationList{
+		RevokedCertificateEntries: entries,
+		Number:                    big.NewInt(now.UnixNano()),
+		ThisUpdate:                now,
+		NextUpdate:                nextUpdate,
+	}, a.certificate, a.privateKey)
+}
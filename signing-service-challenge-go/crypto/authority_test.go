@@ -0,0 +1,97 @@
+package crypto
+
+import (
+	"crypto/rsa"
+	"crypto/x509"
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/assert"
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/domain"
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/persistence"
+	"testing"
+	"time"
+)
+
+func newTestDevice(algorithm domain.CryptoAlgorithmType, label string) *domain.Device {
+	deviceId, _ := storage.CreateSignatureDevice("test", algorithm, label)
+	publicKey, privateKey, _ := GenerateKeyPair(algorithm)
+	storage.SetDeviceKeys(deviceId, publicKey, privateKey)
+	return storage.GetDevice(deviceId)
+}
+
+func TestCertificateAuthority_IssueCertificate(t *testing.T) {
+	now := time.Now()
+	authority, err := NewCertificateAuthority(persistence.NewLocalStorage(), domain.ECC, now, time.Hour)
+	assert.ShouldBe(t, err, nil)
+	device := newTestDevice(domain.RSA, "till 1")
+
+	chainBytes, err := authority.IssueCertificate(device, now, 24*time.Hour)
+	assert.ShouldBe(t, err, nil)
+	chain, _ := ParseCertificateChain(chainBytes)
+	assert.ShouldBe(t, len(chain), 2)
+	assert.ShouldBe(t, chain[0].Subject.CommonName, device.Id)
+	assert.ShouldBe(t, chain[0].Subject.OrganizationalUnit[0], "till 1")
+	assert.ShouldBe(t, chain[0].NotAfter.Equal(chain[1].NotAfter), true)
+	assert.ShouldBe(t, VerifyCertificateChain(device, chain, now), nil)
+	assert.ShouldBe(t, VerifyCertificateChain(device, chain, now.Add(2*time.Hour)) != nil, true)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(chain[1])
+	_, err = chain[0].Verify(x509.VerifyOptions{Roots: roots, CurrentTime: now})
+	assert.ShouldBe(t, err, nil)
+}
+
+func TestCertificateAuthority_CreateRevocationList(t *testing.T) {
+	now := time.Now()
+	authority, _ := NewCertificateAuthority(persistence.NewLocalStorage(), domain.ECC, now, time.Hour)
+	retiredDevice := newTestDevice(domain.ECC, "")
+	activeDevice := newTestDevice(domain.ECC, "")
+	var retiredChains [][]*x509.Certificate
+	for _, device := range []*domain.Device{retiredDevice, retiredDevice, activeDevice} {
+		chainBytes, _ := authority.IssueCertificate(device, now, time.Hour)
+		storage.SetDeviceCertificate(device.Id, chainBytes)
+		chain, _ := ParseCertificateChain(chainBytes)
+		if device == retiredDevice {
+			retiredChains = append(retiredChains, chain)
+		}
+	}
+	// Replacing the chain does not take the issued certificates off the revocation list.
+	selfSigned, _ := CreateSelfSignedCertificate(retiredDevice, now, time.Hour)
+	storage.SetDeviceCertificate(retiredDevice.Id, selfSigned)
+	storage.RetireDevice(retiredDevice.Id, now)
+
+	der, err := authority.CreateRevocationList(storage.ListDevices(), now, now.Add(time.Hour))
+	assert.ShouldBe(t, err, nil)
+	crl, _ := x509.ParseRevocationList(der)
+	root, _ := authority.Certificate()
+	assert.ShouldBe(t, crl.CheckSignatureFrom(root), nil)
+	assert.ShouldBe(t, len(crl.RevokedCertificateEntries), 2)
+	for i, chain := range retiredChains {
+		assert.ShouldBe(t, crl.RevokedCertificateEntries[i].SerialNumber.Cmp(chain[0].SerialNumber), 0)
+	}
+}
+
+func TestNewCertificateAuthority_LoadsStoredRoot(t *testing.T) {
+	now := time.Now()
+	authorityStorage := persistence.NewLocalStorage()
+	authority, err := NewCertificateAuthority(authorityStorage, domain.ECC, now, time.Hour)
+	assert.ShouldBe(t, err, nil)
+	device := newTestDevice(domain.ECC, "")
+	chainBytes, _ := authority.IssueCertificate(device, now, time.Hour)
+
+	restarted, err := NewCertificateAuthority(authorityStorage, domain.RSA, now, time.Hour)
+	assert.ShouldBe(t, err, nil)
+	assert.ShouldBe(t, string(restarted.CertificateChain), string(authority.CertificateChain))
+	chain, _ := ParseCertificateChain(chainBytes)
+	root, _ := restarted.Certificate()
+	assert.ShouldBe(t, chain[0].CheckSignatureFrom(root), nil)
+	issued, _ := authorityStorage.ListIssuedCertificates(device.Id)
+	assert.ShouldBe(t, len(issued), 1)
+	assert.ShouldBe(t, issued[0].SerialNumber.Cmp(chain[0].SerialNumber), 0)
+}
+
+func TestNewCertificateAuthority_KeyOptions(t *testing.T) {
+	authority, err := NewCertificateAuthority(persistence.NewLocalStorage(), domain.RSA, time.Now(), time.Hour, WithRSAKeySize(3072))
+	assert.ShouldBe(t, err, nil)
+	root, _ := authority.Certificate()
+	assert.ShouldBe(t, root.PublicKey.(*rsa.PublicKey).N.BitLen(), 3072)
+	assert.ShouldBe(t, root.CheckSignatureFrom(root), nil)
+}
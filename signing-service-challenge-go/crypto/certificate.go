@@ -0,0 +1,146 @@
+package crypto
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/domain"
+	"math/big"
+	"net/url"
+	"time"
+)
+
+// ErrCertificateKeyMismatch is returned when a certificate does not certify the device public key.
+var ErrCertificateKeyMismatch = errors.New("certificate does not match the device public key")
+
+// DeviceSubject returns the distinguished name that identifies a device in certificates.
+func DeviceSubject(device *domain.Device) pkix.Name {
+	return pkix.Name{
+		CommonName:         device.Id,
+		OrganizationalUnit: []string{device.Label},
+	}
+}
+
+// deviceURI returns the URI subject alternative name of a device.
+func deviceURI(device *domain.Device) *url.URL {
+	return &url.URL{Scheme: "urn", Opaque: "uuid:" + device.Id}
+}
+
+// CreateCertificateRequest creates a PEM encoded PKCS#10 certificate signing request for the device key.
+func CreateCertificateRequest(device *domain.Device) ([]byte, error) {
+	privateKey, err := ParsePrivateKey(device.Algorithm, device.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject: DeviceSubject(device),
+		URIs:    []*url.URL{deviceURI(device)},
+	}, privateKey)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{
+		Type:  "CERTIFICATE REQUEST",
+		Bytes: der,
+	}), nil
+}
+
+// CreateSelfSignedCertificate creates a PEM encoded certificate for the device key,
+// signed by the device key itself and valid from now on for the given duration.
+func CreateSelfSignedCertificate(device *domain.Device, now time.Time, validity time.Duration) ([]byte, error) {
+	privateKey, err := ParsePrivateKey(device.Algorithm, device.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+	serialNumber, err := NewSerialNumber()
+	if err != nil {
+		return nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber:          serialNumber,
+		Subject:               DeviceSubject(device),
+		URIs:                  []*url.URL{deviceURI(device)},
+		NotBefore:             now.Add(-time.Minute),
+		NotAfter:              now.Add(validity),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageContentCommitment,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, privateKey.Public(), privateKey)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{
+		Type:  "CERTIFICATE",
+		Bytes: der,
+	}), nil
+}
+
+// NewSerialNumber generates a random positive 128 bit certificate serial number.
+func NewSerialNumber() (*big.Int, error) {
+	return rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+}
+
+// ParseCertificateChain decodes all PEM encoded certificates of a chain, leaf first.
+func ParseCertificateChain(chainBytes []byte) ([]*x509.Certificate, error) {
+	var chain []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, chainBytes = pem.Decode(chainBytes)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			return nil, fmt.Errorf("unexpected PEM block %q in certificate chain", block.Type)
+		}
+		certificate, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, certificate)
+	}
+	if len(chain) == 0 {
+		return nil, errors.New("certificate chain is empty")
+	}
+	return chain, nil
+}
+
+// VerifyCertificateChain checks that the leaf of a chain certifies the device public key,
+// that every certificate of the chain is issued by its successor and that the chain is
+// valid at now up to its last certificate, with CA certificates above the leaf.
+func VerifyCertificateChain(device *domain.Device, chain []*x509.Certificate, now time.Time) error {
+	publicKey, err := ParsePublicKey(device.Algorithm, device.PublicKey)
+	if err != nil {
+		return err
+	}
+	leafKey, ok := chain[0].PublicKey.(interface{ Equal(x crypto.PublicKey) bool })
+	if !ok || !leafKey.Equal(publicKey) {
+		return ErrCertificateKeyMismatch
+	}
+	for i := 0; i < len(chain)-1; i++ {
+		err := chain[i].CheckSignatureFrom(chain[i+1])
+		if err != nil {
+			return fmt.Errorf("certificate %d is not issued by certificate %d: %w", i, i+1, err)
+		}
+	}
+
+	roots := x509.NewCertPool()
+	roots.AddCert(chain[len(chain)-1])
+	intermediates := x509.NewCertPool()
+	for _, certificate := range chain[1:] {
+		intermediates.AddCert(certificate)
+	}
+	_, err = chain[0].Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		CurrentTime:   now,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	if err != nil {
+		return fmt.Errorf("certificate chain is not valid: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,142 @@
+package crypto
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/domain"
+)
+
+const (
+	coseHeaderAlgorithm = 1
+	coseHeaderKeyId     = 4
+	coseHeaderCounter   = "counter"
+	coseSign1Tag        = 18
+)
+
+// COSEAlgorithm returns the COSE algorithm identifier (RFC 9053, RFC 8812) used by devices of the given algorithm.
+func COSEAlgorithm(algorithm domain.CryptoAlgorithmType) (int64, error) {
+	switch algorithm {
+	case domain.RSA:
+		return -257, nil // RS256
+	case domain.ECC:
+		return -35, nil // ES384
+	default:
+		return 0, fmt.Errorf("algorithm %s has no COSE equivalent", algorithm)
+	}
+}
+
+// COSESign1Input encodes the protected header for the device and signature counter.
+// It returns the encoded header and the Sig_structure to be signed for the payload (RFC 9052, Section 4.4).
+func COSESign1Input(device *domain.Device, counter int, payload []byte) ([]byte, []byte, error) {
+	algorithm, err := COSEAlgorithm(device.Algorithm)
+	if err != nil {
+		return nil, nil, err
+	}
+	// Map keys are written in the deterministic CBOR order: 1, 4, "counter".
+	protected := cborHead(5, 3)
+	protected = append(protected, cborInt(coseHeaderAlgorithm)...)
+	protected = append(protected, cborInt(algorithm)...)
+	protected = append(protected, cborInt(coseHeaderKeyId)...)
+	protected = append(protected, cborBytes([]byte(device.Id))...)
+	protected = append(protected, cborText(coseHeaderCounter)...)
+	protected = append(protected, cborInt(int64(counter))...)
+
+	toBeSigned := cborHead(4, 4)
+	toBeSigned = append(toBeSigned, cborText("Signature1")...)
+	toBeSigned = append(toBeSigned, cborBytes(protected)...)
+	toBeSigned = append(toBeSigned, cborBytes(nil)...)
+	toBeSigned = append(toBeSigned, cborBytes(payload)...)
+	return protected, toBeSigned, nil
+}
+
+// COSESign1Detached assembles a tagged COSE_Sign1 message with a detached payload.
+func COSESign1Detached(protected []byte, signature []byte) []byte {
+	message := cborHead(6, coseSign1Tag)
+	message = append(message, cborHead(4, 4)...)
+	message = append(message, cborBytes(protected)...)
+	message = append(message, cborHead(5, 0)...)
+	message = append(message, 0xf6) // nil payload
+	message = append(message, cborBytes(signature)...)
+	return message
+}
+
+// COSESign1Payload returns the payload of a Sig_structure created by COSESign1Input.
+func COSESign1Payload(toBeSigned []byte) ([]byte, error) {
+	rest := toBeSigned
+	for _, majorType := range []byte{4, 3, 2, 2} {
+		head, argument, remainder, err := cborReadHead(rest)
+		if err != nil || head != majorType {
+			return nil, ErrMalformedSigStructure
+		}
+		rest = remainder
+		if majorType != 4 {
+			if uint64(len(rest)) < argument {
+				return nil, ErrMalformedSigStructure
+			}
+			rest = rest[argument:]
+		}
+	}
+	head, length, payload, err := cborReadHead(rest)
+	if err != nil || head != 2 || uint64(len(payload)) != length {
+		return nil, ErrMalformedSigStructure
+	}
+	return payload, nil
+}
+
+// ErrMalformedSigStructure is returned for bytes that are not a COSE_Sign1 Sig_structure.
+var ErrMalformedSigStructure = errors.New("malformed COSE Sig_structure")
+
+// cborReadHead decodes the initial bytes of a CBOR data item, it returns the major type,
+// the argument and the bytes following the head.
+func cborReadHead(data []byte) (byte, uint64, []byte, error) {
+	if len(data) == 0 {
+		return 0, 0, nil, ErrMalformedSigStructure
+	}
+	majorType, additional := data[0]>>5, data[0]&0x1f
+	data = data[1:]
+	if additional < 24 {
+		return majorType, uint64(additional), data, nil
+	}
+	size := map[byte]int{24: 1, 25: 2, 26: 4, 27: 8}[additional]
+	if size == 0 || len(data) < size {
+		return 0, 0, nil, ErrMalformedSigStructure
+	}
+	var argument uint64
+	for _, b := range data[:size] {
+		argument = argument<<8 | uint64(b)
+	}
+	return majorType, argument, data[size:], nil
+}
+
+// cborHead encodes the initial bytes of a CBOR data item of the given major type.
+func cborHead(majorType byte, argument uint64) []byte {
+	major := majorType << 5
+	switch {
+	case argument < 24:
+		return []byte{major | byte(argument)}
+	case argument <= 0xff:
+		return []byte{major | 24, byte(argument)}
+	case argument <= 0xffff:
+		return binary.BigEndian.AppendUint16([]byte{major | 25}, uint16(argument))
+	case argument <= 0xffffffff:
+		return binary.BigEndian.AppendUint32([]byte{major | 26}, uint32(argument))
+	default:
+		return binary.BigEndian.AppendUint64([]byte{major | 27}, argument)
+	}
+}
+
+func cborInt(value int64) []byte {
+	if value < 0 {
+		return cborHead(1, uint64(-1-value))
+	}
+	return cborHead(0, uint64(value))
+}
+
+func cborBytes(value []byte) []byte {
+	return append(cborHead(2, uint64(len(value))), value...)
+}
+
+func cborText(value string) []byte {
+	return append(cborHead(3, uint64(len(value))), value...)
+}
@@ -5,15 +5,29 @@ import (
 	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/rsa"
+	"fmt"
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/domain"
 )
 
-// RSAGenerator generates a RSA key pair.
-type RSAGenerator struct{}
+// RSAKeySize is the default modulus size of generated RSA keys in bits, it is also the minimum.
+// Go refuses to operate on RSA keys below 1024 bits.
+const RSAKeySize = 2048
+
+// RSAKeySizes lists the modulus sizes RSA keys can be generated with.
+var RSAKeySizes = []int{2048, 3072, 4096}
+
+// RSAGenerator generates a RSA key pair with a modulus of Bits, RSAKeySize if unset.
+type RSAGenerator struct {
+	Bits int
+}
 
 // Generate generates a new RSAKeyPair.
 func (g *RSAGenerator) Generate() (*RSAKeyPair, error) {
-	// Security has been ignored for the sake of simplicity.
-	key, err := rsa.GenerateKey(rand.Reader, 512)
+	bits := g.Bits
+	if bits == 0 {
+		bits = RSAKeySize
+	}
+	key, err := rsa.GenerateKey(rand.Reader, bits)
 	if err != nil {
 		return nil, err
 	}
@@ -40,3 +54,46 @@ func (g *ECCGenerator) Generate() (*ECCKeyPair, error) {
 		Private: key,
 	}, nil
 }
+
+// KeyOptions holds the parameters of generated key pairs.
+type KeyOptions struct {
+	RSAKeySize int
+}
+
+// KeyOption configures the parameters of generated key pairs.
+type KeyOption func(*KeyOptions)
+
+// WithRSAKeySize sets the modulus size of generated RSA keys in bits.
+func WithRSAKeySize(bits int) KeyOption {
+	return func(o *KeyOptions) {
+		o.RSAKeySize = bits
+	}
+}
+
+// GenerateKeyPair generates a new key pair for the given algorithm.
+// It returns the public and the private key encoded by the algorithm's marshaler.
+func GenerateKeyPair(algorithm domain.CryptoAlgorithmType, options ...KeyOption) ([]byte, []byte, error) {
+	var keyOptions KeyOptions
+	for _, option := range options {
+		option(&keyOptions)
+	}
+	switch algorithm {
+	case domain.RSA:
+		generator := RSAGenerator{Bits: keyOptions.RSAKeySize}
+		keyPair, err := generator.Generate()
+		if err != nil {
+			return nil, nil, err
+		}
+		marshaler := NewRSAMarshaler()
+		return marshaler.Marshal(*keyPair)
+	case domain.ECC:
+		generator := ECCGenerator{}
+		keyPair, err := generator.Generate()
+		if err != nil {
+			return nil, nil, err
+		}
+		return NewECCMarshaler().Encode(*keyPair)
+	default:
+		return nil, nil, fmt.Errorf("algorithm %s is not implemented", algorithm)
+	}
+}
@@ -0,0 +1,66 @@
+package crypto
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"fmt"
+	"math/big"
+)
+
+// JWK is the JSON Web Key (RFC 7517) representation of a public key.
+type JWK struct {
+	KeyType string `json:"kty"`
+	KeyId   string `json:"kid,omitempty"`
+	Use     string `json:"use,omitempty"`
+	Curve   string `json:"crv,omitempty"`
+	X       string `json:"x,omitempty"`
+	Y       string `json:"y,omitempty"`
+	N       string `json:"n,omitempty"`
+	E       string `json:"e,omitempty"`
+}
+
+// NewJWK converts an RSA or ECC public key into a JWK identified by keyId.
+func NewJWK(publicKey crypto.PublicKey, keyId string) (*JWK, error) {
+	switch key := publicKey.(type) {
+	case *rsa.PublicKey:
+		return &JWK{
+			KeyType: "RSA",
+			KeyId:   keyId,
+			Use:     "sig",
+			N:       base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			E:       base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}, nil
+	case *ecdsa.PublicKey:
+		curve, size, err := jwkCurve(key.Curve)
+		if err != nil {
+			return nil, err
+		}
+		return &JWK{
+			KeyType: "EC",
+			KeyId:   keyId,
+			Use:     "sig",
+			Curve:   curve,
+			X:       base64.RawURLEncoding.EncodeToString(key.X.FillBytes(make([]byte, size))),
+			Y:       base64.RawURLEncoding.EncodeToString(key.Y.FillBytes(make([]byte, size))),
+		}, nil
+	default:
+		return nil, fmt.Errorf("public key of type %T is not supported", publicKey)
+	}
+}
+
+// jwkCurve returns the JWK curve name and the coordinate size in bytes.
+func jwkCurve(curve elliptic.Curve) (string, int, error) {
+	switch curve {
+	case elliptic.P256():
+		return "P-256", 32, nil
+	case elliptic.P384():
+		return "P-384", 48, nil
+	case elliptic.P521():
+		return "P-521", 66, nil
+	default:
+		return "", 0, fmt.Errorf("curve %s is not supported", curve.Params().Name)
+	}
+}
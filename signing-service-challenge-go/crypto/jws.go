@@ -0,0 +1,91 @@
+package crypto
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/domain"
+	"math/big"
+	"strings"
+)
+
+// JWSHeader is the protected header of the JWS signatures created by the service.
+type JWSHeader struct {
+	Algorithm string `json:"alg"`
+	KeyId     string `json:"kid"`
+	Counter   int    `json:"counter"`
+}
+
+// JWSAlgorithm returns the JWS algorithm (RFC 7518) used by devices of the given algorithm.
+func JWSAlgorithm(algorithm domain.CryptoAlgorithmType) (string, error) {
+	switch algorithm {
+	case domain.RSA:
+		return "RS256", nil
+	case domain.ECC:
+		return "ES384", nil
+	default:
+		return "", fmt.Errorf("algorithm %s has no JWS equivalent", algorithm)
+	}
+}
+
+// JWSSigningInput encodes the protected header for the device and signature counter.
+// It returns the encoded header and the JWS signing input for the payload.
+func JWSSigningInput(device *domain.Device, counter int, payload []byte) (string, []byte, error) {
+	algorithm, err := JWSAlgorithm(device.Algorithm)
+	if err != nil {
+		return "", nil, err
+	}
+	header, err := json.Marshal(JWSHeader{
+		Algorithm: algorithm,
+		KeyId:     device.Id,
+		Counter:   counter,
+	})
+	if err != nil {
+		return "", nil, err
+	}
+	encodedHeader := base64.RawURLEncoding.EncodeToString(header)
+	return encodedHeader, []byte(encodedHeader + "." + base64.RawURLEncoding.EncodeToString(payload)), nil
+}
+
+// JWSPayload returns the payload of a JWS signing input created by JWSSigningInput.
+func JWSPayload(signingInput []byte) ([]byte, error) {
+	_, payload, found := strings.Cut(string(signingInput), ".")
+	if !found {
+		return nil, errors.New("JWS signing input has no payload")
+	}
+	return base64.RawURLEncoding.DecodeString(payload)
+}
+
+// JWSCompactDetached assembles the compact serialization of a JWS with a detached payload (RFC 7515, Appendix F).
+func JWSCompactDetached(encodedHeader string, signature []byte) string {
+	return encodedHeader + ".." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+// JOSESignature converts a signature created by a Signer into the encoding JOSE and COSE expect.
+// ECDSA signatures are turned from ASN.1 DER into the fixed size concatenation of R and S,
+// all other signatures are returned unchanged.
+func JOSESignature(publicKey crypto.PublicKey, signature []byte) ([]byte, error) {
+	eccPublicKey, ok := publicKey.(*ecdsa.PublicKey)
+	if !ok {
+		return signature, nil
+	}
+	var esig struct {
+		R, S *big.Int
+	}
+	rest, err := asn1.Unmarshal(signature, &esig)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) != 0 {
+		return nil, errors.New("trailing data after ecdsa signature")
+	}
+	size := (eccPublicKey.Curve.Params().BitSize + 7) / 8
+	raw := make([]byte, 2*size)
+	esig.R.FillBytes(raw[:size])
+	esig.S.FillBytes(raw[size:])
+	return raw, nil
+}
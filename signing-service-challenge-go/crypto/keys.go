@@ -0,0 +1,71 @@
+package crypto
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/domain"
+)
+
+// ParsePublicKey decodes a public key that was encoded by the marshaler of the given algorithm.
+func ParsePublicKey(algorithm domain.CryptoAlgorithmType, publicKeyBytes []byte) (crypto.PublicKey, error) {
+	switch algorithm {
+	case domain.RSA:
+		marshaler := NewRSAMarshaler()
+		return marshaler.UnmarshalPublic(publicKeyBytes)
+	case domain.ECC:
+		return NewECCMarshaler().DecodePublic(publicKeyBytes)
+	default:
+		return nil, fmt.Errorf("algorithm %s is not implemented", algorithm)
+	}
+}
+
+// PublicKeyDER returns the DER encoded SubjectPublicKeyInfo of an encoded public key,
+// whatever encoding the marshaler of the algorithm stores it in.
+func PublicKeyDER(algorithm domain.CryptoAlgorithmType, publicKeyBytes []byte) ([]byte, error) {
+	publicKey, err := ParsePublicKey(algorithm, publicKeyBytes)
+	if err != nil {
+		return nil, err
+	}
+	return x509.MarshalPKIXPublicKey(publicKey)
+}
+
+// PublicKeyPEM re-encodes a public key under the standard PEM label of its encoding,
+// "RSA PUBLIC KEY" for PKCS #1 RSA keys and "PUBLIC KEY" for SubjectPublicKeyInfo.
+func PublicKeyPEM(algorithm domain.CryptoAlgorithmType, publicKeyBytes []byte) ([]byte, error) {
+	publicKey, err := ParsePublicKey(algorithm, publicKeyBytes)
+	if err != nil {
+		return nil, err
+	}
+	if rsaPublicKey, ok := publicKey.(*rsa.PublicKey); ok {
+		return pem.EncodeToMemory(&pem.Block{Type: "RSA PUBLIC KEY", Bytes: x509.MarshalPKCS1PublicKey(rsaPublicKey)}), nil
+	}
+	der, err := x509.MarshalPKIXPublicKey(publicKey)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), nil
+}
+
+// ParsePrivateKey decodes a private key that was encoded by the marshaler of the given algorithm.
+func ParsePrivateKey(algorithm domain.CryptoAlgorithmType, privateKeyBytes []byte) (crypto.Signer, error) {
+	switch algorithm {
+	case domain.RSA:
+		marshaler := NewRSAMarshaler()
+		keyPair, err := marshaler.Unmarshal(privateKeyBytes)
+		if err != nil {
+			return nil, err
+		}
+		return keyPair.Private, nil
+	case domain.ECC:
+		keyPair, err := NewECCMarshaler().Decode(privateKeyBytes)
+		if err != nil {
+			return nil, err
+		}
+		return keyPair.Private, nil
+	default:
+		return nil, fmt.Errorf("algorithm %s is not implemented", algorithm)
+	}
+}
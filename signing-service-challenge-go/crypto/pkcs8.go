@@ -0,0 +1,315 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/domain"
+)
+
+const (
+	pkcs8Iterations    = 100000
+	pkcs8MaxIterations = 10 * pkcs8Iterations
+	pkcs8SaltSize      = 16
+	pkcs8KeySize       = 32
+)
+
+var (
+	oidPBES2          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 13}
+	oidPBKDF2         = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 12}
+	oidHMACWithSHA256 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 9}
+	oidAES256CBC      = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 42}
+)
+
+// ErrKeyAlgorithmMismatch is returned when a private key does not belong to the declared algorithm.
+var ErrKeyAlgorithmMismatch = errors.New("private key does not match the declared algorithm")
+
+// ErrInvalidKey is returned when a private key can not be decoded as a key of any algorithm.
+var ErrInvalidKey = errors.New("private key is invalid")
+
+// ErrDecryption is returned when an encrypted private key cannot be decrypted with the given password.
+var ErrDecryption = errors.New("private key could not be decrypted, the password is wrong or the key is corrupt")
+
+type encryptedPrivateKeyInfo struct {
+	EncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedData       []byte
+}
+
+type pbes2Parameters struct {
+	KeyDerivationFunc pkix.AlgorithmIdentifier
+	EncryptionScheme  pkix.AlgorithmIdentifier
+}
+
+type pbkdf2Parameters struct {
+	Salt           []byte
+	IterationCount int
+	KeyLength      int                      `asn1:"optional"`
+	PRF            pkix.AlgorithmIdentifier `asn1:"optional"`
+}
+
+// EncryptPKCS8 encrypts a private key with a password as PKCS#8 (PBES2, PBKDF2 with
+// HMAC-SHA256 and AES-256-CBC) and returns it PEM encoded.
+func EncryptPKCS8(privateKey any, password []byte) ([]byte, error) {
+	return encryptPKCS8(privateKey, password, pkcs8Iterations)
+}
+
+func encryptPKCS8(privateKey any, password []byte, iterations int) ([]byte, error) {
+	if len(password) == 0 {
+		return nil, errors.New("password must not be empty")
+	}
+	plaintext, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		return nil, err
+	}
+
+	salt := make([]byte, pkcs8SaltSize)
+	iv := make([]byte, aes.BlockSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	if _, err := rand.Read(iv); err != nil {
+		return nil, err
+	}
+	key, err := pbkdf2.Key(sha256.New, string(password), salt, iterations, pkcs8KeySize)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	padding := aes.BlockSize - len(plaintext)%aes.BlockSize
+	ciphertext := append(plaintext, bytes.Repeat([]byte{byte(padding)}, padding)...)
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(ciphertext, ciphertext)
+
+	kdfParameters, err := asn1.Marshal(pbkdf2Parameters{
+		Salt:           salt,
+		IterationCount: iterations,
+		KeyLength:      pkcs8KeySize,
+		PRF:            pkix.AlgorithmIdentifier{Algorithm: oidHMACWithSHA256, Parameters: asn1.NullRawValue},
+	})
+	if err != nil {
+		return nil, err
+	}
+	ivParameter, err := asn1.Marshal(iv)
+	if err != nil {
+		return nil, err
+	}
+	schemeParameters, err := asn1.Marshal(pbes2Parameters{
+		KeyDerivationFunc: pkix.AlgorithmIdentifier{Algorithm: oidPBKDF2, Parameters: asn1.RawValue{FullBytes: kdfParameters}},
+		EncryptionScheme:  pkix.AlgorithmIdentifier{Algorithm: oidAES256CBC, Parameters: asn1.RawValue{FullBytes: ivParameter}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	der, err := asn1.Marshal(encryptedPrivateKeyInfo{
+		EncryptionAlgorithm: pkix.AlgorithmIdentifier{Algorithm: oidPBES2, Parameters: asn1.RawValue{FullBytes: schemeParameters}},
+		EncryptedData:       ciphertext,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return pem.EncodeToMemory(&pem.Block{
+		Type:  "ENCRYPTED PRIVATE KEY",
+		Bytes: der,
+	}), nil
+}
+
+// DecryptPKCS8 decrypts a PEM encoded private key that was encrypted by EncryptPKCS8
+// or any other PKCS#8 implementation using PBES2 with HMAC-SHA256 and AES-256-CBC.
+func DecryptPKCS8(encryptedKeyBytes []byte, password []byte) (any, error) {
+	block, _ := pem.Decode(encryptedKeyBytes)
+	if block == nil || block.Type != "ENCRYPTED PRIVATE KEY" {
+		return nil, errors.New("private key is not an encrypted PKCS#8 key")
+	}
+
+	var info encryptedPrivateKeyInfo
+	if _, err := asn1.Unmarshal(block.Bytes, &info); err != nil {
+		return nil, err
+	}
+	if !info.EncryptionAlgorithm.Algorithm.Equal(oidPBES2) {
+		return nil, fmt.Errorf("encryption algorithm %s is not supported", info.EncryptionAlgorithm.Algorithm)
+	}
+	var scheme pbes2Parameters
+	if _, err := asn1.Unmarshal(info.EncryptionAlgorithm.Parameters.FullBytes, &scheme); err != nil {
+		return nil, err
+	}
+	if !scheme.KeyDerivationFunc.Algorithm.Equal(oidPBKDF2) || !scheme.EncryptionScheme.Algorithm.Equal(oidAES256CBC) {
+		return nil, errors.New("only PBKDF2 with AES-256-CBC is supported")
+	}
+	var kdfParameters pbkdf2Parameters
+	if _, err := asn1.Unmarshal(scheme.KeyDerivationFunc.Parameters.FullBytes, &kdfParameters); err != nil {
+		return nil, err
+	}
+	if !kdfParameters.PRF.Algorithm.Equal(oidHMACWithSHA256) {
+		return nil, errors.New("only HMAC-SHA256 is supported as PBKDF2 pseudorandom function")
+	}
+	if kdfParameters.IterationCount < 1 || kdfParameters.IterationCount > pkcs8MaxIterations {
+		// Bounds the key derivation work an uploaded key can ask for.
+		return nil, fmt.Errorf("PBKDF2 iteration count must be between 1 and %d", pkcs8MaxIterations)
+	}
+	var iv []byte
+	if _, err := asn1.Unmarshal(scheme.EncryptionScheme.Parameters.FullBytes, &iv); err != nil {
+		return nil, err
+	}
+	if len(iv) != aes.BlockSize || len(info.EncryptedData) == 0 || len(info.EncryptedData)%aes.BlockSize != 0 {
+		return nil, ErrDecryption
+	}
+
+	key, err := pbkdf2.Key(sha256.New, string(password), kdfParameters.Salt, kdfParameters.IterationCount, pkcs8KeySize)
+	if err != nil {
+		return nil, err
+	}
+	cipherBlock, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	plaintext := make([]byte, len(info.EncryptedData))
+	cipher.NewCBCDecrypter(cipherBlock, iv).CryptBlocks(plaintext, info.EncryptedData)
+
+	padding := int(plaintext[len(plaintext)-1])
+	if padding == 0 || padding > aes.BlockSize || !bytes.Equal(plaintext[len(plaintext)-padding:], bytes.Repeat([]byte{byte(padding)}, padding)) {
+		return nil, ErrDecryption
+	}
+	privateKey, err := x509.ParsePKCS8PrivateKey(plaintext[:len(plaintext)-padding])
+	if err != nil {
+		return nil, ErrDecryption
+	}
+	return privateKey, nil
+}
+
+// ImportPrivateKey takes a private key in PEM format and encodes it with the marshaler
+// of the given algorithm. Accepted inputs are the marshaler's own encoding, unencrypted
+// PKCS#8 and encrypted PKCS#8, which requires the password.
+// It returns the public and the private key as a byte slice.
+func ImportPrivateKey(algorithm domain.CryptoAlgorithmType, privateKeyBytes []byte, password []byte) ([]byte, []byte, error) {
+	block, _ := pem.Decode(privateKeyBytes)
+	if block == nil {
+		return nil, nil, fmt.Errorf("%w: private key is not PEM encoded", ErrInvalidKey)
+	}
+
+	var privateKey any
+	var err error
+	switch block.Type {
+	case "ENCRYPTED PRIVATE KEY":
+		privateKey, err = DecryptPKCS8(privateKeyBytes, password)
+	case "PRIVATE KEY":
+		privateKey, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	default:
+		return importMarshaledPrivateKey(algorithm, privateKeyBytes)
+	}
+	if errors.Is(err, ErrDecryption) {
+		return nil, nil, err
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", ErrInvalidKey, err)
+	}
+
+	switch key := privateKey.(type) {
+	case *rsa.PrivateKey:
+		if algorithm != domain.RSA {
+			return nil, nil, ErrKeyAlgorithmMismatch
+		}
+		marshaler := NewRSAMarshaler()
+		encodedPublic, encodedPrivate, err := marshaler.Marshal(RSAKeyPair{Public: &key.PublicKey, Private: key})
+		if err != nil {
+			return nil, nil, err
+		}
+		return importMarshaledPrivateKey(algorithm, encodedPrivate, encodedPublic)
+	case *ecdsa.PrivateKey:
+		if algorithm != domain.ECC {
+			return nil, nil, ErrKeyAlgorithmMismatch
+		}
+		encodedPublic, encodedPrivate, err := NewECCMarshaler().Encode(ECCKeyPair{Public: &key.PublicKey, Private: key})
+		if err != nil {
+			return nil, nil, err
+		}
+		return importMarshaledPrivateKey(algorithm, encodedPrivate, encodedPublic)
+	default:
+		return nil, nil, ErrKeyAlgorithmMismatch
+	}
+}
+
+// importMarshaledPrivateKey validates a private key in the marshaler encoding of the
+// given algorithm and returns the public and the private key as a byte slice.
+// The public key is derived from the private key unless it is passed along.
+func importMarshaledPrivateKey(algorithm domain.CryptoAlgorithmType, privateKeyBytes []byte, publicKeyBytes ...[]byte) ([]byte, []byte, error) {
+	switch algorithm {
+	case domain.RSA:
+		marshaler := NewRSAMarshaler()
+		keyPair, err := marshaler.Unmarshal(privateKeyBytes)
+		if err != nil {
+			return nil, nil, keyDecodingError(algorithm, privateKeyBytes, err)
+		}
+		if keyPair.Private.N.BitLen() < RSAKeySize {
+			return nil, nil, fmt.Errorf("rsa private key must have at least %d bits", RSAKeySize)
+		}
+		if len(publicKeyBytes) > 0 {
+			return publicKeyBytes[0], privateKeyBytes, nil
+		}
+		return marshaler.Marshal(*keyPair)
+	case domain.ECC:
+		marshaler := NewECCMarshaler()
+		keyPair, err := marshaler.Decode(privateKeyBytes)
+		if err != nil {
+			return nil, nil, keyDecodingError(algorithm, privateKeyBytes, err)
+		}
+		if keyPair.Private.Curve != elliptic.P384() {
+			return nil, nil, errors.New("ecc private key must use the P-384 curve")
+		}
+		if len(publicKeyBytes) > 0 {
+			return publicKeyBytes[0], privateKeyBytes, nil
+		}
+		return marshaler.Encode(*keyPair)
+	default:
+		return nil, nil, fmt.Errorf("algorithm %s is not implemented", algorithm)
+	}
+}
+
+// ExportPrivateKey decodes a private key that was encoded by the marshaler of the given
+// algorithm and encrypts it with a password as PKCS#8.
+func ExportPrivateKey(algorithm domain.CryptoAlgorithmType, privateKeyBytes []byte, password []byte) ([]byte, error) {
+	switch algorithm {
+	case domain.RSA:
+		marshaler := NewRSAMarshaler()
+		keyPair, err := marshaler.Unmarshal(privateKeyBytes)
+		if err != nil {
+			return nil, keyDecodingError(algorithm, privateKeyBytes, err)
+		}
+		return EncryptPKCS8(keyPair.Private, password)
+	case domain.ECC:
+		keyPair, err := NewECCMarshaler().Decode(privateKeyBytes)
+		if err != nil {
+			return nil, keyDecodingError(algorithm, privateKeyBytes, err)
+		}
+		return EncryptPKCS8(keyPair.Private, password)
+	default:
+		return nil, fmt.Errorf("algorithm %s is not implemented", algorithm)
+	}
+}
+
+// keyDecodingError tells a key in the marshaler encoding of the other algorithm
+// apart from a key that can not be decoded at all.
+func keyDecodingError(algorithm domain.CryptoAlgorithmType, privateKeyBytes []byte, err error) error {
+	other := domain.RSA
+	if algorithm == domain.RSA {
+		other = domain.ECC
+	}
+	if _, otherErr := ParsePrivateKey(other, privateKeyBytes); otherErr == nil {
+		return ErrKeyAlgorithmMismatch
+	}
+	return fmt.Errorf("%w: %v", ErrInvalidKey, err)
+}
@@ -4,8 +4,11 @@ import (
 	"crypto"
 	"crypto/rand"
 	"crypto/sha256"
+	_ "crypto/sha512"
+	"fmt"
 	"github.com/DrMonez/coding-challenges/signing-service-challenge/domain"
 	"github.com/DrMonez/coding-challenges/signing-service-challenge/persistence"
+	"time"
 )
 
 // Signer defines a contract for different types of signing implementations.
@@ -13,67 +16,232 @@ type Signer interface {
 	Sign(dataToBeSigned []byte) ([]byte, error)
 }
 
+// SignatureMetadata is stored along with the signatures of a Signer.
+type SignatureMetadata struct {
+	CreatedAt      time.Time
+	PayloadVersion domain.PayloadVersion
+}
+
+// KeyLoadObserver is called before a signer loads the key pair of its device,
+// the function it returns is called with the outcome, e.g. to trace key parsing.
+type KeyLoadObserver func() func(err error)
+
+type signerOptions struct {
+	metadata        SignatureMetadata
+	keyLoadObserver KeyLoadObserver
+}
+
+// SignerOption configures optional behaviour of the signers created by NewSigner.
+type SignerOption func(*signerOptions)
+
+// WithMetadata makes the signer store its signatures with the given metadata. Without it
+// signatures are stored with the current time and payload version 1.
+func WithMetadata(metadata SignatureMetadata) SignerOption {
+	return func(options *signerOptions) {
+		options.metadata = metadata
+	}
+}
+
+// WithKeyLoadObserver makes the signer report every load of the key pair of its device.
+func WithKeyLoadObserver(observer KeyLoadObserver) SignerOption {
+	return func(options *signerOptions) {
+		options.keyLoadObserver = observer
+	}
+}
+
+// NewSigner is a factory to instantiate the Signer matching the device algorithm.
+// Signatures are created as required by the given format.
+func NewSigner(device *domain.Device, storage persistence.Storage, format domain.SignatureFormat, options ...SignerOption) (Signer, error) {
+	signerOptions := signerOptions{}
+	for _, option := range options {
+		option(&signerOptions)
+	}
+	switch device.Algorithm {
+	case domain.RSA:
+		return &RSASigner{
+			Storage:      storage,
+			RsaMarshaler: NewRSAMarshaler(),
+			RsaGenerator: RSAGenerator{},
+			Device:       device,
+			Format:       format,
+			Metadata:     signerOptions.metadata,
+			KeyLoad:      signerOptions.keyLoadObserver,
+		}, nil
+	case domain.ECC:
+		signer := ECCSigner{
+			Storage:      storage,
+			EccMarshaler: NewECCMarshaler(),
+			EccGenerator: ECCGenerator{},
+			Device:       device,
+			Format:       format,
+			Metadata:     signerOptions.metadata,
+			KeyLoad:      signerOptions.keyLoadObserver,
+		}
+		if format == domain.FormatJWS || format == domain.FormatCOSESign1 {
+			// JOSE and COSE pair the P-384 curve with SHA-384 (ES384).
+			signer.Hash = crypto.SHA384
+		}
+		return signer, nil
+	default:
+		return nil, fmt.Errorf("algorithm is not implemented")
+	}
+}
+
+// RSASigner signs with RSASSA-PKCS1-v1_5 and SHA-256 unless another Hash is given.
 type RSASigner struct {
 	Device       *domain.Device
 	Storage      persistence.Storage
 	RsaGenerator RSAGenerator
 	RsaMarshaler RSAMarshaler
+	Hash         crypto.Hash
+	Format       domain.SignatureFormat
+	Metadata     SignatureMetadata
+	KeyLoad      KeyLoadObserver
 }
 
 func (s RSASigner) Sign(dataToBeSigned []byte) ([]byte, error) {
-	keyPair, err := s.RsaGenerator.Generate()
+	keyPair, err := observeKeyLoad(s.KeyLoad, s.keyPair)
 	if err != nil {
 		return nil, err
 	}
-	digits := GetSha256Hash(dataToBeSigned)
+	hash := hashOrDefault(s.Hash)
+	digits := GetHash(hash, dataToBeSigned)
 
-	signedData, err := keyPair.Private.Sign(rand.Reader, digits, crypto.SHA256)
+	signedData, err := keyPair.Private.Sign(rand.Reader, digits, hash)
 	if err != nil {
 		return nil, err
 	}
-	marshaledPublicKey, marshaledPrivateKey, err := s.RsaMarshaler.Marshal(*keyPair)
-	if err != nil {
-		return nil, err
-	}
-	err = s.Storage.AddSignature(s.Device.Id, marshaledPublicKey, marshaledPrivateKey, signedData)
+	err = storeSignature(s.Storage, s.Device, s.Format, s.Metadata, dataToBeSigned, signedData)
 	if err != nil {
 		return nil, err
 	}
 	return signedData, nil
 }
 
+// keyPair loads the key pair of the device.
+// Devices that were created without a key pair get a new one assigned.
+func (s RSASigner) keyPair() (*RSAKeyPair, error) {
+	if len(s.Device.PrivateKey) == 0 {
+		keyPair, err := s.RsaGenerator.Generate()
+		if err != nil {
+			return nil, err
+		}
+		marshaledPublicKey, marshaledPrivateKey, err := s.RsaMarshaler.Marshal(*keyPair)
+		if err != nil {
+			return nil, err
+		}
+		err = s.Storage.SetDeviceKeys(s.Device.Id, marshaledPublicKey, marshaledPrivateKey)
+		if err != nil {
+			return nil, err
+		}
+		s.Device.PublicKey, s.Device.PrivateKey = marshaledPublicKey, marshaledPrivateKey
+		return keyPair, nil
+	}
+	return s.RsaMarshaler.Unmarshal(s.Device.PrivateKey)
+}
+
+// ECCSigner signs with ECDSA and SHA-256 unless another Hash is given.
+// Signatures are ASN.1 DER encoded.
 type ECCSigner struct {
 	Device       *domain.Device
 	Storage      persistence.Storage
 	EccGenerator ECCGenerator
 	EccMarshaler ECCMarshaler
+	Hash         crypto.Hash
+	Format       domain.SignatureFormat
+	Metadata     SignatureMetadata
+	KeyLoad      KeyLoadObserver
 }
 
 func (s ECCSigner) Sign(dataToBeSigned []byte) ([]byte, error) {
-	keyPair, err := s.EccGenerator.Generate()
+	keyPair, err := observeKeyLoad(s.KeyLoad, s.keyPair)
 	if err != nil {
 		return nil, err
 	}
-	digits := GetSha256Hash(dataToBeSigned)
+	hash := hashOrDefault(s.Hash)
+	digits := GetHash(hash, dataToBeSigned)
 
-	signedData, err := keyPair.Private.Sign(rand.Reader, digits, crypto.SHA256)
+	signedData, err := keyPair.Private.Sign(rand.Reader, digits, hash)
 	if err != nil {
 		return nil, err
 	}
 
-	marshaledPublicKey, marshaledPrivateKey, err := s.EccMarshaler.Encode(*keyPair)
-	if err != nil {
-		return nil, err
-	}
-	err = s.Storage.AddSignature(s.Device.Id, marshaledPublicKey, marshaledPrivateKey, signedData)
+	err = storeSignature(s.Storage, s.Device, s.Format, s.Metadata, dataToBeSigned, signedData)
 	if err != nil {
 		return nil, err
 	}
 	return signedData, nil
 }
 
+// keyPair loads the key pair of the device.
+// Devices that were created without a key pair get a new one assigned.
+func (s ECCSigner) keyPair() (*ECCKeyPair, error) {
+	if len(s.Device.PrivateKey) == 0 {
+		keyPair, err := s.EccGenerator.Generate()
+		if err != nil {
+			return nil, err
+		}
+		marshaledPublicKey, marshaledPrivateKey, err := s.EccMarshaler.Encode(*keyPair)
+		if err != nil {
+			return nil, err
+		}
+		err = s.Storage.SetDeviceKeys(s.Device.Id, marshaledPublicKey, marshaledPrivateKey)
+		if err != nil {
+			return nil, err
+		}
+		s.Device.PublicKey, s.Device.PrivateKey = marshaledPublicKey, marshaledPrivateKey
+		return keyPair, nil
+	}
+	return s.EccMarshaler.Decode(s.Device.PrivateKey)
+}
+
+// observeKeyLoad loads a key pair and reports it to the observer, if there is one.
+func observeKeyLoad[K any](observer KeyLoadObserver, load func() (K, error)) (K, error) {
+	if observer == nil {
+		return load()
+	}
+	done := observer()
+	keyPair, err := load()
+	done(err)
+	return keyPair, err
+}
+
+// storeSignature appends a signature to the signature chain of the device.
+func storeSignature(storage persistence.Storage, device *domain.Device, format domain.SignatureFormat, metadata SignatureMetadata, dataToBeSigned []byte, signedData []byte) error {
+	if format == "" {
+		format = domain.FormatRaw
+	}
+	if metadata.CreatedAt.IsZero() {
+		metadata = SignatureMetadata{CreatedAt: time.Now().UTC(), PayloadVersion: domain.PayloadV1}
+	}
+	_, err := storage.AddSignatureRecord(device.Id, domain.Signature{
+		SignedData: signedData,
+		PublicKey:  device.PublicKey,
+		PrivateKey: device.PrivateKey,
+		Data:       dataToBeSigned,
+		Format:     format,
+		// The metadata has been taken under the device lock, along with the counter.
+		CreatedAt:      metadata.CreatedAt,
+		PayloadVersion: metadata.PayloadVersion,
+	})
+	return err
+}
+
+func hashOrDefault(hash crypto.Hash) crypto.Hash {
+	if hash == 0 {
+		return crypto.SHA256
+	}
+	return hash
+}
+
 func GetSha256Hash(dataToBeSigned []byte) []byte {
 	hash := sha256.New()
 	hash.Write(dataToBeSigned)
 	return hash.Sum(nil)
 }
+
+func GetHash(hash crypto.Hash, dataToBeSigned []byte) []byte {
+	h := hash.New()
+	h.Write(dataToBeSigned)
+	return h.Sum(nil)
+}
@@ -26,11 +26,7 @@ func TestSignInfo(t *testing.T) {
 	assert.ShouldBe(t, err, nil)
 }
 
-var storage persistence.Storage = &persistence.LocalStorage{
-	UserDevices: make(map[string]map[string]struct{}),
-	Devices:     make(map[string]*domain.Device),
-	Signatures:  make(map[string]map[int]*domain.Signature),
-}
+var storage persistence.Storage = persistence.NewLocalStorage()
 
 var rsaSigner = RSASigner{
 	Storage:      storage,
@@ -71,3 +67,12 @@ func TestECCSigner_Sign(t *testing.T) {
 	isValid := ecdsa.Verify(keyPair.Public, GetSha256Hash(dataToBeSigned), esig.R, esig.S)
 	assert.ShouldBe(t, isValid, true)
 }
+
+func TestGenerateKeyPair_RSAKeySize(t *testing.T) {
+	_, privateKey, err := GenerateKeyPair(domain.RSA, WithRSAKeySize(3072))
+	assert.ShouldBe(t, err, nil)
+	marshaler := NewRSAMarshaler()
+	keyPair, err := marshaler.Unmarshal(privateKey)
+	assert.ShouldBe(t, err, nil)
+	assert.ShouldBe(t, keyPair.Private.N.BitLen(), 3072)
+}
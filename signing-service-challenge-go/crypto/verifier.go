@@ -0,0 +1,68 @@
+package crypto
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/domain"
+)
+
+// ErrInvalidSignature is returned when a signature does not match the signed data.
+var ErrInvalidSignature = errors.New("signature is not valid")
+
+// Verifier defines a contract for checking the signatures created by the Signer implementations.
+type Verifier interface {
+	Verify(signedData []byte, signature []byte) error
+}
+
+// NewVerifier is a factory to instantiate the Verifier matching the algorithm of a public key
+// that was encoded by the algorithm's marshaler. Signatures are expected as created for the given format.
+func NewVerifier(algorithm domain.CryptoAlgorithmType, publicKeyBytes []byte, format domain.SignatureFormat) (Verifier, error) {
+	publicKey, err := ParsePublicKey(algorithm, publicKeyBytes)
+	if err != nil {
+		return nil, err
+	}
+	switch key := publicKey.(type) {
+	case *rsa.PublicKey:
+		return RSAVerifier{PublicKey: key}, nil
+	case *ecdsa.PublicKey:
+		verifier := ECCVerifier{PublicKey: key}
+		if format == domain.FormatJWS || format == domain.FormatCOSESign1 {
+			verifier.Hash = crypto.SHA384
+		}
+		return verifier, nil
+	default:
+		return nil, fmt.Errorf("algorithm is not implemented")
+	}
+}
+
+// RSAVerifier verifies RSASSA-PKCS1-v1_5 signatures with SHA-256 unless another Hash is given.
+type RSAVerifier struct {
+	PublicKey *rsa.PublicKey
+	Hash      crypto.Hash
+}
+
+func (v RSAVerifier) Verify(signedData []byte, signature []byte) error {
+	hash := hashOrDefault(v.Hash)
+	err := rsa.VerifyPKCS1v15(v.PublicKey, hash, GetHash(hash, signedData), signature)
+	if err != nil {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+// ECCVerifier verifies ASN.1 DER encoded ECDSA signatures with SHA-256 unless another Hash is given.
+type ECCVerifier struct {
+	PublicKey *ecdsa.PublicKey
+	Hash      crypto.Hash
+}
+
+func (v ECCVerifier) Verify(signedData []byte, signature []byte) error {
+	hash := hashOrDefault(v.Hash)
+	if !ecdsa.VerifyASN1(v.PublicKey, GetHash(hash, signedData), signature) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
@@ -0,0 +1,18 @@
+package domain
+
+import "time"
+
+type AuditAction string
+
+const (
+	AuditPrivateKeyImported AuditAction = "private_key_imported"
+	AuditPrivateKeyExported AuditAction = "private_key_exported"
+	AuditDeviceRetired      AuditAction = "device_retired"
+)
+
+type AuditEvent struct {
+	Action   AuditAction
+	DeviceId string
+	Origin   string
+	Time     time.Time
+}
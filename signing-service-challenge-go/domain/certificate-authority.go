@@ -0,0 +1,22 @@
+package domain
+
+import (
+	"math/big"
+	"time"
+)
+
+// CertificateAuthority is the stored root of the certificate authority of the service.
+// Its private key is encoded by the marshaler of its algorithm, like the key of a device.
+type CertificateAuthority struct {
+	Algorithm   CryptoAlgorithmType
+	PrivateKey  []byte
+	Certificate []byte
+}
+
+// IssuedCertificate records a certificate the certificate authority issued to a device.
+type IssuedCertificate struct {
+	DeviceId     string
+	SerialNumber *big.Int
+	IssuedAt     time.Time
+	NotAfter     time.Time
+}
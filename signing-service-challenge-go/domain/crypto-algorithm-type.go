@@ -7,6 +7,9 @@ const (
 	ECC                     = "ECC"
 )
 
+// CryptoAlgorithms lists the algorithms signature devices can be created with.
+var CryptoAlgorithms = []CryptoAlgorithmType{ECC, RSA}
+
 func (algorithmType CryptoAlgorithmType) String() string {
 	switch algorithmType {
 	case RSA:
@@ -1,8 +1,26 @@
 package domain
 
+import "time"
+
 type Device struct {
 	Id               string
 	Algorithm        CryptoAlgorithmType
 	Label            string
 	SignatureCounter int
+	PublicKey        []byte
+	PrivateKey       []byte
+	CertificateChain []byte
+	RetiredAt        time.Time
+}
+
+// IsRetired reports whether the device has been taken out of service.
+func (d *Device) IsRetired() bool {
+	return !d.RetiredAt.IsZero()
+}
+
+// Clone returns a copy of the device. The keys and the certificate chain are shared,
+// they are only ever replaced, never modified in place.
+func (d *Device) Clone() *Device {
+	clone := *d
+	return &clone
 }
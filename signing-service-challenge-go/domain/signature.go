@@ -1,8 +1,40 @@
 package domain
 
+import "time"
+
+type SignatureFormat string
+
+const (
+	FormatRaw       SignatureFormat = "raw"
+	FormatJWS       SignatureFormat = "jws"
+	FormatCOSESign1 SignatureFormat = "cose_sign1"
+)
+
+// PayloadVersion selects the layout of the signed data. Version 1 is
+// <signature_counter>_<data_to_be_signed>_<last_signature_base64_encoded>, version 2 adds the
+// timestamp of the signature after the counter.
+type PayloadVersion string
+
+const (
+	PayloadV1 PayloadVersion = "v1"
+	PayloadV2 PayloadVersion = "v2"
+)
+
+// TimestampLayout is the layout of the timestamps in v2 signed data, UTC with microsecond precision.
+const TimestampLayout = "2006-01-02T15:04:05.000000Z07:00"
+
 type Signature struct {
 	Id         int
 	SignedData []byte
 	PrivateKey []byte
 	PublicKey  []byte
+	// Data holds the exact bytes that have been signed.
+	Data   []byte
+	Format SignatureFormat
+	// CreatedAt is the server time the signature counter has been allocated at.
+	// It never goes backwards within the signatures of a device.
+	CreatedAt      time.Time
+	PayloadVersion PayloadVersion
+	// TimestampToken is the RFC 3161 timestamp token over SignedData, if one has been requested.
+	TimestampToken []byte
 }
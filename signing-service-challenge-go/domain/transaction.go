@@ -0,0 +1,57 @@
+package domain
+
+import (
+	"slices"
+	"time"
+)
+
+type TransactionState string
+
+const (
+	TransactionActive   TransactionState = "active"
+	TransactionFinished TransactionState = "finished"
+)
+
+// TransactionOperation is the step of a transaction a signature has been created for.
+type TransactionOperation string
+
+const (
+	TransactionStart       TransactionOperation = "start"
+	TransactionUpdate      TransactionOperation = "update"
+	TransactionFinish      TransactionOperation = "finish"
+	TransactionForceFinish TransactionOperation = "force_finish"
+)
+
+// Transaction is a business transaction that is signed when it starts, on every update
+// and when it finishes. Transactions are numbered per device, starting at 1.
+type Transaction struct {
+	DeviceId    string
+	Number      int
+	State       TransactionState
+	ProcessType string
+	ProcessData string
+	StartedAt   time.Time
+	UpdatedAt   time.Time
+	FinishedAt  time.Time
+	// ForceClosed marks transactions that have been finished because they went stale.
+	ForceClosed bool
+	// SignatureCounters holds the signature counter of every step in order.
+	SignatureCounters []int
+}
+
+// IsOpen reports whether the transaction still takes updates.
+func (t *Transaction) IsOpen() bool {
+	return t.State == TransactionActive
+}
+
+// Revision is the number of steps the transaction has been signed for.
+func (t *Transaction) Revision() int {
+	return len(t.SignatureCounters)
+}
+
+// Clone returns a copy that does not share the signature counters.
+func (t *Transaction) Clone() *Transaction {
+	clone := *t
+	clone.SignatureCounters = slices.Clone(t.SignatureCounters)
+	return &clone
+}
@@ -0,0 +1,263 @@
+// Package export packages the signatures of a device into signed tar archives for audits
+// by tax authorities. Archives are deterministic: the same signatures and range always
+// produce the same bytes.
+package export
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	signingcrypto "github.com/DrMonez/coding-challenges/signing-service-challenge/crypto"
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/domain"
+	"io"
+	"time"
+)
+
+// Version identifies the archive format.
+const Version = "signing-service-export/v1"
+
+// Names of the files of an archive besides the signature records.
+// The device certificate is only included if the device has one.
+const (
+	ManifestFile           = "manifest.json"
+	ManifestSignatureFile  = "manifest.json.sig"
+	PublicKeyFile          = "device/public-key.pem"
+	CertificateFile        = "device/certificate.pem"
+	ServiceCertificateFile = "service/certificate.pem"
+)
+
+// Range selects the signatures of an archive. Counters are inclusive, times are half-open
+// [From, To). Zero values leave a bound open, ToCounter is open if it is negative.
+type Range struct {
+	FromCounter int        `json:"from_counter"`
+	ToCounter   int        `json:"to_counter"`
+	From        *time.Time `json:"from,omitempty"`
+	To          *time.Time `json:"to,omitempty"`
+}
+
+// Contains reports whether a signature lies within the range.
+func (r Range) Contains(signature *domain.Signature) bool {
+	if signature.Id < r.FromCounter || (r.ToCounter >= 0 && signature.Id > r.ToCounter) {
+		return false
+	}
+	if r.From != nil && signature.CreatedAt.Before(*r.From) {
+		return false
+	}
+	if r.To != nil && !signature.CreatedAt.Before(*r.To) {
+		return false
+	}
+	return true
+}
+
+// After reports whether a signature lies behind the range. Signatures are created in counter
+// order, so every later signature of the device does too.
+func (r Range) After(signature *domain.Signature) bool {
+	return (r.ToCounter >= 0 && signature.Id > r.ToCounter) || (r.To != nil && !signature.CreatedAt.Before(*r.To))
+}
+
+// Manifest describes the content of an archive, its signature covers every other file by hash.
+type Manifest struct {
+	Version        string                     `json:"version"`
+	DeviceId       string                     `json:"device_id"`
+	Algorithm      domain.CryptoAlgorithmType `json:"algorithm"`
+	Label          string                     `json:"label"`
+	Range          Range                      `json:"range"`
+	SignatureCount int                        `json:"signature_count"`
+	Files          []File                     `json:"files"`
+}
+
+type File struct {
+	Name   string `json:"name"`
+	Size   int    `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// Record is the file of a single signature.
+type Record struct {
+	SignatureCounter int                    `json:"signature_counter"`
+	Format           domain.SignatureFormat `json:"format"`
+	CreatedAt        time.Time              `json:"created_at"`
+	PayloadVersion   domain.PayloadVersion  `json:"payload_version,omitempty"`
+	// Data is the base64 encoded bytes the device has signed.
+	Data string `json:"data"`
+	// Signature is the base64 encoded signature as created by the device.
+	Signature string `json:"signature"`
+}
+
+// RecordFile returns the name of the record of a signature counter. The counter is
+// zero-padded so the records sort in counter order.
+func RecordFile(counter int) string {
+	return fmt.Sprintf("signatures/%012d.json", counter)
+}
+
+// Write writes the archive of the signatures of a device within the range. next returns the
+// signatures in counter order and io.EOF after the last one, it is not called again once a
+// signature lies behind the range. The modification time of all files is the creation time of
+// the last signature, so it does not depend on when the archive has been written.
+func Write(w io.Writer, key *ServiceKey, device *domain.Device, next func() (*domain.Signature, error), r Range) error {
+	var files []namedFile
+	add := func(name string, content []byte) {
+		files = append(files, namedFile{name: name, content: content})
+	}
+	add(PublicKeyFile, device.PublicKey)
+	if len(device.CertificateChain) > 0 {
+		add(CertificateFile, device.CertificateChain)
+	}
+	add(ServiceCertificateFile, key.CertificateChain)
+
+	var modified time.Time
+	count := 0
+	for {
+		signature, err := next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if r.After(signature) {
+			break
+		}
+		if !r.Contains(signature) {
+			continue
+		}
+		record, err := json.MarshalIndent(Record{
+			SignatureCounter: signature.Id,
+			Format:           signature.Format,
+			CreatedAt:        signature.CreatedAt,
+			PayloadVersion:   signature.PayloadVersion,
+			Data:             base64.StdEncoding.EncodeToString(signature.Data),
+			Signature:        base64.StdEncoding.EncodeToString(signature.SignedData),
+		}, "", "  ")
+		if err != nil {
+			return err
+		}
+		add(RecordFile(signature.Id), append(record, '\n'))
+		modified = signature.CreatedAt
+		count++
+	}
+
+	manifest := Manifest{
+		Version:        Version,
+		DeviceId:       device.Id,
+		Algorithm:      device.Algorithm,
+		Label:          device.Label,
+		Range:          r,
+		SignatureCount: count,
+		Files:          make([]File, 0, len(files)),
+	}
+	for _, file := range files {
+		digest := sha256.Sum256(file.content)
+		manifest.Files = append(manifest.Files, File{Name: file.name, Size: len(file.content), SHA256: hex.EncodeToString(digest[:])})
+	}
+	encodedManifest, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	encodedManifest = append(encodedManifest, '\n')
+	manifestSignature, err := key.Sign(encodedManifest)
+	if err != nil {
+		return err
+	}
+
+	archive := tar.NewWriter(w)
+	for _, file := range append([]namedFile{{ManifestFile, encodedManifest}, {ManifestSignatureFile, manifestSignature}}, files...) {
+		err := archive.WriteHeader(&tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     file.name,
+			Mode:     0o644,
+			Size:     int64(len(file.content)),
+			ModTime:  modified.Truncate(time.Second),
+			Format:   tar.FormatUSTAR,
+		})
+		if err != nil {
+			return err
+		}
+		if _, err := archive.Write(file.content); err != nil {
+			return err
+		}
+	}
+	return archive.Close()
+}
+
+type namedFile struct {
+	name    string
+	content []byte
+}
+
+// ErrManifestSignature is returned by Verify if the manifest signature does not verify.
+var ErrManifestSignature = errors.New("manifest signature does not verify with the service certificate")
+
+// Verify reads an archive, checks the manifest signature with the service certificate of the
+// archive and the hashes of all files listed by the manifest. Whether the service certificate
+// has been issued by a trusted certificate authority is checked against roots, if given.
+// Archives are kept for years, so the chain is checked as of the issuance of the service
+// certificate and an archive stays verifiable after the certificate has expired.
+func Verify(r io.Reader, roots *x509.CertPool) (*Manifest, error) {
+	files := make(map[string][]byte)
+	archive := tar.NewReader(r)
+	for {
+		header, err := archive.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		content, err := io.ReadAll(archive)
+		if err != nil {
+			return nil, err
+		}
+		files[header.Name] = content
+	}
+
+	chain, err := signingcrypto.ParseCertificateChain(files[ServiceCertificateFile])
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", ServiceCertificateFile, err)
+	}
+	if roots != nil {
+		intermediates := x509.NewCertPool()
+		for _, certificate := range chain[1:] {
+			intermediates.AddCert(certificate)
+		}
+		_, err := chain[0].Verify(x509.VerifyOptions{
+			Roots:         roots,
+			Intermediates: intermediates,
+			CurrentTime:   chain[0].NotBefore,
+			KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+		})
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", ServiceCertificateFile, err)
+		}
+	}
+	if err := chain[0].CheckSignature(x509.ECDSAWithSHA256, files[ManifestFile], files[ManifestSignatureFile]); err != nil {
+		return nil, ErrManifestSignature
+	}
+
+	var manifest Manifest
+	if err := json.Unmarshal(files[ManifestFile], &manifest); err != nil {
+		return nil, fmt.Errorf("%s: %w", ManifestFile, err)
+	}
+	if manifest.Version != Version {
+		return nil, fmt.Errorf("archive version %q is not supported, must be %s", manifest.Version, Version)
+	}
+	for _, file := range manifest.Files {
+		content, ok := files[file.Name]
+		if !ok {
+			return nil, fmt.Errorf("%s is missing", file.Name)
+		}
+		digest := sha256.Sum256(content)
+		if hex.EncodeToString(digest[:]) != file.SHA256 || len(content) != file.Size {
+			return nil, fmt.Errorf("%s does not match the manifest", file.Name)
+		}
+	}
+	if len(files) != len(manifest.Files)+2 {
+		return nil, errors.New("archive holds files that are not listed by the manifest")
+	}
+	return &manifest, nil
+}
@@ -0,0 +1,158 @@
+package export
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/x509"
+	"errors"
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/assert"
+	signingcrypto "github.com/DrMonez/coding-challenges/signing-service-challenge/crypto"
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/domain"
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/persistence"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+var start = time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+
+func newServiceKey(t *testing.T) (*ServiceKey, *x509.CertPool) {
+	authority, err := signingcrypto.NewCertificateAuthority(persistence.NewLocalStorage(), domain.ECC, start, 24*time.Hour)
+	assert.ShouldBe(t, err, nil)
+	key, err := NewServiceKey(authority, start, time.Hour)
+	assert.ShouldBe(t, err, nil)
+	root, _ := authority.Certificate()
+	roots := x509.NewCertPool()
+	roots.AddCert(root)
+	return key, roots
+}
+
+// newSignatures returns signatures created an hour apart, starting at start.
+func newSignatures(count int) []*domain.Signature {
+	var signatures []*domain.Signature
+	for counter := 0; counter < count; counter++ {
+		signatures = append(signatures, &domain.Signature{
+			Id:         counter,
+			SignedData: []byte{byte(counter)},
+			Data:       []byte("data"),
+			Format:     domain.FormatRaw,
+			CreatedAt:  start.Add(time.Duration(counter) * time.Hour),
+		})
+	}
+	return signatures
+}
+
+// slice returns the next function of Write over signatures.
+func slice(signatures []*domain.Signature) func() (*domain.Signature, error) {
+	return func() (*domain.Signature, error) {
+		if len(signatures) == 0 {
+			return nil, io.EOF
+		}
+		signature := signatures[0]
+		signatures = signatures[1:]
+		return signature, nil
+	}
+}
+
+var device = &domain.Device{Id: "2b6a1c8e-5d0f-4f6e-9a43-1c2d3e4f5a6b", Algorithm: domain.ECC, Label: "till", PublicKey: []byte("public key")}
+
+func writeArchive(t *testing.T, key *ServiceKey, r Range) []byte {
+	var archive bytes.Buffer
+	assert.ShouldBe(t, Write(&archive, key, device, slice(newSignatures(5)), r), nil)
+	return archive.Bytes()
+}
+
+func fileNames(t *testing.T, archive []byte) string {
+	var names []string
+	reader := tar.NewReader(bytes.NewReader(archive))
+	for {
+		header, err := reader.Next()
+		if errors.Is(err, io.EOF) {
+			return strings.Join(names, ",")
+		}
+		assert.ShouldBe(t, err, nil)
+		names = append(names, header.Name)
+	}
+}
+
+// rewrite replaces the content of a file of an archive.
+func rewrite(t *testing.T, archive []byte, name string, content []byte) []byte {
+	var rewritten bytes.Buffer
+	reader := tar.NewReader(bytes.NewReader(archive))
+	writer := tar.NewWriter(&rewritten)
+	for {
+		header, err := reader.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		assert.ShouldBe(t, err, nil)
+		original, _ := io.ReadAll(reader)
+		if header.Name == name {
+			original = content
+			header.Size = int64(len(content))
+		}
+		writer.WriteHeader(header)
+		writer.Write(original)
+	}
+	writer.Close()
+	return rewritten.Bytes()
+}
+
+func TestWrite_IsDeterministic(t *testing.T) {
+	key, _ := newServiceKey(t)
+	first := writeArchive(t, key, Range{ToCounter: -1})
+	assert.ShouldBe(t, bytes.Equal(first, writeArchive(t, key, Range{ToCounter: -1})), true)
+	assert.ShouldBe(t, fileNames(t, first), "manifest.json,manifest.json.sig,device/public-key.pem,service/certificate.pem,"+
+		"signatures/000000000000.json,signatures/000000000001.json,signatures/000000000002.json,signatures/000000000003.json,signatures/000000000004.json")
+}
+
+func TestWrite_SelectsRange(t *testing.T) {
+	key, roots := newServiceKey(t)
+	from, to := start.Add(2*time.Hour), start.Add(4*time.Hour)
+
+	manifest, err := Verify(bytes.NewReader(writeArchive(t, key, Range{FromCounter: 1, ToCounter: 3})), roots)
+	assert.ShouldBe(t, err, nil)
+	assert.ShouldBe(t, manifest.SignatureCount, 3)
+	assert.ShouldBe(t, manifest.Files[2].Name, RecordFile(1))
+
+	archive := writeArchive(t, key, Range{ToCounter: -1, From: &from, To: &to})
+	manifest, err = Verify(bytes.NewReader(archive), roots)
+	assert.ShouldBe(t, err, nil)
+	assert.ShouldBe(t, manifest.SignatureCount, 2)
+	assert.ShouldBe(t, manifest.Files[2].Name, RecordFile(2))
+	assert.ShouldBe(t, manifest.Files[3].Name, RecordFile(3))
+
+	header, _ := tar.NewReader(bytes.NewReader(archive)).Next()
+	assert.ShouldBe(t, header.ModTime.Equal(start.Add(3*time.Hour)), true)
+}
+
+func TestWrite_StopsBehindRange(t *testing.T) {
+	key, _ := newServiceKey(t)
+	to := start.Add(2 * time.Hour)
+	for _, r := range []Range{{ToCounter: 1}, {ToCounter: -1, To: &to}} {
+		reads := 0
+		next := slice(newSignatures(5))
+		err := Write(io.Discard, key, device, func() (*domain.Signature, error) {
+			reads++
+			return next()
+		}, r)
+		assert.ShouldBe(t, err, nil)
+		assert.ShouldBe(t, reads, 3)
+	}
+}
+
+func TestVerify_DetectsTampering(t *testing.T) {
+	key, roots := newServiceKey(t)
+	archive := writeArchive(t, key, Range{ToCounter: -1})
+
+	_, err := Verify(bytes.NewReader(rewrite(t, archive, RecordFile(2), []byte("{}"))), roots)
+	assert.ShouldBe(t, err.Error(), "signatures/000000000002.json does not match the manifest")
+
+	_, err = Verify(bytes.NewReader(rewrite(t, archive, ManifestFile, []byte(`{"version": "signing-service-export/v1"}`))), roots)
+	assert.ShouldBe(t, err, ErrManifestSignature)
+
+	_, otherRoots := newServiceKey(t)
+	_, err = Verify(bytes.NewReader(archive), otherRoots)
+	assert.ShouldBe(t, strings.HasPrefix(err.Error(), ServiceCertificateFile+": x509: certificate signed by unknown authority"), true)
+}
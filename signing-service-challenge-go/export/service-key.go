@@ -0,0 +1,54 @@
+package export
+
+import (
+	"crypto"
+	"crypto/sha256"
+	"errors"
+	signingcrypto "github.com/DrMonez/coding-challenges/signing-service-challenge/crypto"
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/domain"
+	"github.com/google/uuid"
+	"time"
+)
+
+const ServiceKeyLabel = "Signing Service Export Key"
+
+// ServiceKey signs the manifests of archives. Its certificate is issued by the certificate
+// authority of the service, so auditors can trace the manifest signature to the root certificate.
+// Unlike device keys it is not stored and can not be used to sign transactions.
+type ServiceKey struct {
+	privateKey crypto.Signer
+	// CertificateChain is the PEM encoded certificate of the key followed by the root certificate.
+	CertificateChain []byte
+}
+
+// NewServiceKey generates an ECC service key and has the certificate authority certify it.
+func NewServiceKey(authority *signingcrypto.CertificateAuthority, now time.Time, validity time.Duration) (*ServiceKey, error) {
+	if authority == nil {
+		return nil, errors.New("a certificate authority is required to certify the service key")
+	}
+	publicKey, privateKey, err := signingcrypto.GenerateKeyPair(domain.ECC)
+	if err != nil {
+		return nil, err
+	}
+	signer, err := signingcrypto.ParsePrivateKey(domain.ECC, privateKey)
+	if err != nil {
+		return nil, err
+	}
+	chain, err := authority.IssueCertificate(&domain.Device{
+		Id:        uuid.NewString(),
+		Algorithm: domain.ECC,
+		Label:     ServiceKeyLabel,
+		PublicKey: publicKey,
+	}, now, validity)
+	if err != nil {
+		return nil, err
+	}
+	return &ServiceKey{privateKey: signer, CertificateChain: chain}, nil
+}
+
+// Sign creates an ASN.1 DER encoded ECDSA signature with SHA-256 over the message.
+// Signatures are deterministic (RFC 6979), so the same manifest always yields the same archive.
+func (k *ServiceKey) Sign(message []byte) ([]byte, error) {
+	digest := sha256.Sum256(message)
+	return k.privateKey.Sign(nil, digest[:], crypto.SHA256)
+}
@@ -0,0 +1,356 @@
+// Package journal defines the exported signature journal of a device and verifies it offline.
+// A journal holds everything needed to check a signature chain without access to the service:
+// the device metadata, its public key and all of its signatures in counter order.
+package journal
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/crypto"
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/domain"
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/signing"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Version identifies the journal format, readers reject other versions.
+const Version = "signing-service-journal/v1"
+
+// Journal is the exported signature journal of a device.
+type Journal struct {
+	Version    string    `json:"version"`
+	ExportedAt time.Time `json:"exported_at"`
+	Device     Device    `json:"device"`
+	Signatures []Entry   `json:"signatures"`
+}
+
+type Device struct {
+	Id        string                     `json:"id"`
+	Algorithm domain.CryptoAlgorithmType `json:"algorithm"`
+	Label     string                     `json:"label"`
+	// PublicKey is the PEM encoded public key the signatures verify with.
+	PublicKey string `json:"public_key"`
+	// CertificateChain is the PEM encoded certificate chain of the device, if it has one.
+	CertificateChain string     `json:"certificate_chain,omitempty"`
+	RetiredAt        *time.Time `json:"retired_at,omitempty"`
+}
+
+// Entry is a signature of the device.
+type Entry struct {
+	SignatureCounter int                    `json:"signature_counter"`
+	Format           domain.SignatureFormat `json:"format"`
+	// SignedData is <signature_counter>_<data_to_be_signed>_<last_signature_base64_encoded>.
+	SignedData string `json:"signed_data"`
+	// Signature is the base64 encoded signature as created by the device.
+	Signature string `json:"signature"`
+	// Timestamp is the server time of the signature, v2 signed data contains it after the counter.
+	Timestamp      *time.Time            `json:"timestamp,omitempty"`
+	PayloadVersion domain.PayloadVersion `json:"payload_version,omitempty"`
+}
+
+// New exports the journal of a device with its signature records in counter order.
+func New(device *domain.Device, records []signing.SignatureRecord) *Journal {
+	journal := &Journal{
+		Version:    Version,
+		ExportedAt: time.Now().UTC(),
+		Device: Device{
+			Id:               device.Id,
+			Algorithm:        device.Algorithm,
+			Label:            device.Label,
+			PublicKey:        string(device.PublicKey),
+			CertificateChain: string(device.CertificateChain),
+		},
+		Signatures: make([]Entry, 0, len(records)),
+	}
+	if device.IsRetired() {
+		journal.Device.RetiredAt = &device.RetiredAt
+	}
+	for _, record := range records {
+		journal.Signatures = append(journal.Signatures, newEntry(record))
+	}
+	return journal
+}
+
+func newEntry(record signing.SignatureRecord) Entry {
+	return Entry{
+		SignatureCounter: record.Counter,
+		Format:           record.Format,
+		SignedData:       record.SignedData,
+		Signature:        base64.StdEncoding.EncodeToString(record.Signature),
+		Timestamp:        &record.Timestamp,
+		PayloadVersion:   record.PayloadVersion,
+	}
+}
+
+// Read decodes a journal and checks its version.
+func Read(r io.Reader) (*Journal, error) {
+	var journal Journal
+	decoder := json.NewDecoder(r)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&journal); err != nil {
+		return nil, fmt.Errorf("journal is not valid JSON: %w", err)
+	}
+	if journal.Version != Version {
+		return nil, fmt.Errorf("journal version %q is not supported, must be %s", journal.Version, Version)
+	}
+	return &journal, nil
+}
+
+// Write encodes a journal as indented JSON.
+func Write(w io.Writer, journal *Journal) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(journal)
+}
+
+// Stream writes the journal of a device as Write would, reading its signature records from next
+// until it returns io.EOF, so a long journal is never held in memory as a whole.
+func Stream(w io.Writer, device *domain.Device, next func() (signing.SignatureRecord, error)) error {
+	header, err := json.MarshalIndent(New(device, nil), "", "  ")
+	if err != nil {
+		return err
+	}
+	// The header ends with the empty signatures array, the entries are written into it.
+	header = bytes.TrimSuffix(header, []byte("]\n}"))
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	count := 0
+	for {
+		record, err := next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		entry, err := json.MarshalIndent(newEntry(record), "    ", "  ")
+		if err != nil {
+			return err
+		}
+		separator := ",\n    "
+		if count == 0 {
+			separator = "\n    "
+		}
+		if _, err := io.WriteString(w, separator); err != nil {
+			return err
+		}
+		if _, err := w.Write(entry); err != nil {
+			return err
+		}
+		count++
+	}
+	end := "]\n}\n"
+	if count > 0 {
+		end = "\n  ]\n}\n"
+	}
+	_, err = io.WriteString(w, end)
+	return err
+}
+
+// Report is the result of verifying a journal.
+type Report struct {
+	DeviceId       string
+	SignatureCount int
+	Failures       []Failure
+}
+
+// Failure describes why an entry breaks the signature chain.
+type Failure struct {
+	SignatureCounter int    `json:"signature_counter"`
+	Reason           string `json:"reason"`
+}
+
+// Passed reports whether every signature and every chain link verified.
+func (r *Report) Passed() bool {
+	return len(r.Failures) == 0
+}
+
+// FirstBrokenCounter returns the counter of the first entry that failed, -1 if all passed.
+func (r *Report) FirstBrokenCounter() int {
+	if r.Passed() {
+		return -1
+	}
+	return r.Failures[0].SignatureCounter
+}
+
+// Trust anchors the verification of a journal in keys the auditor obtained from the service
+// beforehand. A journal carries the public key of its device, so on its own a journal
+// signed with any key would verify.
+type Trust struct {
+	// PublicKey is the PEM encoded public key of the device.
+	PublicKey []byte
+	// Roots are the CA certificates the certificate chain of the device has to lead to.
+	Roots *x509.CertPool
+}
+
+// ErrNoTrust is returned when a journal is to be verified without a trusted key or root.
+var ErrNoTrust = errors.New("a trusted public key or root certificate is required")
+
+// Verify checks that the public key of the device is trusted and then every entry of a journal:
+// the counters start at zero without gaps, the signed data starts with its counter and ends with
+// the previous signature, or the device id for the first one, the timestamps never go backwards
+// and the signature verifies with the public key of the device.
+// It fails only if the device itself can not be checked or is not trusted, broken entries are reported.
+func Verify(journal *Journal, trust Trust) (*Report, error) {
+	device := &domain.Device{
+		Id:        journal.Device.Id,
+		Algorithm: journal.Device.Algorithm,
+		PublicKey: []byte(journal.Device.PublicKey),
+	}
+	// The verifiers only differ in the hash, which depends on the format.
+	verifiers := make(map[domain.SignatureFormat]crypto.Verifier)
+	for _, format := range []domain.SignatureFormat{domain.FormatRaw, domain.FormatJWS, domain.FormatCOSESign1} {
+		verifier, err := crypto.NewVerifier(device.Algorithm, device.PublicKey, format)
+		if err != nil {
+			return nil, fmt.Errorf("public key of device %s can not be used: %w", device.Id, err)
+		}
+		verifiers[format] = verifier
+	}
+	if err := checkTrust(journal, device, trust); err != nil {
+		return nil, err
+	}
+
+	report := &Report{
+		DeviceId:       device.Id,
+		SignatureCount: len(journal.Signatures),
+	}
+	lastSignature := base64.StdEncoding.EncodeToString([]byte(device.Id))
+	var lastTimestamp time.Time
+	for index, entry := range journal.Signatures {
+		err := verifyEntry(device, verifiers, entry, index, lastSignature)
+		if err == nil && entry.Timestamp != nil && entry.Timestamp.Before(lastTimestamp) {
+			err = errors.New("timestamp is before the timestamp of the previous signature")
+		}
+		if err != nil {
+			report.Failures = append(report.Failures, Failure{SignatureCounter: entry.SignatureCounter, Reason: err.Error()})
+		}
+		lastSignature = entry.Signature
+		if entry.Timestamp != nil {
+			lastTimestamp = *entry.Timestamp
+		}
+	}
+	return report, nil
+}
+
+// verifyEntry checks the entry at the given index of a journal, lastSignature is the
+// base64 encoded signature of the entry before it.
+func verifyEntry(device *domain.Device, verifiers map[domain.SignatureFormat]crypto.Verifier, entry Entry, index int, lastSignature string) error {
+	if entry.SignatureCounter != index {
+		return fmt.Errorf("signature counter %d found where %d was expected", entry.SignatureCounter, index)
+	}
+	if !strings.HasPrefix(entry.SignedData, strconv.Itoa(index)+"_") {
+		return fmt.Errorf("signed data does not start with counter %d", index)
+	}
+	switch entry.PayloadVersion {
+	case "", domain.PayloadV1:
+	case domain.PayloadV2:
+		if entry.Timestamp == nil {
+			return errors.New("timestamp is missing")
+		}
+		prefix := fmt.Sprintf("%d_%s_", index, entry.Timestamp.UTC().Format(domain.TimestampLayout))
+		if !strings.HasPrefix(entry.SignedData, prefix) {
+			return errors.New("signed data does not contain the timestamp of the signature")
+		}
+	default:
+		return fmt.Errorf("payload version %q is not supported", entry.PayloadVersion)
+	}
+	if !strings.HasSuffix(entry.SignedData, "_"+lastSignature) {
+		if index == 0 {
+			return errors.New("signed data does not link to the device id")
+		}
+		return errors.New("signed data does not link to the previous signature")
+	}
+	format := entry.Format
+	if format == "" {
+		format = domain.FormatRaw
+	}
+	verifier, ok := verifiers[format]
+	if !ok {
+		return fmt.Errorf("signature format %q is not supported", entry.Format)
+	}
+	signature, err := base64.StdEncoding.DecodeString(entry.Signature)
+	if err != nil {
+		return errors.New("signature is not base64 encoded")
+	}
+	message, err := signing.SigningInput(device, entry.SignedData, format)
+	if err != nil {
+		return err
+	}
+	if err := verifier.Verify(message, signature); err != nil {
+		return fmt.Errorf("signature does not verify: %w", err)
+	}
+	return nil
+}
+
+// checkTrust checks the public key of the device against the trusted public key
+// and its certificate chain against the trusted roots, as of the time of the export.
+func checkTrust(journal *Journal, device *domain.Device, trust Trust) error {
+	if len(trust.PublicKey) == 0 && trust.Roots == nil {
+		return ErrNoTrust
+	}
+	deviceKey, err := crypto.PublicKeyDER(device.Algorithm, device.PublicKey)
+	if err != nil {
+		return fmt.Errorf("public key of device %s can not be used: %w", device.Id, err)
+	}
+	if len(trust.PublicKey) > 0 {
+		trustedKey, err := trustedPublicKeyDER(device.Algorithm, trust.PublicKey)
+		if err != nil {
+			return fmt.Errorf("trusted public key can not be used: %w", err)
+		}
+		if !bytes.Equal(deviceKey, trustedKey) {
+			return fmt.Errorf("public key of device %s is not the trusted public key", device.Id)
+		}
+	}
+	if trust.Roots != nil {
+		if journal.Device.CertificateChain == "" {
+			return fmt.Errorf("device %s has no certificate chain", device.Id)
+		}
+		chain, err := crypto.ParseCertificateChain([]byte(journal.Device.CertificateChain))
+		if err != nil {
+			return fmt.Errorf("certificate chain of device %s can not be read: %w", device.Id, err)
+		}
+		leafKey, err := x509.MarshalPKIXPublicKey(chain[0].PublicKey)
+		if err != nil || !bytes.Equal(leafKey, deviceKey) {
+			return fmt.Errorf("certificate of device %s does not certify its public key", device.Id)
+		}
+		intermediates := x509.NewCertPool()
+		for _, certificate := range chain[1:] {
+			intermediates.AddCert(certificate)
+		}
+		_, err = chain[0].Verify(x509.VerifyOptions{
+			Roots:         trust.Roots,
+			Intermediates: intermediates,
+			CurrentTime:   journal.ExportedAt,
+			KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+		})
+		if err != nil {
+			return fmt.Errorf("certificate chain of device %s is not trusted: %w", device.Id, err)
+		}
+	}
+	return nil
+}
+
+// trustedPublicKeyDER returns the SubjectPublicKeyInfo of a PEM public key, which may be
+// in the encoding of the marshaler of the algorithm or a standard PUBLIC KEY block.
+func trustedPublicKeyDER(algorithm domain.CryptoAlgorithmType, publicKeyBytes []byte) ([]byte, error) {
+	block, _ := pem.Decode(publicKeyBytes)
+	if block == nil {
+		return nil, errors.New("public key is not PEM encoded")
+	}
+	if block.Type == "PUBLIC KEY" {
+		publicKey, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		return x509.MarshalPKIXPublicKey(publicKey)
+	}
+	return crypto.PublicKeyDER(algorithm, publicKeyBytes)
+}
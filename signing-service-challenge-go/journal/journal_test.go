@@ -0,0 +1,209 @@
+package journal
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/assert"
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/crypto"
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/domain"
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/persistence"
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/signing"
+	"io"
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newJournal signs the data with a new device in the given format and exports its journal.
+func newJournal(t *testing.T, algorithm domain.CryptoAlgorithmType, format domain.SignatureFormat, data ...string) *Journal {
+	return newJournalWithPayload(t, domain.PayloadV1, algorithm, format, data...)
+}
+
+func newJournalWithPayload(t *testing.T, version domain.PayloadVersion, algorithm domain.CryptoAlgorithmType, format domain.SignatureFormat, data ...string) *Journal {
+	storage := persistence.NewLocalStorage()
+	service := signing.NewService(storage, signing.WithPayloadVersion(version))
+	deviceId, _ := storage.CreateSignatureDevice("test", algorithm, "till")
+	publicKey, privateKey, err := crypto.GenerateKeyPair(algorithm)
+	assert.ShouldBe(t, err, nil)
+	storage.SetDeviceKeys(deviceId, publicKey, privateKey)
+	_, err = service.SignBatch(context.Background(), deviceId, data, format)
+	assert.ShouldBe(t, err, nil)
+
+	records, _, err := service.History(context.Background(), deviceId, 0, len(data))
+	assert.ShouldBe(t, err, nil)
+	return New(storage.GetDevice(deviceId), records)
+}
+
+// trusted returns a journal with the trust in the public key of its device, for tests
+// that check the entries. Trust is taken before entries are tampered with.
+func trusted(journal *Journal) (*Journal, Trust) {
+	return journal, Trust{PublicKey: []byte(journal.Device.PublicKey)}
+}
+
+func TestVerify(t *testing.T) {
+	for _, algorithm := range domain.CryptoAlgorithms {
+		for _, format := range []domain.SignatureFormat{domain.FormatRaw, domain.FormatJWS, domain.FormatCOSESign1} {
+			report, err := Verify(trusted(newJournal(t, algorithm, format, "a", "b", "c")))
+			assert.ShouldBe(t, err, nil)
+			assert.ShouldBe(t, report.SignatureCount, 3)
+			assert.ShouldBe(t, report.Passed(), true)
+			assert.ShouldBe(t, report.FirstBrokenCounter(), -1)
+		}
+	}
+}
+
+func TestVerify_ReportsBrokenChain(t *testing.T) {
+	journal := newJournal(t, domain.ECC, domain.FormatRaw, "a", "b", "c", "d")
+	journal.Signatures[1].Signature = journal.Signatures[0].Signature
+
+	report, err := Verify(journal, Trust{PublicKey: []byte(journal.Device.PublicKey)})
+	assert.ShouldBe(t, err, nil)
+	assert.ShouldBe(t, report.Passed(), false)
+	assert.ShouldBe(t, report.FirstBrokenCounter(), 1)
+	assert.ShouldBe(t, len(report.Failures), 2)
+	assert.ShouldBe(t, report.Failures[0].Reason, "signature does not verify: signature is not valid")
+	assert.ShouldBe(t, report.Failures[1], Failure{SignatureCounter: 2, Reason: "signed data does not link to the previous signature"})
+}
+
+func TestVerify_ReportsMissingSignatures(t *testing.T) {
+	journal := newJournal(t, domain.RSA, domain.FormatJWS, "a", "b", "c")
+	journal.Signatures = append(journal.Signatures[:1], journal.Signatures[2:]...)
+
+	report, err := Verify(journal, Trust{PublicKey: []byte(journal.Device.PublicKey)})
+	assert.ShouldBe(t, err, nil)
+	assert.ShouldBe(t, report.FirstBrokenCounter(), 2)
+	assert.ShouldBe(t, report.Failures[0].Reason, "signature counter 2 found where 1 was expected")
+}
+
+func TestVerify_PayloadV2(t *testing.T) {
+	for _, format := range []domain.SignatureFormat{domain.FormatRaw, domain.FormatJWS, domain.FormatCOSESign1} {
+		report, err := Verify(trusted(newJournalWithPayload(t, domain.PayloadV2, domain.ECC, format, "a", "b")))
+		assert.ShouldBe(t, err, nil)
+		assert.ShouldBe(t, report.Passed(), true)
+	}
+
+	journal := newJournalWithPayload(t, domain.PayloadV2, domain.ECC, domain.FormatRaw, "a", "b", "c")
+	shifted := journal.Signatures[1].Timestamp.Add(time.Second)
+	journal.Signatures[1].Timestamp = &shifted
+	earlier := journal.Signatures[0].Timestamp.Add(-time.Second)
+	journal.Signatures[2].Timestamp = &earlier
+	journal.Signatures[2].PayloadVersion = domain.PayloadV1
+
+	report, err := Verify(journal, Trust{PublicKey: []byte(journal.Device.PublicKey)})
+	assert.ShouldBe(t, err, nil)
+	assert.ShouldBe(t, len(report.Failures), 2)
+	assert.ShouldBe(t, report.Failures[0], Failure{SignatureCounter: 1, Reason: "signed data does not contain the timestamp of the signature"})
+	assert.ShouldBe(t, report.Failures[1], Failure{SignatureCounter: 2, Reason: "timestamp is before the timestamp of the previous signature"})
+}
+
+func TestVerify_RejectsUnusablePublicKey(t *testing.T) {
+	journal := newJournal(t, domain.ECC, domain.FormatRaw, "a")
+	journal.Device.PublicKey = "not a key"
+	_, err := Verify(journal, Trust{PublicKey: []byte(journal.Device.PublicKey)})
+	assert.ShouldNotBe(t, err, nil)
+}
+
+func TestVerify_ChecksTrust(t *testing.T) {
+	journal := newJournal(t, domain.ECC, domain.FormatRaw, "a")
+	_, err := Verify(journal, Trust{})
+	assert.ShouldBe(t, err, ErrNoTrust)
+
+	// A journal signed with another key verifies on its own but not against the trusted key.
+	forged := newJournal(t, domain.ECC, domain.FormatRaw, "a")
+	forged.Device.Id = journal.Device.Id
+	_, err = Verify(forged, Trust{PublicKey: []byte(journal.Device.PublicKey)})
+	assert.ShouldBe(t, err.Error(), "public key of device "+journal.Device.Id+" is not the trusted public key")
+
+	// The public key endpoint serves standard PEM blocks.
+	standard, _ := crypto.PublicKeyPEM(journal.Device.Algorithm, []byte(journal.Device.PublicKey))
+	report, err := Verify(journal, Trust{PublicKey: standard})
+	assert.ShouldBe(t, err, nil)
+	assert.ShouldBe(t, report.Passed(), true)
+}
+
+func TestVerify_ChecksCertificateChain(t *testing.T) {
+	storage := persistence.NewLocalStorage()
+	authority, _ := crypto.NewCertificateAuthority(storage, domain.ECC, time.Now(), time.Hour)
+	service := signing.NewService(storage)
+	device, _ := service.CreateDevice(context.Background(), "test", domain.RSA, "till")
+	chain, _ := authority.IssueCertificate(device, time.Now(), time.Hour)
+	storage.SetDeviceCertificate(device.Id, chain)
+	service.SignBatch(context.Background(), device.Id, []string{"a", "b"}, domain.FormatJWS)
+	records, _, _ := service.History(context.Background(), device.Id, 0, 2)
+	journal := New(storage.GetDevice(device.Id), records)
+
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(authority.CertificateChain)
+	report, err := Verify(journal, Trust{Roots: roots})
+	assert.ShouldBe(t, err, nil)
+	assert.ShouldBe(t, report.Passed(), true)
+
+	other, _ := crypto.NewCertificateAuthority(persistence.NewLocalStorage(), domain.ECC, time.Now(), time.Hour)
+	otherRoots := x509.NewCertPool()
+	otherRoots.AppendCertsFromPEM(other.CertificateChain)
+	_, err = Verify(journal, Trust{Roots: otherRoots})
+	assert.ShouldBe(t, strings.HasPrefix(err.Error(), "certificate chain of device "+device.Id+" is not trusted"), true)
+
+	journal.Device.CertificateChain = ""
+	_, err = Verify(journal, Trust{Roots: roots})
+	assert.ShouldBe(t, err.Error(), "device "+device.Id+" has no certificate chain")
+}
+
+func TestReadWrite(t *testing.T) {
+	journal := newJournal(t, domain.ECC, domain.FormatCOSESign1, "a")
+	var buffer bytes.Buffer
+	assert.ShouldBe(t, Write(&buffer, journal), nil)
+	read, err := Read(&buffer)
+	assert.ShouldBe(t, err, nil)
+	assert.ShouldBe(t, read.Device.Id, journal.Device.Id)
+	entry := read.Signatures[0]
+	assert.ShouldBe(t, entry.Timestamp.Equal(*journal.Signatures[0].Timestamp), true)
+	entry.Timestamp = journal.Signatures[0].Timestamp
+	assert.ShouldBe(t, entry, journal.Signatures[0])
+
+	_, err = Read(strings.NewReader(`{"version": "signing-service-journal/v0"}`))
+	assert.ShouldBe(t, err.Error(), `journal version "signing-service-journal/v0" is not supported, must be signing-service-journal/v1`)
+}
+
+func TestStream(t *testing.T) {
+	storage := persistence.NewLocalStorage()
+	service := signing.NewService(storage)
+	deviceId, _ := storage.CreateSignatureDevice("test", domain.ECC, "till")
+	publicKey, privateKey, err := crypto.GenerateKeyPair(domain.ECC)
+	assert.ShouldBe(t, err, nil)
+	storage.SetDeviceKeys(deviceId, publicKey, privateKey)
+	device := storage.GetDevice(deviceId)
+
+	for _, data := range [][]string{nil, {"a"}, {"b", "c"}} {
+		if len(data) > 0 {
+			_, err = service.SignBatch(context.Background(), deviceId, data, domain.FormatRaw)
+			assert.ShouldBe(t, err, nil)
+		}
+		records, _, err := service.History(context.Background(), deviceId, 0, math.MaxInt)
+		assert.ShouldBe(t, err, nil)
+		next := func() (signing.SignatureRecord, error) {
+			if len(records) == 0 {
+				return signing.SignatureRecord{}, io.EOF
+			}
+			record := records[0]
+			records = records[1:]
+			return record, nil
+		}
+
+		var streamed bytes.Buffer
+		assert.ShouldBe(t, Stream(&streamed, device, next), nil)
+		read, err := Read(bytes.NewReader(streamed.Bytes()))
+		assert.ShouldBe(t, err, nil)
+		report, err := Verify(trusted(read))
+		assert.ShouldBe(t, err, nil)
+		assert.ShouldBe(t, report.Passed(), true)
+		assert.ShouldBe(t, report.SignatureCount, storage.GetDeviceSignaturesCount(deviceId))
+
+		// Streaming writes the same bytes as Write.
+		var written bytes.Buffer
+		assert.ShouldBe(t, Write(&written, read), nil)
+		assert.ShouldBe(t, streamed.String(), written.String())
+	}
+}
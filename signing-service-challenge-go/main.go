@@ -1,29 +1,198 @@
 package main
 
 import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"flag"
+	"fmt"
 	"github.com/DrMonez/coding-challenges/signing-service-challenge/api"
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/apiconfig"
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/config"
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/crypto"
 	"github.com/DrMonez/coding-challenges/signing-service-challenge/domain"
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/export"
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/metrics"
 	"github.com/DrMonez/coding-challenges/signing-service-challenge/persistence"
-	"log"
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/rpc"
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/signing"
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/tracing"
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/tsa"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
 )
 
 const (
-	ListenAddress = ":8080"
+	// CertificateAuthorityValidity is the lifetime of the root certificate of the certificate authority.
+	CertificateAuthorityValidity = 10 * 365 * 24 * time.Hour
+	ServiceName                  = "signing-service"
 )
 
 func main() {
-	var storage persistence.Storage = &persistence.LocalStorage{
-		UserDevices: make(map[string]map[string]struct{}),
-		Devices:     make(map[string]*domain.Device),
-		Signatures:  make(map[string]map[int]*domain.Signature),
+	settings, err := config.Load(os.Args[1:], os.Getenv)
+	if errors.Is(err, flag.ErrHelp) {
+		fmt.Fprintf(os.Stderr, "Usage of %s:\n", os.Args[0])
+		config.Usage(os.Stderr)
+		os.Exit(0)
 	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid configuration:\n%v\n", err)
+		os.Exit(2)
+	}
+
+	handlerOptions := &slog.HandlerOptions{Level: settings.LogLevel()}
+	var handler slog.Handler = slog.NewJSONHandler(os.Stdout, handlerOptions)
+	if settings.Log.Format == config.LogFormatText {
+		handler = slog.NewTextHandler(os.Stdout, handlerOptions)
+	}
+	logger := slog.New(handler)
+	slog.SetDefault(logger)
+
+	shutdownTracing, err := tracing.Setup(context.Background(), tracing.Config{
+		Exporter:    settings.Tracing.Exporter,
+		ServiceName: ServiceName,
+	})
+	if err != nil {
+		logger.Error("could not set up tracing", "error", err)
+		os.Exit(1)
+	}
+
+	registry := metrics.NewRegistry()
+	// The storage backend has been validated by the config, memory is the only one.
+	storage := metrics.NewStorageMetrics(registry).Storage(persistence.NewLocalStorage())
+
+	keyOptions := []crypto.KeyOption{crypto.WithRSAKeySize(settings.Crypto.RSAKeySize)}
+	authority, err := crypto.NewCertificateAuthority(storage, domain.CryptoAlgorithmType(settings.Crypto.CAAlgorithm), time.Now().UTC(), CertificateAuthorityValidity, keyOptions...)
+	if err != nil {
+		logger.Error("could not create certificate authority", "error", err)
+		os.Exit(1)
+	}
+
+	exportKey, err := export.NewServiceKey(authority, time.Now().UTC(), CertificateAuthorityValidity)
+	if err != nil {
+		logger.Error("could not create export key", "error", err)
+		os.Exit(1)
+	}
+
+	signingOptions := []signing.Option{
+		signing.WithMetrics(metrics.NewSigningMetrics(registry)),
+		signing.WithAlgorithms(settings.CryptoAlgorithms()...),
+		signing.WithKeyOptions(keyOptions...),
+		signing.WithPayloadVersion(domain.PayloadVersion(settings.Crypto.PayloadVersion)),
+	}
+	if settings.Timestamp.URL != "" {
+		roots, err := loadCertificates(settings.Timestamp.CAFile)
+		if err != nil {
+			logger.Error("could not load timestamp authority certificates", "error", err)
+			os.Exit(1)
+		}
+		client := tsa.NewClient(settings.Timestamp.URL, tsa.WithHTTPClient(&http.Client{Timeout: settings.Timestamp.Timeout}))
+		signingOptions = append(signingOptions, signing.WithTimestampAuthority(client, roots))
+	}
+	signatures := signing.NewService(storage, signingOptions...)
+
+	grpcServer := rpc.NewServer(settings.GRPCListenAddress, storage, signatures)
+
+	options := []api.Option{
+		api.WithAdminToken(settings.AdminToken),
+		api.WithCertificateAuthority(authority),
+		api.WithExportKey(exportKey),
+		api.WithSigningService(signatures),
+		api.WithLogger(logger),
+		api.WithMetrics(registry),
+		api.WithTimeouts(apiconfig.Timeouts{
+			ReadHeader: apiconfig.DefaultTimeouts.ReadHeader,
+			Read:       settings.HTTP.ReadTimeout,
+			Write:      settings.HTTP.WriteTimeout,
+			Idle:       settings.HTTP.IdleTimeout,
+		}),
+		api.WithMaxBodySize(settings.HTTP.MaxBodySize),
+		api.WithRateLimits(settings.RateLimits()),
+	}
+	if settings.TLS.CertFile != "" {
+		options = append(options, api.WithTLS(settings.TLS.CertFile, settings.TLS.KeyFile))
+	}
+	if settings.ClientAuth() != apiconfig.ClientAuthNone {
+		options = append(options,
+			api.WithClientCertificates(settings.TLS.ClientCAFile, settings.ClientAuth()),
+			api.WithClientTenants(settings.TLS.ClientTenants),
+		)
+	}
+	server := api.NewServer(settings.ListenAddress, storage, options...)
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	failed := make(chan error, 2)
+	go func() {
+		if err := grpcServer.Run(); err != nil {
+			failed <- fmt.Errorf("gRPC server on %s: %w", settings.GRPCListenAddress, err)
+		}
+	}()
+	go func() {
+		if err := server.Run(); err != nil {
+			failed <- fmt.Errorf("HTTP server on %s: %w", settings.ListenAddress, err)
+		}
+	}()
+	logger.Info("starting server", "address", settings.ListenAddress, "grpc_address", settings.GRPCListenAddress, "tls", settings.TLS.CertFile != "", "client_auth", settings.TLS.ClientAuth)
+
+	exitCode := 0
+	select {
+	case err := <-failed:
+		logger.Error("server failed", "error", err)
+		exitCode = 1
+	case <-ctx.Done():
+		logger.Info("shutting down", "timeout", settings.HTTP.ShutdownTimeout)
+	}
+	if err := shutdown(settings.HTTP.ShutdownTimeout, server, grpcServer, signatures, storage); err != nil {
+		logger.Error("could not shut down cleanly", "error", err)
+		exitCode = 1
+	}
+	if err := shutdownTracing(context.Background()); err != nil {
+		logger.Error("could not flush traces", "error", err)
+	}
+	os.Exit(exitCode)
+}
+
+// loadCertificates reads a pool of PEM certificates, an empty path stands for the system roots.
+func loadCertificates(path string) (*x509.CertPool, error) {
+	if path == "" {
+		return nil, nil
+	}
+	pem, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("%s holds no PEM certificates", path)
+	}
+	return pool, nil
+}
 
-	server := api.NewServer(
-		ListenAddress,
-		storage,
-	)
+// shutdown stops both servers from accepting requests and waits until their requests in flight
+// have been answered. Only then the signing service is drained, so that no request starts a
+// signing after the drain began. The storage is closed last. Requests still running after the
+// timeout are cut off.
+func shutdown(timeout time.Duration, server *api.Server, grpcServer *rpc.Server, signatures *signing.Service, storage persistence.Storage) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
 
-	if err := server.Run(); err != nil {
-		log.Fatal("Could not start server on ", ListenAddress)
+	var wg sync.WaitGroup
+	errs := make([]error, 3)
+	for i, stop := range []func(context.Context) error{server.Shutdown, grpcServer.Shutdown} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = stop(ctx)
+		}()
 	}
+	wg.Wait()
+	errs[2] = signatures.Shutdown(ctx)
+	return errors.Join(errors.Join(errs...), storage.Close())
 }
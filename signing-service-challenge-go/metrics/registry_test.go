@@ -0,0 +1,60 @@
+package metrics
+
+import (
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRegistry_WriteTo(t *testing.T) {
+	registry := NewRegistry()
+	counter := registry.NewCounterVec("requests_total", "Requests.", "route")
+	histogram := registry.NewHistogramVec("latency_seconds", "Latency.", []float64{1, 0.5}, "route")
+	registry.NewGaugeFunc("devices", "Devices.", func() float64 { return 3 })
+
+	counter.Inc(`/a"b`)
+	counter.Add(2, "/c")
+	histogram.Observe(0.25, "/a")
+	histogram.Observe(0.75, "/a")
+
+	var scrape strings.Builder
+	registry.WriteTo(&scrape)
+	assert.ShouldBe(t, scrape.String(), `# HELP requests_total Requests.
+# TYPE requests_total counter
+requests_total{route="/a\"b"} 1
+requests_total{route="/c"} 2
+# HELP latency_seconds Latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{route="/a",le="0.5"} 1
+latency_seconds_bucket{route="/a",le="1"} 2
+latency_seconds_bucket{route="/a",le="+Inf"} 2
+latency_seconds_sum{route="/a"} 1
+latency_seconds_count{route="/a"} 2
+# HELP devices Devices.
+# TYPE devices gauge
+devices 3
+`)
+	assert.ShouldBe(t, counter.Value("/c"), float64(2))
+	assert.ShouldBe(t, histogram.Count("/a"), uint64(2))
+	assert.ShouldBe(t, histogram.Count("/missing"), uint64(0))
+}
+
+func TestRegistry_ServeHTTP(t *testing.T) {
+	registry := NewRegistry()
+	registry.NewCounterVec("total", "Total.").Inc()
+	response := httptest.NewRecorder()
+	registry.ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.ShouldBe(t, response.Header().Get("Content-Type"), ContentType)
+	assert.ShouldBe(t, strings.HasSuffix(response.Body.String(), "total 1\n"), true)
+}
+
+func TestRegistry_RejectsDuplicateNames(t *testing.T) {
+	registry := NewRegistry()
+	registry.NewCounterVec("total", "Total.")
+	defer func() {
+		assert.ShouldNotBe(t, recover(), nil)
+	}()
+	registry.NewCounterVec("total", "Total.")
+}
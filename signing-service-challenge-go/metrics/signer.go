@@ -0,0 +1,50 @@
+package metrics
+
+import (
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/crypto"
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/domain"
+	"time"
+)
+
+// SigningMetrics counts the signatures created per algorithm and measures how long creating them takes.
+type SigningMetrics struct {
+	Signatures *CounterVec
+	Failures   *CounterVec
+	Duration   *HistogramVec
+}
+
+// NewSigningMetrics registers the signing metrics.
+func NewSigningMetrics(registry *Registry) *SigningMetrics {
+	return &SigningMetrics{
+		Signatures: registry.NewCounterVec("signing_signatures_total", "Signatures created.", "algorithm"),
+		Failures:   registry.NewCounterVec("signing_failures_total", "Signatures that could not be created.", "algorithm"),
+		Duration:   registry.NewHistogramVec("signing_duration_seconds", "Time taken to sign the prepared data of a signature and store it.", DefaultBuckets, "algorithm"),
+	}
+}
+
+// Signer instruments a signer of a device with the given algorithm.
+func (m *SigningMetrics) Signer(signer crypto.Signer, algorithm domain.CryptoAlgorithmType) crypto.Signer {
+	return &instrumentedSigner{
+		signer:    signer,
+		algorithm: string(algorithm),
+		metrics:   m,
+	}
+}
+
+type instrumentedSigner struct {
+	signer    crypto.Signer
+	algorithm string
+	metrics   *SigningMetrics
+}
+
+func (s *instrumentedSigner) Sign(dataToBeSigned []byte) ([]byte, error) {
+	start := time.Now()
+	signature, err := s.signer.Sign(dataToBeSigned)
+	s.metrics.Duration.Observe(time.Since(start).Seconds(), s.algorithm)
+	if err != nil {
+		s.metrics.Failures.Inc(s.algorithm)
+		return nil, err
+	}
+	s.metrics.Signatures.Inc(s.algorithm)
+	return signature, nil
+}
@@ -0,0 +1,49 @@
+package metrics
+
+import (
+	"errors"
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/assert"
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/domain"
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/persistence"
+	"testing"
+)
+
+type signerFunc func([]byte) ([]byte, error)
+
+func (f signerFunc) Sign(dataToBeSigned []byte) ([]byte, error) {
+	return f(dataToBeSigned)
+}
+
+func TestSigningMetrics_Signer(t *testing.T) {
+	signingMetrics := NewSigningMetrics(NewRegistry())
+	signer := signingMetrics.Signer(signerFunc(func(data []byte) ([]byte, error) {
+		return data, nil
+	}), domain.ECC)
+	signature, err := signer.Sign([]byte("data"))
+	assert.ShouldBe(t, err, nil)
+	assert.ShouldBe(t, string(signature), "data")
+
+	failing := signingMetrics.Signer(signerFunc(func([]byte) ([]byte, error) {
+		return nil, errors.New("failed")
+	}), domain.RSA)
+	_, err = failing.Sign([]byte("data"))
+	assert.ShouldNotBe(t, err, nil)
+
+	assert.ShouldBe(t, signingMetrics.Signatures.Value("ECC"), float64(1))
+	assert.ShouldBe(t, signingMetrics.Signatures.Value("RSA"), float64(0))
+	assert.ShouldBe(t, signingMetrics.Failures.Value("RSA"), float64(1))
+	assert.ShouldBe(t, signingMetrics.Duration.Count("ECC"), uint64(1))
+}
+
+func TestStorageMetrics_Storage(t *testing.T) {
+	storageMetrics := NewStorageMetrics(NewRegistry())
+	storage := storageMetrics.Storage(persistence.NewLocalStorage())
+	deviceId, _ := storage.CreateSignatureDevice("test", domain.ECC, "")
+	assert.ShouldBe(t, storage.SetDeviceKeys(deviceId, nil, nil), nil)
+	assert.ShouldNotBe(t, storage.SetDeviceKeys("missing", nil, nil), nil)
+	_, err := storage.GetSignature(deviceId, 0)
+	assert.ShouldNotBe(t, err, nil)
+
+	assert.ShouldBe(t, storageMetrics.Errors.Value("set_device_keys"), float64(1))
+	assert.ShouldBe(t, storageMetrics.Errors.Value("get_signature"), float64(1))
+}
@@ -0,0 +1,121 @@
+package metrics
+
+import (
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/domain"
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/persistence"
+	"time"
+)
+
+// StorageMetrics counts the failed operations of a storage.
+type StorageMetrics struct {
+	Errors *CounterVec
+}
+
+// NewStorageMetrics registers the storage metrics.
+func NewStorageMetrics(registry *Registry) *StorageMetrics {
+	return &StorageMetrics{
+		Errors: registry.NewCounterVec("storage_errors_total", "Storage operations that returned an error.", "operation"),
+	}
+}
+
+// Storage instruments every storage operation that can fail.
+func (m *StorageMetrics) Storage(storage persistence.Storage) persistence.Storage {
+	return &instrumentedStorage{
+		Storage: storage,
+		metrics: m,
+	}
+}
+
+type instrumentedStorage struct {
+	persistence.Storage
+	metrics *StorageMetrics
+}
+
+func (s *instrumentedStorage) observe(operation string, err error) error {
+	if err != nil {
+		s.metrics.Errors.Inc(operation)
+	}
+	return err
+}
+
+func (s *instrumentedStorage) RetireDevice(deviceId string, retiredAt time.Time) error {
+	return s.observe("retire_device", s.Storage.RetireDevice(deviceId, retiredAt))
+}
+
+func (s *instrumentedStorage) SetDeviceKeys(deviceId string, publicKey []byte, privateKey []byte) error {
+	return s.observe("set_device_keys", s.Storage.SetDeviceKeys(deviceId, publicKey, privateKey))
+}
+
+func (s *instrumentedStorage) SetDeviceCertificate(deviceId string, certificateChain []byte) error {
+	return s.observe("set_device_certificate", s.Storage.SetDeviceCertificate(deviceId, certificateChain))
+}
+
+func (s *instrumentedStorage) UpdateSignatureCounter(deviceId string) error {
+	return s.observe("update_signature_counter", s.Storage.UpdateSignatureCounter(deviceId))
+}
+
+func (s *instrumentedStorage) AddSignature(deviceId string, publicKey []byte, privateKey []byte, signedData []byte) error {
+	return s.observe("add_signature", s.Storage.AddSignature(deviceId, publicKey, privateKey, signedData))
+}
+
+func (s *instrumentedStorage) AddSignatureRecord(deviceId string, signature domain.Signature) (*domain.Signature, error) {
+	stored, err := s.Storage.AddSignatureRecord(deviceId, signature)
+	return stored, s.observe("add_signature_record", err)
+}
+
+func (s *instrumentedStorage) SetSignatureTimestampToken(deviceId string, counter int, token []byte) error {
+	return s.observe("set_signature_timestamp_token", s.Storage.SetSignatureTimestampToken(deviceId, counter, token))
+}
+
+func (s *instrumentedStorage) GetLastDeviceSignature(deviceId string) (*domain.Signature, error) {
+	signature, err := s.Storage.GetLastDeviceSignature(deviceId)
+	return signature, s.observe("get_last_device_signature", err)
+}
+
+func (s *instrumentedStorage) GetSignature(deviceId string, counter int) (*domain.Signature, error) {
+	signature, err := s.Storage.GetSignature(deviceId, counter)
+	return signature, s.observe("get_signature", err)
+}
+
+func (s *instrumentedStorage) AddAuditEvent(event domain.AuditEvent) error {
+	return s.observe("add_audit_event", s.Storage.AddAuditEvent(event))
+}
+
+func (s *instrumentedStorage) SaveTransaction(transaction domain.Transaction) error {
+	return s.observe("save_transaction", s.Storage.SaveTransaction(transaction))
+}
+
+func (s *instrumentedStorage) DeleteTransaction(deviceId string, number int) error {
+	return s.observe("delete_transaction", s.Storage.DeleteTransaction(deviceId, number))
+}
+
+func (s *instrumentedStorage) GetTransaction(deviceId string, number int) (*domain.Transaction, error) {
+	transaction, err := s.Storage.GetTransaction(deviceId, number)
+	return transaction, s.observe("get_transaction", err)
+}
+
+func (s *instrumentedStorage) ListTransactions(deviceId string) ([]*domain.Transaction, error) {
+	transactions, err := s.Storage.ListTransactions(deviceId)
+	return transactions, s.observe("list_transactions", err)
+}
+
+func (s *instrumentedStorage) SetCertificateAuthority(authority domain.CertificateAuthority) error {
+	return s.observe("set_certificate_authority", s.Storage.SetCertificateAuthority(authority))
+}
+
+func (s *instrumentedStorage) AddIssuedCertificate(certificate domain.IssuedCertificate) error {
+	return s.observe("add_issued_certificate", s.Storage.AddIssuedCertificate(certificate))
+}
+
+func (s *instrumentedStorage) ListIssuedCertificates(deviceId string) ([]domain.IssuedCertificate, error) {
+	certificates, err := s.Storage.ListIssuedCertificates(deviceId)
+	return certificates, s.observe("list_issued_certificates", err)
+}
+
+func (s *instrumentedStorage) Ping() error {
+	return s.observe("ping", s.Storage.Ping())
+}
+
+func (s *instrumentedStorage) Close() error {
+	return s.observe("close", s.Storage.Close())
+}
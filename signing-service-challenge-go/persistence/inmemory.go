@@ -1,24 +1,62 @@
 package persistence
 
 import (
+	"errors"
 	"fmt"
 	"github.com/DrMonez/coding-challenges/signing-service-challenge/domain"
 	"github.com/google/uuid"
+	"slices"
+	"sort"
 	"sync"
+	"time"
 )
 
 const DEFAULT_LABEL = "Signing transaction..."
 
+var (
+	ErrDeviceNotFound = errors.New("device not found")
+	ErrDeviceRetired  = errors.New("device is retired")
+)
+
 type Storage interface {
 	CreateSignatureDevice(
 		userId string, algorithm domain.CryptoAlgorithmType, label string,
 	) (DeviceId string, Label string)
 
+	// GetDevice and ListDevices return copies, so readers never share a device with its writers.
 	GetDevice(deviceId string) *domain.Device
+	ListDevices() []*domain.Device
+	RetireDevice(deviceId string, retiredAt time.Time) error
+	SetDeviceKeys(deviceId string, publicKey []byte, privateKey []byte) error
+	// SetDeviceCertificate replaces the certificate chain of a device that is not retired.
+	SetDeviceCertificate(deviceId string, certificateChain []byte) error
 	UpdateSignatureCounter(deviceId string) error
 	AddSignature(deviceId string, publicKey []byte, privateKey []byte, signedData []byte) error
+	AddSignatureRecord(deviceId string, signature domain.Signature) (*domain.Signature, error)
 	GetDeviceSignaturesCount(deviceId string) int
 	GetLastDeviceSignature(deviceId string) (*domain.Signature, error)
+	GetSignature(deviceId string, counter int) (*domain.Signature, error)
+	// SetSignatureTimestampToken attaches a timestamp token to a stored signature.
+	SetSignatureTimestampToken(deviceId string, counter int, token []byte) error
+	AddAuditEvent(event domain.AuditEvent) error
+	GetAuditEvents(deviceId string) []domain.AuditEvent
+	// SaveTransaction stores a new transaction or replaces the one with the same device and number.
+	SaveTransaction(transaction domain.Transaction) error
+	// DeleteTransaction removes a transaction, it is not an error if there is none.
+	DeleteTransaction(deviceId string, number int) error
+	GetTransaction(deviceId string, number int) (*domain.Transaction, error)
+	// ListTransactions returns the transactions of a device ordered by number.
+	ListTransactions(deviceId string) ([]*domain.Transaction, error)
+	// GetCertificateAuthority returns the stored certificate authority, nil if none has been stored yet.
+	GetCertificateAuthority() *domain.CertificateAuthority
+	SetCertificateAuthority(authority domain.CertificateAuthority) error
+	AddIssuedCertificate(certificate domain.IssuedCertificate) error
+	// ListIssuedCertificates returns the certificates issued to a device in the order they were issued.
+	ListIssuedCertificates(deviceId string) ([]domain.IssuedCertificate, error)
+	// Ping checks that the storage is reachable.
+	Ping() error
+	// Close releases the resources of the storage, it must not be used afterwards.
+	Close() error
 }
 
 type LocalStorage struct {
@@ -28,6 +66,24 @@ type LocalStorage struct {
 	Devices          map[string]*domain.Device
 	SignaturesMutex  sync.Mutex
 	Signatures       map[string]map[int]*domain.Signature
+	AuditEventsMutex sync.Mutex
+	AuditEvents      []domain.AuditEvent
+	// TransactionsMutex guards Transactions, the transactions of each device by number.
+	TransactionsMutex sync.Mutex
+	Transactions      map[string]map[int]*domain.Transaction
+	// AuthorityMutex guards Authority and IssuedCertificates, the certificates issued to each device.
+	AuthorityMutex     sync.Mutex
+	Authority          *domain.CertificateAuthority
+	IssuedCertificates map[string][]domain.IssuedCertificate
+}
+
+// NewLocalStorage is a factory to instantiate an empty LocalStorage.
+func NewLocalStorage() *LocalStorage {
+	return &LocalStorage{
+		UserDevices: make(map[string]map[string]struct{}),
+		Devices:     make(map[string]*domain.Device),
+		Signatures:  make(map[string]map[int]*domain.Signature),
+	}
 }
 
 func (s *LocalStorage) CreateSignatureDevice(
@@ -68,9 +124,63 @@ func (s *LocalStorage) CreateSignatureDevice(
 
 func (s *LocalStorage) GetDevice(deviceId string) *domain.Device {
 	s.DevicesMutex.Lock()
+	defer s.DevicesMutex.Unlock()
 	device := s.Devices[deviceId]
+	if device == nil {
+		return nil
+	}
+	return device.Clone()
+}
+
+func (s *LocalStorage) ListDevices() []*domain.Device {
+	s.DevicesMutex.Lock()
+	devices := make([]*domain.Device, 0, len(s.Devices))
+	for _, device := range s.Devices {
+		devices = append(devices, device.Clone())
+	}
 	s.DevicesMutex.Unlock()
-	return device
+	sort.Slice(devices, func(i, j int) bool {
+		return devices[i].Id < devices[j].Id
+	})
+	return devices
+}
+
+func (s *LocalStorage) RetireDevice(deviceId string, retiredAt time.Time) error {
+	s.DevicesMutex.Lock()
+	defer s.DevicesMutex.Unlock()
+	device := s.Devices[deviceId]
+	if device == nil {
+		return fmt.Errorf("%w: Device with Id=\"%s\" does not exist", ErrDeviceNotFound, deviceId)
+	}
+	if device.IsRetired() {
+		return fmt.Errorf("%w: Device with Id=\"%s\" is already retired", ErrDeviceRetired, deviceId)
+	}
+	device.RetiredAt = retiredAt
+	return nil
+}
+
+func (s *LocalStorage) SetDeviceKeys(deviceId string, publicKey []byte, privateKey []byte) error {
+	s.DevicesMutex.Lock()
+	defer s.DevicesMutex.Unlock()
+	if s.Devices[deviceId] == nil {
+		return fmt.Errorf("Device with Id=\"%s\" does not exist", deviceId)
+	}
+	s.Devices[deviceId].PublicKey = publicKey
+	s.Devices[deviceId].PrivateKey = privateKey
+	return nil
+}
+
+func (s *LocalStorage) SetDeviceCertificate(deviceId string, certificateChain []byte) error {
+	s.DevicesMutex.Lock()
+	defer s.DevicesMutex.Unlock()
+	if s.Devices[deviceId] == nil {
+		return fmt.Errorf("%w: Device with Id=\"%s\" does not exist", ErrDeviceNotFound, deviceId)
+	}
+	if s.Devices[deviceId].IsRetired() {
+		return fmt.Errorf("%w: Device with Id=\"%s\" is retired", ErrDeviceRetired, deviceId)
+	}
+	s.Devices[deviceId].CertificateChain = certificateChain
+	return nil
 }
 
 func (s *LocalStorage) UpdateSignatureCounter(deviceId string) error {
@@ -91,26 +201,32 @@ func (s *LocalStorage) GetDeviceSignaturesCount(deviceId string) int {
 }
 
 func (s *LocalStorage) AddSignature(deviceId string, publicKey []byte, privateKey []byte, signedData []byte) error {
+	_, err := s.AddSignatureRecord(deviceId, domain.Signature{
+		SignedData: signedData,
+		PublicKey:  publicKey,
+		PrivateKey: privateKey,
+	})
+	return err
+}
+
+func (s *LocalStorage) AddSignatureRecord(deviceId string, signature domain.Signature) (*domain.Signature, error) {
 	s.SignaturesMutex.Lock()
+	defer s.SignaturesMutex.Unlock()
+	if s.GetDevice(deviceId) == nil {
+		return nil, fmt.Errorf("Device with Id=\"%s\" does not exist", deviceId)
+	}
 	deviceSignatures := s.Signatures[deviceId]
 	if deviceSignatures == nil {
 		deviceSignatures = make(map[int]*domain.Signature)
 	}
-	signatureCount := s.GetDeviceSignaturesCount(deviceId)
-	signature := domain.Signature{
-		Id:         signatureCount,
-		SignedData: signedData,
-		PublicKey:  publicKey,
-		PrivateKey: privateKey,
-	}
-	deviceSignatures[signature.Id] = &signature
-	s.Signatures[deviceId] = deviceSignatures
-	s.SignaturesMutex.Unlock()
+	signature.Id = s.GetDeviceSignaturesCount(deviceId)
 	err := s.UpdateSignatureCounter(deviceId)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	return nil
+	deviceSignatures[signature.Id] = &signature
+	s.Signatures[deviceId] = deviceSignatures
+	return &signature, nil
 }
 
 func (s *LocalStorage) GetLastDeviceSignature(deviceId string) (*domain.Signature, error) {
@@ -127,3 +243,137 @@ func (s *LocalStorage) GetLastDeviceSignature(deviceId string) (*domain.Signatur
 	}
 	return lastSignature, nil
 }
+
+func (s *LocalStorage) GetSignature(deviceId string, counter int) (*domain.Signature, error) {
+	s.SignaturesMutex.Lock()
+	defer s.SignaturesMutex.Unlock()
+	signature := s.Signatures[deviceId][counter]
+	if signature == nil {
+		return nil, fmt.Errorf("Signature %d of device with Id=\"%s\" does not exist", counter, deviceId)
+	}
+	return signature, nil
+}
+
+func (s *LocalStorage) SetSignatureTimestampToken(deviceId string, counter int, token []byte) error {
+	s.SignaturesMutex.Lock()
+	defer s.SignaturesMutex.Unlock()
+	signature := s.Signatures[deviceId][counter]
+	if signature == nil {
+		return fmt.Errorf("Signature %d of device with Id=\"%s\" does not exist", counter, deviceId)
+	}
+	// The stored signature is replaced, readers may still hold the previous one.
+	updated := *signature
+	updated.TimestampToken = token
+	s.Signatures[deviceId][counter] = &updated
+	return nil
+}
+
+func (s *LocalStorage) AddAuditEvent(event domain.AuditEvent) error {
+	s.AuditEventsMutex.Lock()
+	defer s.AuditEventsMutex.Unlock()
+	s.AuditEvents = append(s.AuditEvents, event)
+	return nil
+}
+
+func (s *LocalStorage) GetAuditEvents(deviceId string) []domain.AuditEvent {
+	s.AuditEventsMutex.Lock()
+	defer s.AuditEventsMutex.Unlock()
+	var events []domain.AuditEvent
+	for _, event := range s.AuditEvents {
+		if event.DeviceId == deviceId {
+			events = append(events, event)
+		}
+	}
+	return events
+}
+
+func (s *LocalStorage) SaveTransaction(transaction domain.Transaction) error {
+	if s.GetDevice(transaction.DeviceId) == nil {
+		return fmt.Errorf("Device with Id=\"%s\" does not exist", transaction.DeviceId)
+	}
+	s.TransactionsMutex.Lock()
+	defer s.TransactionsMutex.Unlock()
+	if s.Transactions == nil {
+		s.Transactions = make(map[string]map[int]*domain.Transaction)
+	}
+	deviceTransactions := s.Transactions[transaction.DeviceId]
+	if deviceTransactions == nil {
+		deviceTransactions = make(map[int]*domain.Transaction)
+		s.Transactions[transaction.DeviceId] = deviceTransactions
+	}
+	deviceTransactions[transaction.Number] = transaction.Clone()
+	return nil
+}
+
+func (s *LocalStorage) DeleteTransaction(deviceId string, number int) error {
+	s.TransactionsMutex.Lock()
+	defer s.TransactionsMutex.Unlock()
+	delete(s.Transactions[deviceId], number)
+	return nil
+}
+
+func (s *LocalStorage) GetTransaction(deviceId string, number int) (*domain.Transaction, error) {
+	s.TransactionsMutex.Lock()
+	defer s.TransactionsMutex.Unlock()
+	transaction := s.Transactions[deviceId][number]
+	if transaction == nil {
+		return nil, fmt.Errorf("Transaction %d of device with Id=\"%s\" does not exist", number, deviceId)
+	}
+	return transaction.Clone(), nil
+}
+
+func (s *LocalStorage) ListTransactions(deviceId string) ([]*domain.Transaction, error) {
+	s.TransactionsMutex.Lock()
+	transactions := make([]*domain.Transaction, 0, len(s.Transactions[deviceId]))
+	for _, transaction := range s.Transactions[deviceId] {
+		transactions = append(transactions, transaction.Clone())
+	}
+	s.TransactionsMutex.Unlock()
+	sort.Slice(transactions, func(i, j int) bool {
+		return transactions[i].Number < transactions[j].Number
+	})
+	return transactions, nil
+}
+
+func (s *LocalStorage) GetCertificateAuthority() *domain.CertificateAuthority {
+	s.AuthorityMutex.Lock()
+	defer s.AuthorityMutex.Unlock()
+	if s.Authority == nil {
+		return nil
+	}
+	authority := *s.Authority
+	return &authority
+}
+
+func (s *LocalStorage) SetCertificateAuthority(authority domain.CertificateAuthority) error {
+	s.AuthorityMutex.Lock()
+	defer s.AuthorityMutex.Unlock()
+	s.Authority = &authority
+	return nil
+}
+
+func (s *LocalStorage) AddIssuedCertificate(certificate domain.IssuedCertificate) error {
+	s.AuthorityMutex.Lock()
+	defer s.AuthorityMutex.Unlock()
+	if s.IssuedCertificates == nil {
+		s.IssuedCertificates = make(map[string][]domain.IssuedCertificate)
+	}
+	s.IssuedCertificates[certificate.DeviceId] = append(s.IssuedCertificates[certificate.DeviceId], certificate)
+	return nil
+}
+
+func (s *LocalStorage) ListIssuedCertificates(deviceId string) ([]domain.IssuedCertificate, error) {
+	s.AuthorityMutex.Lock()
+	defer s.AuthorityMutex.Unlock()
+	return slices.Clone(s.IssuedCertificates[deviceId]), nil
+}
+
+// Ping always succeeds, the storage lives in the memory of the process.
+func (s *LocalStorage) Ping() error {
+	return nil
+}
+
+// Close has nothing to release, the data is lost with the process.
+func (s *LocalStorage) Close() error {
+	return nil
+}
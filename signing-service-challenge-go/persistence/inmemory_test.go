@@ -1,9 +1,11 @@
 package persistence
 
 import (
+	"errors"
 	"github.com/DrMonez/coding-challenges/signing-service-challenge/assert"
 	"github.com/DrMonez/coding-challenges/signing-service-challenge/domain"
 	"testing"
+	"time"
 )
 
 var storage = LocalStorage{
@@ -67,3 +69,71 @@ func TestLocalStorage_GetLastDeviceSignature(t *testing.T) {
 	lastSignature, _ := storage.GetLastDeviceSignature(deviceId)
 	assert.ShouldBe(t, string(lastSignature.SignedData), string(signedData))
 }
+
+func TestLocalStorage_GetAuditEvents(t *testing.T) {
+	deviceId, _ := storage.CreateSignatureDevice("test", "RSA", "label")
+	otherDeviceId, _ := storage.CreateSignatureDevice("test", "RSA", "label")
+	storage.AddAuditEvent(domain.AuditEvent{Action: domain.AuditPrivateKeyImported, DeviceId: deviceId})
+	storage.AddAuditEvent(domain.AuditEvent{Action: domain.AuditPrivateKeyExported, DeviceId: otherDeviceId})
+	storage.AddAuditEvent(domain.AuditEvent{Action: domain.AuditPrivateKeyExported, DeviceId: deviceId})
+	events := storage.GetAuditEvents(deviceId)
+	assert.ShouldBe(t, len(events), 2)
+	assert.ShouldBe(t, events[0].Action, domain.AuditPrivateKeyImported)
+	assert.ShouldBe(t, events[1].Action, domain.AuditPrivateKeyExported)
+}
+
+func TestLocalStorage_RetireDevice(t *testing.T) {
+	deviceId, _ := storage.CreateSignatureDevice("test", "RSA", "label")
+	device := storage.GetDevice(deviceId)
+	assert.ShouldBe(t, device.IsRetired(), false)
+	err := storage.RetireDevice(deviceId, time.Now())
+	assert.ShouldBe(t, err, nil)
+	assert.ShouldBe(t, storage.GetDevice(deviceId).IsRetired(), true)
+	// Devices that have been read before are copies and stay as they were.
+	assert.ShouldBe(t, device.IsRetired(), false)
+	err = storage.RetireDevice(deviceId, time.Now())
+	assert.ShouldBe(t, errors.Is(err, ErrDeviceRetired), true)
+	err = storage.RetireDevice("unknown", time.Now())
+	assert.ShouldBe(t, errors.Is(err, ErrDeviceNotFound), true)
+}
+
+func TestLocalStorage_GetSignature(t *testing.T) {
+	deviceId, _ := storage.CreateSignatureDevice("test", "RSA", "label")
+	storage.AddSignature(deviceId, nil, nil, []byte("first"))
+	storage.AddSignature(deviceId, nil, nil, []byte("second"))
+	signature, err := storage.GetSignature(deviceId, 0)
+	assert.ShouldBe(t, err, nil)
+	assert.ShouldBe(t, string(signature.SignedData), "first")
+	_, err = storage.GetSignature(deviceId, 2)
+	assert.ShouldNotBe(t, err, nil)
+}
+
+func TestLocalStorage_SaveTransaction(t *testing.T) {
+	deviceId, _ := storage.CreateSignatureDevice("test", "ECC", "")
+	transaction := domain.Transaction{DeviceId: deviceId, Number: 1, State: domain.TransactionActive, SignatureCounters: []int{0}}
+	assert.ShouldBe(t, storage.SaveTransaction(transaction), nil)
+	transaction.SignatureCounters[0] = 5
+	assert.ShouldBe(t, storage.SaveTransaction(domain.Transaction{DeviceId: deviceId, Number: 2}), nil)
+
+	stored, err := storage.GetTransaction(deviceId, 1)
+	assert.ShouldBe(t, err, nil)
+	assert.ShouldBe(t, stored.SignatureCounters[0], 0)
+	stored.State = domain.TransactionFinished
+	assert.ShouldBe(t, storage.SaveTransaction(*stored), nil)
+
+	transactions, err := storage.ListTransactions(deviceId)
+	assert.ShouldBe(t, err, nil)
+	assert.ShouldBe(t, len(transactions), 2)
+	assert.ShouldBe(t, transactions[0].State, domain.TransactionFinished)
+	assert.ShouldBe(t, transactions[1].Number, 2)
+
+	_, err = storage.GetTransaction(deviceId, 3)
+	assert.ShouldNotBe(t, err, nil)
+	assert.ShouldNotBe(t, storage.SaveTransaction(domain.Transaction{DeviceId: "unknown", Number: 1}), nil)
+
+	assert.ShouldBe(t, storage.DeleteTransaction(deviceId, 1), nil)
+	assert.ShouldBe(t, storage.DeleteTransaction(deviceId, 1), nil)
+	transactions, _ = storage.ListTransactions(deviceId)
+	assert.ShouldBe(t, len(transactions), 1)
+	assert.ShouldBe(t, transactions[0].Number, 2)
+}
@@ -0,0 +1,184 @@
+// Package ratelimit implements token bucket rate limits keyed by client, tenant or device.
+package ratelimit
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// Limit allows Rate requests per second on average and bursts of up to Burst requests.
+// A zero Rate disables the limit.
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+// Enabled reports whether the limit restricts requests at all.
+func (l Limit) Enabled() bool {
+	return l.Rate > 0 && l.Burst > 0
+}
+
+// Decision is the outcome of taking a token from a bucket.
+type Decision struct {
+	Allowed bool
+	// Limit is the size of the bucket, Remaining the tokens left in it.
+	Limit     int
+	Remaining int
+	// RetryAfter is the time until the next token is available, zero if one is.
+	RetryAfter time.Duration
+	// Reset is the time until the bucket is full again.
+	Reset time.Duration
+}
+
+type bucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// DefaultMaxKeys is the number of buckets a Limiter keeps at most by default.
+const DefaultMaxKeys = 10000
+
+// Limiter keeps one token bucket per key. Buckets that have refilled completely are
+// dropped from time to time, so keys that come and go do not accumulate. The number of
+// buckets is bounded, when it is reached the bucket closest to full makes room for a new key.
+type Limiter struct {
+	limit   Limit
+	now     func() time.Time
+	maxKeys int
+
+	mutex     sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+// Option configures optional behaviour of a Limiter.
+type Option func(*Limiter)
+
+// WithClock makes the Limiter read the time from now instead of the system clock.
+func WithClock(now func() time.Time) Option {
+	return func(l *Limiter) {
+		l.now = now
+	}
+}
+
+// WithMaxKeys bounds the number of buckets the Limiter keeps.
+func WithMaxKeys(maxKeys int) Option {
+	return func(l *Limiter) {
+		l.maxKeys = maxKeys
+	}
+}
+
+// NewLimiter is a factory to instantiate a Limiter for the given limit.
+func NewLimiter(limit Limit, options ...Option) *Limiter {
+	limiter := &Limiter{
+		limit:   limit,
+		now:     time.Now,
+		maxKeys: DefaultMaxKeys,
+		buckets: make(map[string]*bucket),
+	}
+	for _, option := range options {
+		option(limiter)
+	}
+	return limiter
+}
+
+// Limit returns the limit the Limiter enforces.
+func (l *Limiter) Limit() Limit {
+	return l.limit
+}
+
+// fillTime is the time it takes an empty bucket to refill completely.
+func (l *Limiter) fillTime() time.Duration {
+	return time.Duration(float64(l.limit.Burst) / l.limit.Rate * float64(time.Second))
+}
+
+// refill returns the bucket of key with the tokens accrued until now, the mutex must be held.
+func (l *Limiter) refill(key string, now time.Time) *bucket {
+	b, ok := l.buckets[key]
+	if !ok {
+		l.makeRoom(now)
+		b = &bucket{tokens: float64(l.limit.Burst), updated: now}
+		l.buckets[key] = b
+	}
+	if elapsed := now.Sub(b.updated).Seconds(); elapsed > 0 {
+		b.tokens = math.Min(float64(l.limit.Burst), b.tokens+elapsed*l.limit.Rate)
+		b.updated = now
+	}
+	return b
+}
+
+// Allow takes a token from the bucket of key if there is one.
+func (l *Limiter) Allow(key string) Decision {
+	now := l.now()
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.sweep(now)
+
+	b := l.refill(key, now)
+	decision := Decision{Limit: l.limit.Burst}
+	if b.tokens >= 1 {
+		b.tokens--
+		decision.Allowed = true
+	} else {
+		decision.RetryAfter = l.duration(1 - b.tokens)
+	}
+	decision.Remaining = int(b.tokens)
+	decision.Reset = l.duration(float64(l.limit.Burst) - b.tokens)
+	return decision
+}
+
+// Refund returns a token taken by Allow, e.g. when another limit rejected the request.
+func (l *Limiter) Refund(key string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	b := l.refill(key, l.now())
+	b.tokens = math.Min(float64(l.limit.Burst), b.tokens+1)
+}
+
+// duration returns the time it takes to accrue the given number of tokens.
+func (l *Limiter) duration(tokens float64) time.Duration {
+	return time.Duration(math.Ceil(tokens / l.limit.Rate * float64(time.Second)))
+}
+
+// sweep drops the buckets that have refilled completely, at most once per fill time.
+// The mutex must be held.
+func (l *Limiter) sweep(now time.Time) {
+	fillTime := l.fillTime()
+	if now.Sub(l.lastSweep) < fillTime {
+		return
+	}
+	l.lastSweep = now
+	for key, b := range l.buckets {
+		if now.Sub(b.updated) >= fillTime {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// makeRoom drops a bucket if the Limiter keeps as many as it may, the mutex must be held.
+// The buckets that have refilled completely go first, otherwise the one with the most tokens.
+func (l *Limiter) makeRoom(now time.Time) {
+	if len(l.buckets) < l.maxKeys {
+		return
+	}
+	l.lastSweep = time.Time{}
+	l.sweep(now)
+	if len(l.buckets) < l.maxKeys {
+		return
+	}
+	fullest, tokens := "", -1.0
+	for key, b := range l.buckets {
+		if accrued := b.tokens + now.Sub(b.updated).Seconds()*l.limit.Rate; accrued > tokens {
+			fullest, tokens = key, accrued
+		}
+	}
+	delete(l.buckets, fullest)
+}
+
+// Len returns the number of buckets currently kept.
+func (l *Limiter) Len() int {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return len(l.buckets)
+}
@@ -0,0 +1,89 @@
+package ratelimit
+
+import (
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/assert"
+	"testing"
+	"time"
+)
+
+type clock struct {
+	now time.Time
+}
+
+func (c *clock) Now() time.Time {
+	return c.now
+}
+
+func TestLimiter_Allow(t *testing.T) {
+	clock := &clock{now: time.Unix(0, 0)}
+	limiter := NewLimiter(Limit{Rate: 2, Burst: 3}, WithClock(clock.Now))
+
+	for remaining := 2; remaining >= 0; remaining-- {
+		decision := limiter.Allow("a")
+		assert.ShouldBe(t, decision.Allowed, true)
+		assert.ShouldBe(t, decision.Remaining, remaining)
+		assert.ShouldBe(t, decision.Limit, 3)
+	}
+	decision := limiter.Allow("a")
+	assert.ShouldBe(t, decision.Allowed, false)
+	assert.ShouldBe(t, decision.RetryAfter, 500*time.Millisecond)
+	assert.ShouldBe(t, decision.Reset, 1500*time.Millisecond)
+
+	// Keys have buckets of their own.
+	assert.ShouldBe(t, limiter.Allow("b").Allowed, true)
+
+	clock.now = clock.now.Add(500 * time.Millisecond)
+	assert.ShouldBe(t, limiter.Allow("a").Allowed, true)
+	assert.ShouldBe(t, limiter.Allow("a").Allowed, false)
+}
+
+func TestLimiter_Refund(t *testing.T) {
+	clock := &clock{now: time.Unix(0, 0)}
+	limiter := NewLimiter(Limit{Rate: 1, Burst: 1}, WithClock(clock.Now))
+
+	assert.ShouldBe(t, limiter.Allow("a").Allowed, true)
+	limiter.Refund("a")
+	assert.ShouldBe(t, limiter.Allow("a").Allowed, true)
+	assert.ShouldBe(t, limiter.Allow("a").Allowed, false)
+	// A refund never fills a bucket beyond its burst.
+	limiter.Refund("a")
+	limiter.Refund("a")
+	assert.ShouldBe(t, limiter.Allow("a").Remaining, 0)
+}
+
+func TestLimiter_DropsFullBuckets(t *testing.T) {
+	clock := &clock{now: time.Unix(0, 0)}
+	limiter := NewLimiter(Limit{Rate: 1, Burst: 2}, WithClock(clock.Now))
+
+	limiter.Allow("a")
+	limiter.Allow("b")
+	assert.ShouldBe(t, limiter.Len(), 2)
+
+	clock.now = clock.now.Add(time.Second)
+	limiter.Allow("b")
+	assert.ShouldBe(t, limiter.Len(), 2)
+
+	clock.now = clock.now.Add(time.Second)
+	limiter.Allow("c")
+	// a has refilled and is dropped, b has not been full for a whole fill time yet.
+	assert.ShouldBe(t, limiter.Len(), 2)
+}
+
+func TestLimiter_MaxKeys(t *testing.T) {
+	clock := &clock{now: time.Unix(0, 0)}
+	limiter := NewLimiter(Limit{Rate: 1, Burst: 2}, WithClock(clock.Now), WithMaxKeys(2))
+
+	limiter.Allow("a")
+	limiter.Allow("a")
+	limiter.Allow("b")
+	limiter.Allow("c")
+	assert.ShouldBe(t, limiter.Len(), 2)
+	// The bucket of b had more tokens left than the one of a, a is still exhausted.
+	assert.ShouldBe(t, limiter.Allow("a").Allowed, false)
+}
+
+func TestLimit_Enabled(t *testing.T) {
+	assert.ShouldBe(t, Limit{}.Enabled(), false)
+	assert.ShouldBe(t, Limit{Rate: 1}.Enabled(), false)
+	assert.ShouldBe(t, Limit{Rate: 1, Burst: 1}.Enabled(), true)
+}
@@ -0,0 +1,5 @@
+// Package pb contains the protobuf messages and gRPC stubs of the signing service.
+// The generated files are committed, so clients can build without protoc.
+package pb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative signing.proto
@@ -0,0 +1,220 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/build"
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/domain"
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/persistence"
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/rpc/pb"
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/signing"
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/tracing"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"net"
+	"sync"
+)
+
+// Server exposes the signature devices over gRPC.
+type Server struct {
+	pb.UnimplementedSigningServiceServer
+
+	listenAddress string
+	storage       persistence.Storage
+	signatures    *signing.Service
+
+	grpcServerOnce sync.Once
+	grpcServer     *grpc.Server
+}
+
+// NewServer is a factory to instantiate a new Server.
+func NewServer(
+	listenAddress string,
+	storage persistence.Storage,
+	signatures *signing.Service,
+) *Server {
+	return &Server{
+		listenAddress: listenAddress,
+		storage:       storage,
+		signatures:    signatures,
+	}
+}
+
+// Register registers the signing service on a gRPC server.
+func (s *Server) Register(server *grpc.Server) {
+	pb.RegisterSigningServiceServer(server, s)
+}
+
+// Run starts the gRPC server and blocks until it fails or has been shut down.
+func (s *Server) Run() error {
+	listener, err := net.Listen("tcp", s.listenAddress)
+	if err != nil {
+		return err
+	}
+	return s.server().Serve(listener)
+}
+
+// Shutdown stops accepting RPCs and waits until those in flight have completed.
+// Once ctx is done, the remaining RPCs are cancelled.
+func (s *Server) Shutdown(ctx context.Context) error {
+	server := s.server()
+	stopped := make(chan struct{})
+	go func() {
+		server.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+		return nil
+	case <-ctx.Done():
+		server.Stop()
+		return ctx.Err()
+	}
+}
+
+func (s *Server) server() *grpc.Server {
+	s.grpcServerOnce.Do(func() {
+		s.grpcServer = grpc.NewServer()
+		s.Register(s.grpcServer)
+	})
+	return s.grpcServer
+}
+
+func (s *Server) CreateSignatureDevice(ctx context.Context, request *pb.CreateSignatureDeviceRequest) (*pb.Device, error) {
+	device, err := s.signatures.CreateDevice(ctx, request.GetId(), domain.CryptoAlgorithmType(request.GetAlgorithm()), request.GetLabel())
+	if errors.Is(err, signing.ErrShuttingDown) {
+		return nil, statusError(err)
+	}
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	return s.newDevice(ctx, device), nil
+}
+
+func (s *Server) GetDevice(ctx context.Context, request *pb.GetDeviceRequest) (*pb.Device, error) {
+	device := tracing.Storage(ctx, s.storage).GetDevice(request.GetDeviceId())
+	if device == nil {
+		return nil, statusError(signing.ErrDeviceNotFound)
+	}
+	return s.newDevice(ctx, device), nil
+}
+
+func (s *Server) ListDevices(ctx context.Context, request *pb.ListDevicesRequest) (*pb.ListDevicesResponse, error) {
+	devices := tracing.Storage(ctx, s.storage).ListDevices()
+	response := &pb.ListDevicesResponse{
+		Devices: make([]*pb.Device, 0, len(devices)),
+	}
+	for _, device := range devices {
+		response.Devices = append(response.Devices, s.newDevice(ctx, device))
+	}
+	return response, nil
+}
+
+func (s *Server) SignTransaction(ctx context.Context, request *pb.SignTransactionRequest) (*pb.SignTransactionResponse, error) {
+	signature, err := s.signatures.SignTransaction(ctx, request.GetDeviceId(), request.GetData(), domain.SignatureFormat(request.GetFormat()))
+	if err != nil {
+		return nil, statusError(err)
+	}
+	return &pb.SignTransactionResponse{
+		SignatureCounter: int64(signature.Counter),
+		Signature:        signature.Signature,
+		SignedData:       signature.SignedData,
+		Envelope:         signature.Envelope,
+	}, nil
+}
+
+func (s *Server) VerifySignature(ctx context.Context, request *pb.VerifySignatureRequest) (*pb.VerifySignatureResponse, error) {
+	isValid, err := s.signatures.Verify(ctx, request.GetDeviceId(), request.GetSignedData(), request.GetSignature(), domain.SignatureFormat(request.GetFormat()))
+	if err != nil {
+		return nil, statusError(err)
+	}
+	return &pb.VerifySignatureResponse{Valid: isValid}, nil
+}
+
+// Health reports "pass" if the service can sign, with the checks of the readiness probe of
+// the HTTP API: the storage must be reachable and every enabled algorithm must pass a
+// signing self-test. It reports "fail" otherwise.
+func (s *Server) Health(ctx context.Context, request *pb.HealthRequest) (*pb.HealthResponse, error) {
+	health := "pass"
+	if err := s.checkHealth(ctx); err != nil {
+		health = "fail"
+	}
+	return &pb.HealthResponse{
+		Status:  health,
+		Version: build.Version(),
+	}, nil
+}
+
+func (s *Server) checkHealth(ctx context.Context) error {
+	if err := tracing.Storage(ctx, s.storage).Ping(); err != nil {
+		return err
+	}
+	for _, algorithm := range s.signatures.Algorithms() {
+		if err := s.signatures.SelfTest(ctx, algorithm); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Server) StreamSignatureHistory(request *pb.SignatureHistoryRequest, stream pb.SigningService_StreamSignatureHistoryServer) error {
+	storage := tracing.Storage(stream.Context(), s.storage)
+	device := storage.GetDevice(request.GetDeviceId())
+	if device == nil {
+		return statusError(signing.ErrDeviceNotFound)
+	}
+	if request.GetFromCounter() < 0 {
+		return status.Error(codes.InvalidArgument, "from_counter must not be negative")
+	}
+
+	count := storage.GetDeviceSignaturesCount(device.Id)
+	for counter := int(request.GetFromCounter()); counter < count; counter++ {
+		if err := stream.Context().Err(); err != nil {
+			return status.FromContextError(err).Err()
+		}
+		signature, err := storage.GetSignature(device.Id, counter)
+		if err != nil {
+			return status.Error(codes.Internal, signing.ErrChainInconsistency.Error())
+		}
+		err = stream.Send(&pb.SignatureRecord{
+			SignatureCounter: int64(signature.Id),
+			Signature:        signature.SignedData,
+			Data:             signature.Data,
+			Format:           string(signature.Format),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Server) newDevice(ctx context.Context, device *domain.Device) *pb.Device {
+	return &pb.Device{
+		Id:               device.Id,
+		Algorithm:        string(device.Algorithm),
+		Label:            device.Label,
+		SignatureCounter: int64(tracing.Storage(ctx, s.storage).GetDeviceSignaturesCount(device.Id)),
+		Retired:          device.IsRetired(),
+		PublicKey:        device.PublicKey,
+	}
+}
+
+// statusError maps errors of the signing service to gRPC status errors.
+func statusError(err error) error {
+	switch {
+	case errors.Is(err, signing.ErrDeviceNotFound):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, signing.ErrDeviceRetired):
+		return status.Error(codes.FailedPrecondition, err.Error())
+	case errors.Is(err, signing.ErrUnsupportedFormat), errors.Is(err, signing.ErrInvalidSignedData):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, signing.ErrShuttingDown):
+		return status.Error(codes.Unavailable, err.Error())
+	case errors.Is(err, signing.ErrTimestampFailed):
+		return status.Error(codes.Unavailable, signing.ErrTimestampFailed.Error())
+	default:
+		return status.Error(codes.Internal, "internal error")
+	}
+}
@@ -0,0 +1,124 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/assert"
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/persistence"
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/rpc/pb"
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/signing"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"io"
+	"net"
+	"testing"
+)
+
+func newTestClient(t *testing.T) pb.SigningServiceClient {
+	storage := persistence.NewLocalStorage()
+	listener := bufconn.Listen(1 << 20)
+	server := grpc.NewServer()
+	NewServer("", storage, signing.NewService(storage)).Register(server)
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+
+	connection, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	assert.ShouldBe(t, err, nil)
+	t.Cleanup(func() { connection.Close() })
+	return pb.NewSigningServiceClient(connection)
+}
+
+func TestServer_SignAndVerify(t *testing.T) {
+	client := newTestClient(t)
+	ctx := context.Background()
+	device, err := client.CreateSignatureDevice(ctx, &pb.CreateSignatureDeviceRequest{Algorithm: "ECC", Label: "till"})
+	assert.ShouldBe(t, err, nil)
+	assert.ShouldBe(t, device.Label, "till")
+
+	signature, err := client.SignTransaction(ctx, &pb.SignTransactionRequest{DeviceId: device.Id, Data: "data", Format: "jws"})
+	assert.ShouldBe(t, err, nil)
+	assert.ShouldBe(t, signature.SignatureCounter, int64(0))
+
+	verification, err := client.VerifySignature(ctx, &pb.VerifySignatureRequest{
+		DeviceId:   device.Id,
+		SignedData: signature.SignedData,
+		Signature:  signature.Signature,
+		Format:     "jws",
+	})
+	assert.ShouldBe(t, err, nil)
+	assert.ShouldBe(t, verification.Valid, true)
+
+	verification, _ = client.VerifySignature(ctx, &pb.VerifySignatureRequest{
+		DeviceId:   device.Id,
+		SignedData: signature.SignedData + "tampered",
+		Signature:  signature.Signature,
+		Format:     "jws",
+	})
+	assert.ShouldBe(t, verification.Valid, false)
+
+	fetched, _ := client.GetDevice(ctx, &pb.GetDeviceRequest{DeviceId: device.Id})
+	assert.ShouldBe(t, fetched.SignatureCounter, int64(1))
+	devices, _ := client.ListDevices(ctx, &pb.ListDevicesRequest{})
+	assert.ShouldBe(t, len(devices.Devices), 1)
+}
+
+func TestServer_StreamSignatureHistory(t *testing.T) {
+	client := newTestClient(t)
+	ctx := context.Background()
+	device, _ := client.CreateSignatureDevice(ctx, &pb.CreateSignatureDeviceRequest{Algorithm: "RSA"})
+	for _, data := range []string{"a", "b", "c"} {
+		client.SignTransaction(ctx, &pb.SignTransactionRequest{DeviceId: device.Id, Data: data})
+	}
+
+	stream, err := client.StreamSignatureHistory(ctx, &pb.SignatureHistoryRequest{DeviceId: device.Id, FromCounter: 1})
+	assert.ShouldBe(t, err, nil)
+	var records []*pb.SignatureRecord
+	for {
+		record, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		assert.ShouldBe(t, err, nil)
+		records = append(records, record)
+	}
+	assert.ShouldBe(t, len(records), 2)
+	assert.ShouldBe(t, records[0].SignatureCounter, int64(1))
+	assert.ShouldBe(t, records[1].Format, "raw")
+}
+
+func TestServer_Errors(t *testing.T) {
+	client := newTestClient(t)
+	_, err := client.SignTransaction(context.Background(), &pb.SignTransactionRequest{DeviceId: "missing", Data: "data"})
+	assert.ShouldBe(t, status.Code(err), codes.NotFound)
+	_, err = client.CreateSignatureDevice(context.Background(), &pb.CreateSignatureDeviceRequest{Algorithm: "DSA"})
+	assert.ShouldBe(t, status.Code(err), codes.InvalidArgument)
+}
+
+// unreachableStorage fails the health check of the storage.
+type unreachableStorage struct {
+	persistence.Storage
+}
+
+func (s unreachableStorage) Ping() error {
+	return errors.New("connection refused")
+}
+
+func TestServer_Health(t *testing.T) {
+	client := newTestClient(t)
+	health, err := client.Health(context.Background(), &pb.HealthRequest{})
+	assert.ShouldBe(t, err, nil)
+	assert.ShouldBe(t, health.Status, "pass")
+
+	storage := unreachableStorage{&persistence.LocalStorage{}}
+	health, err = NewServer("", storage, signing.NewService(storage)).Health(context.Background(), &pb.HealthRequest{})
+	assert.ShouldBe(t, err, nil)
+	assert.ShouldBe(t, health.Status, "fail")
+}
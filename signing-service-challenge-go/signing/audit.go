@@ -0,0 +1,203 @@
+package signing
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/crypto"
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/domain"
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/persistence"
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/tracing"
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/tsa"
+	"go.opentelemetry.io/otel/attribute"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TimestampTolerance is how far the time of a timestamp token may be off the timestamp of its
+// signature. The token is requested after the signature is stored, and clocks differ.
+const TimestampTolerance = time.Minute
+
+// SignatureRecord is a stored signature of a device.
+type SignatureRecord struct {
+	Counter    int
+	Signature  []byte
+	SignedData string
+	Format     domain.SignatureFormat
+	Timestamp  time.Time
+	// PayloadVersion is the layout of SignedData, it is empty for signatures stored without one.
+	PayloadVersion domain.PayloadVersion
+	// TimestampToken is the RFC 3161 token over Signature, if the signature has one.
+	TimestampToken []byte
+}
+
+// ChainAudit is the result of checking the signature chain of a device.
+type ChainAudit struct {
+	DeviceId       string
+	SignatureCount int
+	Failures       []ChainFailure
+}
+
+// Valid reports whether the audit found no failures.
+func (a *ChainAudit) Valid() bool {
+	return len(a.Failures) == 0
+}
+
+// ChainFailure describes why a signature does not belong to a consistent chain.
+type ChainFailure struct {
+	Counter int
+	Reason  string
+}
+
+// History returns up to limit signatures of a device, starting at counter from,
+// along with the number of signatures the device has created.
+func (s *Service) History(ctx context.Context, deviceId string, from int, limit int) ([]SignatureRecord, int, error) {
+	storage := tracing.Storage(ctx, s.storage)
+	device := storage.GetDevice(deviceId)
+	if device == nil {
+		return nil, 0, ErrDeviceNotFound
+	}
+	count := storage.GetDeviceSignaturesCount(device.Id)
+	records := make([]SignatureRecord, 0, max(0, min(limit, count-from)))
+	for counter := from; counter < count && len(records) < limit; counter++ {
+		signature, err := storage.GetSignature(device.Id, counter)
+		if err != nil {
+			return nil, 0, ErrChainInconsistency
+		}
+		signedData, err := SignedDataOf(signature)
+		if err != nil {
+			return nil, 0, err
+		}
+		records = append(records, SignatureRecord{
+			Counter:        signature.Id,
+			Signature:      signature.SignedData,
+			SignedData:     signedData,
+			Format:         signature.Format,
+			Timestamp:      signature.CreatedAt,
+			PayloadVersion: signature.PayloadVersion,
+			TimestampToken: signature.TimestampToken,
+		})
+	}
+	return records, count, nil
+}
+
+// SignedDataOf recovers the signed data, <signature_counter>_<data>_<last_signature>,
+// from the bytes a stored signature has been created over.
+func SignedDataOf(signature *domain.Signature) (string, error) {
+	switch signature.Format {
+	case domain.FormatJWS:
+		payload, err := crypto.JWSPayload(signature.Data)
+		return string(payload), err
+	case domain.FormatCOSESign1:
+		payload, err := crypto.COSESign1Payload(signature.Data)
+		return string(payload), err
+	default:
+		return string(signature.Data), nil
+	}
+}
+
+// AuditChain checks every signature of a device: the counters have no gaps, each signed data
+// starts with its counter and ends with the signature before it, the timestamps never go backwards,
+// each signature verifies with the key of the device and each timestamp token verifies with the
+// roots of the timestamp authority at a time within TimestampTolerance of its signature. All failures are reported, not only the first.
+func (s *Service) AuditChain(ctx context.Context, deviceId string) (*ChainAudit, error) {
+	ctx, span := tracing.Tracer().Start(ctx, "Service.AuditChain")
+	span.SetAttributes(attribute.String("signing.device_id", deviceId))
+	audit, err := s.auditChain(tracing.Storage(ctx, s.storage), deviceId)
+	tracing.End(span, err)
+	return audit, err
+}
+
+func (s *Service) auditChain(storage persistence.Storage, deviceId string) (*ChainAudit, error) {
+	device := storage.GetDevice(deviceId)
+	if device == nil {
+		return nil, ErrDeviceNotFound
+	}
+	audit := &ChainAudit{
+		DeviceId:       device.Id,
+		SignatureCount: storage.GetDeviceSignaturesCount(device.Id),
+	}
+	fail := func(counter int, format string, args ...interface{}) {
+		audit.Failures = append(audit.Failures, ChainFailure{Counter: counter, Reason: fmt.Sprintf(format, args...)})
+	}
+
+	lastSignature := base64.StdEncoding.EncodeToString([]byte(device.Id))
+	var lastTimestamp time.Time
+	for counter := 0; counter < audit.SignatureCount; counter++ {
+		signature, err := storage.GetSignature(device.Id, counter)
+		if err != nil {
+			fail(counter, "signature is missing")
+			lastSignature = ""
+			continue
+		}
+		if err := checkLink(device, signature, counter, lastSignature); err != nil {
+			fail(counter, "%v", err)
+		}
+		if signature.CreatedAt.Before(lastTimestamp) {
+			fail(counter, "timestamp %s is before the timestamp of the previous signature", signature.CreatedAt.Format(domain.TimestampLayout))
+		}
+		if err := verifyRecord(device, signature); err != nil {
+			fail(counter, "%v", err)
+		}
+		if len(signature.TimestampToken) > 0 {
+			info, err := tsa.Verify(signature.TimestampToken, signature.SignedData, s.timestampRoots)
+			switch {
+			case err != nil:
+				fail(counter, "timestamp token does not verify: %v", err)
+			case info.Time.Sub(signature.CreatedAt).Abs() > TimestampTolerance:
+				fail(counter, "timestamp token time %s is not within %s of the signature timestamp", info.Time.UTC().Format(domain.TimestampLayout), TimestampTolerance)
+			}
+		}
+		lastSignature = base64.StdEncoding.EncodeToString(signature.SignedData)
+		lastTimestamp = signature.CreatedAt
+	}
+	return audit, nil
+}
+
+// checkLink checks that a signature has been created over the signed data for its counter
+// that links to lastSignature. An empty lastSignature is not checked, its predecessor is missing.
+func checkLink(device *domain.Device, signature *domain.Signature, counter int, lastSignature string) error {
+	signedData, err := SignedDataOf(signature)
+	if err != nil {
+		return fmt.Errorf("signed data can not be recovered: %w", err)
+	}
+	if !strings.HasPrefix(signedData, strconv.Itoa(counter)+"_") {
+		return fmt.Errorf("signed data does not start with counter %d", counter)
+	}
+	if signature.PayloadVersion == domain.PayloadV2 {
+		prefix := fmt.Sprintf("%d_%s_", counter, signature.CreatedAt.UTC().Format(domain.TimestampLayout))
+		if !strings.HasPrefix(signedData, prefix) {
+			return errors.New("signed data does not contain the timestamp of the signature")
+		}
+	}
+	if lastSignature != "" && !strings.HasSuffix(signedData, "_"+lastSignature) {
+		return errors.New("signed data does not link to the previous signature")
+	}
+	message, err := SigningInput(device, signedData, signature.Format)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(message, signature.Data) {
+		return errors.New("signing input does not match the device and counter")
+	}
+	return nil
+}
+
+// verifyRecord verifies a stored signature with the public key it has been created with.
+func verifyRecord(device *domain.Device, signature *domain.Signature) error {
+	publicKey := signature.PublicKey
+	if len(publicKey) == 0 {
+		publicKey = device.PublicKey
+	}
+	verifier, err := crypto.NewVerifier(device.Algorithm, publicKey, signature.Format)
+	if err != nil {
+		return err
+	}
+	if err := verifier.Verify(signature.Data, signature.SignedData); err != nil {
+		return fmt.Errorf("signature does not verify: %w", err)
+	}
+	return nil
+}
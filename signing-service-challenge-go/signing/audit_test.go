@@ -0,0 +1,117 @@
+package signing
+
+import (
+	"context"
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/assert"
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/domain"
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/tsa"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestService_AuditChain(t *testing.T) {
+	for _, algorithm := range domain.CryptoAlgorithms {
+		device := newTestDevice(algorithm)
+		for _, format := range []domain.SignatureFormat{domain.FormatRaw, domain.FormatJWS, domain.FormatCOSESign1} {
+			_, err := service.SignTransaction(context.Background(), device.Id, "data", format)
+			assert.ShouldBe(t, err, nil)
+		}
+		audit, err := service.AuditChain(context.Background(), device.Id)
+		assert.ShouldBe(t, err, nil)
+		assert.ShouldBe(t, audit.SignatureCount, 3)
+		assert.ShouldBe(t, audit.Valid(), true)
+	}
+
+	_, err := service.AuditChain(context.Background(), "unknown")
+	assert.ShouldBe(t, err, ErrDeviceNotFound)
+}
+
+func TestService_AuditChainReportsTampering(t *testing.T) {
+	device := newTestDevice(domain.ECC)
+	service.SignBatch(context.Background(), device.Id, []string{"a", "b", "c"}, domain.FormatRaw)
+
+	second, _ := storage.GetSignature(device.Id, 1)
+	second.Data = []byte("1_forged_" + string(second.Data[len("1_b_"):]))
+	delete(storage.Signatures[device.Id], 2)
+
+	audit, err := service.AuditChain(context.Background(), device.Id)
+	assert.ShouldBe(t, err, nil)
+	assert.ShouldBe(t, audit.Valid(), false)
+	assert.ShouldBe(t, len(audit.Failures), 2)
+	assert.ShouldBe(t, audit.Failures[0], ChainFailure{Counter: 1, Reason: "signature does not verify: signature is not valid"})
+	assert.ShouldBe(t, audit.Failures[1], ChainFailure{Counter: 2, Reason: "signature is missing"})
+}
+
+func TestService_AuditChainWithPayloadV2(t *testing.T) {
+	service := NewService(storage, WithPayloadVersion(domain.PayloadV2))
+	device := newTestDevice(domain.RSA)
+	_, err := service.SignBatch(context.Background(), device.Id, []string{"a", "b", "c"}, domain.FormatCOSESign1)
+	assert.ShouldBe(t, err, nil)
+	audit, _ := service.AuditChain(context.Background(), device.Id)
+	assert.ShouldBe(t, audit.Valid(), true)
+
+	second, _ := storage.GetSignature(device.Id, 1)
+	second.CreatedAt = second.CreatedAt.Add(time.Hour)
+	audit, _ = service.AuditChain(context.Background(), device.Id)
+	assert.ShouldBe(t, len(audit.Failures), 2)
+	assert.ShouldBe(t, audit.Failures[0], ChainFailure{Counter: 1, Reason: "signed data does not contain the timestamp of the signature"})
+	assert.ShouldBe(t, audit.Failures[1].Counter, 2)
+	assert.ShouldBe(t, strings.HasSuffix(audit.Failures[1].Reason, "is before the timestamp of the previous signature"), true)
+}
+
+func TestService_AuditChainVerifiesTimestampTokens(t *testing.T) {
+	authority, _ := tsa.NewLocalAuthority(time.Hour)
+	service := NewService(storage, WithTimestampAuthority(authority, authority.Roots()))
+	device := newTestDevice(domain.ECC)
+	service.SignTransaction(context.Background(), device.Id, "a", domain.FormatRaw)
+	service.SignTransaction(context.Background(), device.Id, "b", domain.FormatJWS)
+	audit, _ := service.AuditChain(context.Background(), device.Id)
+	assert.ShouldBe(t, audit.Valid(), true)
+
+	first, _ := storage.GetSignature(device.Id, 0)
+	second, _ := storage.GetSignature(device.Id, 1)
+	second.TimestampToken = first.TimestampToken
+	audit, _ = service.AuditChain(context.Background(), device.Id)
+	assert.ShouldBe(t, len(audit.Failures), 1)
+	assert.ShouldBe(t, audit.Failures[0], ChainFailure{Counter: 1, Reason: "timestamp token does not verify: timestamp token does not cover the message"})
+
+	untrusted := NewService(storage)
+	audit, _ = untrusted.AuditChain(context.Background(), device.Id)
+	assert.ShouldBe(t, strings.HasPrefix(audit.Failures[0].Reason, "timestamp token does not verify: certificate of the timestamp authority is not trusted"), true)
+
+	records, _, _ := service.History(context.Background(), device.Id, 0, 1)
+	assert.ShouldBe(t, string(records[0].TimestampToken), string(first.TimestampToken))
+}
+
+func TestService_AuditChainChecksTimestampTokenTime(t *testing.T) {
+	authority, _ := tsa.NewLocalAuthority(time.Hour, tsa.WithClock(func() time.Time {
+		return time.Now().Add(2 * TimestampTolerance)
+	}))
+	service := NewService(storage, WithTimestampAuthority(authority, authority.Roots()))
+	device := newTestDevice(domain.ECC)
+	_, err := service.SignTransaction(context.Background(), device.Id, "a", domain.FormatRaw)
+	assert.ShouldBe(t, err, nil)
+
+	audit, _ := service.AuditChain(context.Background(), device.Id)
+	assert.ShouldBe(t, len(audit.Failures), 1)
+	assert.ShouldBe(t, strings.HasSuffix(audit.Failures[0].Reason, "is not within 1m0s of the signature timestamp"), true)
+}
+
+func TestService_History(t *testing.T) {
+	device := newTestDevice(domain.ECC)
+	signatures, _ := service.SignBatch(context.Background(), device.Id, []string{"a", "b", "c"}, domain.FormatJWS)
+
+	records, count, err := service.History(context.Background(), device.Id, 1, 1)
+	assert.ShouldBe(t, err, nil)
+	assert.ShouldBe(t, count, 3)
+	assert.ShouldBe(t, len(records), 1)
+	assert.ShouldBe(t, records[0].Counter, 1)
+	assert.ShouldBe(t, records[0].SignedData, signatures[1].SignedData)
+	assert.ShouldBe(t, records[0].Format, domain.FormatJWS)
+	assert.ShouldBe(t, records[0].Timestamp, signatures[1].Timestamp)
+	assert.ShouldBe(t, records[0].PayloadVersion, domain.PayloadV1)
+
+	records, _, _ = service.History(context.Background(), device.Id, 3, 10)
+	assert.ShouldBe(t, len(records), 0)
+}
@@ -0,0 +1,76 @@
+package signing
+
+import (
+	"context"
+	"fmt"
+	signingcrypto "github.com/DrMonez/coding-challenges/signing-service-challenge/crypto"
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/domain"
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/persistence"
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/tracing"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// selfTestData is signed by the self-test, it never reaches the storage of the service.
+var selfTestData = []byte("signing service self-test")
+
+// testKeyPair is the encoded key pair the self-test of an algorithm signs with.
+type testKeyPair struct {
+	publicKey  []byte
+	privateKey []byte
+}
+
+// SelfTest signs and verifies a fixed message with a key pair of the given algorithm, passing
+// through the same key encoding, signing and verification as device signatures.
+// The key pair is generated on the first run, so later runs are cheap enough for readiness probes.
+func (s *Service) SelfTest(ctx context.Context, algorithm domain.CryptoAlgorithmType) error {
+	_, span := tracing.Tracer().Start(ctx, "Service.SelfTest")
+	span.SetAttributes(attribute.String("signing.algorithm", string(algorithm)))
+	err := s.selfTest(algorithm)
+	tracing.End(span, err)
+	return err
+}
+
+func (s *Service) selfTest(algorithm domain.CryptoAlgorithmType) error {
+	keyPair, err := s.testKeyPair(algorithm)
+	if err != nil {
+		return err
+	}
+	// The signer stores what it signs, a scratch storage keeps the self-test out of the chains.
+	storage := persistence.NewLocalStorage()
+	deviceId, _ := storage.CreateSignatureDevice("", algorithm, "self-test")
+	err = storage.SetDeviceKeys(deviceId, keyPair.publicKey, keyPair.privateKey)
+	if err != nil {
+		return err
+	}
+	signer, err := signingcrypto.NewSigner(storage.GetDevice(deviceId), storage, domain.FormatRaw)
+	if err != nil {
+		return err
+	}
+	signature, err := signer.Sign(selfTestData)
+	if err != nil {
+		return err
+	}
+	verifier, err := signingcrypto.NewVerifier(algorithm, keyPair.publicKey, domain.FormatRaw)
+	if err != nil {
+		return err
+	}
+	if err := verifier.Verify(selfTestData, signature); err != nil {
+		return fmt.Errorf("self-test signature of %s does not verify: %w", algorithm, err)
+	}
+	return nil
+}
+
+func (s *Service) testKeyPair(algorithm domain.CryptoAlgorithmType) (*testKeyPair, error) {
+	s.testKeysMutex.Lock()
+	defer s.testKeysMutex.Unlock()
+	if keyPair, ok := s.testKeys[algorithm]; ok {
+		return keyPair, nil
+	}
+	publicKey, privateKey, err := signingcrypto.GenerateKeyPair(algorithm, s.keyOptions...)
+	if err != nil {
+		return nil, err
+	}
+	keyPair := &testKeyPair{publicKey: publicKey, privateKey: privateKey}
+	s.testKeys[algorithm] = keyPair
+	return keyPair, nil
+}
@@ -0,0 +1,619 @@
+package signing
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/crypto"
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/domain"
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/metrics"
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/persistence"
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrDeviceNotFound     = errors.New("device not found")
+	ErrDeviceRetired      = errors.New("device is retired")
+	ErrUnsupportedFormat  = errors.New("signature format is not supported")
+	ErrChainInconsistency = errors.New("signature chain of the device is inconsistent")
+	ErrInvalidSignedData  = errors.New("signed data does not start with a signature counter")
+	ErrAlgorithmDisabled  = errors.New("algorithm is not enabled")
+	ErrInvalidPrivateKey  = errors.New("private key cannot be imported")
+	ErrShuttingDown       = errors.New("signing service is shutting down")
+	ErrTimestampFailed    = errors.New("timestamp authority did not issue a token")
+)
+
+// Clock tells the time signatures are created at.
+type Clock interface {
+	Now() time.Time
+}
+
+// TimestampAuthority issues RFC 3161 timestamp tokens over messages.
+type TimestampAuthority interface {
+	Timestamp(ctx context.Context, message []byte) ([]byte, error)
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+// Signature is the result of signing transaction data with a device.
+type Signature struct {
+	Counter    int
+	Signature  []byte
+	SignedData string
+	// Timestamp is the server time the signature counter has been allocated at.
+	Timestamp time.Time
+	// Envelope holds the serialized JWS or COSE_Sign1 message, it is empty for raw signatures.
+	Envelope []byte
+}
+
+// Service creates the chained signatures of signature devices.
+// Signing is serialized per device, so every signature counter is used exactly once
+// and every signature links to its predecessor.
+type Service struct {
+	storage persistence.Storage
+	locks   keyedMutex
+	metrics *metrics.SigningMetrics
+	clock   Clock
+
+	payloadVersion domain.PayloadVersion
+
+	timestampAuthority TimestampAuthority
+	timestampRoots     *x509.CertPool
+
+	algorithms []domain.CryptoAlgorithmType
+	keyOptions []crypto.KeyOption
+
+	testKeysMutex sync.Mutex
+	testKeys      map[domain.CryptoAlgorithmType]*testKeyPair
+
+	// drainMutex guards draining, so no operation starts after Shutdown has begun to wait.
+	drainMutex sync.Mutex
+	draining   bool
+	inFlight   sync.WaitGroup
+}
+
+// Option configures optional behaviour of a Service.
+type Option func(*Service)
+
+// WithMetrics instruments the signers of the Service.
+func WithMetrics(signingMetrics *metrics.SigningMetrics) Option {
+	return func(s *Service) {
+		s.metrics = signingMetrics
+	}
+}
+
+// WithAlgorithms restricts the algorithms new devices can be created with, by default all are enabled.
+func WithAlgorithms(algorithms ...domain.CryptoAlgorithmType) Option {
+	return func(s *Service) {
+		s.algorithms = algorithms
+	}
+}
+
+// WithKeyOptions sets the parameters of the key pairs generated for new devices.
+func WithKeyOptions(options ...crypto.KeyOption) Option {
+	return func(s *Service) {
+		s.keyOptions = options
+	}
+}
+
+// WithClock replaces the system clock the signature timestamps are taken from.
+func WithClock(clock Clock) Option {
+	return func(s *Service) {
+		s.clock = clock
+	}
+}
+
+// WithPayloadVersion sets the layout of the signed data of new signatures, by default v1.
+func WithPayloadVersion(version domain.PayloadVersion) Option {
+	return func(s *Service) {
+		s.payloadVersion = version
+	}
+}
+
+// WithTimestampAuthority timestamps every signature, or the last one of a batch, with the
+// authority once it has been stored. The tokens are audited against roots, nil roots stand for the system roots.
+func WithTimestampAuthority(authority TimestampAuthority, roots *x509.CertPool) Option {
+	return func(s *Service) {
+		s.timestampAuthority = authority
+		s.timestampRoots = roots
+	}
+}
+
+// NewService is a factory to instantiate a new Service.
+func NewService(storage persistence.Storage, options ...Option) *Service {
+	service := &Service{
+		storage:        storage,
+		clock:          systemClock{},
+		payloadVersion: domain.PayloadV1,
+		algorithms:     domain.CryptoAlgorithms,
+		testKeys:       make(map[domain.CryptoAlgorithmType]*testKeyPair),
+	}
+	for _, option := range options {
+		option(service)
+	}
+	return service
+}
+
+// Algorithms returns the algorithms new devices can be created with.
+func (s *Service) Algorithms() []domain.CryptoAlgorithmType {
+	return s.algorithms
+}
+
+// Begin registers an operation that writes to the storage and returns the function that
+// completes it. It fails once the Service is shutting down.
+func (s *Service) Begin() (func(), error) {
+	s.drainMutex.Lock()
+	defer s.drainMutex.Unlock()
+	if s.draining {
+		return nil, ErrShuttingDown
+	}
+	s.inFlight.Add(1)
+	return s.inFlight.Done, nil
+}
+
+// Shutdown rejects new signings and device creations with ErrShuttingDown and waits until
+// those in flight have been stored, or until ctx is done. The storage can be closed afterwards.
+func (s *Service) Shutdown(ctx context.Context) error {
+	s.drainMutex.Lock()
+	s.draining = true
+	s.drainMutex.Unlock()
+
+	drained := make(chan struct{})
+	go func() {
+		s.inFlight.Wait()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// SecuredData extends the transaction data with the signature counter and the last signature:
+// <signature_counter>_<data_to_be_signed>_<last_signature_base64_encoded>
+func SecuredData(counter int, data string, lastSignature string) string {
+	return fmt.Sprintf("%d_%s_%s", counter, data, lastSignature)
+}
+
+// SecuredDataV2 additionally puts the signature timestamp behind the signature counter:
+// <signature_counter>_<timestamp>_<data_to_be_signed>_<last_signature_base64_encoded>
+func SecuredDataV2(counter int, timestamp time.Time, data string, lastSignature string) string {
+	return fmt.Sprintf("%d_%s_%s_%s", counter, timestamp.UTC().Format(domain.TimestampLayout), data, lastSignature)
+}
+
+// ValidateFormat checks that signatures can be created in the given format.
+// The empty format stands for raw signatures.
+func ValidateFormat(format domain.SignatureFormat) error {
+	switch format {
+	case "", domain.FormatRaw, domain.FormatJWS, domain.FormatCOSESign1:
+		return nil
+	default:
+		return ErrUnsupportedFormat
+	}
+}
+
+// CreateDevice creates a signature device with a new key pair for the given algorithm.
+func (s *Service) CreateDevice(ctx context.Context, userId string, algorithm domain.CryptoAlgorithmType, label string) (*domain.Device, error) {
+	ctx, span := tracing.Tracer().Start(ctx, "Service.CreateDevice")
+	span.SetAttributes(attribute.String("signing.algorithm", string(algorithm)))
+	device, err := s.createDevice(ctx, userId, algorithm, label)
+	tracing.End(span, err)
+	return device, err
+}
+
+func (s *Service) createDevice(ctx context.Context, userId string, algorithm domain.CryptoAlgorithmType, label string) (*domain.Device, error) {
+	return s.addDevice(ctx, userId, algorithm, label, func() ([]byte, []byte, error) {
+		_, span := tracing.Tracer().Start(ctx, "GenerateKeyPair")
+		publicKey, privateKey, err := crypto.GenerateKeyPair(algorithm, s.keyOptions...)
+		tracing.End(span, err)
+		return publicKey, privateKey, err
+	})
+}
+
+// ImportDevice creates a signature device from an existing private key in one of the
+// encodings crypto.ImportPrivateKey accepts. Keys that cannot be imported are reported
+// as ErrInvalidPrivateKey.
+func (s *Service) ImportDevice(ctx context.Context, userId string, algorithm domain.CryptoAlgorithmType, label string, privateKeyBytes []byte, password []byte) (*domain.Device, error) {
+	ctx, span := tracing.Tracer().Start(ctx, "Service.ImportDevice")
+	span.SetAttributes(attribute.String("signing.algorithm", string(algorithm)))
+	device, err := s.addDevice(ctx, userId, algorithm, label, func() ([]byte, []byte, error) {
+		publicKey, privateKey, err := crypto.ImportPrivateKey(algorithm, privateKeyBytes, password)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%w: %w", ErrInvalidPrivateKey, err)
+		}
+		return publicKey, privateKey, nil
+	})
+	tracing.End(span, err)
+	return device, err
+}
+
+// addDevice stores a device of an enabled algorithm with the key pair returned by keys.
+func (s *Service) addDevice(ctx context.Context, userId string, algorithm domain.CryptoAlgorithmType, label string, keys func() ([]byte, []byte, error)) (*domain.Device, error) {
+	if !slices.Contains(s.algorithms, algorithm) && slices.Contains(domain.CryptoAlgorithms, algorithm) {
+		return nil, ErrAlgorithmDisabled
+	}
+	done, err := s.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+	publicKey, privateKey, err := keys()
+	if err != nil {
+		return nil, err
+	}
+	storage := tracing.Storage(ctx, s.storage)
+	deviceId, _ := storage.CreateSignatureDevice(userId, algorithm, label)
+	err = storage.SetDeviceKeys(deviceId, publicKey, privateKey)
+	if err != nil {
+		return nil, err
+	}
+	return storage.GetDevice(deviceId), nil
+}
+
+// lock serializes signing on a device and returns the function that releases the device again.
+func (s *Service) lock(deviceId string) func() {
+	return s.locks.lock(deviceId)
+}
+
+// keyedMutex holds a mutex per key while the key is locked or waited for. Entries are
+// reference counted and removed with their last holder, so the map only grows with the
+// number of keys in use. The zero value is ready to use.
+type keyedMutex struct {
+	mutex   sync.Mutex
+	entries map[string]*keyedMutexEntry
+}
+
+type keyedMutexEntry struct {
+	sync.Mutex
+	holders int
+}
+
+func (m *keyedMutex) lock(key string) func() {
+	m.mutex.Lock()
+	if m.entries == nil {
+		m.entries = make(map[string]*keyedMutexEntry)
+	}
+	entry := m.entries[key]
+	if entry == nil {
+		entry = &keyedMutexEntry{}
+		m.entries[key] = entry
+	}
+	entry.holders++
+	m.mutex.Unlock()
+
+	entry.Lock()
+	return func() {
+		entry.Unlock()
+		m.mutex.Lock()
+		entry.holders--
+		if entry.holders == 0 {
+			delete(m.entries, key)
+		}
+		m.mutex.Unlock()
+	}
+}
+
+// SignTransaction signs transaction data with a device in the requested format.
+// A signature that has been stored but could not be timestamped is returned along with ErrTimestampFailed.
+func (s *Service) SignTransaction(ctx context.Context, deviceId string, data string, format domain.SignatureFormat) (*Signature, error) {
+	signatures, err := s.SignBatch(ctx, deviceId, []string{data}, format)
+	if len(signatures) == 0 {
+		return nil, err
+	}
+	return signatures[0], err
+}
+
+// SignBatch signs an ordered list of transaction data with a device in one segment of its
+// signature chain, so the signatures get consecutive counters. Signatures are committed one
+// by one: if signing fails partway, the signatures created so far are returned along with
+// the error and the counter continues right after the last of them.
+func (s *Service) SignBatch(ctx context.Context, deviceId string, data []string, format domain.SignatureFormat) ([]*Signature, error) {
+	ctx, span := tracing.Tracer().Start(ctx, "Service.SignBatch")
+	span.SetAttributes(
+		attribute.String("signing.device_id", deviceId),
+		attribute.String("signing.format", string(format)),
+		attribute.Int("signing.batch_size", len(data)),
+	)
+	signatures, err := s.signBatch(ctx, deviceId, data, format)
+	tracing.End(span, err)
+	return signatures, err
+}
+
+func (s *Service) signBatch(ctx context.Context, deviceId string, data []string, format domain.SignatureFormat) ([]*Signature, error) {
+	done, err := s.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+
+	storage := tracing.Storage(ctx, s.storage)
+	signatures, err := s.signChain(ctx, storage, deviceId, data, format, nil)
+	if err != nil {
+		return signatures, err
+	}
+	return signatures, s.timestampLast(ctx, storage, deviceId, signatures)
+}
+
+// timestampLast attaches a timestamp token to the last of the signatures. Signatures link to
+// their predecessors, so the token covers all of them. It is requested once the device is
+// released again, so a slow authority does not hold up the other signings of the device.
+func (s *Service) timestampLast(ctx context.Context, storage persistence.Storage, deviceId string, signatures []*Signature) error {
+	if s.timestampAuthority == nil {
+		return nil
+	}
+	last := signatures[len(signatures)-1]
+	token, err := s.timestamp(ctx, last.Signature)
+	if err != nil {
+		return err
+	}
+	return storage.SetSignatureTimestampToken(deviceId, last.Counter, token)
+}
+
+// signChain signs the data items under the device lock. Unless it is nil, prepare is called
+// with the counter and creation time of each signature before the signature is created and
+// stored, a signature is left out if prepare fails.
+func (s *Service) signChain(ctx context.Context, storage persistence.Storage, deviceId string, data []string, format domain.SignatureFormat, prepare func(counter int, timestamp time.Time) error) ([]*Signature, error) {
+	if format == "" {
+		format = domain.FormatRaw
+	}
+	if err := ValidateFormat(format); err != nil {
+		return nil, err
+	}
+	// Unknown devices are turned away before they get a lock.
+	if storage.GetDevice(deviceId) == nil {
+		return nil, ErrDeviceNotFound
+	}
+	_, lockSpan := tracing.Tracer().Start(ctx, "Service.lock")
+	unlock := s.lock(deviceId)
+	lockSpan.End()
+	defer unlock()
+
+	device := storage.GetDevice(deviceId)
+	if device.IsRetired() {
+		return nil, ErrDeviceRetired
+	}
+	// Every signature gets a signer of its own that stores it with the metadata sign has taken.
+	newSigner := func(metadata crypto.SignatureMetadata) (crypto.Signer, error) {
+		signer, err := crypto.NewSigner(device, storage, format,
+			crypto.WithMetadata(metadata),
+			crypto.WithKeyLoadObserver(tracing.KeyLoad(ctx, device.Algorithm)),
+		)
+		if err != nil {
+			return nil, err
+		}
+		if s.metrics != nil {
+			signer = s.metrics.Signer(signer, device.Algorithm)
+		}
+		return tracing.Signer(ctx, signer, device.Algorithm), nil
+	}
+
+	signatures := make([]*Signature, 0, len(data))
+	for _, item := range data {
+		signature, err := s.sign(storage, newSigner, device, item, format, prepare)
+		if err != nil {
+			return signatures, err
+		}
+		signatures = append(signatures, signature)
+	}
+	return signatures, nil
+}
+
+// timestamp requests a timestamp token over a signature.
+func (s *Service) timestamp(ctx context.Context, signature []byte) ([]byte, error) {
+	ctx, span := tracing.Tracer().Start(ctx, "TimestampAuthority.Timestamp")
+	token, err := s.timestampAuthority.Timestamp(ctx, signature)
+	tracing.End(span, err)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrTimestampFailed, err)
+	}
+	return token, nil
+}
+
+// SignStream signs the data items returned by next one at a time until next returns io.EOF.
+// Every signature is handed to emit before the next item is read, so a slow consumer slows
+// down reading. The device is only locked while an item is signed, other callers can sign in between.
+// It returns the number of signatures created.
+func (s *Service) SignStream(ctx context.Context, deviceId string, format domain.SignatureFormat, next func() (string, error), emit func(*Signature) error) (int, error) {
+	count := 0
+	for {
+		data, err := next()
+		if errors.Is(err, io.EOF) {
+			return count, nil
+		}
+		if err != nil {
+			return count, err
+		}
+		signature, err := s.SignTransaction(ctx, deviceId, data, format)
+		if signature == nil {
+			return count, err
+		}
+		// A signature that has been stored is emitted even if it could not be timestamped.
+		count++
+		if emitErr := emit(signature); emitErr != nil {
+			return count, emitErr
+		}
+		if err != nil {
+			return count, err
+		}
+	}
+}
+
+// Verify checks a signature of a device over signed data in the given format.
+// JWS and COSE_Sign1 signing inputs are rebuilt from the counter the signed data starts with.
+func (s *Service) Verify(ctx context.Context, deviceId string, signedData string, signature []byte, format domain.SignatureFormat) (bool, error) {
+	if err := ValidateFormat(format); err != nil {
+		return false, err
+	}
+	device := tracing.Storage(ctx, s.storage).GetDevice(deviceId)
+	if device == nil {
+		return false, ErrDeviceNotFound
+	}
+	message, err := SigningInput(device, signedData, format)
+	if err != nil {
+		return false, err
+	}
+	verifier, err := crypto.NewVerifier(device.Algorithm, device.PublicKey, format)
+	if err != nil {
+		return false, err
+	}
+	err = verifier.Verify(message, signature)
+	if errors.Is(err, crypto.ErrInvalidSignature) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// SigningInput returns the bytes a device signs for the signed data in the given format.
+func SigningInput(device *domain.Device, signedData string, format domain.SignatureFormat) ([]byte, error) {
+	switch format {
+	case domain.FormatJWS, domain.FormatCOSESign1:
+		counterText, _, found := strings.Cut(signedData, "_")
+		counter, err := strconv.Atoi(counterText)
+		if !found || err != nil {
+			return nil, ErrInvalidSignedData
+		}
+		if format == domain.FormatJWS {
+			_, signingInput, err := crypto.JWSSigningInput(device, counter, []byte(signedData))
+			return signingInput, err
+		}
+		_, toBeSigned, err := crypto.COSESign1Input(device, counter, []byte(signedData))
+		return toBeSigned, err
+	default:
+		return []byte(signedData), nil
+	}
+}
+
+// sign creates the next signature in the chain of a device. The caller must hold the device lock.
+// The timestamp is taken along with the counter and never goes back behind the previous one,
+// even when the clock does.
+func (s *Service) sign(storage persistence.Storage, newSigner func(crypto.SignatureMetadata) (crypto.Signer, error), device *domain.Device, data string, format domain.SignatureFormat, prepare func(int, time.Time) error) (*Signature, error) {
+	counter := storage.GetDeviceSignaturesCount(device.Id)
+	previous, err := s.previousSignature(storage, device, counter)
+	if err != nil {
+		return nil, err
+	}
+	lastSignature := base64.StdEncoding.EncodeToString([]byte(device.Id))
+	timestamp := s.clock.Now().UTC().Truncate(time.Microsecond)
+	if previous != nil {
+		lastSignature = base64.StdEncoding.EncodeToString(previous.SignedData)
+		if timestamp.Before(previous.CreatedAt) {
+			timestamp = previous.CreatedAt
+		}
+	}
+	signer, err := newSigner(crypto.SignatureMetadata{CreatedAt: timestamp, PayloadVersion: s.payloadVersion})
+	if err != nil {
+		return nil, err
+	}
+	if prepare != nil {
+		if err := prepare(counter, timestamp); err != nil {
+			return nil, err
+		}
+	}
+
+	securedData := SecuredData(counter, data, lastSignature)
+	if s.payloadVersion == domain.PayloadV2 {
+		securedData = SecuredDataV2(counter, timestamp, data, lastSignature)
+	}
+
+	var signature *Signature
+	switch format {
+	case domain.FormatJWS:
+		signature, err = s.signJWS(signer, device, counter, securedData)
+	case domain.FormatCOSESign1:
+		signature, err = s.signCOSE(signer, device, counter, securedData)
+	default:
+		var signed []byte
+		signed, err = signer.Sign([]byte(securedData))
+		signature = &Signature{
+			Counter:    counter,
+			Signature:  signed,
+			SignedData: securedData,
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	signature.Timestamp = timestamp
+	return signature, nil
+}
+
+// previousSignature returns the signature the next signature links to.
+// The first signature of a device has none and links to the device id instead.
+func (s *Service) previousSignature(storage persistence.Storage, device *domain.Device, counter int) (*domain.Signature, error) {
+	if counter == 0 {
+		return nil, nil
+	}
+	lastSignature, err := storage.GetLastDeviceSignature(device.Id)
+	if err != nil || lastSignature.Id != counter-1 {
+		return nil, ErrChainInconsistency
+	}
+	return lastSignature, nil
+}
+
+func (s *Service) signJWS(signer crypto.Signer, device *domain.Device, counter int, securedData string) (*Signature, error) {
+	encodedHeader, signingInput, err := crypto.JWSSigningInput(device, counter, []byte(securedData))
+	if err != nil {
+		return nil, err
+	}
+	signature, err := signer.Sign(signingInput)
+	if err != nil {
+		return nil, err
+	}
+	joseSignature, err := s.joseSignature(device, signature)
+	if err != nil {
+		return nil, err
+	}
+	return &Signature{
+		Counter:    counter,
+		Signature:  signature,
+		SignedData: securedData,
+		Envelope:   []byte(crypto.JWSCompactDetached(encodedHeader, joseSignature)),
+	}, nil
+}
+
+func (s *Service) signCOSE(signer crypto.Signer, device *domain.Device, counter int, securedData string) (*Signature, error) {
+	protected, toBeSigned, err := crypto.COSESign1Input(device, counter, []byte(securedData))
+	if err != nil {
+		return nil, err
+	}
+	signature, err := signer.Sign(toBeSigned)
+	if err != nil {
+		return nil, err
+	}
+	joseSignature, err := s.joseSignature(device, signature)
+	if err != nil {
+		return nil, err
+	}
+	return &Signature{
+		Counter:    counter,
+		Signature:  signature,
+		SignedData: securedData,
+		Envelope:   crypto.COSESign1Detached(protected, joseSignature),
+	}, nil
+}
+
+func (s *Service) joseSignature(device *domain.Device, signature []byte) ([]byte, error) {
+	publicKey, err := crypto.ParsePublicKey(device.Algorithm, device.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	return crypto.JOSESignature(publicKey, signature)
+}
@@ -0,0 +1,308 @@
+package signing
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/assert"
+	signingcrypto "github.com/DrMonez/coding-challenges/signing-service-challenge/crypto"
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/domain"
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/persistence"
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/tsa"
+	"math/big"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+var storage = persistence.NewLocalStorage()
+
+var service = NewService(storage)
+
+func newTestDevice(algorithm domain.CryptoAlgorithmType) *domain.Device {
+	deviceId, _ := storage.CreateSignatureDevice("test", algorithm, "")
+	publicKey, privateKey, _ := signingcrypto.GenerateKeyPair(algorithm)
+	storage.SetDeviceKeys(deviceId, publicKey, privateKey)
+	return storage.GetDevice(deviceId)
+}
+
+func TestService_SignTransactionChainsSignatures(t *testing.T) {
+	device := newTestDevice(domain.ECC)
+	first, err := service.SignTransaction(context.Background(), device.Id, "first", domain.FormatRaw)
+	assert.ShouldBe(t, err, nil)
+	assert.ShouldBe(t, first.SignedData, "0_first_"+base64.StdEncoding.EncodeToString([]byte(device.Id)))
+
+	second, _ := service.SignTransaction(context.Background(), device.Id, "second", "")
+	assert.ShouldBe(t, second.Counter, 1)
+	assert.ShouldBe(t, second.SignedData, "1_second_"+base64.StdEncoding.EncodeToString(first.Signature))
+	assert.ShouldBe(t, storage.GetDeviceSignaturesCount(device.Id), 2)
+
+	publicKey, _ := signingcrypto.ParsePublicKey(device.Algorithm, device.PublicKey)
+	isValid := ecdsa.VerifyASN1(publicKey.(*ecdsa.PublicKey), signingcrypto.GetSha256Hash([]byte(second.SignedData)), second.Signature)
+	assert.ShouldBe(t, isValid, true)
+}
+
+func TestService_SignTransactionConcurrently(t *testing.T) {
+	device := newTestDevice(domain.ECC)
+	var wait sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wait.Add(1)
+		go func(i int) {
+			defer wait.Done()
+			service.SignTransaction(context.Background(), device.Id, fmt.Sprint(i), domain.FormatRaw)
+		}(i)
+	}
+	wait.Wait()
+
+	assert.ShouldBe(t, storage.GetDeviceSignaturesCount(device.Id), 50)
+	lastSignature := base64.StdEncoding.EncodeToString([]byte(device.Id))
+	for counter := 0; counter < 50; counter++ {
+		signature := storage.Signatures[device.Id][counter]
+		assert.ShouldBe(t, signature.Id, counter)
+		assert.ShouldBe(t, strings.HasPrefix(string(signature.Data), fmt.Sprintf("%d_", counter)), true)
+		assert.ShouldBe(t, strings.HasSuffix(string(signature.Data), "_"+lastSignature), true)
+		lastSignature = base64.StdEncoding.EncodeToString(signature.SignedData)
+	}
+}
+
+func TestService_SignTransactionAsJWS(t *testing.T) {
+	device := newTestDevice(domain.ECC)
+	service.SignTransaction(context.Background(), device.Id, "first", domain.FormatRaw)
+	signature, err := service.SignTransaction(context.Background(), device.Id, "second", domain.FormatJWS)
+	assert.ShouldBe(t, err, nil)
+
+	parts := strings.Split(string(signature.Envelope), ".")
+	assert.ShouldBe(t, len(parts), 3)
+	assert.ShouldBe(t, parts[1], "")
+	headerBytes, _ := base64.RawURLEncoding.DecodeString(parts[0])
+	var header signingcrypto.JWSHeader
+	json.Unmarshal(headerBytes, &header)
+	assert.ShouldBe(t, header, signingcrypto.JWSHeader{Algorithm: "ES384", KeyId: device.Id, Counter: 1})
+
+	rawSignature, _ := base64.RawURLEncoding.DecodeString(parts[2])
+	assert.ShouldBe(t, len(rawSignature), 96)
+	signingInput := parts[0] + "." + base64.RawURLEncoding.EncodeToString([]byte(signature.SignedData))
+	publicKey, _ := signingcrypto.ParsePublicKey(device.Algorithm, device.PublicKey)
+	isValid := ecdsa.Verify(
+		publicKey.(*ecdsa.PublicKey),
+		signingcrypto.GetHash(crypto.SHA384, []byte(signingInput)),
+		new(big.Int).SetBytes(rawSignature[:48]),
+		new(big.Int).SetBytes(rawSignature[48:]),
+	)
+	assert.ShouldBe(t, isValid, true)
+}
+
+func TestService_SignTransactionAsCOSESign1(t *testing.T) {
+	device := newTestDevice(domain.RSA)
+	signature, err := service.SignTransaction(context.Background(), device.Id, "data", domain.FormatCOSESign1)
+	assert.ShouldBe(t, err, nil)
+
+	// Tag 18 followed by an array of 4 items.
+	assert.ShouldBe(t, signature.Envelope[0], byte(0xd2))
+	assert.ShouldBe(t, signature.Envelope[1], byte(0x84))
+	protected, toBeSigned, _ := signingcrypto.COSESign1Input(device, 0, []byte(signature.SignedData))
+	assert.ShouldBe(t, strings.Contains(string(signature.Envelope), string(protected)), true)
+
+	publicKey, _ := signingcrypto.ParsePublicKey(device.Algorithm, device.PublicKey)
+	err = rsa.VerifyPKCS1v15(publicKey.(*rsa.PublicKey), crypto.SHA256, signingcrypto.GetSha256Hash(toBeSigned), signature.Signature)
+	assert.ShouldBe(t, err, nil)
+}
+
+func TestService_SignTransactionErrors(t *testing.T) {
+	_, err := service.SignTransaction(context.Background(), "missing", "data", domain.FormatRaw)
+	assert.ShouldBe(t, err, ErrDeviceNotFound)
+
+	device := newTestDevice(domain.RSA)
+	_, err = service.SignTransaction(context.Background(), device.Id, "data", "pdf")
+	assert.ShouldBe(t, err, ErrUnsupportedFormat)
+}
+
+func TestService_SignBatch(t *testing.T) {
+	device := newTestDevice(domain.RSA)
+	service.SignTransaction(context.Background(), device.Id, "before", domain.FormatRaw)
+	signatures, err := service.SignBatch(context.Background(), device.Id, []string{"a", "b", "c"}, domain.FormatJWS)
+	assert.ShouldBe(t, err, nil)
+	assert.ShouldBe(t, len(signatures), 3)
+	for i, signature := range signatures {
+		assert.ShouldBe(t, signature.Counter, i+1)
+		assert.ShouldBe(t, storage.Signatures[device.Id][i+1].Format, domain.FormatJWS)
+	}
+	assert.ShouldBe(t, signatures[2].SignedData, "3_c_"+base64.StdEncoding.EncodeToString(signatures[1].Signature))
+	assert.ShouldBe(t, storage.GetDeviceSignaturesCount(device.Id), 4)
+}
+
+func TestService_SignBatchStopsWithoutGaps(t *testing.T) {
+	device := newTestDevice(domain.ECC)
+	service.SignBatch(context.Background(), device.Id, []string{"a", "b"}, domain.FormatRaw)
+	// Corrupting the key makes every following signature fail.
+	storage.SetDeviceKeys(device.Id, device.PublicKey, []byte("corrupt"))
+	signatures, err := service.SignBatch(context.Background(), device.Id, []string{"c", "d"}, domain.FormatRaw)
+	assert.ShouldNotBe(t, err, nil)
+	assert.ShouldBe(t, len(signatures), 0)
+	assert.ShouldBe(t, storage.GetDeviceSignaturesCount(device.Id), 2)
+	assert.ShouldBe(t, len(storage.Signatures[device.Id]), 2)
+}
+
+// fakeClock returns the given times one after another.
+type fakeClock struct {
+	times []time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	now := c.times[0]
+	c.times = c.times[1:]
+	return now
+}
+
+func TestService_SignTransactionTimestampsNeverGoBackwards(t *testing.T) {
+	start := time.Date(2026, 3, 1, 12, 0, 0, 1500, time.FixedZone("CET", 3600))
+	clock := &fakeClock{times: []time.Time{start, start.Add(-time.Minute), start.Add(time.Second)}}
+	service := NewService(storage, WithClock(clock))
+	device := newTestDevice(domain.ECC)
+
+	signatures, err := service.SignBatch(context.Background(), device.Id, []string{"a", "b", "c"}, domain.FormatRaw)
+	assert.ShouldBe(t, err, nil)
+	assert.ShouldBe(t, signatures[0].Timestamp, time.Date(2026, 3, 1, 11, 0, 0, 1000, time.UTC))
+	assert.ShouldBe(t, signatures[1].Timestamp, signatures[0].Timestamp)
+	assert.ShouldBe(t, signatures[2].Timestamp, time.Date(2026, 3, 1, 11, 0, 1, 1000, time.UTC))
+	for _, signature := range signatures {
+		assert.ShouldBe(t, storage.Signatures[device.Id][signature.Counter].CreatedAt, signature.Timestamp)
+		assert.ShouldBe(t, storage.Signatures[device.Id][signature.Counter].PayloadVersion, domain.PayloadV1)
+	}
+}
+
+func TestService_SignTransactionWithPayloadV2(t *testing.T) {
+	service := NewService(storage, WithClock(&fakeClock{times: []time.Time{time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)}}), WithPayloadVersion(domain.PayloadV2))
+	device := newTestDevice(domain.ECC)
+
+	signature, err := service.SignTransaction(context.Background(), device.Id, "data", domain.FormatJWS)
+	assert.ShouldBe(t, err, nil)
+	assert.ShouldBe(t, signature.SignedData, "0_2026-03-01T12:00:00.000000Z_data_"+base64.StdEncoding.EncodeToString([]byte(device.Id)))
+	assert.ShouldBe(t, storage.Signatures[device.Id][0].PayloadVersion, domain.PayloadV2)
+	isValid, err := service.Verify(context.Background(), device.Id, signature.SignedData, signature.Signature, domain.FormatJWS)
+	assert.ShouldBe(t, err, nil)
+	assert.ShouldBe(t, isValid, true)
+}
+
+// failingAuthority never issues a timestamp token.
+type failingAuthority struct{}
+
+func (failingAuthority) Timestamp(context.Context, []byte) ([]byte, error) {
+	return nil, errors.New("connection refused")
+}
+
+func TestService_SignBatchTimestampsLastSignature(t *testing.T) {
+	authority, err := tsa.NewLocalAuthority(time.Hour)
+	assert.ShouldBe(t, err, nil)
+	service := NewService(storage, WithTimestampAuthority(authority, authority.Roots()))
+	device := newTestDevice(domain.RSA)
+
+	signatures, err := service.SignBatch(context.Background(), device.Id, []string{"a", "b"}, domain.FormatRaw)
+	assert.ShouldBe(t, err, nil)
+	assert.ShouldBe(t, len(storage.Signatures[device.Id][0].TimestampToken), 0)
+	token := storage.Signatures[device.Id][1].TimestampToken
+	_, err = tsa.Verify(token, signatures[1].Signature, authority.Roots())
+	assert.ShouldBe(t, err, nil)
+}
+
+func TestService_SignTransactionWithoutTimestamp(t *testing.T) {
+	service := NewService(storage, WithTimestampAuthority(failingAuthority{}, nil))
+	device := newTestDevice(domain.ECC)
+
+	// The signature is stored before the token is requested, it stays without one.
+	signature, err := service.SignTransaction(context.Background(), device.Id, "data", domain.FormatRaw)
+	assert.ShouldBe(t, errors.Is(err, ErrTimestampFailed), true)
+	assert.ShouldBe(t, err.Error(), "timestamp authority did not issue a token: connection refused")
+	assert.ShouldBe(t, signature.Counter, 0)
+	assert.ShouldBe(t, storage.GetDeviceSignaturesCount(device.Id), 1)
+	assert.ShouldBe(t, len(storage.Signatures[device.Id][0].TimestampToken), 0)
+}
+
+func TestService_Verify(t *testing.T) {
+	device := newTestDevice(domain.ECC)
+	for _, format := range []domain.SignatureFormat{domain.FormatRaw, domain.FormatJWS, domain.FormatCOSESign1} {
+		signature, _ := service.SignTransaction(context.Background(), device.Id, "data", format)
+		isValid, err := service.Verify(context.Background(), device.Id, signature.SignedData, signature.Signature, format)
+		assert.ShouldBe(t, err, nil)
+		assert.ShouldBe(t, isValid, true)
+
+		isValid, _ = service.Verify(context.Background(), device.Id, signature.SignedData+"x", signature.Signature, format)
+		assert.ShouldBe(t, isValid, false)
+	}
+}
+
+func TestService_SelfTest(t *testing.T) {
+	service := NewService(storage)
+	for _, algorithm := range domain.CryptoAlgorithms {
+		assert.ShouldBe(t, service.SelfTest(context.Background(), algorithm), nil)
+		// The second run reuses the key pair of the first.
+		assert.ShouldBe(t, service.SelfTest(context.Background(), algorithm), nil)
+	}
+	assert.ShouldNotBe(t, service.SelfTest(context.Background(), "DSA"), nil)
+}
+
+func TestService_CreateDeviceWithDisabledAlgorithm(t *testing.T) {
+	service := NewService(storage, WithAlgorithms(domain.ECC))
+	_, err := service.CreateDevice(context.Background(), "test", domain.RSA, "")
+	assert.ShouldBe(t, err, ErrAlgorithmDisabled)
+	device, err := service.CreateDevice(context.Background(), "test", domain.ECC, "")
+	assert.ShouldBe(t, err, nil)
+	assert.ShouldBe(t, device.Algorithm, domain.CryptoAlgorithmType(domain.ECC))
+}
+
+func TestService_ReleasesDeviceLocks(t *testing.T) {
+	service := NewService(storage)
+	device := newTestDevice(domain.ECC)
+	_, err := service.SignTransaction(context.Background(), "unknown", "data", domain.FormatRaw)
+	assert.ShouldBe(t, err, ErrDeviceNotFound)
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			service.SignTransaction(context.Background(), device.Id, "data", domain.FormatRaw)
+		}()
+	}
+	wg.Wait()
+	assert.ShouldBe(t, len(service.locks.entries), 0)
+}
+
+func TestService_ImportDevice(t *testing.T) {
+	service := NewService(storage, WithAlgorithms(domain.ECC))
+	source := newTestDevice(domain.RSA)
+	_, err := service.ImportDevice(context.Background(), "", domain.RSA, "", source.PrivateKey, nil)
+	assert.ShouldBe(t, err, ErrAlgorithmDisabled)
+	_, err = service.ImportDevice(context.Background(), "", domain.ECC, "", []byte("not a key"), nil)
+	assert.ShouldBe(t, errors.Is(err, ErrInvalidPrivateKey), true)
+
+	source = newTestDevice(domain.ECC)
+	device, err := service.ImportDevice(context.Background(), "", domain.ECC, "imported", source.PrivateKey, nil)
+	assert.ShouldBe(t, err, nil)
+	assert.ShouldBe(t, device.Label, "imported")
+	assert.ShouldBe(t, string(device.PublicKey), string(source.PublicKey))
+}
+
+func TestService_ShutdownDrainsInFlightSignings(t *testing.T) {
+	service := NewService(storage)
+	device := newTestDevice(domain.ECC)
+	// An operation that has begun but not yet stored its signature.
+	done, err := service.Begin()
+	assert.ShouldBe(t, err, nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.ShouldBe(t, service.Shutdown(ctx), context.Canceled)
+	_, err = service.SignTransaction(context.Background(), device.Id, "late", domain.FormatRaw)
+	assert.ShouldBe(t, err, ErrShuttingDown)
+	_, err = service.CreateDevice(context.Background(), "test", domain.ECC, "")
+	assert.ShouldBe(t, err, ErrShuttingDown)
+
+	done()
+	assert.ShouldBe(t, service.Shutdown(context.Background()), nil)
+}
@@ -0,0 +1,287 @@
+package signing
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/domain"
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/persistence"
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"time"
+)
+
+var (
+	ErrTransactionNotFound = errors.New("transaction not found")
+	ErrTransactionFinished = errors.New("transaction is already finished")
+)
+
+// TransactionStep is a transaction along with the signature of its latest step.
+type TransactionStep struct {
+	Transaction *domain.Transaction
+	Signature   *Signature
+}
+
+// TransactionData is the data signed for a step of a transaction, encoded as JSON.
+type TransactionData struct {
+	Operation         domain.TransactionOperation `json:"operation"`
+	TransactionNumber int                         `json:"transaction_number"`
+	Revision          int                         `json:"revision"`
+	ProcessType       string                      `json:"process_type"`
+	ProcessData       string                      `json:"process_data"`
+}
+
+func (d TransactionData) String() string {
+	// Strings and integers always encode.
+	data, _ := json.Marshal(d)
+	return string(data)
+}
+
+// TransactionError is the failure of a step of a transaction.
+type TransactionError struct {
+	Number int
+	Err    error
+}
+
+func (e *TransactionError) Error() string {
+	return fmt.Sprintf("transaction %d: %v", e.Number, e.Err)
+}
+
+func (e *TransactionError) Unwrap() error {
+	return e.Err
+}
+
+// lockTransactions serializes the transaction steps of a device. It is taken before the
+// device lock, which every step takes on its own while it signs and stores the transaction.
+// Unknown devices are turned away before they get a lock.
+func (s *Service) lockTransactions(storage persistence.Storage, deviceId string) (func(), error) {
+	if storage.GetDevice(deviceId) == nil {
+		return nil, ErrDeviceNotFound
+	}
+	return s.lock("transactions/" + deviceId), nil
+}
+
+// StartTransaction starts the next transaction of a device and signs its start.
+func (s *Service) StartTransaction(ctx context.Context, deviceId string, processType string, processData string, format domain.SignatureFormat) (*TransactionStep, error) {
+	ctx, span := tracing.Tracer().Start(ctx, "Service.StartTransaction")
+	span.SetAttributes(attribute.String("signing.device_id", deviceId))
+	step, err := s.startTransaction(ctx, deviceId, processType, processData, format)
+	tracing.End(span, err)
+	return step, err
+}
+
+func (s *Service) startTransaction(ctx context.Context, deviceId string, processType string, processData string, format domain.SignatureFormat) (*TransactionStep, error) {
+	storage := tracing.Storage(ctx, s.storage)
+	unlock, err := s.lockTransactions(storage, deviceId)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	transactions, err := storage.ListTransactions(deviceId)
+	if err != nil {
+		return nil, err
+	}
+	// Numbers are taken by stored transactions, which are stored before their start is signed.
+	number := 1
+	if len(transactions) > 0 {
+		number = transactions[len(transactions)-1].Number + 1
+	}
+	transaction := &domain.Transaction{
+		DeviceId:    deviceId,
+		Number:      number,
+		State:       domain.TransactionActive,
+		ProcessType: processType,
+		ProcessData: processData,
+	}
+	return s.signStep(ctx, storage, transaction, nil, domain.TransactionStart, format)
+}
+
+// UpdateTransaction replaces the process data of an open transaction and signs the update.
+// An empty process type keeps the current one.
+func (s *Service) UpdateTransaction(ctx context.Context, deviceId string, number int, processType string, processData string, format domain.SignatureFormat) (*TransactionStep, error) {
+	return s.continueTransaction(ctx, "Service.UpdateTransaction", deviceId, number, domain.TransactionUpdate, processType, processData, format)
+}
+
+// FinishTransaction signs the final process data of an open transaction and finishes it.
+// An empty process type keeps the current one.
+func (s *Service) FinishTransaction(ctx context.Context, deviceId string, number int, processType string, processData string, format domain.SignatureFormat) (*TransactionStep, error) {
+	return s.continueTransaction(ctx, "Service.FinishTransaction", deviceId, number, domain.TransactionFinish, processType, processData, format)
+}
+
+func (s *Service) continueTransaction(ctx context.Context, name string, deviceId string, number int, operation domain.TransactionOperation, processType string, processData string, format domain.SignatureFormat) (*TransactionStep, error) {
+	ctx, span := tracing.Tracer().Start(ctx, name)
+	span.SetAttributes(
+		attribute.String("signing.device_id", deviceId),
+		attribute.Int("signing.transaction_number", number),
+	)
+	step, err := s.continueOpenTransaction(ctx, deviceId, number, operation, processType, processData, format)
+	tracing.End(span, err)
+	return step, err
+}
+
+func (s *Service) continueOpenTransaction(ctx context.Context, deviceId string, number int, operation domain.TransactionOperation, processType string, processData string, format domain.SignatureFormat) (*TransactionStep, error) {
+	storage := tracing.Storage(ctx, s.storage)
+	unlock, err := s.lockTransactions(storage, deviceId)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	transaction, err := s.openTransaction(storage, deviceId, number)
+	if err != nil {
+		return nil, err
+	}
+	stored := transaction.Clone()
+	if processType != "" {
+		transaction.ProcessType = processType
+	}
+	transaction.ProcessData = processData
+	return s.signStep(ctx, storage, transaction, stored, operation, format)
+}
+
+// openTransaction loads a transaction that still takes updates.
+func (s *Service) openTransaction(storage persistence.Storage, deviceId string, number int) (*domain.Transaction, error) {
+	if storage.GetDevice(deviceId) == nil {
+		return nil, ErrDeviceNotFound
+	}
+	transaction, err := storage.GetTransaction(deviceId, number)
+	if err != nil {
+		return nil, ErrTransactionNotFound
+	}
+	if !transaction.IsOpen() {
+		return nil, ErrTransactionFinished
+	}
+	return transaction, nil
+}
+
+// signStep signs a step of a transaction and stores the transaction as of that step. The
+// transaction is stored under the device lock before the signature, so a signature always
+// belongs to a stored step, and the step is complete before the next signature and before a
+// shutdown. If the signature cannot be created, the transaction is restored to stored, or
+// deleted if stored is nil because the step starts it. The caller must hold the transaction
+// lock of the device. A step that could not be timestamped is returned along with ErrTimestampFailed.
+func (s *Service) signStep(ctx context.Context, storage persistence.Storage, transaction *domain.Transaction, stored *domain.Transaction, operation domain.TransactionOperation, format domain.SignatureFormat) (*TransactionStep, error) {
+	data := TransactionData{
+		Operation:         operation,
+		TransactionNumber: transaction.Number,
+		Revision:          transaction.Revision() + 1,
+		ProcessType:       transaction.ProcessType,
+		ProcessData:       transaction.ProcessData,
+	}
+	done, err := s.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+
+	saved := false
+	signatures, err := s.signChain(ctx, storage, transaction.DeviceId, []string{data.String()}, format, func(counter int, timestamp time.Time) error {
+		transaction.SignatureCounters = append(transaction.SignatureCounters, counter)
+		transaction.UpdatedAt = timestamp
+		switch operation {
+		case domain.TransactionStart:
+			transaction.StartedAt = timestamp
+		case domain.TransactionFinish, domain.TransactionForceFinish:
+			transaction.State = domain.TransactionFinished
+			transaction.FinishedAt = timestamp
+			transaction.ForceClosed = operation == domain.TransactionForceFinish
+		}
+		if err := storage.SaveTransaction(*transaction); err != nil {
+			return err
+		}
+		saved = true
+		return nil
+	})
+	if err != nil {
+		if saved {
+			err = errors.Join(err, s.restoreTransaction(storage, transaction, stored))
+		}
+		return nil, err
+	}
+	step := &TransactionStep{Transaction: transaction, Signature: signatures[0]}
+	return step, s.timestampLast(ctx, storage, transaction.DeviceId, signatures)
+}
+
+// restoreTransaction undoes a step whose signature could not be created.
+func (s *Service) restoreTransaction(storage persistence.Storage, transaction *domain.Transaction, stored *domain.Transaction) error {
+	if stored == nil {
+		return storage.DeleteTransaction(transaction.DeviceId, transaction.Number)
+	}
+	return storage.SaveTransaction(*stored)
+}
+
+// Transaction returns a transaction of a device.
+func (s *Service) Transaction(ctx context.Context, deviceId string, number int) (*domain.Transaction, error) {
+	storage := tracing.Storage(ctx, s.storage)
+	if storage.GetDevice(deviceId) == nil {
+		return nil, ErrDeviceNotFound
+	}
+	transaction, err := storage.GetTransaction(deviceId, number)
+	if err != nil {
+		return nil, ErrTransactionNotFound
+	}
+	return transaction, nil
+}
+
+// OpenTransactions returns the transactions of a device that have not been finished, ordered by number.
+func (s *Service) OpenTransactions(ctx context.Context, deviceId string) ([]*domain.Transaction, error) {
+	storage := tracing.Storage(ctx, s.storage)
+	if storage.GetDevice(deviceId) == nil {
+		return nil, ErrDeviceNotFound
+	}
+	transactions, err := storage.ListTransactions(deviceId)
+	if err != nil {
+		return nil, err
+	}
+	open := make([]*domain.Transaction, 0)
+	for _, transaction := range transactions {
+		if transaction.IsOpen() {
+			open = append(open, transaction)
+		}
+	}
+	return open, nil
+}
+
+// CloseStaleTransactions force-finishes the open transactions of a device that have not been
+// updated for maxIdle. Each gets a signed force_finish step with its last process data.
+// The transactions that could not be closed are reported as TransactionErrors, joined into
+// the error returned along with the steps of the others.
+func (s *Service) CloseStaleTransactions(ctx context.Context, deviceId string, maxIdle time.Duration) ([]*TransactionStep, error) {
+	ctx, span := tracing.Tracer().Start(ctx, "Service.CloseStaleTransactions")
+	span.SetAttributes(attribute.String("signing.device_id", deviceId))
+	steps, err := s.closeStaleTransactions(ctx, deviceId, maxIdle)
+	tracing.End(span, err)
+	return steps, err
+}
+
+func (s *Service) closeStaleTransactions(ctx context.Context, deviceId string, maxIdle time.Duration) ([]*TransactionStep, error) {
+	storage := tracing.Storage(ctx, s.storage)
+	unlock, err := s.lockTransactions(storage, deviceId)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	transactions, err := storage.ListTransactions(deviceId)
+	if err != nil {
+		return nil, err
+	}
+	now := s.clock.Now()
+	steps := make([]*TransactionStep, 0)
+	var errs []error
+	for _, transaction := range transactions {
+		if !transaction.IsOpen() || now.Sub(transaction.UpdatedAt) < maxIdle {
+			continue
+		}
+		step, err := s.signStep(ctx, storage, transaction, transaction.Clone(), domain.TransactionForceFinish, domain.FormatRaw)
+		if step != nil {
+			steps = append(steps, step)
+		}
+		if err != nil {
+			errs = append(errs, &TransactionError{Number: transaction.Number, Err: err})
+		}
+	}
+	return steps, errors.Join(errs...)
+}
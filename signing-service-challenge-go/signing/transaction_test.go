@@ -0,0 +1,236 @@
+package signing
+
+import (
+	"context"
+	"errors"
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/assert"
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/domain"
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/persistence"
+	"testing"
+	"time"
+)
+
+// manualClock returns the same time until it is advanced.
+type manualClock struct {
+	now time.Time
+}
+
+func (c *manualClock) Now() time.Time {
+	return c.now
+}
+
+func TestService_TransactionLifecycle(t *testing.T) {
+	device := newTestDevice(domain.ECC)
+	ctx := context.Background()
+
+	started, err := service.StartTransaction(ctx, device.Id, "Kassenbeleg-V1", "Beleg^0.00_0.00_0.00_0.00_0.00", domain.FormatRaw)
+	assert.ShouldBe(t, err, nil)
+	assert.ShouldBe(t, started.Transaction.Number, 1)
+	assert.ShouldBe(t, started.Transaction.State, domain.TransactionActive)
+	assert.ShouldBe(t, started.Signature.Counter, 0)
+
+	updated, err := service.UpdateTransaction(ctx, device.Id, 1, "", "Beleg^10.00_0.00_0.00_0.00_0.00", domain.FormatRaw)
+	assert.ShouldBe(t, err, nil)
+	assert.ShouldBe(t, updated.Transaction.ProcessType, "Kassenbeleg-V1")
+	assert.ShouldBe(t, updated.Transaction.Revision(), 2)
+
+	// Signatures outside of the transaction keep sharing the chain.
+	_, err = service.SignTransaction(ctx, device.Id, "receipt", domain.FormatRaw)
+	assert.ShouldBe(t, err, nil)
+
+	finished, err := service.FinishTransaction(ctx, device.Id, 1, "", "Beleg^10.00_0.00_0.00_0.00_0.00", domain.FormatRaw)
+	assert.ShouldBe(t, err, nil)
+	assert.ShouldBe(t, finished.Transaction.State, domain.TransactionFinished)
+	assert.ShouldBe(t, finished.Transaction.ForceClosed, false)
+	assert.ShouldBe(t, finished.Transaction.FinishedAt, finished.Signature.Timestamp)
+	assert.ShouldBe(t, len(finished.Transaction.SignatureCounters), 3)
+	assert.ShouldBe(t, finished.Transaction.SignatureCounters[2], 3)
+
+	stored, err := service.Transaction(ctx, device.Id, 1)
+	assert.ShouldBe(t, err, nil)
+	assert.ShouldBe(t, stored.State, domain.TransactionFinished)
+	assert.ShouldBe(t, stored.StartedAt, started.Signature.Timestamp)
+
+	audit, err := service.AuditChain(ctx, device.Id)
+	assert.ShouldBe(t, err, nil)
+	assert.ShouldBe(t, audit.Valid(), true)
+
+	_, err = service.UpdateTransaction(ctx, device.Id, 1, "", "late", domain.FormatRaw)
+	assert.ShouldBe(t, err, ErrTransactionFinished)
+	_, err = service.FinishTransaction(ctx, device.Id, 2, "", "", domain.FormatRaw)
+	assert.ShouldBe(t, err, ErrTransactionNotFound)
+	_, err = service.StartTransaction(ctx, "unknown", "Kassenbeleg-V1", "", domain.FormatRaw)
+	assert.ShouldBe(t, err, ErrDeviceNotFound)
+
+	next, err := service.StartTransaction(ctx, device.Id, "SonstigerVorgang", "", domain.FormatRaw)
+	assert.ShouldBe(t, err, nil)
+	assert.ShouldBe(t, next.Transaction.Number, 2)
+}
+
+func TestService_TransactionSignsStepData(t *testing.T) {
+	device := newTestDevice(domain.RSA)
+
+	step, err := service.StartTransaction(context.Background(), device.Id, "Kassenbeleg-V1", "Beleg^1.00", domain.FormatRaw)
+	assert.ShouldBe(t, err, nil)
+	signed, err := service.Verify(context.Background(), device.Id, step.Signature.SignedData, step.Signature.Signature, domain.FormatRaw)
+	assert.ShouldBe(t, err, nil)
+	assert.ShouldBe(t, signed, true)
+
+	expected := TransactionData{
+		Operation:         domain.TransactionStart,
+		TransactionNumber: 1,
+		Revision:          1,
+		ProcessType:       "Kassenbeleg-V1",
+		ProcessData:       "Beleg^1.00",
+	}
+	assert.ShouldBe(t, step.Signature.SignedData[:len("0_")+len(expected.String())], "0_"+expected.String())
+}
+
+func TestService_CloseStaleTransactions(t *testing.T) {
+	clock := &manualClock{now: time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)}
+	service := NewService(storage, WithClock(clock))
+	device := newTestDevice(domain.ECC)
+	ctx := context.Background()
+
+	service.StartTransaction(ctx, device.Id, "Kassenbeleg-V1", "stale", domain.FormatRaw)
+	service.StartTransaction(ctx, device.Id, "Kassenbeleg-V1", "finished", domain.FormatRaw)
+	service.FinishTransaction(ctx, device.Id, 2, "", "finished", domain.FormatRaw)
+	clock.now = clock.now.Add(10 * time.Minute)
+	service.StartTransaction(ctx, device.Id, "Kassenbeleg-V1", "fresh", domain.FormatRaw)
+
+	open, err := service.OpenTransactions(ctx, device.Id)
+	assert.ShouldBe(t, err, nil)
+	assert.ShouldBe(t, len(open), 2)
+	assert.ShouldBe(t, open[0].Number, 1)
+	assert.ShouldBe(t, open[1].Number, 3)
+
+	clock.now = clock.now.Add(time.Minute)
+	closed, err := service.CloseStaleTransactions(ctx, device.Id, 5*time.Minute)
+	assert.ShouldBe(t, err, nil)
+	assert.ShouldBe(t, len(closed), 1)
+	assert.ShouldBe(t, closed[0].Transaction.Number, 1)
+	assert.ShouldBe(t, closed[0].Transaction.ForceClosed, true)
+	assert.ShouldBe(t, closed[0].Transaction.ProcessData, "stale")
+	assert.ShouldBe(t, closed[0].Transaction.FinishedAt, clock.now)
+
+	open, _ = service.OpenTransactions(ctx, device.Id)
+	assert.ShouldBe(t, len(open), 1)
+	assert.ShouldBe(t, open[0].Number, 3)
+
+	_, err = service.OpenTransactions(ctx, "unknown")
+	assert.ShouldBe(t, err, ErrDeviceNotFound)
+	_, err = service.CloseStaleTransactions(ctx, "unknown", time.Minute)
+	assert.ShouldBe(t, err, ErrDeviceNotFound)
+}
+
+// closeFailingStorage fails to save force closed transactions with the given number.
+type closeFailingStorage struct {
+	persistence.Storage
+	number int
+}
+
+func (s *closeFailingStorage) SaveTransaction(transaction domain.Transaction) error {
+	if transaction.Number == s.number && transaction.ForceClosed {
+		return errors.New("disk full")
+	}
+	return s.Storage.SaveTransaction(transaction)
+}
+
+func TestService_CloseStaleTransactionsReportsFailures(t *testing.T) {
+	clock := &manualClock{now: time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)}
+	service := NewService(&closeFailingStorage{Storage: storage, number: 1}, WithClock(clock))
+	device := newTestDevice(domain.ECC)
+	ctx := context.Background()
+
+	service.StartTransaction(ctx, device.Id, "Kassenbeleg-V1", "failing", domain.FormatRaw)
+	service.StartTransaction(ctx, device.Id, "Kassenbeleg-V1", "stale", domain.FormatRaw)
+	clock.now = clock.now.Add(10 * time.Minute)
+
+	closed, err := service.CloseStaleTransactions(ctx, device.Id, 5*time.Minute)
+	var failed *TransactionError
+	assert.ShouldBe(t, errors.As(err, &failed), true)
+	assert.ShouldBe(t, failed.Number, 1)
+	assert.ShouldBe(t, len(closed), 1)
+	assert.ShouldBe(t, closed[0].Transaction.Number, 2)
+	assert.ShouldBe(t, closed[0].Transaction.ForceClosed, true)
+
+	open, _ := service.OpenTransactions(ctx, device.Id)
+	assert.ShouldBe(t, len(open), 1)
+	assert.ShouldBe(t, open[0].Number, 1)
+}
+
+// stepFailingStorage fails to save transactions or to store signatures while the flags are set.
+type stepFailingStorage struct {
+	persistence.Storage
+	failSave      bool
+	failSignature bool
+}
+
+func (s *stepFailingStorage) SaveTransaction(transaction domain.Transaction) error {
+	if s.failSave {
+		return errors.New("disk full")
+	}
+	return s.Storage.SaveTransaction(transaction)
+}
+
+func (s *stepFailingStorage) AddSignatureRecord(deviceId string, signature domain.Signature) (*domain.Signature, error) {
+	if s.failSignature {
+		return nil, errors.New("disk full")
+	}
+	return s.Storage.AddSignatureRecord(deviceId, signature)
+}
+
+func TestService_TransactionStepFailures(t *testing.T) {
+	failing := &stepFailingStorage{Storage: storage}
+	service := NewService(failing)
+	device := newTestDevice(domain.ECC)
+	ctx := context.Background()
+
+	// A transaction that cannot be stored is not signed.
+	failing.failSave = true
+	_, err := service.StartTransaction(ctx, device.Id, "Kassenbeleg-V1", "a", domain.FormatRaw)
+	assert.ShouldNotBe(t, err, nil)
+	assert.ShouldBe(t, storage.GetDeviceSignaturesCount(device.Id), 0)
+	failing.failSave = false
+
+	// A step that cannot be signed is undone.
+	failing.failSignature = true
+	_, err = service.StartTransaction(ctx, device.Id, "Kassenbeleg-V1", "a", domain.FormatRaw)
+	assert.ShouldNotBe(t, err, nil)
+	_, err = service.Transaction(ctx, device.Id, 1)
+	assert.ShouldBe(t, err, ErrTransactionNotFound)
+	failing.failSignature = false
+
+	started, err := service.StartTransaction(ctx, device.Id, "Kassenbeleg-V1", "a", domain.FormatRaw)
+	assert.ShouldBe(t, err, nil)
+	assert.ShouldBe(t, started.Transaction.Number, 1)
+	failing.failSignature = true
+	_, err = service.UpdateTransaction(ctx, device.Id, 1, "", "b", domain.FormatRaw)
+	assert.ShouldNotBe(t, err, nil)
+	failing.failSignature = false
+	stored, _ := service.Transaction(ctx, device.Id, 1)
+	assert.ShouldBe(t, stored.Revision(), 1)
+	assert.ShouldBe(t, stored.ProcessData, "a")
+	assert.ShouldBe(t, stored.IsOpen(), true)
+
+	// Numbers follow the highest stored number, not the number of transactions.
+	service.StartTransaction(ctx, device.Id, "Kassenbeleg-V1", "c", domain.FormatRaw)
+	assert.ShouldBe(t, storage.DeleteTransaction(device.Id, 1), nil)
+	next, err := service.StartTransaction(ctx, device.Id, "Kassenbeleg-V1", "d", domain.FormatRaw)
+	assert.ShouldBe(t, err, nil)
+	assert.ShouldBe(t, next.Transaction.Number, 3)
+
+	audit, _ := service.AuditChain(ctx, device.Id)
+	assert.ShouldBe(t, audit.Valid(), true)
+}
+
+func TestService_ReleasesTransactionLocks(t *testing.T) {
+	service := NewService(storage)
+	_, err := service.StartTransaction(context.Background(), "unknown", "Kassenbeleg-V1", "", domain.FormatRaw)
+	assert.ShouldBe(t, err, ErrDeviceNotFound)
+	_, err = service.FinishTransaction(context.Background(), "unknown", 1, "", "", domain.FormatRaw)
+	assert.ShouldBe(t, err, ErrDeviceNotFound)
+	_, err = service.CloseStaleTransactions(context.Background(), "unknown", time.Minute)
+	assert.ShouldBe(t, err, ErrDeviceNotFound)
+	assert.ShouldBe(t, len(service.locks.entries), 0)
+}
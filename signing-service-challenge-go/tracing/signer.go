@@ -0,0 +1,43 @@
+package tracing
+
+import (
+	"context"
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/crypto"
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/domain"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Signer instruments a signer of a device with the given algorithm, its spans are children of ctx.
+func Signer(ctx context.Context, signer crypto.Signer, algorithm domain.CryptoAlgorithmType) crypto.Signer {
+	return &tracedSigner{
+		ctx:       ctx,
+		signer:    signer,
+		algorithm: string(algorithm),
+	}
+}
+
+type tracedSigner struct {
+	ctx       context.Context
+	signer    crypto.Signer
+	algorithm string
+}
+
+func (s *tracedSigner) Sign(dataToBeSigned []byte) ([]byte, error) {
+	_, span := Tracer().Start(s.ctx, "Signer.Sign")
+	span.SetAttributes(attribute.String("signing.algorithm", s.algorithm))
+	signature, err := s.signer.Sign(dataToBeSigned)
+	End(span, err)
+	return signature, err
+}
+
+// KeyLoad traces the loading of the key pair of a device with the given algorithm
+// by a signer, its spans are children of ctx.
+func KeyLoad(ctx context.Context, algorithm domain.CryptoAlgorithmType) crypto.KeyLoadObserver {
+	return func() func(error) {
+		_, span := Tracer().Start(ctx, "Signer.loadKey")
+		span.SetAttributes(attribute.String("signing.algorithm", string(algorithm)))
+		return func(err error) {
+			End(span, err)
+		}
+	}
+}
@@ -0,0 +1,203 @@
+package tracing
+
+import (
+	"context"
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/domain"
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/persistence"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"time"
+)
+
+// Storage instruments every call of a storage with a span that is a child of ctx.
+// Keys and signed data are never recorded, only the device the call refers to.
+func Storage(ctx context.Context, storage persistence.Storage) persistence.Storage {
+	return &tracedStorage{
+		ctx:     ctx,
+		storage: storage,
+	}
+}
+
+type tracedStorage struct {
+	ctx     context.Context
+	storage persistence.Storage
+}
+
+func (s *tracedStorage) start(operation string, deviceId string) trace.Span {
+	_, span := Tracer().Start(s.ctx, "Storage."+operation)
+	if deviceId != "" {
+		span.SetAttributes(attribute.String("signing.device_id", deviceId))
+	}
+	return span
+}
+
+func (s *tracedStorage) CreateSignatureDevice(userId string, algorithm domain.CryptoAlgorithmType, label string) (DeviceId string, Label string) {
+	span := s.start("CreateSignatureDevice", "")
+	deviceId, label := s.storage.CreateSignatureDevice(userId, algorithm, label)
+	span.SetAttributes(attribute.String("signing.device_id", deviceId))
+	span.End()
+	return deviceId, label
+}
+
+func (s *tracedStorage) GetDevice(deviceId string) *domain.Device {
+	span := s.start("GetDevice", deviceId)
+	defer span.End()
+	return s.storage.GetDevice(deviceId)
+}
+
+func (s *tracedStorage) ListDevices() []*domain.Device {
+	span := s.start("ListDevices", "")
+	defer span.End()
+	return s.storage.ListDevices()
+}
+
+func (s *tracedStorage) RetireDevice(deviceId string, retiredAt time.Time) error {
+	span := s.start("RetireDevice", deviceId)
+	err := s.storage.RetireDevice(deviceId, retiredAt)
+	End(span, err)
+	return err
+}
+
+func (s *tracedStorage) SetDeviceKeys(deviceId string, publicKey []byte, privateKey []byte) error {
+	span := s.start("SetDeviceKeys", deviceId)
+	err := s.storage.SetDeviceKeys(deviceId, publicKey, privateKey)
+	End(span, err)
+	return err
+}
+
+func (s *tracedStorage) SetDeviceCertificate(deviceId string, certificateChain []byte) error {
+	span := s.start("SetDeviceCertificate", deviceId)
+	err := s.storage.SetDeviceCertificate(deviceId, certificateChain)
+	End(span, err)
+	return err
+}
+
+func (s *tracedStorage) UpdateSignatureCounter(deviceId string) error {
+	span := s.start("UpdateSignatureCounter", deviceId)
+	err := s.storage.UpdateSignatureCounter(deviceId)
+	End(span, err)
+	return err
+}
+
+func (s *tracedStorage) AddSignature(deviceId string, publicKey []byte, privateKey []byte, signedData []byte) error {
+	span := s.start("AddSignature", deviceId)
+	err := s.storage.AddSignature(deviceId, publicKey, privateKey, signedData)
+	End(span, err)
+	return err
+}
+
+func (s *tracedStorage) AddSignatureRecord(deviceId string, signature domain.Signature) (*domain.Signature, error) {
+	span := s.start("AddSignatureRecord", deviceId)
+	result, err := s.storage.AddSignatureRecord(deviceId, signature)
+	End(span, err)
+	return result, err
+}
+
+func (s *tracedStorage) SetSignatureTimestampToken(deviceId string, counter int, token []byte) error {
+	span := s.start("SetSignatureTimestampToken", deviceId)
+	err := s.storage.SetSignatureTimestampToken(deviceId, counter, token)
+	End(span, err)
+	return err
+}
+
+func (s *tracedStorage) GetDeviceSignaturesCount(deviceId string) int {
+	span := s.start("GetDeviceSignaturesCount", deviceId)
+	defer span.End()
+	return s.storage.GetDeviceSignaturesCount(deviceId)
+}
+
+func (s *tracedStorage) GetLastDeviceSignature(deviceId string) (*domain.Signature, error) {
+	span := s.start("GetLastDeviceSignature", deviceId)
+	result, err := s.storage.GetLastDeviceSignature(deviceId)
+	End(span, err)
+	return result, err
+}
+
+func (s *tracedStorage) GetSignature(deviceId string, counter int) (*domain.Signature, error) {
+	span := s.start("GetSignature", deviceId)
+	result, err := s.storage.GetSignature(deviceId, counter)
+	End(span, err)
+	return result, err
+}
+
+func (s *tracedStorage) AddAuditEvent(event domain.AuditEvent) error {
+	span := s.start("AddAuditEvent", event.DeviceId)
+	err := s.storage.AddAuditEvent(event)
+	End(span, err)
+	return err
+}
+
+func (s *tracedStorage) GetAuditEvents(deviceId string) []domain.AuditEvent {
+	span := s.start("GetAuditEvents", deviceId)
+	defer span.End()
+	return s.storage.GetAuditEvents(deviceId)
+}
+
+func (s *tracedStorage) SaveTransaction(transaction domain.Transaction) error {
+	span := s.start("SaveTransaction", transaction.DeviceId)
+	err := s.storage.SaveTransaction(transaction)
+	End(span, err)
+	return err
+}
+
+func (s *tracedStorage) DeleteTransaction(deviceId string, number int) error {
+	span := s.start("DeleteTransaction", deviceId)
+	err := s.storage.DeleteTransaction(deviceId, number)
+	End(span, err)
+	return err
+}
+
+func (s *tracedStorage) GetTransaction(deviceId string, number int) (*domain.Transaction, error) {
+	span := s.start("GetTransaction", deviceId)
+	result, err := s.storage.GetTransaction(deviceId, number)
+	End(span, err)
+	return result, err
+}
+
+func (s *tracedStorage) ListTransactions(deviceId string) ([]*domain.Transaction, error) {
+	span := s.start("ListTransactions", deviceId)
+	transactions, err := s.storage.ListTransactions(deviceId)
+	End(span, err)
+	return transactions, err
+}
+
+func (s *tracedStorage) GetCertificateAuthority() *domain.CertificateAuthority {
+	span := s.start("GetCertificateAuthority", "")
+	defer span.End()
+	return s.storage.GetCertificateAuthority()
+}
+
+func (s *tracedStorage) SetCertificateAuthority(authority domain.CertificateAuthority) error {
+	span := s.start("SetCertificateAuthority", "")
+	err := s.storage.SetCertificateAuthority(authority)
+	End(span, err)
+	return err
+}
+
+func (s *tracedStorage) AddIssuedCertificate(certificate domain.IssuedCertificate) error {
+	span := s.start("AddIssuedCertificate", certificate.DeviceId)
+	err := s.storage.AddIssuedCertificate(certificate)
+	End(span, err)
+	return err
+}
+
+func (s *tracedStorage) ListIssuedCertificates(deviceId string) ([]domain.IssuedCertificate, error) {
+	span := s.start("ListIssuedCertificates", deviceId)
+	certificates, err := s.storage.ListIssuedCertificates(deviceId)
+	End(span, err)
+	return certificates, err
+}
+
+func (s *tracedStorage) Ping() error {
+	span := s.start("Ping", "")
+	err := s.storage.Ping()
+	End(span, err)
+	return err
+}
+
+func (s *tracedStorage) Close() error {
+	span := s.start("Close", "")
+	err := s.storage.Close()
+	End(span, err)
+	return err
+}
@@ -0,0 +1,89 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/assert"
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/crypto"
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/domain"
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/persistence"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"testing"
+)
+
+func recordSpans(t *testing.T) *tracetest.SpanRecorder {
+	recorder := tracetest.NewSpanRecorder()
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+	return recorder
+}
+
+type signerFunc func([]byte) ([]byte, error)
+
+func (f signerFunc) Sign(dataToBeSigned []byte) ([]byte, error) {
+	return f(dataToBeSigned)
+}
+
+func TestSetup(t *testing.T) {
+	shutdown, err := Setup(context.Background(), Config{Exporter: ExporterNone})
+	assert.ShouldBe(t, err, nil)
+	assert.ShouldBe(t, shutdown(context.Background()), nil)
+
+	_, err = Setup(context.Background(), Config{Exporter: "zipkin"})
+	assert.ShouldNotBe(t, err, nil)
+}
+
+func TestSigner(t *testing.T) {
+	recorder := recordSpans(t)
+	ctx, parent := Tracer().Start(context.Background(), "parent")
+	signer := Signer(ctx, signerFunc(func([]byte) ([]byte, error) {
+		return nil, errors.New("failed")
+	}), domain.RSA)
+	_, err := signer.Sign([]byte("data"))
+	assert.ShouldNotBe(t, err, nil)
+	parent.End()
+
+	spans := recorder.Ended()
+	assert.ShouldBe(t, len(spans), 2)
+	assert.ShouldBe(t, spans[0].Name(), "Signer.Sign")
+	assert.ShouldBe(t, spans[0].Parent().SpanID(), parent.SpanContext().SpanID())
+	assert.ShouldBe(t, spans[0].Status().Code, codes.Error)
+	assert.ShouldBe(t, spans[0].Attributes()[0].Value.AsString(), "RSA")
+}
+
+func TestKeyLoad(t *testing.T) {
+	recorder := recordSpans(t)
+	storage := persistence.NewLocalStorage()
+	deviceId, _ := storage.CreateSignatureDevice("test", domain.ECC, "")
+	storage.SetDeviceKeys(deviceId, nil, []byte("corrupt"))
+	signer, _ := crypto.NewSigner(storage.GetDevice(deviceId), storage, domain.FormatRaw,
+		crypto.WithKeyLoadObserver(KeyLoad(context.Background(), domain.ECC)))
+	_, err := signer.Sign([]byte("data"))
+	assert.ShouldNotBe(t, err, nil)
+
+	spans := recorder.Ended()
+	assert.ShouldBe(t, len(spans), 1)
+	assert.ShouldBe(t, spans[0].Name(), "Signer.loadKey")
+	assert.ShouldBe(t, spans[0].Status().Code, codes.Error)
+}
+
+func TestStorage(t *testing.T) {
+	recorder := recordSpans(t)
+	storage := Storage(context.Background(), persistence.NewLocalStorage())
+	deviceId, _ := storage.CreateSignatureDevice("test", domain.ECC, "")
+	_, err := storage.GetSignature(deviceId, 0)
+	assert.ShouldNotBe(t, err, nil)
+
+	spans := recorder.Ended()
+	assert.ShouldBe(t, len(spans), 2)
+	assert.ShouldBe(t, spans[0].Name(), "Storage.CreateSignatureDevice")
+	assert.ShouldBe(t, spans[1].Name(), "Storage.GetSignature")
+	assert.ShouldBe(t, spans[1].Status().Code, codes.Error)
+	for _, span := range spans {
+		assert.ShouldBe(t, span.Attributes()[0].Value.AsString(), deviceId)
+	}
+}
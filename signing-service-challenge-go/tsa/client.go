@@ -0,0 +1,150 @@
+package tsa
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"strings"
+)
+
+const (
+	ContentTypeQuery = "application/timestamp-query"
+	ContentTypeReply = "application/timestamp-reply"
+	// maxReplySize bounds the replies read from an authority.
+	maxReplySize = 1 << 20
+)
+
+// The PKIStatus values of RFC 3161 that grant a token.
+const (
+	statusGranted         = 0
+	statusGrantedWithMods = 1
+)
+
+var ErrRejected = errors.New("timestamp request has been rejected")
+
+type timeStampReq struct {
+	Version        int
+	MessageImprint messageImprint
+	ReqPolicy      asn1.ObjectIdentifier `asn1:"optional"`
+	Nonce          *big.Int              `asn1:"optional"`
+	CertReq        bool                  `asn1:"optional"`
+}
+
+type pkiStatusInfo struct {
+	Status       int
+	StatusString []string       `asn1:"optional"`
+	FailInfo     asn1.BitString `asn1:"optional"`
+}
+
+type timeStampResp struct {
+	Status         pkiStatusInfo
+	TimeStampToken asn1.RawValue `asn1:"optional"`
+}
+
+// Client requests timestamp tokens from an RFC 3161 authority over HTTP.
+type Client struct {
+	url        string
+	httpClient *http.Client
+	hash       crypto.Hash
+}
+
+// ClientOption configures optional behaviour of a Client.
+type ClientOption func(*Client)
+
+// WithHTTPClient replaces the default HTTP client, to set timeouts or TLS settings.
+func WithHTTPClient(httpClient *http.Client) ClientOption {
+	return func(c *Client) {
+		c.httpClient = httpClient
+	}
+}
+
+// WithHash sets the hash of the message imprints, by default SHA-256.
+func WithHash(hash crypto.Hash) ClientOption {
+	return func(c *Client) {
+		c.hash = hash
+	}
+}
+
+// NewClient is a factory to instantiate a Client of the authority at url.
+func NewClient(url string, options ...ClientOption) *Client {
+	client := &Client{
+		url:        url,
+		httpClient: http.DefaultClient,
+		hash:       crypto.SHA256,
+	}
+	for _, option := range options {
+		option(client)
+	}
+	return client
+}
+
+// Timestamp requests a token over the hash of message. The token is checked to answer the
+// request, it is not verified against any roots.
+func (c *Client) Timestamp(ctx context.Context, message []byte) ([]byte, error) {
+	algorithm, err := hashAlgorithm(c.hash)
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 64))
+	if err != nil {
+		return nil, err
+	}
+	imprint := messageImprint{HashAlgorithm: algorithm, HashedMessage: digest(c.hash, message)}
+	query, err := asn1.Marshal(timeStampReq{Version: 1, MessageImprint: imprint, Nonce: nonce, CertReq: true})
+	if err != nil {
+		return nil, err
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url, bytes.NewReader(query))
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Content-Type", ContentTypeQuery)
+	response, err := c.httpClient.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("timestamp authority answered %s", response.Status)
+	}
+	if contentType := response.Header.Get("Content-Type"); contentType != ContentTypeReply {
+		return nil, fmt.Errorf("timestamp authority answered with content type %q", contentType)
+	}
+	reply, err := io.ReadAll(io.LimitReader(response.Body, maxReplySize))
+	if err != nil {
+		return nil, err
+	}
+	return parseReply(reply, imprint, nonce)
+}
+
+// parseReply returns the token of a reply after checking it answers the request.
+func parseReply(reply []byte, imprint messageImprint, nonce *big.Int) ([]byte, error) {
+	var resp timeStampResp
+	if rest, err := asn1.Unmarshal(reply, &resp); err != nil || len(rest) > 0 {
+		return nil, fmt.Errorf("%w: reply is not a TimeStampResp", ErrInvalidToken)
+	}
+	if resp.Status.Status != statusGranted && resp.Status.Status != statusGrantedWithMods {
+		return nil, fmt.Errorf("%w: status %d %s", ErrRejected, resp.Status.Status, strings.Join(resp.Status.StatusString, ", "))
+	}
+	token := resp.TimeStampToken.FullBytes
+	parsed, err := parseToken(token)
+	if err != nil {
+		return nil, err
+	}
+	if !parsed.info.MessageImprint.HashAlgorithm.Algorithm.Equal(imprint.HashAlgorithm.Algorithm) ||
+		!bytes.Equal(parsed.info.MessageImprint.HashedMessage, imprint.HashedMessage) {
+		return nil, ErrImprintMismatch
+	}
+	if parsed.info.Nonce == nil || parsed.info.Nonce.Cmp(nonce) != 0 {
+		return nil, fmt.Errorf("%w: nonce does not match the request", ErrInvalidToken)
+	}
+	return token, nil
+}
@@ -0,0 +1,242 @@
+package tsa
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"io"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// LocalPolicy is the policy of the tokens a LocalAuthority issues.
+var LocalPolicy = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 99999, 3161, 1}
+
+// The PKIFailureInfo bits of RFC 3161 a LocalAuthority answers with.
+const (
+	failBadAlg        = 0
+	failBadRequest    = 2
+	failBadDataFormat = 5
+)
+
+// LocalAuthority is a stand-in time-stamp authority with a self-signed certificate, for tests
+// and local setups without access to a real authority. It issues tokens directly through
+// Timestamp and over HTTP as an http.Handler.
+type LocalAuthority struct {
+	key         *ecdsa.PrivateKey
+	certificate *x509.Certificate
+	now         func() time.Time
+
+	mutex  sync.Mutex
+	serial int64
+}
+
+// LocalOption configures optional behaviour of a LocalAuthority.
+type LocalOption func(*LocalAuthority)
+
+// WithClock sets the clock the generation time of tokens is taken from.
+func WithClock(now func() time.Time) LocalOption {
+	return func(a *LocalAuthority) {
+		a.now = now
+	}
+}
+
+// NewLocalAuthority creates an authority with a new P-256 key and a self-signed
+// certificate for timestamping that is valid for the given duration.
+func NewLocalAuthority(validity time.Duration, options ...LocalOption) (*LocalAuthority, error) {
+	authority := &LocalAuthority{now: time.Now}
+	for _, option := range options {
+		option(authority)
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	now := authority.now()
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Local Time-Stamp Authority"},
+		NotBefore:             now.Add(-time.Minute),
+		NotAfter:              now.Add(validity),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageTimeStamping},
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		return nil, err
+	}
+	authority.key = key
+	authority.certificate, err = x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	return authority, nil
+}
+
+// Certificate returns the self-signed certificate the tokens verify with.
+func (a *LocalAuthority) Certificate() *x509.Certificate {
+	return a.certificate
+}
+
+// Roots returns a pool that trusts the authority.
+func (a *LocalAuthority) Roots() *x509.CertPool {
+	roots := x509.NewCertPool()
+	roots.AddCert(a.certificate)
+	return roots
+}
+
+// Timestamp issues a SHA-256 token over message without going through HTTP.
+func (a *LocalAuthority) Timestamp(_ context.Context, message []byte) ([]byte, error) {
+	algorithm, _ := hashAlgorithm(crypto.SHA256)
+	return a.issue(messageImprint{HashAlgorithm: algorithm, HashedMessage: digest(crypto.SHA256, message)}, nil)
+}
+
+// ServeHTTP answers RFC 3161 timestamp queries.
+func (a *LocalAuthority) ServeHTTP(response http.ResponseWriter, request *http.Request) {
+	if request.Method != http.MethodPost {
+		response.Header().Set("Allow", http.MethodPost)
+		http.Error(response, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	if request.Header.Get("Content-Type") != ContentTypeQuery {
+		http.Error(response, "content type must be "+ContentTypeQuery, http.StatusUnsupportedMediaType)
+		return
+	}
+	query, err := io.ReadAll(io.LimitReader(request.Body, maxReplySize))
+	if err != nil {
+		http.Error(response, err.Error(), http.StatusBadRequest)
+		return
+	}
+	response.Header().Set("Content-Type", ContentTypeReply)
+	response.Write(a.reply(query))
+}
+
+// reply answers a DER encoded TimeStampReq with a TimeStampResp, rejections included.
+func (a *LocalAuthority) reply(query []byte) []byte {
+	var req timeStampReq
+	if rest, err := asn1.Unmarshal(query, &req); err != nil || len(rest) > 0 || req.Version != 1 {
+		return rejection(failBadDataFormat, "request is not a TimeStampReq")
+	}
+	if req.ReqPolicy != nil && !req.ReqPolicy.Equal(LocalPolicy) {
+		return rejection(failBadRequest, "policy is not supported")
+	}
+	hash, err := hashOf(req.MessageImprint.HashAlgorithm)
+	if err != nil || len(req.MessageImprint.HashedMessage) != hash.Size() {
+		return rejection(failBadAlg, "message imprint is not supported")
+	}
+	token, err := a.issue(req.MessageImprint, req.Nonce)
+	if err != nil {
+		return rejection(failBadRequest, err.Error())
+	}
+	return grant(token)
+}
+
+// rejection encodes a reply that rejects a request with a single failure bit.
+func rejection(failInfo int, text string) []byte {
+	reply, _ := asn1.Marshal(timeStampResp{Status: pkiStatusInfo{
+		Status:       2,
+		StatusString: []string{text},
+		FailInfo:     asn1.BitString{Bytes: []byte{byte(0x80 >> failInfo)}, BitLength: failInfo + 1},
+	}})
+	return reply
+}
+
+// issue signs a TSTInfo over the imprint into a CMS SignedData token.
+func (a *LocalAuthority) issue(imprint messageImprint, nonce *big.Int) ([]byte, error) {
+	a.mutex.Lock()
+	a.serial++
+	serial := a.serial
+	a.mutex.Unlock()
+
+	info, err := asn1.Marshal(tstInfo{
+		Version:        1,
+		Policy:         LocalPolicy,
+		MessageImprint: imprint,
+		SerialNumber:   big.NewInt(serial),
+		GenTime:        a.now().UTC().Truncate(time.Second),
+		Accuracy:       accuracy{Seconds: 1},
+		Nonce:          nonce,
+	})
+	if err != nil {
+		return nil, err
+	}
+	digestAlgorithm, _ := hashAlgorithm(crypto.SHA256)
+	certHash := sha256.Sum256(a.certificate.Raw)
+	signedAttributes := []struct {
+		oid   asn1.ObjectIdentifier
+		value interface{}
+	}{
+		{oidContentType, oidTSTInfo},
+		{oidMessageDigest, digest(crypto.SHA256, info)},
+		{oidSigningCertV2, signingCertificateV2{Certs: []essCertIDv2{{CertHash: certHash[:]}}}},
+	}
+	var attributeList []attribute
+	for _, signedAttribute := range signedAttributes {
+		value, err := asn1.Marshal(signedAttribute.value)
+		if err != nil {
+			return nil, err
+		}
+		attributeList = append(attributeList, attribute{Type: signedAttribute.oid, Values: []asn1.RawValue{{FullBytes: value}}})
+	}
+	// The signature covers the attributes as a DER SET OF.
+	attributes, err := asn1.MarshalWithParams(attributeList, "set")
+	if err != nil {
+		return nil, err
+	}
+	attributesDigest := sha256.Sum256(attributes)
+	signature, err := ecdsa.SignASN1(rand.Reader, a.key, attributesDigest[:])
+	if err != nil {
+		return nil, err
+	}
+
+	eContent, err := asn1.Marshal(info)
+	if err != nil {
+		return nil, err
+	}
+	certificates, err := asn1.Marshal(asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: a.certificate.Raw})
+	if err != nil {
+		return nil, err
+	}
+	signed, err := asn1.Marshal(signedData{
+		Version:          3,
+		DigestAlgorithms: []pkix.AlgorithmIdentifier{digestAlgorithm},
+		EncapContentInfo: encapsulatedContentInfo{
+			EContentType: oidTSTInfo,
+			EContent:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: eContent},
+		},
+		Certificates: rawElement{Raw: certificates},
+		SignerInfos: []signerInfo{{
+			Version:            1,
+			SID:                issuerAndSerialNumber{Issuer: asn1.RawValue{FullBytes: a.certificate.RawIssuer}, SerialNumber: a.certificate.SerialNumber},
+			DigestAlgorithm:    digestAlgorithm,
+			SignedAttributes:   rawElement{Raw: attributes},
+			SignatureAlgorithm: pkix.AlgorithmIdentifier{Algorithm: oidECDSAWithSHA256},
+			Signature:          signature,
+		}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(contentInfo{
+		ContentType: oidSignedData,
+		Content:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: signed},
+	})
+}
+
+// grant encodes a reply that grants a token.
+func grant(token []byte) []byte {
+	reply, _ := asn1.Marshal(timeStampResp{
+		Status:         pkiStatusInfo{Status: statusGranted},
+		TimeStampToken: asn1.RawValue{FullBytes: token},
+	})
+	return reply
+}
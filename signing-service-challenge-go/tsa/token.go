@@ -0,0 +1,355 @@
+// Package tsa requests RFC 3161 timestamp tokens from a time-stamp authority and verifies them.
+// A token is the DER encoded CMS SignedData the authority has signed over the hash of a message
+// and the time it has seen that hash at.
+package tsa
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+)
+
+var (
+	ErrInvalidToken     = errors.New("timestamp token is malformed")
+	ErrImprintMismatch  = errors.New("timestamp token does not cover the message")
+	ErrUnsupportedHash  = errors.New("hash algorithm is not supported")
+	ErrInvalidSignature = errors.New("timestamp token signature is not valid")
+)
+
+var (
+	oidSignedData    = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+	oidTSTInfo       = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 1, 4}
+	oidContentType   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 3}
+	oidMessageDigest = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 4}
+	oidSigningCertV2 = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 2, 47}
+
+	oidSHA256 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+	oidSHA384 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 2}
+	oidSHA512 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 3}
+
+	oidRSAEncryption   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 1}
+	oidSHA256WithRSA   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 11}
+	oidSHA384WithRSA   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 12}
+	oidSHA512WithRSA   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 13}
+	oidECDSAWithSHA256 = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2}
+	oidECDSAWithSHA384 = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 3}
+	oidECDSAWithSHA512 = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 4}
+)
+
+// The structures follow RFC 3161 and the CMS SignedData of RFC 5652.
+
+type messageImprint struct {
+	HashAlgorithm pkix.AlgorithmIdentifier
+	HashedMessage []byte
+}
+
+type accuracy struct {
+	Seconds int `asn1:"optional"`
+	Millis  int `asn1:"optional,tag:0"`
+	Micros  int `asn1:"optional,tag:1"`
+}
+
+type tstInfo struct {
+	Version        int
+	Policy         asn1.ObjectIdentifier
+	MessageImprint messageImprint
+	SerialNumber   *big.Int
+	GenTime        time.Time `asn1:"generalized"`
+	Accuracy       accuracy  `asn1:"optional"`
+	Ordering       bool      `asn1:"optional"`
+	Nonce          *big.Int  `asn1:"optional"`
+}
+
+// contentInfo holds its content with the [0] EXPLICIT tag, the tag is built by hand.
+type contentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue
+}
+
+type encapsulatedContentInfo struct {
+	EContentType asn1.ObjectIdentifier
+	EContent     asn1.RawValue
+}
+
+// rawElement keeps an optional implicitly tagged element undecoded.
+type rawElement struct {
+	Raw asn1.RawContent
+}
+
+type signedData struct {
+	Version          int
+	DigestAlgorithms []pkix.AlgorithmIdentifier `asn1:"set"`
+	EncapContentInfo encapsulatedContentInfo
+	Certificates     rawElement      `asn1:"optional,tag:0"`
+	CRLs             []asn1.RawValue `asn1:"optional,tag:1"`
+	SignerInfos      []signerInfo    `asn1:"set"`
+}
+
+type issuerAndSerialNumber struct {
+	Issuer       asn1.RawValue
+	SerialNumber *big.Int
+}
+
+type signerInfo struct {
+	Version            int
+	SID                issuerAndSerialNumber
+	DigestAlgorithm    pkix.AlgorithmIdentifier
+	SignedAttributes   rawElement `asn1:"tag:0"`
+	SignatureAlgorithm pkix.AlgorithmIdentifier
+	Signature          []byte
+}
+
+type attribute struct {
+	Type   asn1.ObjectIdentifier
+	Values []asn1.RawValue `asn1:"set"`
+}
+
+type essCertIDv2 struct {
+	HashAlgorithm pkix.AlgorithmIdentifier `asn1:"optional"`
+	CertHash      []byte
+}
+
+type signingCertificateV2 struct {
+	Certs []essCertIDv2
+}
+
+// Info is the content of a verified timestamp token.
+type Info struct {
+	// Time is the time the authority has seen the message at.
+	Time         time.Time
+	SerialNumber *big.Int
+	Policy       asn1.ObjectIdentifier
+	// Certificate is the certificate the authority has signed the token with.
+	Certificate *x509.Certificate
+}
+
+var hashes = []struct {
+	oid  asn1.ObjectIdentifier
+	hash crypto.Hash
+}{{oidSHA256, crypto.SHA256}, {oidSHA384, crypto.SHA384}, {oidSHA512, crypto.SHA512}}
+
+func hashOf(algorithm pkix.AlgorithmIdentifier) (crypto.Hash, error) {
+	for _, h := range hashes {
+		if algorithm.Algorithm.Equal(h.oid) {
+			return h.hash, nil
+		}
+	}
+	return 0, fmt.Errorf("%w: %v", ErrUnsupportedHash, algorithm.Algorithm)
+}
+
+func hashAlgorithm(hash crypto.Hash) (pkix.AlgorithmIdentifier, error) {
+	for _, h := range hashes {
+		if h.hash == hash {
+			return pkix.AlgorithmIdentifier{Algorithm: h.oid, Parameters: asn1.NullRawValue}, nil
+		}
+	}
+	return pkix.AlgorithmIdentifier{}, ErrUnsupportedHash
+}
+
+func digest(hash crypto.Hash, message []byte) []byte {
+	h := hash.New()
+	h.Write(message)
+	return h.Sum(nil)
+}
+
+// signatureAlgorithm maps the signature algorithm of a signer info to the one of x509.
+// RSA signers may name the plain key algorithm, the hash is then the digest algorithm.
+func signatureAlgorithm(info *signerInfo) (x509.SignatureAlgorithm, error) {
+	algorithm := info.SignatureAlgorithm.Algorithm
+	switch {
+	case algorithm.Equal(oidECDSAWithSHA256):
+		return x509.ECDSAWithSHA256, nil
+	case algorithm.Equal(oidECDSAWithSHA384):
+		return x509.ECDSAWithSHA384, nil
+	case algorithm.Equal(oidECDSAWithSHA512):
+		return x509.ECDSAWithSHA512, nil
+	case algorithm.Equal(oidSHA256WithRSA):
+		return x509.SHA256WithRSA, nil
+	case algorithm.Equal(oidSHA384WithRSA):
+		return x509.SHA384WithRSA, nil
+	case algorithm.Equal(oidSHA512WithRSA):
+		return x509.SHA512WithRSA, nil
+	case algorithm.Equal(oidRSAEncryption):
+		hash, err := hashOf(info.DigestAlgorithm)
+		if err != nil {
+			return 0, err
+		}
+		return map[crypto.Hash]x509.SignatureAlgorithm{
+			crypto.SHA256: x509.SHA256WithRSA,
+			crypto.SHA384: x509.SHA384WithRSA,
+			crypto.SHA512: x509.SHA512WithRSA,
+		}[hash], nil
+	default:
+		return 0, fmt.Errorf("%w: signature algorithm %v is not supported", ErrInvalidToken, algorithm)
+	}
+}
+
+// parsedToken is a timestamp token split into the parts verification needs.
+type parsedToken struct {
+	info         tstInfo
+	infoDER      []byte
+	signer       signerInfo
+	certificates []*x509.Certificate
+}
+
+func parseToken(token []byte) (*parsedToken, error) {
+	var content contentInfo
+	if rest, err := asn1.Unmarshal(token, &content); err != nil || len(rest) > 0 {
+		return nil, fmt.Errorf("%w: not a CMS content info", ErrInvalidToken)
+	}
+	if !content.ContentType.Equal(oidSignedData) {
+		return nil, fmt.Errorf("%w: content is not signed data", ErrInvalidToken)
+	}
+	var signed signedData
+	if _, err := asn1.Unmarshal(content.Content.Bytes, &signed); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	if !signed.EncapContentInfo.EContentType.Equal(oidTSTInfo) {
+		return nil, fmt.Errorf("%w: content is not a TSTInfo", ErrInvalidToken)
+	}
+	var parsed parsedToken
+	if _, err := asn1.Unmarshal(signed.EncapContentInfo.EContent.Bytes, &parsed.infoDER); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	if _, err := asn1.Unmarshal(parsed.infoDER, &parsed.info); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	if len(signed.SignerInfos) != 1 {
+		return nil, fmt.Errorf("%w: %d signer infos found where 1 was expected", ErrInvalidToken, len(signed.SignerInfos))
+	}
+	parsed.signer = signed.SignerInfos[0]
+	if len(signed.Certificates.Raw) > 0 {
+		var certificates asn1.RawValue
+		if _, err := asn1.Unmarshal(signed.Certificates.Raw, &certificates); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+		}
+		var err error
+		parsed.certificates, err = x509.ParseCertificates(certificates.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+		}
+	}
+	return &parsed, nil
+}
+
+// signerCertificate returns the certificate of the signer of the token.
+func (t *parsedToken) signerCertificate() (*x509.Certificate, error) {
+	for _, certificate := range t.certificates {
+		if bytes.Equal(certificate.RawIssuer, t.signer.SID.Issuer.FullBytes) && certificate.SerialNumber.Cmp(t.signer.SID.SerialNumber) == 0 {
+			return certificate, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: certificate of the signer is not included", ErrInvalidToken)
+}
+
+// checkSignature checks the signed attributes against the TSTInfo and their signature.
+func (t *parsedToken) checkSignature(certificate *x509.Certificate) error {
+	// The signature covers the attributes encoded as SET OF instead of the implicit [0] tag.
+	signedAttributes := append([]byte{0x31}, t.signer.SignedAttributes.Raw[1:]...)
+	var attributes []attribute
+	if _, err := asn1.UnmarshalWithParams(signedAttributes, &attributes, "set"); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	hash, err := hashOf(t.signer.DigestAlgorithm)
+	if err != nil {
+		return err
+	}
+	var contentTypeFound, digestFound bool
+	for _, attribute := range attributes {
+		if len(attribute.Values) != 1 {
+			return fmt.Errorf("%w: attribute %v has %d values", ErrInvalidToken, attribute.Type, len(attribute.Values))
+		}
+		value := attribute.Values[0].FullBytes
+		switch {
+		case attribute.Type.Equal(oidContentType):
+			var contentType asn1.ObjectIdentifier
+			_, err := asn1.Unmarshal(value, &contentType)
+			contentTypeFound = err == nil && contentType.Equal(oidTSTInfo)
+		case attribute.Type.Equal(oidMessageDigest):
+			var messageDigest []byte
+			_, err := asn1.Unmarshal(value, &messageDigest)
+			digestFound = err == nil && bytes.Equal(messageDigest, digest(hash, t.infoDER))
+		case attribute.Type.Equal(oidSigningCertV2):
+			var signingCertificate signingCertificateV2
+			if _, err := asn1.Unmarshal(value, &signingCertificate); err != nil || len(signingCertificate.Certs) == 0 {
+				return fmt.Errorf("%w: signing certificate attribute is malformed", ErrInvalidToken)
+			}
+			certHash := crypto.SHA256
+			if algorithm := signingCertificate.Certs[0].HashAlgorithm; len(algorithm.Algorithm) > 0 {
+				if certHash, err = hashOf(algorithm); err != nil {
+					return err
+				}
+			}
+			if !bytes.Equal(signingCertificate.Certs[0].CertHash, digest(certHash, certificate.Raw)) {
+				return fmt.Errorf("%w: signing certificate attribute does not match the certificate", ErrInvalidToken)
+			}
+		}
+	}
+	if !contentTypeFound || !digestFound {
+		return fmt.Errorf("%w: signed attributes do not match the TSTInfo", ErrInvalidToken)
+	}
+	algorithm, err := signatureAlgorithm(&t.signer)
+	if err != nil {
+		return err
+	}
+	if err := certificate.CheckSignature(algorithm, signedAttributes, t.signer.Signature); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidSignature, err)
+	}
+	return nil
+}
+
+// Verify checks that a timestamp token has been issued over message by an authority whose
+// certificate chains up to roots for timestamping, at the time stated by the token. Nil roots
+// stand for the system roots.
+func Verify(token []byte, message []byte, roots *x509.CertPool) (*Info, error) {
+	parsed, err := parseToken(token)
+	if err != nil {
+		return nil, err
+	}
+	certificate, err := parsed.signerCertificate()
+	if err != nil {
+		return nil, err
+	}
+	if err := parsed.checkSignature(certificate); err != nil {
+		return nil, err
+	}
+	intermediates := x509.NewCertPool()
+	for _, other := range parsed.certificates {
+		intermediates.AddCert(other)
+	}
+	_, err = certificate.Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		CurrentTime:   parsed.info.GenTime,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageTimeStamping},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("certificate of the timestamp authority is not trusted: %w", err)
+	}
+	if err := checkImprint(parsed.info.MessageImprint, message); err != nil {
+		return nil, err
+	}
+	return &Info{
+		Time:         parsed.info.GenTime,
+		SerialNumber: parsed.info.SerialNumber,
+		Policy:       parsed.info.Policy,
+		Certificate:  certificate,
+	}, nil
+}
+
+func checkImprint(imprint messageImprint, message []byte) error {
+	hash, err := hashOf(imprint.HashAlgorithm)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(imprint.HashedMessage, digest(hash, message)) {
+		return ErrImprintMismatch
+	}
+	return nil
+}
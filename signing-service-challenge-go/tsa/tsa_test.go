@@ -0,0 +1,94 @@
+package tsa
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/x509"
+	"errors"
+	"github.com/DrMonez/coding-challenges/signing-service-challenge/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func newTestAuthority(t *testing.T, options ...LocalOption) *LocalAuthority {
+	authority, err := NewLocalAuthority(time.Hour, options...)
+	assert.ShouldBe(t, err, nil)
+	return authority
+}
+
+func TestClient_Timestamp(t *testing.T) {
+	genTime := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	authority := newTestAuthority(t, WithClock(func() time.Time { return genTime }))
+	server := httptest.NewServer(authority)
+	defer server.Close()
+
+	for _, hash := range []crypto.Hash{crypto.SHA256, crypto.SHA512} {
+		client := NewClient(server.URL, WithHTTPClient(server.Client()), WithHash(hash))
+		token, err := client.Timestamp(context.Background(), []byte("signature"))
+		assert.ShouldBe(t, err, nil)
+
+		info, err := Verify(token, []byte("signature"), authority.Roots())
+		assert.ShouldBe(t, err, nil)
+		assert.ShouldBe(t, info.Time, genTime)
+		assert.ShouldBe(t, info.Policy.Equal(LocalPolicy), true)
+		assert.ShouldBe(t, info.Certificate.Equal(authority.Certificate()), true)
+	}
+}
+
+func TestClient_TimestampErrors(t *testing.T) {
+	authority := newTestAuthority(t)
+	rejecting := httptest.NewServer(http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		response.Header().Set("Content-Type", ContentTypeReply)
+		response.Write(rejection(failBadAlg, "message imprint is not supported"))
+	}))
+	defer rejecting.Close()
+	_, err := NewClient(rejecting.URL).Timestamp(context.Background(), []byte("signature"))
+	assert.ShouldBe(t, errors.Is(err, ErrRejected), true)
+	assert.ShouldBe(t, err.Error(), "timestamp request has been rejected: status 2 message imprint is not supported")
+
+	replaying := httptest.NewServer(http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		token, _ := authority.Timestamp(request.Context(), []byte("signature"))
+		response.Header().Set("Content-Type", ContentTypeReply)
+		response.Write(grant(token))
+	}))
+	defer replaying.Close()
+	_, err = NewClient(replaying.URL).Timestamp(context.Background(), []byte("signature"))
+	assert.ShouldBe(t, err.Error(), "timestamp token is malformed: nonce does not match the request")
+
+	server := httptest.NewServer(authority)
+	defer server.Close()
+	response, _ := http.Post(server.URL, "application/json", bytes.NewReader(nil))
+	assert.ShouldBe(t, response.StatusCode, http.StatusUnsupportedMediaType)
+
+	missing := httptest.NewServer(http.NotFoundHandler())
+	defer missing.Close()
+	_, err = NewClient(missing.URL).Timestamp(context.Background(), []byte("signature"))
+	assert.ShouldBe(t, err.Error(), "timestamp authority answered 404 Not Found")
+}
+
+func TestVerify(t *testing.T) {
+	authority := newTestAuthority(t)
+	token, err := authority.Timestamp(context.Background(), []byte("signature"))
+	assert.ShouldBe(t, err, nil)
+
+	_, err = Verify(token, []byte("other signature"), authority.Roots())
+	assert.ShouldBe(t, err, ErrImprintMismatch)
+
+	_, err = Verify(token, []byte("signature"), newTestAuthority(t).Roots())
+	var unknownAuthority x509.UnknownAuthorityError
+	assert.ShouldBe(t, errors.As(err, &unknownAuthority), true)
+
+	tampered := bytes.Clone(token)
+	// The serial number of the TSTInfo is the only 0x02 0x01 0x01 sequence, it becomes 2.
+	index := bytes.Index(tampered, []byte{0x02, 0x01, 0x01, 0x18})
+	assert.ShouldBe(t, index > 0, true)
+	tampered[index+2] = 0x02
+	_, err = Verify(tampered, []byte("signature"), authority.Roots())
+	assert.ShouldBe(t, err.Error(), "timestamp token is malformed: signed attributes do not match the TSTInfo")
+
+	_, err = Verify([]byte("not a token"), []byte("signature"), authority.Roots())
+	assert.ShouldBe(t, errors.Is(err, ErrInvalidToken), true)
+}